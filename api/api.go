@@ -60,6 +60,7 @@ func Register(r *gin.Engine, opts *Options) {
 	config.POST("", saveConfig)
 
 	config.POST("/reload", reloadConfig)
+	config.POST("/validate", validateConfig)
 
 	config.GET("/services", getServiceList)
 	config.GET("/services/:service", getService)
@@ -156,4 +157,24 @@ func Register(r *gin.Engine, opts *Options) {
 	config.POST("/rlimiters", createRateLimiter)
 	config.PUT("/rlimiters/:limiter", updateRateLimiter)
 	config.DELETE("/rlimiters/:limiter", deleteRateLimiter)
+
+	tungo := router.Group("/tungo")
+	tungo.Use(mwBasicAuth(opts.Auther))
+
+	tungo.GET("/:service/flows", getTungoFlowList)
+	tungo.DELETE("/:service/flows", deleteTungoFlowList)
+	tungo.DELETE("/:service/flows/:flow", deleteTungoFlow)
+
+	capture := router.Group("/capture")
+	capture.Use(mwBasicAuth(opts.Auther))
+
+	capture.GET("/:service", getCapture)
+	capture.POST("/:service", startCapture)
+	capture.DELETE("/:service", stopCapture)
+
+	hops := router.Group("/hops")
+	hops.Use(mwBasicAuth(opts.Auther))
+
+	hops.GET("/:hop/health", getHopHealth)
+	hops.GET("/:hop/breakers", getHopBreakers)
 }
@@ -0,0 +1,198 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-gost/x/internal/util/pcap"
+)
+
+type captureOptions struct {
+	// pcapng file the packets are written to, the rotated files are path.1, path.2 and so on.
+	Path string `json:"path,omitempty"`
+	// size in bytes a file is rotated at.
+	MaxSize int64 `json:"maxSize,omitempty"`
+	// number of files kept in the ring.
+	MaxFiles int `json:"maxFiles,omitempty"`
+	// maximum number of bytes captured of a packet.
+	SnapLen int `json:"snapLen,omitempty"`
+	// filter expression, e.g. "tcp and port 443 and net 10.0.0.0/8".
+	Filter string `json:"filter,omitempty"`
+}
+
+type captureStatus struct {
+	Running bool           `json:"running"`
+	Options captureOptions `json:"options"`
+	Packets uint64         `json:"packets"`
+	Bytes   uint64         `json:"bytes"`
+	Dropped uint64         `json:"dropped"`
+	Files   int            `json:"files"`
+	Error   string         `json:"error,omitempty"`
+}
+
+func newCaptureStatus(status pcap.Status) captureStatus {
+	return captureStatus{
+		Running: status.Running,
+		Options: captureOptions{
+			Path:     status.Options.Path,
+			MaxSize:  status.Options.MaxSize,
+			MaxFiles: status.Options.MaxFiles,
+			SnapLen:  status.Options.SnapLen,
+			Filter:   status.Options.Filter,
+		},
+		Packets: status.Stats.Packets,
+		Bytes:   status.Stats.Bytes,
+		Dropped: status.Stats.Dropped,
+		Files:   status.Stats.Files,
+		Error:   status.Error,
+	}
+}
+
+// swagger:parameters getCaptureRequest
+type getCaptureRequest struct {
+	// in: path
+	// required: true
+	Service string `uri:"service" json:"service"`
+}
+
+// successful operation.
+// swagger:response getCaptureResponse
+type getCaptureResponse struct {
+	// in: body
+	Data captureStatus
+}
+
+func getCapture(ctx *gin.Context) {
+	// swagger:route GET /capture/{service} Capture getCaptureRequest
+	//
+	// Get the packet capture state of a tun or tungo service.
+	//
+	//     Security:
+	//       basicAuth: []
+	//
+	//     Responses:
+	//       200: getCaptureResponse
+
+	var req getCaptureRequest
+	ctx.ShouldBindUri(&req)
+
+	status, err := pcap.GetStatus(req.Service)
+	if err != nil {
+		writeError(ctx, captureError(req.Service, err))
+		return
+	}
+
+	var resp getCaptureResponse
+	resp.Data = newCaptureStatus(status)
+
+	ctx.JSON(http.StatusOK, Response{
+		Data: resp.Data,
+	})
+}
+
+// swagger:parameters startCaptureRequest
+type startCaptureRequest struct {
+	// in: path
+	// required: true
+	Service string `uri:"service" json:"service"`
+	// in: body
+	Data captureOptions `json:"data"`
+}
+
+// successful operation.
+// swagger:response startCaptureResponse
+type startCaptureResponse struct {
+	// in: body
+	Data captureStatus
+}
+
+func startCapture(ctx *gin.Context) {
+	// swagger:route POST /capture/{service} Capture startCaptureRequest
+	//
+	// Start capturing the packets of a tun or tungo service to pcapng files.
+	//
+	//     Security:
+	//       basicAuth: []
+	//
+	//     Responses:
+	//       200: startCaptureResponse
+
+	var req startCaptureRequest
+	ctx.ShouldBindUri(&req)
+	ctx.ShouldBindJSON(&req.Data)
+
+	status, err := pcap.Start(req.Service, pcap.Options{
+		Path:     req.Data.Path,
+		MaxSize:  req.Data.MaxSize,
+		MaxFiles: req.Data.MaxFiles,
+		SnapLen:  req.Data.SnapLen,
+		Filter:   req.Data.Filter,
+	})
+	if err != nil {
+		writeError(ctx, captureError(req.Service, err))
+		return
+	}
+
+	var resp startCaptureResponse
+	resp.Data = newCaptureStatus(status)
+
+	ctx.JSON(http.StatusOK, Response{
+		Data: resp.Data,
+	})
+}
+
+// swagger:parameters stopCaptureRequest
+type stopCaptureRequest struct {
+	// in: path
+	// required: true
+	Service string `uri:"service" json:"service"`
+}
+
+// successful operation.
+// swagger:response stopCaptureResponse
+type stopCaptureResponse struct {
+	// in: body
+	Data captureStatus
+}
+
+func stopCapture(ctx *gin.Context) {
+	// swagger:route DELETE /capture/{service} Capture stopCaptureRequest
+	//
+	// Stop the packet capture of a tun or tungo service.
+	//
+	//     Security:
+	//       basicAuth: []
+	//
+	//     Responses:
+	//       200: stopCaptureResponse
+
+	var req stopCaptureRequest
+	ctx.ShouldBindUri(&req)
+
+	status, err := pcap.Stop(req.Service)
+	if err != nil {
+		writeError(ctx, captureError(req.Service, err))
+		return
+	}
+
+	var resp stopCaptureResponse
+	resp.Data = newCaptureStatus(status)
+
+	ctx.JSON(http.StatusOK, Response{
+		Data: resp.Data,
+	})
+}
+
+func captureError(service string, err error) error {
+	switch {
+	case errors.Is(err, pcap.ErrTapNotFound):
+		return NewError(http.StatusBadRequest, ErrCodeNotFound, fmt.Sprintf("service %s has no capture point", service))
+	case errors.Is(err, pcap.ErrCaptureRunning):
+		return NewError(http.StatusBadRequest, ErrCodeDup, fmt.Sprintf("capture of service %s is already running", service))
+	case errors.Is(err, pcap.ErrCaptureNotRunning):
+		return NewError(http.StatusBadRequest, ErrCodeNotFound, fmt.Sprintf("capture of service %s is not running", service))
+	}
+	return NewError(http.StatusBadRequest, ErrCodeInvalid, err.Error())
+}
@@ -24,6 +24,9 @@ func reloadConfig(ctx *gin.Context) {
 	//
 	// Hot reload config.
 	//
+	// Only the changed objects are recreated. A service with only its handler changed
+	// keeps listening, the connections in flight are drained on the old handler.
+	//
 	//     Security:
 	//       basicAuth: []
 	//
@@ -43,6 +46,7 @@ func reloadConfig(ctx *gin.Context) {
 		return
 	}
 
+	// the services already serving are not affected.
 	for _, svc := range registry.ServiceRegistry().GetAll() {
 		svc := svc
 		go func() {
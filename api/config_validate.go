@@ -0,0 +1,61 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-gost/x/config"
+	"github.com/go-gost/x/config/validator"
+)
+
+// swagger:parameters validateConfigRequest
+type validateConfigRequest struct {
+	// in: body
+	Data config.Config `json:"data"`
+}
+
+// successful operation.
+// swagger:response validateConfigResponse
+type validateConfigResponse struct {
+	// in: body
+	Data configValidation
+}
+
+type configValidation struct {
+	Valid  bool             `json:"valid"`
+	Errors validator.Errors `json:"errors,omitempty"`
+}
+
+func validateConfig(ctx *gin.Context) {
+	// swagger:route POST /config/validate Config validateConfigRequest
+	//
+	// Validate a config without loading it.
+	//
+	// The objects are parsed without being registered and no service is listening.
+	// The errors are the unresolved references, duplicate names, port collisions and
+	// parse errors, located by the JSON paths in the config.
+	//
+	//     Security:
+	//       basicAuth: []
+	//
+	//     Responses:
+	//       200: validateConfigResponse
+
+	var req validateConfigRequest
+	if err := ctx.ShouldBindJSON(&req.Data); err != nil {
+		writeError(ctx, NewError(http.StatusBadRequest, ErrCodeInvalid, err.Error()))
+		return
+	}
+
+	errs := validator.Validate(&req.Data)
+
+	var resp validateConfigResponse
+	resp.Data = configValidation{
+		Valid:  len(errs) == 0,
+		Errors: errs,
+	}
+
+	ctx.JSON(http.StatusOK, Response{
+		Data: resp.Data,
+	})
+}
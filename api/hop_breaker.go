@@ -0,0 +1,96 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-gost/core/hop"
+	"github.com/go-gost/x/registry"
+	xselector "github.com/go-gost/x/selector"
+)
+
+// swagger:parameters getHopBreakersRequest
+type getHopBreakersRequest struct {
+	// in: path
+	// required: true
+	Hop string `uri:"hop" json:"hop"`
+}
+
+// successful operation.
+// swagger:response getHopBreakersResponse
+type getHopBreakersResponse struct {
+	// in: body
+	Data hopBreakerList
+}
+
+type hopBreakerList struct {
+	Count int           `json:"count"`
+	List  []nodeBreaker `json:"list"`
+}
+
+// nodeBreaker is the circuit breaker state of a node.
+type nodeBreaker struct {
+	Node      string    `json:"node"`
+	Addr      string    `json:"addr"`
+	State     string    `json:"state"`
+	Failures  int       `json:"failures"`
+	Requests  int       `json:"requests"`
+	Errors    int       `json:"errors"`
+	ErrorRate float64   `json:"errorRate"`
+	Trials    int       `json:"trials,omitempty"`
+	OpenedAt  time.Time `json:"openedAt,omitempty"`
+}
+
+func getHopBreakers(ctx *gin.Context) {
+	// swagger:route GET /hops/{hop}/breakers Hop getHopBreakersRequest
+	//
+	// Get the circuit breaker states of the nodes of a hop.
+	//
+	//     Security:
+	//       basicAuth: []
+	//
+	//     Responses:
+	//       200: getHopBreakersResponse
+
+	var req getHopBreakersRequest
+	ctx.ShouldBindUri(&req)
+
+	if !registry.HopRegistry().IsRegistered(req.Hop) {
+		writeError(ctx, NewError(http.StatusBadRequest, ErrCodeNotFound, fmt.Sprintf("hop %s not found", req.Hop)))
+		return
+	}
+
+	var list []nodeBreaker
+	if nl, ok := registry.HopRegistry().Get(req.Hop).(hop.NodeList); ok {
+		for _, node := range nl.Nodes() {
+			b := xselector.LookupBreaker(node)
+			if b == nil {
+				continue
+			}
+			st := b.State()
+			list = append(list, nodeBreaker{
+				Node:      node.Name,
+				Addr:      node.Addr,
+				State:     st.State,
+				Failures:  st.Failures,
+				Requests:  st.Requests,
+				Errors:    st.Errors,
+				ErrorRate: st.ErrorRate,
+				Trials:    st.Trials,
+				OpenedAt:  st.OpenedAt,
+			})
+		}
+	}
+
+	var resp getHopBreakersResponse
+	resp.Data = hopBreakerList{
+		Count: len(list),
+		List:  list,
+	}
+
+	ctx.JSON(http.StatusOK, Response{
+		Data: resp.Data,
+	})
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	xhop "github.com/go-gost/x/hop"
+)
+
+// swagger:parameters getHopHealthRequest
+type getHopHealthRequest struct {
+	// in: path
+	// required: true
+	Hop string `uri:"hop" json:"hop"`
+}
+
+// successful operation.
+// swagger:response getHopHealthResponse
+type getHopHealthResponse struct {
+	// in: body
+	Data hopHealthList
+}
+
+type hopHealthList struct {
+	Count int               `json:"count"`
+	List  []xhop.NodeHealth `json:"list"`
+}
+
+func getHopHealth(ctx *gin.Context) {
+	// swagger:route GET /hops/{hop}/health Hop getHopHealthRequest
+	//
+	// Get the health check states of the nodes of a hop.
+	//
+	//     Security:
+	//       basicAuth: []
+	//
+	//     Responses:
+	//       200: getHopHealthResponse
+
+	var req getHopHealthRequest
+	ctx.ShouldBindUri(&req)
+
+	list, ok := xhop.Health(req.Hop)
+	if !ok {
+		writeError(ctx, NewError(http.StatusBadRequest, ErrCodeNotFound, fmt.Sprintf("hop %s has no health check", req.Hop)))
+		return
+	}
+
+	var resp getHopHealthResponse
+	resp.Data = hopHealthList{
+		Count: len(list),
+		List:  list,
+	}
+
+	ctx.JSON(http.StatusOK, Response{
+		Data: resp.Data,
+	})
+}
@@ -0,0 +1,237 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-gost/x/handler/tungo"
+)
+
+// swagger:parameters getTungoFlowListRequest
+type getTungoFlowListRequest struct {
+	// in: path
+	// required: true
+	Service string `uri:"service" json:"service"`
+	// network, tcp or udp.
+	// in: query
+	Network string `form:"network" json:"network"`
+	// destination IP address or CIDR.
+	// in: query
+	CIDR string `form:"cidr" json:"cidr"`
+	// hostname, also matches its subdomains.
+	// in: query
+	Host string `form:"host" json:"host"`
+	// application ID.
+	// in: query
+	AppID string `form:"appID" json:"appID"`
+}
+
+// successful operation.
+// swagger:response getTungoFlowListResponse
+type getTungoFlowListResponse struct {
+	// in: body
+	Data tungoFlowList
+}
+
+type tungoFlowList struct {
+	Count int               `json:"count"`
+	List  []tungo.FlowState `json:"list"`
+}
+
+func getTungoFlowList(ctx *gin.Context) {
+	// swagger:route GET /tungo/{service}/flows Tungo getTungoFlowListRequest
+	//
+	// Get the live flows of a tungo service.
+	//
+	//     Security:
+	//       basicAuth: []
+	//
+	//     Responses:
+	//       200: getTungoFlowListResponse
+
+	var req getTungoFlowListRequest
+	ctx.ShouldBindUri(&req)
+	ctx.ShouldBindQuery(&req)
+
+	filter, err := parseTungoFlowFilter(req.Network, req.CIDR, req.Host, req.AppID)
+	if err != nil {
+		writeError(ctx, NewError(http.StatusBadRequest, ErrCodeInvalid, err.Error()))
+		return
+	}
+
+	list, err := tungo.Flows(req.Service, filter)
+	if err != nil {
+		writeError(ctx, tungoFlowError(req.Service, err))
+		return
+	}
+
+	var resp getTungoFlowListResponse
+	resp.Data = tungoFlowList{
+		Count: len(list),
+		List:  list,
+	}
+
+	ctx.JSON(http.StatusOK, Response{
+		Data: resp.Data,
+	})
+}
+
+// swagger:parameters deleteTungoFlowRequest
+type deleteTungoFlowRequest struct {
+	// in: path
+	// required: true
+	Service string `uri:"service" json:"service"`
+	// in: path
+	// required: true
+	Flow string `uri:"flow" json:"flow"`
+}
+
+// successful operation.
+// swagger:response deleteTungoFlowResponse
+type deleteTungoFlowResponse struct {
+	Data Response
+}
+
+func deleteTungoFlow(ctx *gin.Context) {
+	// swagger:route DELETE /tungo/{service}/flows/{flow} Tungo deleteTungoFlowRequest
+	//
+	// Close a live flow of a tungo service by ID.
+	//
+	//     Security:
+	//       basicAuth: []
+	//
+	//     Responses:
+	//       200: deleteTungoFlowResponse
+
+	var req deleteTungoFlowRequest
+	ctx.ShouldBindUri(&req)
+
+	id := strings.TrimSpace(req.Flow)
+	if id == "" {
+		writeError(ctx, NewError(http.StatusBadRequest, ErrCodeInvalid, "flow ID is required"))
+		return
+	}
+
+	n, err := tungo.CloseFlows(req.Service, &tungo.FlowFilter{ID: id})
+	if err != nil {
+		writeError(ctx, tungoFlowError(req.Service, err))
+		return
+	}
+	if n == 0 {
+		writeError(ctx, NewError(http.StatusBadRequest, ErrCodeNotFound, fmt.Sprintf("flow %s not found", id)))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, Response{
+		Msg: "OK",
+	})
+}
+
+// swagger:parameters deleteTungoFlowListRequest
+type deleteTungoFlowListRequest struct {
+	// in: path
+	// required: true
+	Service string `uri:"service" json:"service"`
+	// network, tcp or udp.
+	// in: query
+	Network string `form:"network" json:"network"`
+	// destination IP address or CIDR.
+	// in: query
+	CIDR string `form:"cidr" json:"cidr"`
+	// hostname, also matches its subdomains.
+	// in: query
+	Host string `form:"host" json:"host"`
+	// application ID.
+	// in: query
+	AppID string `form:"appID" json:"appID"`
+}
+
+// successful operation.
+// swagger:response deleteTungoFlowListResponse
+type deleteTungoFlowListResponse struct {
+	// in: body
+	Data tungoFlowCount
+}
+
+type tungoFlowCount struct {
+	Count int `json:"count"`
+}
+
+func deleteTungoFlowList(ctx *gin.Context) {
+	// swagger:route DELETE /tungo/{service}/flows Tungo deleteTungoFlowListRequest
+	//
+	// Close the live flows of a tungo service matching the filter, at least one filter is required.
+	//
+	//     Security:
+	//       basicAuth: []
+	//
+	//     Responses:
+	//       200: deleteTungoFlowListResponse
+
+	var req deleteTungoFlowListRequest
+	ctx.ShouldBindUri(&req)
+	ctx.ShouldBindQuery(&req)
+
+	filter, err := parseTungoFlowFilter(req.Network, req.CIDR, req.Host, req.AppID)
+	if err != nil {
+		writeError(ctx, NewError(http.StatusBadRequest, ErrCodeInvalid, err.Error()))
+		return
+	}
+	if filter.IsZero() {
+		writeError(ctx, NewError(http.StatusBadRequest, ErrCodeInvalid, "flow filter is required"))
+		return
+	}
+
+	n, err := tungo.CloseFlows(req.Service, filter)
+	if err != nil {
+		writeError(ctx, tungoFlowError(req.Service, err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, Response{
+		Data: tungoFlowCount{Count: n},
+	})
+}
+
+func parseTungoFlowFilter(network, cidr, host, appID string) (*tungo.FlowFilter, error) {
+	filter := &tungo.FlowFilter{
+		Network:  strings.ToLower(strings.TrimSpace(network)),
+		Hostname: strings.TrimSpace(host),
+		AppID:    strings.TrimSpace(appID),
+	}
+
+	switch filter.Network {
+	case "", "tcp", "udp":
+	default:
+		return nil, fmt.Errorf("invalid network %s", network)
+	}
+
+	if cidr = strings.TrimSpace(cidr); cidr != "" {
+		if !strings.Contains(cidr, "/") {
+			ip, err := netip.ParseAddr(cidr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid cidr %s", cidr)
+			}
+			filter.DstNet = netip.PrefixFrom(ip.Unmap(), ip.Unmap().BitLen())
+		} else {
+			prefix, err := netip.ParsePrefix(cidr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid cidr %s", cidr)
+			}
+			filter.DstNet = prefix.Masked()
+		}
+	}
+
+	return filter, nil
+}
+
+func tungoFlowError(service string, err error) error {
+	if errors.Is(err, tungo.ErrHandlerNotFound) {
+		return NewError(http.StatusBadRequest, ErrCodeNotFound, fmt.Sprintf("tungo service %s not found", service))
+	}
+	return NewError(http.StatusInternalServerError, ErrCodeFailed, err.Error())
+}
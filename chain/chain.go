@@ -2,12 +2,15 @@ package chain
 
 import (
 	"context"
+	"io"
+	"net"
 
 	"github.com/go-gost/core/chain"
 	"github.com/go-gost/core/hop"
 	"github.com/go-gost/core/logger"
 	"github.com/go-gost/core/metadata"
 	"github.com/go-gost/core/selector"
+	xctx "github.com/go-gost/x/ctx"
 )
 
 var (
@@ -65,6 +68,16 @@ func (c *Chain) AddHop(hop hop.Hop) {
 	c.hops = append(c.hops, hop)
 }
 
+// Close closes the inline hops of the chain, the hops referred to by name are kept.
+func (c *Chain) Close() error {
+	for _, hop := range c.hops {
+		if closer, ok := hop.(io.Closer); ok {
+			closer.Close()
+		}
+	}
+	return nil
+}
+
 // Metadata implements metadata.Metadatable interface.
 func (c *Chain) Metadata() metadata.Metadata {
 	return c.metadata
@@ -127,7 +140,11 @@ func (p *chainGroup) WithSelector(s selector.Selector[chain.Chainer]) *chainGrou
 }
 
 func (p *chainGroup) Route(ctx context.Context, network, address string, opts ...chain.RouteOption) chain.Route {
-	if chain := p.next(ctx); chain != nil {
+	host := address
+	if h, _, _ := net.SplitHostPort(address); h != "" {
+		host = h
+	}
+	if chain := p.next(xctx.ContextWithTarget(ctx, host)); chain != nil {
 		return chain.Route(ctx, network, address, opts...)
 	}
 	return nil
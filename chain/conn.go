@@ -0,0 +1,70 @@
+package chain
+
+import (
+	"net"
+	"sync"
+
+	xio "github.com/go-gost/x/internal/io"
+	ws_util "github.com/go-gost/x/internal/util/ws"
+)
+
+// trackConn calls release once the connection is closed. The half-close of the connection
+// and its packet and websocket interfaces are kept.
+func trackConn(c net.Conn, release func()) net.Conn {
+	tc := trackedConn{Conn: c, release: release}
+	switch cc := c.(type) {
+	case ws_util.WebsocketConn:
+		return &trackedWebsocketConn{WebsocketConn: cc, trackedConn: &tc}
+	case net.PacketConn:
+		return &trackedPacketConn{trackedConn: &tc, pc: cc}
+	default:
+		return &tc
+	}
+}
+
+type trackedConn struct {
+	net.Conn
+	release func()
+	once    sync.Once
+}
+
+func (c *trackedConn) Close() error {
+	c.once.Do(c.release)
+	return c.Conn.Close()
+}
+
+func (c *trackedConn) CloseRead() error {
+	if cr, ok := c.Conn.(xio.CloseRead); ok {
+		return cr.CloseRead()
+	}
+	return xio.ErrUnsupported
+}
+
+func (c *trackedConn) CloseWrite() error {
+	if cw, ok := c.Conn.(xio.CloseWrite); ok {
+		return cw.CloseWrite()
+	}
+	return xio.ErrUnsupported
+}
+
+type trackedPacketConn struct {
+	*trackedConn
+	pc net.PacketConn
+}
+
+func (c *trackedPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	return c.pc.ReadFrom(b)
+}
+
+func (c *trackedPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	return c.pc.WriteTo(b, addr)
+}
+
+type trackedWebsocketConn struct {
+	ws_util.WebsocketConn
+	trackedConn *trackedConn
+}
+
+func (c *trackedWebsocketConn) Close() error {
+	return c.trackedConn.Close()
+}
@@ -0,0 +1,422 @@
+package chain
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/go-gost/core/hosts"
+	"github.com/go-gost/core/logger"
+	"github.com/go-gost/core/resolver"
+	xio "github.com/go-gost/x/internal/io"
+	xnet "github.com/go-gost/x/internal/net"
+	ws_util "github.com/go-gost/x/internal/util/ws"
+)
+
+const (
+	defaultPoolMaxAge      = 60 * time.Second
+	defaultPoolIdleTimeout = 5 * time.Minute
+	defaultPoolDialTimeout = 10 * time.Second
+	maxPoolBackoff         = 30 * time.Second
+)
+
+type PoolOptions struct {
+	// Size is the number of the idle connections kept ready.
+	Size int
+	// MaxAge is the maximum age of an idle connection.
+	MaxAge time.Duration
+	// IdleTimeout stops the pool if it is not used for the duration, it is started again on demand.
+	IdleTimeout time.Duration
+	// DialTimeout is the timeout of the dial and handshake of a connection.
+	DialTimeout time.Duration
+	// Key identifies the pool across the reloads, the transports of the same key share
+	// the pool of the first one, it is closed with the last of them.
+	// The key is derived from the node config, so a changed node gets a new pool.
+	Key        string
+	Resolver   resolver.Resolver
+	HostMapper hosts.HostMapper
+	Logger     logger.Logger
+}
+
+// connPool keeps the connections of a node transport dialed and handshaken before Connect,
+// so the requests through a non-multiplexed transport skip the dial and handshake latency.
+// The idle connections are refilled in the background, closed when they reach the max age,
+// and discarded once the peer closes them or sends unexpected data.
+type connPool struct {
+	tr      *Transport
+	options PoolOptions
+	// ctx is canceled by close, it stops the dials and the backoff of the pool.
+	ctx    context.Context
+	cancel context.CancelFunc
+	// refs is the number of the transports sharing the pool, guarded by poolsMu.
+	refs int
+
+	mu       sync.Mutex
+	idle     []*pooledConn
+	running  bool
+	closed   bool
+	lastUsed time.Time
+	notify   chan struct{}
+}
+
+func newConnPool(tr *Transport, opts PoolOptions) *connPool {
+	if opts.MaxAge <= 0 {
+		opts.MaxAge = defaultPoolMaxAge
+	}
+	if opts.IdleTimeout <= 0 {
+		opts.IdleTimeout = defaultPoolIdleTimeout
+	}
+	if opts.DialTimeout <= 0 {
+		opts.DialTimeout = defaultPoolDialTimeout
+	}
+	if opts.Logger == nil {
+		opts.Logger = logger.Default()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &connPool{
+		tr:      tr,
+		options: opts,
+		ctx:     ctx,
+		cancel:  cancel,
+		notify:  make(chan struct{}, 1),
+	}
+}
+
+var (
+	poolsMu sync.Mutex
+	// pools are the shared pools by key.
+	pools = make(map[string]*connPool)
+)
+
+// acquirePool returns the pool of the key, it is created for the transport tr
+// if there is none. The pool is released by release.
+func acquirePool(tr *Transport, opts PoolOptions) *connPool {
+	if opts.Key == "" {
+		return newConnPool(tr, opts)
+	}
+
+	poolsMu.Lock()
+	defer poolsMu.Unlock()
+
+	p := pools[opts.Key]
+	if p == nil {
+		p = newConnPool(tr, opts)
+		pools[opts.Key] = p
+	}
+	p.refs++
+	return p
+}
+
+// release closes the pool if it is not shared by another transport.
+func (p *connPool) release() {
+	if p == nil {
+		return
+	}
+
+	if key := p.options.Key; key != "" {
+		poolsMu.Lock()
+		p.refs--
+		last := p.refs <= 0
+		if last && pools[key] == p {
+			delete(pools, key)
+		}
+		poolsMu.Unlock()
+
+		if !last {
+			return
+		}
+	}
+	p.close()
+}
+
+// get takes an idle connection, nil if none is ready.
+func (p *connPool) get() net.Conn {
+	if p == nil {
+		return nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return nil
+	}
+	p.lastUsed = time.Now()
+	defer p.startLocked()
+	defer p.signal()
+
+	for len(p.idle) > 0 {
+		c := p.idle[0]
+		p.idle = p.idle[1:]
+		if c.expired(p.options.MaxAge) || !c.alive() {
+			c.Close()
+			continue
+		}
+		return c.handout()
+	}
+	return nil
+}
+
+func (p *connPool) start() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.lastUsed = time.Now()
+	p.startLocked()
+}
+
+func (p *connPool) startLocked() {
+	if p.running || p.closed {
+		return
+	}
+	p.running = true
+	go p.run()
+}
+
+// close stops the pool and closes the idle connections.
+func (p *connPool) close() {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.closed = true
+	p.cancel()
+	for _, c := range p.idle {
+		c.Close()
+	}
+	p.idle = nil
+	p.signal()
+}
+
+func (p *connPool) signal() {
+	select {
+	case p.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (p *connPool) run() {
+	interval := p.options.MaxAge / 2
+	if interval > time.Second {
+		interval = time.Second
+	}
+	if interval <= 0 {
+		interval = time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var backoff time.Duration
+	for {
+		n, ok := p.sweep()
+		if !ok {
+			return
+		}
+
+		if n < p.options.Size {
+			conn, err := p.dial()
+			if err == nil {
+				backoff = 0
+				p.put(conn)
+				continue
+			}
+
+			if backoff == 0 {
+				backoff = time.Second
+			} else if backoff *= 2; backoff > maxPoolBackoff {
+				backoff = maxPoolBackoff
+			}
+			p.options.Logger.Warnf("pool %s: %v, retry in %v", p.tr.options.Addr, err, backoff)
+
+			// the pool is stopped by the next sweep once it is closed.
+			timer := time.NewTimer(backoff)
+			select {
+			case <-timer.C:
+			case <-p.ctx.Done():
+				timer.Stop()
+			}
+			continue
+		}
+
+		select {
+		case <-ticker.C:
+		case <-p.notify:
+		case <-p.ctx.Done():
+		}
+	}
+}
+
+// sweep closes the expired and broken connections and returns the number of the idle connections.
+// It stops the pool and returns false if the pool is closed or not used within the idle timeout.
+func (p *connPool) sweep() (int, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed || time.Since(p.lastUsed) > p.options.IdleTimeout {
+		for _, c := range p.idle {
+			c.Close()
+		}
+		p.idle = nil
+		p.running = false
+		return 0, false
+	}
+
+	idle := p.idle[:0]
+	for _, c := range p.idle {
+		if c.expired(p.options.MaxAge) || !c.alive() {
+			c.Close()
+			continue
+		}
+		idle = append(idle, c)
+	}
+	for i := len(idle); i < len(p.idle); i++ {
+		p.idle[i] = nil
+	}
+	p.idle = idle
+
+	return len(p.idle), true
+}
+
+func (p *connPool) dial() (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(p.ctx, p.options.DialTimeout)
+	defer cancel()
+
+	addr, err := xnet.Resolve(ctx, "ip", p.tr.options.Addr, p.options.Resolver, p.options.HostMapper, p.options.Logger)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := p.tr.Dial(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	cc, err := p.tr.Handshake(ctx, conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return cc, nil
+}
+
+func (p *connPool) put(conn net.Conn) {
+	c := &pooledConn{
+		Conn:    conn,
+		created: time.Now(),
+	}
+
+	c.watch(p.discard)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		c.Close()
+		return
+	}
+	p.idle = append(p.idle, c)
+}
+
+// discard removes the idle connection that fails the liveness check.
+func (p *connPool) discard(c *pooledConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for i := range p.idle {
+		if p.idle[i] == c {
+			p.idle = append(p.idle[:i], p.idle[i+1:]...)
+			c.Close()
+			p.signal()
+			return
+		}
+	}
+}
+
+type readResult struct {
+	b   byte
+	n   int
+	err error
+}
+
+type pooledConn struct {
+	net.Conn
+	created time.Time
+	// result is the result of the background read, nil if the connection is not watched.
+	result chan readResult
+}
+
+// watch reads from the idle connection in the background. Nothing is expected
+// before Connect, so the read completes only if the connection is broken.
+// The websocket and packet connections are not watched, their reads are message oriented.
+func (c *pooledConn) watch(discard func(*pooledConn)) {
+	switch c.Conn.(type) {
+	case ws_util.WebsocketConn, net.PacketConn:
+		return
+	}
+
+	c.result = make(chan readResult, 1)
+	go func() {
+		var b [1]byte
+		n, err := c.Conn.Read(b[:])
+		c.result <- readResult{b: b[0], n: n, err: err}
+		discard(c)
+	}()
+}
+
+func (c *pooledConn) expired(maxAge time.Duration) bool {
+	return time.Since(c.created) > maxAge
+}
+
+func (c *pooledConn) alive() bool {
+	if c.result == nil {
+		return true
+	}
+	return len(c.result) == 0
+}
+
+// handout returns the connection for use. The result of the pending background read
+// is returned by the first read of the connection.
+func (c *pooledConn) handout() net.Conn {
+	if c.result == nil {
+		return c.Conn
+	}
+	return &watchedConn{Conn: c.Conn, result: c.result}
+}
+
+type watchedConn struct {
+	net.Conn
+	result chan readResult
+}
+
+func (c *watchedConn) Read(b []byte) (int, error) {
+	if c.result == nil {
+		return c.Conn.Read(b)
+	}
+	if len(b) == 0 {
+		return 0, nil
+	}
+
+	r := <-c.result
+	c.result = nil
+	if r.n > 0 {
+		b[0] = r.b
+	}
+	return r.n, r.err
+}
+
+func (c *watchedConn) CloseRead() error {
+	if cr, ok := c.Conn.(xio.CloseRead); ok {
+		return cr.CloseRead()
+	}
+	return xio.ErrUnsupported
+}
+
+func (c *watchedConn) CloseWrite() error {
+	if cw, ok := c.Conn.(xio.CloseWrite); ok {
+		return cw.CloseWrite()
+	}
+	return xio.ErrUnsupported
+}
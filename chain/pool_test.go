@@ -0,0 +1,234 @@
+package chain
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-gost/core/chain"
+	"github.com/go-gost/core/connector"
+	"github.com/go-gost/core/dialer"
+	"github.com/go-gost/core/metadata"
+	xlogger "github.com/go-gost/x/logger"
+)
+
+type testDialer struct {
+	dials atomic.Int64
+}
+
+func (d *testDialer) Init(metadata.Metadata) error { return nil }
+
+func (d *testDialer) Dial(ctx context.Context, addr string, opts ...dialer.DialOption) (net.Conn, error) {
+	d.dials.Add(1)
+	return (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+}
+
+type testConnector struct{}
+
+func (c *testConnector) Init(metadata.Metadata) error { return nil }
+
+func (c *testConnector) Connect(ctx context.Context, conn net.Conn, network, address string, opts ...connector.ConnectOption) (net.Conn, error) {
+	return conn, nil
+}
+
+// echoServer echoes the connections, close closes the accepted connections.
+func echoServer(t *testing.T) (addr string, close func()) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	var mu sync.Mutex
+	var conns []net.Conn
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			conns = append(conns, c)
+			mu.Unlock()
+			go io.Copy(c, c)
+		}
+	}()
+
+	return ln.Addr().String(), func() {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, c := range conns {
+			c.Close()
+		}
+		conns = nil
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func (p *connPool) idleLen() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.idle)
+}
+
+func TestConnPool(t *testing.T) {
+	addr, closeConns := echoServer(t)
+
+	d := &testDialer{}
+	tr := NewTransport(d, &testConnector{}, chain.AddrTransportOption(addr)).WithPool(&PoolOptions{
+		Size:        2,
+		MaxAge:      time.Minute,
+		IdleTimeout: time.Minute,
+		Logger:      xlogger.Nop(),
+	})
+	waitFor(t, func() bool { return tr.pool.idleLen() == 2 })
+
+	// the request takes a pooled connection and the pool is refilled.
+	node := chain.NewNode("a", addr, chain.TransportNodeOption(tr))
+	route := NewRoute()
+	route.addNode(node)
+	conn, err := route.Dial(context.Background(), "tcp", "example.com:80", chain.LoggerDialOption(xlogger.Nop()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, ok := conn.(*watchedConn); !ok {
+		t.Fatalf("expected a pooled connection, got %T", conn)
+	}
+	waitFor(t, func() bool { return d.dials.Load() == 3 && tr.pool.idleLen() == 2 })
+
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, 4)
+	if _, err := io.ReadFull(conn, b); err != nil || string(b) != "ping" {
+		t.Fatalf("unexpected read %q: %v", b, err)
+	}
+
+	// the connections closed by the peer are discarded and replaced.
+	closeConns()
+	waitFor(t, func() bool { return d.dials.Load() >= 5 && tr.pool.idleLen() == 2 })
+	if c := tr.pool.get(); c == nil {
+		t.Fatal("no pooled connection")
+	} else {
+		c.Close()
+	}
+}
+
+func TestConnPool_MaxAge(t *testing.T) {
+	addr, _ := echoServer(t)
+
+	d := &testDialer{}
+	tr := NewTransport(d, &testConnector{}, chain.AddrTransportOption(addr)).WithPool(&PoolOptions{
+		Size:        1,
+		MaxAge:      50 * time.Millisecond,
+		IdleTimeout: 200 * time.Millisecond,
+		Logger:      xlogger.Nop(),
+	})
+	waitFor(t, func() bool { return d.dials.Load() >= 3 })
+
+	// the unused pool is stopped and started again on demand.
+	waitFor(t, func() bool {
+		tr.pool.mu.Lock()
+		defer tr.pool.mu.Unlock()
+		return !tr.pool.running
+	})
+	if tr.pool.idleLen() != 0 {
+		t.Fatal("idle connections kept by the stopped pool")
+	}
+	if c := tr.pool.get(); c != nil {
+		t.Fatal("unexpected pooled connection")
+	}
+	waitFor(t, func() bool { return tr.pool.idleLen() == 1 })
+}
+
+func TestConnPool_CloseBackoff(t *testing.T) {
+	// nothing listens on the address, the pool backs off.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	d := &testDialer{}
+	tr := NewTransport(d, &testConnector{}, chain.AddrTransportOption(addr)).WithPool(&PoolOptions{
+		Size:   1,
+		Logger: xlogger.Nop(),
+	})
+	waitFor(t, func() bool { return d.dials.Load() >= 1 })
+
+	// the pool stops within the backoff of a second once it is closed.
+	start := time.Now()
+	tr.Close()
+	waitFor(t, func() bool {
+		tr.pool.mu.Lock()
+		defer tr.pool.mu.Unlock()
+		return !tr.pool.running
+	})
+	if d := time.Since(start); d >= 500*time.Millisecond {
+		t.Fatalf("the pool stopped after %v", d)
+	}
+	if n := d.dials.Load(); n != 1 {
+		t.Fatalf("expected 1 dial, got %d", n)
+	}
+}
+
+func TestConnPool_Key(t *testing.T) {
+	addr, _ := echoServer(t)
+
+	d := &testDialer{}
+	opts := &PoolOptions{
+		Size:        1,
+		MaxAge:      time.Minute,
+		IdleTimeout: time.Minute,
+		Key:         "hop/a/1",
+		Logger:      xlogger.Nop(),
+	}
+	tr := NewTransport(d, &testConnector{}, chain.AddrTransportOption(addr)).WithPool(opts)
+	waitFor(t, func() bool { return tr.pool.idleLen() == 1 })
+
+	// the reloaded transport of an unchanged node takes over the pool.
+	reloaded := NewTransport(d, &testConnector{}, chain.AddrTransportOption(addr)).WithPool(opts)
+	if reloaded.pool != tr.pool {
+		t.Fatal("the pool is not shared")
+	}
+	tr.Close()
+	if c := reloaded.pool.get(); c == nil {
+		t.Fatal("the pool is closed with the replaced transport")
+	} else {
+		c.Close()
+	}
+
+	// a changed node gets a new pool.
+	changed := *opts
+	changed.Key = "hop/a/2"
+	other := NewTransport(d, &testConnector{}, chain.AddrTransportOption(addr)).WithPool(&changed)
+	defer other.Close()
+	if other.pool == reloaded.pool {
+		t.Fatal("the pool of a changed node is shared")
+	}
+
+	reloaded.Close()
+	if c := reloaded.pool.get(); c != nil {
+		t.Fatal("the pool is not closed with the last transport")
+	}
+	poolsMu.Lock()
+	defer poolsMu.Unlock()
+	if _, ok := pools[opts.Key]; ok {
+		t.Fatal("the closed pool is kept")
+	}
+}
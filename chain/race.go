@@ -0,0 +1,246 @@
+package chain
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/go-gost/core/chain"
+	"github.com/go-gost/core/logger"
+	ictx "github.com/go-gost/x/internal/ctx"
+	xnet "github.com/go-gost/x/internal/net"
+)
+
+// errRaceLost cancels the dials losing the race, the nodes are not marked as failed by them.
+var errRaceLost = errors.New("race lost")
+
+func raceLost(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), errRaceLost)
+}
+
+type raceResult struct {
+	conn net.Conn
+	// path is the route of the connection, as recorded by the Router.
+	path string
+	err  error
+}
+
+// raceDial dials the candidate routes in the Happy Eyeballs way. The next candidate
+// is started when the stagger delay elapses or the previous one fails,
+// the first established connection wins and the others are cancelled.
+func (r *Router) raceDial(ctx context.Context, network, address string, log logger.Logger) (net.Conn, error) {
+	ipAddr, err := xnet.Resolve(ctx, "ip", address, r.options.Resolver, r.options.HostMapper, log)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	// the route of the winner is recorded, the candidates do not write the buffer themselves.
+	buf := ictx.BufferFromContext(ctx)
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(errRaceLost)
+
+	next := r.raceCandidates(ctx, network, address, ipAddr, log)
+	results := make(chan raceResult)
+
+	var n, pending int
+	start := func() bool {
+		route, addr, ok := next()
+		if !ok {
+			return false
+		}
+
+		i := n
+		n++
+		pending++
+		go func() {
+			conn, path, err := r.dialRoute(ctx, route, network, addr, i, log)
+			select {
+			case results <- raceResult{conn: conn, path: path, err: err}:
+			case <-ctx.Done():
+				if conn != nil {
+					conn.Close()
+				}
+			}
+		}()
+		return true
+	}
+
+	if !start() {
+		return nil, ErrEmptyRoute
+	}
+
+	timer := time.NewTimer(r.race)
+	defer timer.Stop()
+
+	for pending > 0 {
+		select {
+		case res := <-results:
+			pending--
+			if res.err == nil {
+				if buf != nil {
+					buf.Reset()
+					buf.WriteString(res.path)
+				}
+				return res.conn, nil
+			}
+			err = res.err
+			if start() {
+				timer.Reset(r.race)
+			}
+		case <-timer.C:
+			if start() {
+				timer.Reset(r.race)
+			}
+		}
+	}
+
+	return nil, err
+}
+
+// raceCandidates returns the generator of the routes to race, up to Retries+1 and at least 2.
+// The route of a chain is selected again for each candidate, the duplicate routes are skipped.
+// The direct route is raced over the resolved IPv6 and IPv4 addresses.
+func (r *Router) raceCandidates(ctx context.Context, network, address, ipAddr string, log logger.Logger) func() (chain.Route, string, bool) {
+	count := r.options.Retries + 1
+	if count < 2 {
+		count = 2
+	}
+
+	seen := make(map[string]bool)
+	var direct []string
+	directDone := false
+
+	return func() (chain.Route, string, bool) {
+		for count > 0 {
+			if len(direct) == 0 {
+				var route chain.Route
+				if r.options.Chain != nil {
+					route = r.options.Chain.Route(ctx, network, ipAddr, chain.WithHostRouteOption(address))
+				}
+
+				if route != nil && len(route.Nodes()) > 0 {
+					count--
+					key := routeKey(route)
+					if seen[key] {
+						continue
+					}
+					seen[key] = true
+					return route, ipAddr, true
+				}
+
+				if directDone {
+					count--
+					continue
+				}
+				directDone = true
+				direct = r.directAddrs(ctx, network, address, ipAddr, log)
+			}
+
+			addr := direct[0]
+			direct = direct[1:]
+			count--
+			return DefaultRoute, addr, true
+		}
+		return nil, "", false
+	}
+}
+
+// directAddrs returns the addresses of the host resolved by the resolver or host mapper,
+// interleaved by address family. Without them, the domain name is left to the dialer
+// which falls back between address families by itself.
+func (r *Router) directAddrs(ctx context.Context, network, address, ipAddr string, log logger.Logger) []string {
+	host, port, _ := net.SplitHostPort(address)
+	ip, _, _ := net.SplitHostPort(ipAddr)
+	if host == "" || net.ParseIP(host) != nil || net.ParseIP(ip) == nil {
+		return []string{ipAddr}
+	}
+	switch network {
+	case "tcp4", "tcp6", "udp4", "udp6":
+		return []string{ipAddr}
+	}
+
+	var ips []net.IP
+	if r.options.HostMapper != nil {
+		ips, _ = r.options.HostMapper.Lookup(ctx, "ip", host)
+	}
+	if len(ips) == 0 && r.options.Resolver != nil {
+		ips, _ = r.options.Resolver.Resolve(ctx, "ip", host)
+	}
+
+	var v4, v6 []string
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			v4 = append(v4, net.JoinHostPort(ip.String(), port))
+		} else {
+			v6 = append(v6, net.JoinHostPort(ip.String(), port))
+		}
+	}
+	// the family of the resolved address goes first.
+	first, second := v4, v6
+	if net.ParseIP(ip).To4() == nil {
+		first, second = v6, v4
+	}
+
+	addrs := []string{ipAddr}
+	for i := 0; i < len(first) || i < len(second); i++ {
+		if i < len(first) {
+			addrs = append(addrs, first[i])
+		}
+		if i < len(second) {
+			addrs = append(addrs, second[i])
+		}
+	}
+
+	// remove the duplicate of the resolved address.
+	seen := make(map[string]bool)
+	var result []string
+	for _, addr := range addrs {
+		if !seen[addr] {
+			seen[addr] = true
+			result = append(result, addr)
+		}
+	}
+	log.Debugf("race %s over %v", host, result)
+	return result
+}
+
+// dialRoute dials the address over the route, path is the route as recorded by the Router.
+func (r *Router) dialRoute(ctx context.Context, route chain.Route, network, address string, i int, log logger.Logger) (conn net.Conn, path string, err error) {
+	if r.options.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.options.Timeout)
+		defer cancel()
+	}
+
+	var buf bytes.Buffer
+	for _, node := range routePath(route) {
+		fmt.Fprintf(&buf, "%s@%s > ", node.Name, node.Addr)
+	}
+	fmt.Fprintf(&buf, "%s", address)
+	path = buf.String()
+	log.Debugf("route(race=%d) %s", i, path)
+
+	conn, err = route.Dial(ctx, network, address,
+		chain.InterfaceDialOption(r.options.IfceName),
+		chain.NetnsDialOption(r.options.Netns),
+		chain.SockOptsDialOption(r.options.SockOpts),
+		chain.LoggerDialOption(log),
+	)
+	if err != nil && !raceLost(ctx) {
+		log.Errorf("route(race=%d) %s", i, err)
+	}
+	return
+}
+
+func routeKey(route chain.Route) string {
+	var buf bytes.Buffer
+	for _, node := range routePath(route) {
+		fmt.Fprintf(&buf, "%s@%s>", node.Name, node.Addr)
+	}
+	return buf.String()
+}
@@ -0,0 +1,114 @@
+package chain
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-gost/core/chain"
+	"github.com/go-gost/core/connector"
+	ictx "github.com/go-gost/x/internal/ctx"
+	xlogger "github.com/go-gost/x/logger"
+)
+
+type testTransport struct {
+	chain.Transporter
+	// blackhole blocks the dial until it is cancelled.
+	blackhole bool
+}
+
+func (tr *testTransport) Dial(ctx context.Context, addr string) (net.Conn, error) {
+	if tr.blackhole {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	c1, c2 := net.Pipe()
+	c2.Close()
+	return c1, nil
+}
+
+func (tr *testTransport) Handshake(ctx context.Context, conn net.Conn) (net.Conn, error) {
+	return conn, nil
+}
+
+func (tr *testTransport) Connect(ctx context.Context, conn net.Conn, network, address string) (net.Conn, error) {
+	return conn, nil
+}
+
+func (tr *testTransport) Options() *chain.TransportOptions {
+	return &chain.TransportOptions{}
+}
+
+func (tr *testTransport) Bind(ctx context.Context, conn net.Conn, network, address string, opts ...connector.BindOption) (net.Listener, error) {
+	return nil, errors.New("unsupported")
+}
+
+// testChain selects the nodes in turn.
+type testChain struct {
+	nodes []*chain.Node
+	n     atomic.Int64
+}
+
+func (c *testChain) Route(ctx context.Context, network, address string, opts ...chain.RouteOption) chain.Route {
+	route := NewRoute()
+	route.addNode(c.nodes[int(c.n.Add(1)-1)%len(c.nodes)])
+	return route
+}
+
+func TestRouter_Race(t *testing.T) {
+	blackhole := chain.NewNode("blackhole", "127.0.0.1:1", chain.TransportNodeOption(&testTransport{blackhole: true}))
+	ok := chain.NewNode("ok", "127.0.0.1:2", chain.TransportNodeOption(&testTransport{}))
+
+	r := NewRouter(
+		chain.ChainRouterOption(&testChain{nodes: []*chain.Node{blackhole, ok}}),
+		chain.TimeoutRouterOption(10*time.Second),
+		chain.LoggerRouterOption(xlogger.Nop()),
+	).WithRace(20 * time.Millisecond)
+
+	var buf bytes.Buffer
+	start := time.Now()
+	conn, err := r.Dial(ictx.ContextWithBuffer(context.Background(), &buf), "tcp", "127.0.0.1:80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("race took %v", d)
+	}
+	// the route of the winner is recorded.
+	if route := buf.String(); route != "ok@127.0.0.1:2 > 127.0.0.1:80" {
+		t.Fatalf("unexpected route %q", route)
+	}
+
+	// the cancelled dial is not a failure of the node.
+	time.Sleep(50 * time.Millisecond)
+	if n := blackhole.Marker().Count(); n != 0 {
+		t.Fatalf("blackhole marked failed %d times", n)
+	}
+}
+
+func TestRouter_RaceFail(t *testing.T) {
+	blackhole := chain.NewNode("blackhole", "127.0.0.1:1", chain.TransportNodeOption(&testTransport{blackhole: true}))
+
+	r := NewRouter(
+		chain.ChainRouterOption(&testChain{nodes: []*chain.Node{blackhole}}),
+		chain.TimeoutRouterOption(100*time.Millisecond),
+		chain.LoggerRouterOption(xlogger.Nop()),
+	).WithRace(20 * time.Millisecond)
+
+	// the same route is not raced against itself.
+	start := time.Now()
+	if _, err := r.Dial(context.Background(), "tcp", "127.0.0.1:80"); err == nil {
+		t.Fatal("expected error")
+	}
+	if d := time.Since(start); d < 100*time.Millisecond {
+		t.Fatalf("race failed in %v", d)
+	}
+	if n := blackhole.Marker().Count(); n != 1 {
+		t.Fatalf("blackhole marked failed %d times", n)
+	}
+}
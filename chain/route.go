@@ -16,6 +16,7 @@ import (
 	"github.com/go-gost/x/internal/net/dialer"
 	"github.com/go-gost/x/internal/net/udp"
 	xmetrics "github.com/go-gost/x/metrics"
+	xselector "github.com/go-gost/x/selector"
 )
 
 var (
@@ -149,7 +150,30 @@ func (r *chainRoute) Dial(ctx context.Context, network, address string, opts ...
 		}
 		return nil, err
 	}
-	return cc, nil
+	return r.track(cc), nil
+}
+
+// track counts the connection as in-flight for the nodes and the chain of the route
+// if they are selected by the leastconn strategy.
+func (r *chainRoute) track(conn net.Conn) net.Conn {
+	var releases []func()
+	for _, node := range r.nodes {
+		if st := xselector.LookupStats(node); st.TrackConns() {
+			releases = append(releases, st.Acquire())
+		}
+	}
+	if st := xselector.LookupStats(r.options.Chain); st.TrackConns() {
+		releases = append(releases, st.Acquire())
+	}
+	if len(releases) == 0 {
+		return conn
+	}
+
+	return trackConn(conn, func() {
+		for _, release := range releases {
+			release()
+		}
+	})
 }
 
 func (r *chainRoute) Bind(ctx context.Context, network, address string, opts ...chain.BindOption) (net.Listener, error) {
@@ -188,6 +212,7 @@ func (r *chainRoute) Bind(ctx context.Context, network, address string, opts ...
 func (r *chainRoute) connect(ctx context.Context, logger logger.Logger) (conn net.Conn, err error) {
 	network := "ip"
 	node := r.nodes[0]
+	start := time.Now()
 
 	defer func() {
 		if r.options.Chain != nil {
@@ -201,6 +226,9 @@ func (r *chainRoute) connect(ctx context.Context, logger logger.Logger) (conn ne
 			}
 			// chain error
 			if err != nil {
+				if raceLost(ctx) {
+					return
+				}
 				if marker != nil {
 					marker.Mark()
 				}
@@ -214,80 +242,45 @@ func (r *chainRoute) connect(ctx context.Context, logger logger.Logger) (conn ne
 			if marker != nil {
 				marker.Reset()
 			}
+			xselector.StatsOf(r.options.Chain).ObserveLatency(time.Since(start))
 		}
 	}()
 
-	addr, err := xnet.Resolve(ctx, network, node.Addr, node.Options().Resolver, node.Options().HostMapper, logger)
-	marker := node.Marker()
-	if err != nil {
-		if marker != nil {
-			marker.Mark()
-		}
-		return
-	}
-
-	start := time.Now()
-	cc, err := node.Options().Transport.Dial(ctx, addr)
-	if err != nil {
-		if marker != nil {
-			marker.Mark()
-		}
-		return
-	}
-
-	cn, err := node.Options().Transport.Handshake(ctx, cc)
-	if err != nil {
-		cc.Close()
-		if marker != nil {
-			marker.Mark()
-		}
-		return
-	}
-	if marker != nil {
-		marker.Reset()
-	}
-
-	if r.options.Chain != nil {
-		var name string
-		if cn, _ := r.options.Chain.(chainNamer); cn != nil {
-			name = cn.Name()
-		}
-		if v := xmetrics.GetObserver(xmetrics.MetricNodeConnectDurationObserver,
-			metrics.Labels{"chain": name, "node": node.Name}); v != nil {
-			v.Observe(time.Since(start).Seconds())
+	xselector.LookupBreaker(node).Begin()
+	cn := pooledConnOf(node)
+	if cn == nil {
+		cn, err = r.dialNode(ctx, node, logger)
+		if err != nil {
+			markNode(ctx, node, err)
+			return
 		}
 	}
+	markNode(ctx, node, nil)
 
 	preNode := node
 	for _, node := range r.nodes[1:] {
-		marker := node.Marker()
+		var addr string
+		var cc net.Conn
+		xselector.LookupBreaker(node).Begin()
 		addr, err = xnet.Resolve(ctx, network, node.Addr, node.Options().Resolver, node.Options().HostMapper, logger)
 		if err != nil {
 			cn.Close()
-			if marker != nil {
-				marker.Mark()
-			}
+			markNode(ctx, node, err)
 			return
 		}
 		cc, err = preNode.Options().Transport.Connect(ctx, cn, "tcp", addr)
 		if err != nil {
 			cn.Close()
-			if marker != nil {
-				marker.Mark()
-			}
+			markNode(ctx, node, err)
 			return
 		}
 		cc, err = node.Options().Transport.Handshake(ctx, cc)
 		if err != nil {
 			cn.Close()
-			if marker != nil {
-				marker.Mark()
-			}
+			markNode(ctx, node, err)
 			return
 		}
-		if marker != nil {
-			marker.Reset()
-		}
+		markNode(ctx, node, nil)
 
 		cn = cc
 		preNode = node
@@ -297,6 +290,66 @@ func (r *chainRoute) connect(ctx context.Context, logger logger.Logger) (conn ne
 	return
 }
 
+// pooledConnOf takes a pre-established connection from the pool of the node transport.
+func pooledConnOf(node *chain.Node) net.Conn {
+	if tr, _ := node.Options().Transport.(*Transport); tr != nil {
+		return tr.pool.get()
+	}
+	return nil
+}
+
+// dialNode dials and handshakes the first node of the route.
+func (r *chainRoute) dialNode(ctx context.Context, node *chain.Node, logger logger.Logger) (net.Conn, error) {
+	addr, err := xnet.Resolve(ctx, "ip", node.Addr, node.Options().Resolver, node.Options().HostMapper, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	dialStart := time.Now()
+	cc, err := node.Options().Transport.Dial(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	cn, err := node.Options().Transport.Handshake(ctx, cc)
+	if err != nil {
+		cc.Close()
+		return nil, err
+	}
+
+	xselector.StatsOf(node).ObserveLatency(time.Since(dialStart))
+	if r.options.Chain != nil {
+		var name string
+		if cn, _ := r.options.Chain.(chainNamer); cn != nil {
+			name = cn.Name()
+		}
+		if v := xmetrics.GetObserver(xmetrics.MetricNodeConnectDurationObserver,
+			metrics.Labels{"chain": name, "node": node.Name}); v != nil {
+			v.Observe(time.Since(dialStart).Seconds())
+		}
+	}
+	return cn, nil
+}
+
+// markNode records the result of connecting to the node for the fail filter and the circuit breaker.
+// The connections cancelled by the racing dial are not failures of the node.
+func markNode(ctx context.Context, node *chain.Node, err error) {
+	breaker := xselector.LookupBreaker(node)
+	if err != nil && raceLost(ctx) {
+		breaker.Cancel()
+		return
+	}
+
+	if marker := node.Marker(); marker != nil {
+		if err != nil {
+			marker.Mark()
+		} else {
+			marker.Reset()
+		}
+	}
+	breaker.Done(err)
+}
+
 func (r *chainRoute) getNode(index int) *chain.Node {
 	if r == nil || len(r.Nodes()) == 0 || index < 0 || index >= len(r.Nodes()) {
 		return nil
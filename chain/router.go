@@ -17,6 +17,8 @@ import (
 
 type Router struct {
 	options chain.RouterOptions
+	// race is the stagger delay of the racing dial, 0 to dial sequentially.
+	race time.Duration
 }
 
 func NewRouter(opts ...chain.RouterOption) *Router {
@@ -36,6 +38,14 @@ func NewRouter(opts ...chain.RouterOption) *Router {
 	return r
 }
 
+// WithRace enables the racing dial. A dial is started on the selected route,
+// and if it does not succeed within the stagger delay, another is started on an
+// alternate route, up to Retries+1 routes. The first established connection wins.
+func (r *Router) WithRace(stagger time.Duration) *Router {
+	r.race = stagger
+	return r
+}
+
 func (r *Router) Options() *chain.RouterOptions {
 	if r == nil {
 		return nil
@@ -82,6 +92,10 @@ func (r *Router) record(ctx context.Context, name string, data []byte) error {
 }
 
 func (r *Router) dial(ctx context.Context, network, address string, log logger.Logger) (conn net.Conn, err error) {
+	if r.race > 0 {
+		return r.raceDial(ctx, network, address, log)
+	}
+
 	count := r.options.Retries + 1
 	if count <= 0 {
 		count = 1
@@ -14,6 +14,7 @@ type Transport struct {
 	dialer    dialer.Dialer
 	connector connector.Connector
 	options   chain.TransportOptions
+	pool      *connPool
 }
 
 func NewTransport(d dialer.Dialer, c connector.Connector, opts ...chain.TransportOption) *Transport {
@@ -30,6 +31,24 @@ func NewTransport(d dialer.Dialer, c connector.Connector, opts ...chain.Transpor
 	return tr
 }
 
+// WithPool keeps a pool of the connections dialed and handshaken ahead of the requests.
+// The multiplexed transports share their sessions and are not pooled.
+// The transports with the same PoolOptions.Key share the pool.
+func (tr *Transport) WithPool(opts *PoolOptions) *Transport {
+	if opts == nil || opts.Size <= 0 || tr.Multiplex() {
+		return tr
+	}
+	tr.pool = acquirePool(tr, *opts)
+	tr.pool.start()
+	return tr
+}
+
+// Close releases the connection pool of the transport, it is stopped if no other transport shares it.
+func (tr *Transport) Close() error {
+	tr.pool.release()
+	return nil
+}
+
 func (tr *Transport) Dial(ctx context.Context, addr string) (net.Conn, error) {
 	netd := &net_dialer.Dialer{
 		Interface: tr.options.IfceName,
@@ -152,6 +152,30 @@ type SelectorConfig struct {
 	Strategy    string        `json:"strategy"`
 	MaxFails    int           `yaml:"maxFails" json:"maxFails"`
 	FailTimeout time.Duration `yaml:"failTimeout" json:"failTimeout"`
+	// Tolerance is the latency band of the lowest-latency strategy,
+	// the nodes within the band of the fastest node are selected in turn.
+	Tolerance time.Duration `yaml:",omitempty" json:"tolerance,omitempty"`
+	// HashKeys are the request attributes hashed by the consistent-hash strategy,
+	// hash, client, auth or host.
+	HashKeys []string `yaml:"hashKeys,omitempty" json:"hashKeys,omitempty"`
+	// Replicas is the number of virtual nodes per unit of node weight of the consistent-hash strategy.
+	Replicas int `yaml:",omitempty" json:"replicas,omitempty"`
+	// Breaker enables the circuit breaker of the nodes, which replaces the fail filter.
+	Breaker *BreakerConfig `yaml:",omitempty" json:"breaker,omitempty"`
+}
+
+type BreakerConfig struct {
+	// MaxFails trips the breaker after the consecutive failures.
+	MaxFails int `yaml:"maxFails,omitempty" json:"maxFails,omitempty"`
+	// ErrorRate trips the breaker when the rate of the failed connections in the window reaches it,
+	// if there are at least MinRequests connections.
+	ErrorRate   float64       `yaml:"errorRate,omitempty" json:"errorRate,omitempty"`
+	Window      time.Duration `yaml:",omitempty" json:"window,omitempty"`
+	MinRequests int           `yaml:"minRequests,omitempty" json:"minRequests,omitempty"`
+	// OpenTimeout is the time the breaker stays open before the trial connections are allowed.
+	OpenTimeout time.Duration `yaml:"openTimeout,omitempty" json:"openTimeout,omitempty"`
+	// HalfOpenRequests is the number of the trial connections in the half-open state.
+	HalfOpenRequests int `yaml:"halfOpenRequests,omitempty" json:"halfOpenRequests,omitempty"`
 }
 
 type AdmissionConfig struct {
@@ -198,6 +222,23 @@ type HTTPLoader struct {
 	Timeout time.Duration `yaml:",omitempty" json:"timeout,omitempty"`
 }
 
+// DNSLoader discovers the nodes from the DNS records.
+type DNSLoader struct {
+	// Name is the SRV record name, or the hostname expanded by its A/AAAA records.
+	Name string `json:"name"`
+	// Type is the record type: srv (default) or a.
+	Type string `yaml:",omitempty" json:"type,omitempty"`
+	// Port is the port of the nodes expanded from the A/AAAA records.
+	Port int `yaml:",omitempty" json:"port,omitempty"`
+	// Server is the name server of the SRV records, the system name server by default.
+	Server string `yaml:",omitempty" json:"server,omitempty"`
+	// Interval is the refresh interval if the TTL of the records is unknown.
+	Interval time.Duration `yaml:",omitempty" json:"interval,omitempty"`
+	Timeout  time.Duration `yaml:",omitempty" json:"timeout,omitempty"`
+	// Node is the template of the discovered nodes, supplying the connector and dialer.
+	Node *NodeConfig `yaml:",omitempty" json:"node,omitempty"`
+}
+
 type NameserverConfig struct {
 	Addr     string        `json:"addr"`
 	Chain    string        `yaml:",omitempty" json:"chain,omitempty"`
@@ -269,6 +310,27 @@ type RouterConfig struct {
 	Plugin *PluginConfig        `yaml:",omitempty" json:"plugin,omitempty"`
 }
 
+type DecisionRuleConfig struct {
+	Name     string   `yaml:",omitempty" json:"name,omitempty"`
+	Domains  []string `yaml:",omitempty" json:"domains,omitempty"`
+	CIDRs    []string `yaml:"cidrs,omitempty" json:"cidrs,omitempty"`
+	Ports    []string `yaml:",omitempty" json:"ports,omitempty"`
+	Protocol string   `yaml:",omitempty" json:"protocol,omitempty"`
+	AppIDs   []string `yaml:"appIDs,omitempty" json:"appIDs,omitempty"`
+	Action   string   `json:"action"`
+	Proxy    string   `yaml:",omitempty" json:"proxy,omitempty"`
+	Class    string   `yaml:",omitempty" json:"class,omitempty"`
+}
+
+type DecisionConfig struct {
+	Name   string                `json:"name"`
+	Rules  []*DecisionRuleConfig `yaml:",omitempty" json:"rules,omitempty"`
+	Reload time.Duration         `yaml:",omitempty" json:"reload,omitempty"`
+	File   *FileLoader           `yaml:",omitempty" json:"file,omitempty"`
+	Redis  *RedisLoader          `yaml:",omitempty" json:"redis,omitempty"`
+	HTTP   *HTTPLoader           `yaml:"http,omitempty" json:"http,omitempty"`
+}
+
 type RecorderConfig struct {
 	Name   string         `json:"name"`
 	File   *FileRecorder  `yaml:",omitempty" json:"file,omitempty"`
@@ -524,8 +586,40 @@ type HopConfig struct {
 	File     *FileLoader     `yaml:",omitempty" json:"file,omitempty"`
 	Redis    *RedisLoader    `yaml:",omitempty" json:"redis,omitempty"`
 	HTTP     *HTTPLoader     `yaml:"http,omitempty" json:"http,omitempty"`
+	DNS      *DNSLoader      `yaml:"dns,omitempty" json:"dns,omitempty"`
 	Plugin   *PluginConfig   `yaml:",omitempty" json:"plugin,omitempty"`
-	Metadata map[string]any  `yaml:",omitempty" json:"metadata,omitempty"`
+	// HealthCheck enables the active health checks of the nodes.
+	HealthCheck *HealthCheckConfig `yaml:"healthCheck,omitempty" json:"healthCheck,omitempty"`
+	// Sticky pins the sessions of the clients to the nodes.
+	Sticky   *StickyConfig  `yaml:",omitempty" json:"sticky,omitempty"`
+	Metadata map[string]any `yaml:",omitempty" json:"metadata,omitempty"`
+}
+
+type StickyConfig struct {
+	// Key is the session key: ip (default), auth, cookie or header.
+	Key string `yaml:",omitempty" json:"key,omitempty"`
+	// Name is the name of the cookie or header.
+	Name string `yaml:",omitempty" json:"name,omitempty"`
+	// TTL is the idle time after which a session is unpinned.
+	TTL time.Duration `yaml:"ttl,omitempty" json:"ttl,omitempty"`
+	// MaxEntries is the maximum number of the pinned sessions, 65536 by default.
+	// The least recently used session is unpinned beyond it.
+	MaxEntries int `yaml:"maxEntries,omitempty" json:"maxEntries,omitempty"`
+}
+
+type HealthCheckConfig struct {
+	// Type is the probe type: tcp (default), tls, http or connect.
+	Type     string        `yaml:",omitempty" json:"type,omitempty"`
+	Interval time.Duration `yaml:",omitempty" json:"interval,omitempty"`
+	Timeout  time.Duration `yaml:",omitempty" json:"timeout,omitempty"`
+	// Rise and Fall are the numbers of consecutive successful and failed probes
+	// for a node to be marked up and down.
+	Rise int `yaml:",omitempty" json:"rise,omitempty"`
+	Fall int `yaml:",omitempty" json:"fall,omitempty"`
+	// URL is requested through the node by the http probe.
+	URL string `yaml:"url,omitempty" json:"url,omitempty"`
+	// Target is connected to through the node by the connect probe.
+	Target string `yaml:",omitempty" json:"target,omitempty"`
 }
 
 type NodeConfig struct {
@@ -563,6 +657,7 @@ type Config struct {
 	Hosts      []*HostsConfig     `yaml:",omitempty" json:"hosts,omitempty"`
 	Ingresses  []*IngressConfig   `yaml:",omitempty" json:"ingresses,omitempty"`
 	Routers    []*RouterConfig    `yaml:",omitempty" json:"routers,omitempty"`
+	Decisions  []*DecisionConfig  `yaml:",omitempty" json:"decisions,omitempty"`
 	SDs        []*SDConfig        `yaml:"sds,omitempty" json:"sds,omitempty"`
 	Recorders  []*RecorderConfig  `yaml:",omitempty" json:"recorders,omitempty"`
 	Limiters   []*LimiterConfig   `yaml:",omitempty" json:"limiters,omitempty"`
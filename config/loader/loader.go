@@ -1,13 +1,25 @@
 package loader
 
 import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/go-gost/core/chain"
+	"github.com/go-gost/core/handler"
+	"github.com/go-gost/core/hop"
 	"github.com/go-gost/core/logger"
+	"github.com/go-gost/core/recorder"
+	reg "github.com/go-gost/core/registry"
 	"github.com/go-gost/x/config"
 	"github.com/go-gost/x/config/parsing"
 	admission_parser "github.com/go-gost/x/config/parsing/admission"
 	auth_parser "github.com/go-gost/x/config/parsing/auth"
 	bypass_parser "github.com/go-gost/x/config/parsing/bypass"
 	chain_parser "github.com/go-gost/x/config/parsing/chain"
+	decision_parser "github.com/go-gost/x/config/parsing/decision"
 	hop_parser "github.com/go-gost/x/config/parsing/hop"
 	hosts_parser "github.com/go-gost/x/config/parsing/hosts"
 	ingress_parser "github.com/go-gost/x/config/parsing/ingress"
@@ -26,207 +38,335 @@ var (
 	defaultLoader *loader = &loader{}
 )
 
+// Load registers the objects of the config. The config is diffed against the
+// previously loaded one and only the changed objects are recreated, the removed
+// ones are unregistered. A service with only its handler changed keeps its
+// listener and the handler is replaced. The changed and new services are
+// registered but not started.
 func Load(cfg *config.Config) error {
 	return defaultLoader.Load(cfg)
 }
 
-type loader struct{}
+type loader struct {
+	mu sync.Mutex
+	// global is the fingerprint of the settings shared by all the objects,
+	// all the objects are recreated when it is changed.
+	global string
+	// objects are the objects registered by the previous loads by kind and name.
+	objects map[string]map[string]*object
+}
+
+// object is a registered config object.
+type object struct {
+	// sum is the fingerprint of the config the object is parsed from.
+	sum string
+	// listener is the fingerprint of the service config without its handler.
+	listener string
+	value    any
+}
 
 func (l *loader) Load(cfg *config.Config) error {
-	logCfg := cfg.Log
-	if logCfg == nil {
-		logCfg = &config.LogConfig{}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if cfg == nil {
+		return nil
 	}
-	logger.SetDefault(logger_parser.ParseLogger(&config.LoggerConfig{Log: logCfg}))
 
-	tlsCfg, err := parsing.BuildDefaultTLSConfig(cfg.TLS)
-	if err != nil {
-		return err
+	global := fingerprint(struct {
+		Log     *config.LogConfig
+		TLS     *config.TLSConfig
+		Loggers []*config.LoggerConfig
+	}{cfg.Log, cfg.TLS, cfg.Loggers})
+	if l.objects == nil || global != l.global {
+		l.global = ""
+		l.objects = make(map[string]map[string]*object)
+
+		logCfg := cfg.Log
+		if logCfg == nil {
+			logCfg = &config.LogConfig{}
+		}
+		logger.SetDefault(logger_parser.ParseLogger(&config.LoggerConfig{Log: logCfg}))
+
+		tlsCfg, err := parsing.BuildDefaultTLSConfig(cfg.TLS)
+		if err != nil {
+			return err
+		}
+		parsing.SetDefaultTLSConfig(tlsCfg)
 	}
-	parsing.SetDefaultTLSConfig(tlsCfg)
 
-	if err := register(cfg); err != nil {
+	if err := l.register(cfg); err != nil {
 		return err
 	}
+	l.global = global
 
 	return nil
 }
 
-func register(cfg *config.Config) error {
-	if cfg == nil {
-		return nil
+func (l *loader) register(cfg *config.Config) error {
+	if err := reconcile(l, "logger", registry.LoggerRegistry(), cfg.Loggers, noErr(logger_parser.ParseLogger)); err != nil {
+		return err
 	}
-
-	for name := range registry.LoggerRegistry().GetAll() {
-		registry.LoggerRegistry().Unregister(name)
+	if err := reconcile(l, "auther", registry.AutherRegistry(), cfg.Authers, noErr(auth_parser.ParseAuther)); err != nil {
+		return err
 	}
-	for _, loggerCfg := range cfg.Loggers {
-		if err := registry.LoggerRegistry().Register(loggerCfg.Name, logger_parser.ParseLogger(loggerCfg)); err != nil {
-			return err
-		}
+	if err := reconcile(l, "admission", registry.AdmissionRegistry(), cfg.Admissions, noErr(admission_parser.ParseAdmission)); err != nil {
+		return err
 	}
-
-	for name := range registry.AutherRegistry().GetAll() {
-		registry.AutherRegistry().Unregister(name)
+	if err := reconcile(l, "bypass", registry.BypassRegistry(), cfg.Bypasses, noErr(bypass_parser.ParseBypass)); err != nil {
+		return err
 	}
-	for _, autherCfg := range cfg.Authers {
-		if err := registry.AutherRegistry().Register(autherCfg.Name, auth_parser.ParseAuther(autherCfg)); err != nil {
-			return err
-		}
+	if err := reconcile(l, "resolver", registry.ResolverRegistry(), cfg.Resolvers, resolver_parser.ParseResolver); err != nil {
+		return err
 	}
-
-	for name := range registry.AdmissionRegistry().GetAll() {
-		registry.AdmissionRegistry().Unregister(name)
+	if err := reconcile(l, "hosts", registry.HostsRegistry(), cfg.Hosts, noErr(hosts_parser.ParseHostMapper)); err != nil {
+		return err
 	}
-	for _, admissionCfg := range cfg.Admissions {
-		if err := registry.AdmissionRegistry().Register(admissionCfg.Name, admission_parser.ParseAdmission(admissionCfg)); err != nil {
-			return err
-		}
+	if err := reconcile(l, "ingress", registry.IngressRegistry(), cfg.Ingresses, noErr(ingress_parser.ParseIngress)); err != nil {
+		return err
 	}
-
-	for name := range registry.BypassRegistry().GetAll() {
-		registry.BypassRegistry().Unregister(name)
+	if err := reconcile(l, "router", registry.RouterRegistry(), cfg.Routers, noErr(router_parser.ParseRouter)); err != nil {
+		return err
 	}
-	for _, bypassCfg := range cfg.Bypasses {
-		if err := registry.BypassRegistry().Register(bypassCfg.Name, bypass_parser.ParseBypass(bypassCfg)); err != nil {
-			return err
-		}
+	if err := reconcile(l, "decision", registry.DecisionRegistry(), cfg.Decisions, noErr(decision_parser.ParseDecision)); err != nil {
+		return err
 	}
-
-	for name := range registry.ResolverRegistry().GetAll() {
-		registry.ResolverRegistry().Unregister(name)
+	if err := reconcile(l, "sd", registry.SDRegistry(), cfg.SDs, noErr(sd_parser.ParseSD)); err != nil {
+		return err
 	}
-	for _, resolverCfg := range cfg.Resolvers {
-		r, err := resolver_parser.ParseResolver(resolverCfg)
-		if err != nil {
-			return err
-		}
-		if err := registry.ResolverRegistry().Register(resolverCfg.Name, r); err != nil {
-			return err
-		}
+	if err := reconcile(l, "observer", registry.ObserverRegistry(), cfg.Observers, noErr(observer_parser.ParseObserver)); err != nil {
+		return err
 	}
-
-	for name := range registry.HostsRegistry().GetAll() {
-		registry.HostsRegistry().Unregister(name)
+	if err := reconcile(l, "recorder", registry.RecorderRegistry(), cfg.Recorders, noErr(recorder_parser.ParseRecorder)); err != nil {
+		return err
 	}
-	for _, hostsCfg := range cfg.Hosts {
-		if err := registry.HostsRegistry().Register(hostsCfg.Name, hosts_parser.ParseHostMapper(hostsCfg)); err != nil {
-			return err
-		}
+	if err := reconcile(l, "limiter", registry.TrafficLimiterRegistry(), cfg.Limiters, noErr(limiter_parser.ParseTrafficLimiter)); err != nil {
+		return err
 	}
-
-	for name := range registry.IngressRegistry().GetAll() {
-		registry.IngressRegistry().Unregister(name)
+	if err := reconcile(l, "climiter", registry.ConnLimiterRegistry(), cfg.CLimiters, noErr(limiter_parser.ParseConnLimiter)); err != nil {
+		return err
 	}
-	for _, ingressCfg := range cfg.Ingresses {
-		if err := registry.IngressRegistry().Register(ingressCfg.Name, ingress_parser.ParseIngress(ingressCfg)); err != nil {
-			return err
-		}
+	if err := reconcile(l, "rlimiter", registry.RateLimiterRegistry(), cfg.RLimiters, noErr(limiter_parser.ParseRateLimiter)); err != nil {
+		return err
 	}
-
-	for name := range registry.RouterRegistry().GetAll() {
-		registry.RouterRegistry().Unregister(name)
+	if err := reconcile(l, "hop", registry.HopRegistry(), cfg.Hops, func(cfg *config.HopConfig) (hop.Hop, error) {
+		return hop_parser.ParseHop(cfg, logger.Default())
+	}); err != nil {
+		return err
 	}
-	for _, routerCfg := range cfg.Routers {
-		if err := registry.RouterRegistry().Register(routerCfg.Name, router_parser.ParseRouter(routerCfg)); err != nil {
-			return err
-		}
+	if err := reconcile(l, "chain", registry.ChainRegistry(), cfg.Chains, func(cfg *config.ChainConfig) (chain.Chainer, error) {
+		return chain_parser.ParseChain(cfg, logger.Default())
+	}); err != nil {
+		return err
 	}
 
-	for name := range registry.SDRegistry().GetAll() {
-		registry.SDRegistry().Unregister(name)
-	}
-	for _, sdCfg := range cfg.SDs {
-		if err := registry.SDRegistry().Register(sdCfg.Name, sd_parser.ParseSD(sdCfg)); err != nil {
-			return err
+	return l.registerServices(cfg.Services)
+}
+
+// reconcile registers the objects of a kind. The objects removed from the config are
+// unregistered, the unchanged objects are kept and the others are parsed and registered
+// in place of the old ones. The other objects refer to them by name through the registry,
+// so they are not recreated.
+func reconcile[C any, T any](l *loader, kind string, r reg.Registry[T], cfgs []*C, parse func(*C) (T, error)) error {
+	objects := l.objectsOf(kind)
+	registered := r.GetAll()
+
+	names := make(map[string]bool, len(cfgs))
+	for _, cfg := range cfgs {
+		if cfg != nil {
+			names[configName(cfg)] = true
 		}
 	}
+	unregister(r, objects, registered, names)
 
-	for name := range registry.ObserverRegistry().GetAll() {
-		registry.ObserverRegistry().Unregister(name)
-	}
-	for _, observerCfg := range cfg.Observers {
-		if err := registry.ObserverRegistry().Register(observerCfg.Name, observer_parser.ParseObserver(observerCfg)); err != nil {
-			return err
+	seen := make(map[string]bool, len(cfgs))
+	for _, cfg := range cfgs {
+		if cfg == nil {
+			continue
 		}
-	}
+		name := configName(cfg)
+		if name == "" {
+			continue
+		}
+		if seen[name] {
+			return fmt.Errorf("%s %s: %w", kind, name, registry.ErrDup)
+		}
+		seen[name] = true
 
-	for name := range registry.RecorderRegistry().GetAll() {
-		registry.RecorderRegistry().Unregister(name)
-	}
-	for _, recorderCfg := range cfg.Recorders {
-		if err := registry.RecorderRegistry().Register(recorderCfg.Name, recorder_parser.ParseRecorder(recorderCfg)); err != nil {
-			return err
+		// the fingerprint is taken before parsing, which fills in the defaults.
+		sum := fingerprint(cfg)
+		if o := objects[name]; o != nil && o.sum == sum && sameObject(o.value, registered[name]) {
+			continue
 		}
-	}
 
-	for name := range registry.TrafficLimiterRegistry().GetAll() {
-		registry.TrafficLimiterRegistry().Unregister(name)
-	}
-	for _, limiterCfg := range cfg.Limiters {
-		if err := registry.TrafficLimiterRegistry().Register(limiterCfg.Name, limiter_parser.ParseTrafficLimiter(limiterCfg)); err != nil {
+		v, err := parse(cfg)
+		if err != nil {
 			return err
 		}
-	}
-
-	for name := range registry.ConnLimiterRegistry().GetAll() {
-		registry.ConnLimiterRegistry().Unregister(name)
-	}
-	for _, limiterCfg := range cfg.CLimiters {
-		if err := registry.ConnLimiterRegistry().Register(limiterCfg.Name, limiter_parser.ParseConnLimiter(limiterCfg)); err != nil {
+		if err := registry.Swap(r, name, v); err != nil {
 			return err
 		}
+		objects[name] = &object{sum: sum, value: v}
 	}
 
-	for name := range registry.RateLimiterRegistry().GetAll() {
-		registry.RateLimiterRegistry().Unregister(name)
-	}
-	for _, limiterCfg := range cfg.RLimiters {
-		if err := registry.RateLimiterRegistry().Register(limiterCfg.Name, limiter_parser.ParseRateLimiter(limiterCfg)); err != nil {
-			return err
+	return nil
+}
+
+// registerServices registers the services like reconcile. The service with only the handler
+// changed keeps its listener and the handler is replaced, the connections in flight are
+// drained on the old handler. Any other change closes the service before it is recreated,
+// so that the new service can listen on the same address.
+func (l *loader) registerServices(cfgs []*config.ServiceConfig) error {
+	r := registry.ServiceRegistry()
+	objects := l.objectsOf("service")
+	registered := r.GetAll()
+
+	names := make(map[string]bool, len(cfgs))
+	for _, cfg := range cfgs {
+		if cfg != nil {
+			names[cfg.Name] = true
 		}
 	}
+	unregister(r, objects, registered, names)
 
-	for name := range registry.HopRegistry().GetAll() {
-		registry.HopRegistry().Unregister(name)
-	}
-	for _, hopCfg := range cfg.Hops {
-		hop, err := hop_parser.ParseHop(hopCfg, logger.Default())
+	seen := make(map[string]bool, len(cfgs))
+	for _, cfg := range cfgs {
+		if cfg == nil || cfg.Name == "" {
+			continue
+		}
+		if seen[cfg.Name] {
+			return fmt.Errorf("service %s: %w", cfg.Name, registry.ErrDup)
+		}
+		seen[cfg.Name] = true
+
+		sum, listenerSum := fingerprint(serviceConfig(cfg)), fingerprint(listenerConfig(cfg))
+		if o := objects[cfg.Name]; o != nil && sameObject(o.value, registered[cfg.Name]) {
+			if o.sum == sum {
+				continue
+			}
+			if hs, ok := o.value.(interface{ SetHandler(handler.Handler) }); ok && o.listener == listenerSum {
+				h, err := service_parser.ParseHandler(cfg)
+				if err != nil {
+					return err
+				}
+				hs.SetHandler(h)
+				o.sum = sum
+				continue
+			}
+		}
+
+		r.Unregister(cfg.Name)
+		delete(objects, cfg.Name)
+
+		svc, err := service_parser.ParseService(cfg)
 		if err != nil {
 			return err
 		}
-		if err := registry.HopRegistry().Register(hopCfg.Name, hop); err != nil {
+		if svc == nil {
+			continue
+		}
+		if err := r.Register(cfg.Name, svc); err != nil {
 			return err
 		}
+		objects[cfg.Name] = &object{sum: sum, listener: listenerSum, value: svc}
 	}
 
-	for name := range registry.ChainRegistry().GetAll() {
-		registry.ChainRegistry().Unregister(name)
+	return nil
+}
+
+func (l *loader) objectsOf(kind string) map[string]*object {
+	objects := l.objects[kind]
+	if objects == nil {
+		objects = make(map[string]*object)
+		l.objects[kind] = objects
 	}
-	for _, chainCfg := range cfg.Chains {
-		c, err := chain_parser.ParseChain(chainCfg, logger.Default())
-		if err != nil {
-			return err
+	return objects
+}
+
+// unregister unregisters the objects not in names.
+func unregister[T any](r reg.Registry[T], objects map[string]*object, registered map[string]T, names map[string]bool) {
+	for name := range registered {
+		if !names[name] {
+			r.Unregister(name)
 		}
-		if err := registry.ChainRegistry().Register(chainCfg.Name, c); err != nil {
-			return err
+	}
+	for name := range objects {
+		if !names[name] {
+			delete(objects, name)
 		}
 	}
+}
+
+// serviceConfig returns the service config without the status, which is not a setting.
+func serviceConfig(cfg *config.ServiceConfig) *config.ServiceConfig {
+	c := *cfg
+	c.Status = nil
+	return &c
+}
 
-	for name := range registry.ServiceRegistry().GetAll() {
-		registry.ServiceRegistry().Unregister(name)
+// listenerConfig returns the service config without the settings used only by the handler,
+// the listener and the service are created with the others, a change of which recreates them.
+func listenerConfig(cfg *config.ServiceConfig) *config.ServiceConfig {
+	c := *serviceConfig(cfg)
+	c.Handler = nil
+	c.Forwarder = nil
+	c.Bypass = ""
+	c.Bypasses = nil
+	c.RLimiter = ""
+
+	// the service only records the client address, the other records are made by the handler.
+	c.Recorders = nil
+	for _, r := range cfg.Recorders {
+		if r != nil && r.Record == recorder.RecorderServiceClientAddress {
+			c.Recorders = append(c.Recorders, r)
+		}
 	}
-	for _, svcCfg := range cfg.Services {
-		svc, err := service_parser.ParseService(svcCfg)
-		if err != nil {
-			return err
+
+	// the dial race only applies to the router of the handler.
+	c.Metadata = nil
+	for k, v := range cfg.Metadata {
+		if strings.EqualFold(k, parsing.MDKeyDialRace) {
+			continue
 		}
-		if svc != nil {
-			if err := registry.ServiceRegistry().Register(svcCfg.Name, svc); err != nil {
-				return err
-			}
+		if c.Metadata == nil {
+			c.Metadata = make(map[string]any, len(cfg.Metadata))
 		}
+		c.Metadata[k] = v
 	}
+	return &c
+}
 
-	return nil
+func fingerprint(v any) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+func configName(cfg any) string {
+	v := reflect.Indirect(reflect.ValueOf(cfg))
+	if v.Kind() != reflect.Struct {
+		return ""
+	}
+	if f := v.FieldByName("Name"); f.Kind() == reflect.String {
+		return f.String()
+	}
+	return ""
+}
+
+// sameObject reports whether the registered object is still the one loaded,
+// it is not if it has been replaced, for example through the web API.
+func sameObject(a, b any) bool {
+	if a == nil || b == nil {
+		return false
+	}
+	t := reflect.TypeOf(a)
+	if t != reflect.TypeOf(b) || t.Kind() != reflect.Pointer {
+		return false
+	}
+	return a == b
+}
+
+func noErr[C any, T any](parse func(C) T) func(C) (T, error) {
+	return func(cfg C) (T, error) {
+		return parse(cfg), nil
+	}
 }
@@ -0,0 +1,179 @@
+package loader
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-gost/core/handler"
+	"github.com/go-gost/core/metadata"
+	"github.com/go-gost/x/config"
+	_ "github.com/go-gost/x/listener/tcp"
+	"github.com/go-gost/x/registry"
+)
+
+var closedHandlers atomic.Int64
+
+type testHandler struct{}
+
+func (h *testHandler) Init(metadata.Metadata) error { return nil }
+
+func (h *testHandler) Handle(ctx context.Context, conn net.Conn, opts ...handler.HandleOption) error {
+	return conn.Close()
+}
+
+func (h *testHandler) Close() error {
+	closedHandlers.Add(1)
+	return nil
+}
+
+func init() {
+	registry.HandlerRegistry().Register("loadertest", func(opts ...handler.Option) handler.Handler {
+		return &testHandler{}
+	})
+	// relay stands for the handlers with long-lived connections.
+	registry.HandlerRegistry().Register("relay", func(opts ...handler.Option) handler.Handler {
+		return &testHandler{}
+	})
+}
+
+func testConfig(matcher string, handlerMD, listenerMD map[string]any) *config.Config {
+	return &config.Config{
+		Log: &config.LogConfig{Level: "fatal"},
+		Bypasses: []*config.BypassConfig{
+			{Name: "bypass-0", Matchers: []string{matcher}},
+		},
+		Services: []*config.ServiceConfig{
+			{
+				Name:   "service-0",
+				Addr:   "127.0.0.1:0",
+				Bypass: "bypass-0",
+				Handler: &config.HandlerConfig{
+					Type:     "loadertest",
+					Metadata: handlerMD,
+				},
+				Listener: &config.ListenerConfig{
+					Type:     "tcp",
+					Metadata: listenerMD,
+				},
+			},
+		},
+	}
+}
+
+func TestLoad_Diff(t *testing.T) {
+	l := &loader{}
+	defer l.Load(&config.Config{})
+
+	if err := l.Load(testConfig("example.com", nil, nil)); err != nil {
+		t.Fatal(err)
+	}
+	bp := registry.BypassRegistry().GetAll()["bypass-0"]
+	svc := registry.ServiceRegistry().GetAll()["service-0"]
+	if bp == nil || svc == nil {
+		t.Fatal("objects not registered")
+	}
+	go svc.Serve()
+
+	// nothing is recreated for the same config.
+	if err := l.Load(testConfig("example.com", nil, nil)); err != nil {
+		t.Fatal(err)
+	}
+	if registry.BypassRegistry().GetAll()["bypass-0"] != bp || registry.ServiceRegistry().GetAll()["service-0"] != svc {
+		t.Fatal("unchanged objects recreated")
+	}
+
+	// the changed bypass is recreated, the service refers to it by name.
+	if err := l.Load(testConfig("example.org", nil, nil)); err != nil {
+		t.Fatal(err)
+	}
+	if registry.BypassRegistry().GetAll()["bypass-0"] == bp {
+		t.Fatal("changed bypass not recreated")
+	}
+	if registry.ServiceRegistry().GetAll()["service-0"] != svc {
+		t.Fatal("service recreated")
+	}
+
+	// the handler is replaced in the running service, and the idle one is closed.
+	closed := closedHandlers.Load()
+	if err := l.Load(testConfig("example.org", map[string]any{"foo": "bar"}, nil)); err != nil {
+		t.Fatal(err)
+	}
+	if registry.ServiceRegistry().GetAll()["service-0"] != svc {
+		t.Fatal("service recreated")
+	}
+	conn, err := net.Dial("tcp", svc.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Read(make([]byte, 1))
+	conn.Close()
+	waitFor(t, func() bool { return closedHandlers.Load() == closed+1 })
+
+	// the changed listener recreates the service.
+	if err := l.Load(testConfig("example.org", map[string]any{"foo": "bar"}, map[string]any{"backlog": 64})); err != nil {
+		t.Fatal(err)
+	}
+	if v := registry.ServiceRegistry().GetAll()["service-0"]; v == nil || v == svc {
+		t.Fatal("service not recreated")
+	}
+	if _, err := net.Dial("tcp", svc.Addr().String()); err == nil {
+		t.Fatal("old service is listening")
+	}
+
+	// the removed objects are unregistered.
+	if err := l.Load(&config.Config{Log: &config.LogConfig{Level: "fatal"}}); err != nil {
+		t.Fatal(err)
+	}
+	if registry.BypassRegistry().IsRegistered("bypass-0") || registry.ServiceRegistry().IsRegistered("service-0") {
+		t.Fatal("removed objects registered")
+	}
+}
+
+func TestLoad_SessionHandler(t *testing.T) {
+	l := &loader{}
+	defer l.Load(&config.Config{})
+
+	cfg := func(handlerMD, serviceMD map[string]any) *config.Config {
+		cfg := testConfig("example.com", handlerMD, nil)
+		cfg.Services[0].Handler.Type = "relay"
+		cfg.Services[0].Metadata = serviceMD
+		return cfg
+	}
+
+	if err := l.Load(cfg(nil, nil)); err != nil {
+		t.Fatal(err)
+	}
+	svc := registry.ServiceRegistry().GetAll()["service-0"]
+	if svc == nil {
+		t.Fatal("service not registered")
+	}
+
+	// the sessions are drained on the replaced handler, the service keeps listening.
+	if err := l.Load(cfg(map[string]any{"foo": "bar"}, nil)); err != nil {
+		t.Fatal(err)
+	}
+	if registry.ServiceRegistry().GetAll()["service-0"] != svc {
+		t.Fatal("service recreated")
+	}
+
+	// the settings of the handler router do not recreate the listener.
+	if err := l.Load(cfg(map[string]any{"foo": "bar"}, map[string]any{"dialRace": "100ms"})); err != nil {
+		t.Fatal(err)
+	}
+	if registry.ServiceRegistry().GetAll()["service-0"] != svc {
+		t.Fatal("service recreated")
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,72 @@
+package decision
+
+import (
+	"github.com/go-gost/core/logger"
+	"github.com/go-gost/x/config"
+	xdecision "github.com/go-gost/x/decision"
+	"github.com/go-gost/x/internal/loader"
+	"github.com/go-gost/x/registry"
+)
+
+func ParseDecision(cfg *config.DecisionConfig) registry.DecisionEvaluator {
+	if cfg == nil {
+		return nil
+	}
+
+	var rules []*xdecision.Rule
+	for _, rule := range cfg.Rules {
+		if rule == nil {
+			continue
+		}
+		rules = append(rules, &xdecision.Rule{
+			Name:     rule.Name,
+			Domains:  rule.Domains,
+			CIDRs:    rule.CIDRs,
+			Ports:    rule.Ports,
+			Protocol: rule.Protocol,
+			AppIDs:   rule.AppIDs,
+			Action:   rule.Action,
+			Proxy:    rule.Proxy,
+			Class:    rule.Class,
+		})
+	}
+
+	opts := []xdecision.Option{
+		xdecision.RulesOption(rules),
+		xdecision.ReloadPeriodOption(cfg.Reload),
+		xdecision.LoggerOption(logger.Default().WithFields(map[string]any{
+			"kind":     "decision",
+			"decision": cfg.Name,
+		})),
+	}
+	if cfg.File != nil && cfg.File.Path != "" {
+		opts = append(opts, xdecision.FileLoaderOption(loader.FileLoader(cfg.File.Path)))
+	}
+	if cfg.Redis != nil && cfg.Redis.Addr != "" {
+		switch cfg.Redis.Type {
+		case "list": // redis list, one rule per item
+			opts = append(opts, xdecision.RedisLoaderOption(loader.RedisListLoader(
+				cfg.Redis.Addr,
+				loader.DBRedisLoaderOption(cfg.Redis.DB),
+				loader.UsernameRedisLoaderOption(cfg.Redis.Username),
+				loader.PasswordRedisLoaderOption(cfg.Redis.Password),
+				loader.KeyRedisLoaderOption(cfg.Redis.Key),
+			)))
+		default: // redis string, a rules document
+			opts = append(opts, xdecision.RedisLoaderOption(loader.RedisStringLoader(
+				cfg.Redis.Addr,
+				loader.DBRedisLoaderOption(cfg.Redis.DB),
+				loader.UsernameRedisLoaderOption(cfg.Redis.Username),
+				loader.PasswordRedisLoaderOption(cfg.Redis.Password),
+				loader.KeyRedisLoaderOption(cfg.Redis.Key),
+			)))
+		}
+	}
+	if cfg.HTTP != nil && cfg.HTTP.URL != "" {
+		opts = append(opts, xdecision.HTTPLoaderOption(loader.HTTPLoader(
+			cfg.HTTP.URL,
+			loader.TimeoutHTTPLoaderOption(cfg.HTTP.Timeout),
+		)))
+	}
+	return xdecision.NewEvaluator(opts...)
+}
@@ -2,6 +2,7 @@ package hop
 
 import (
 	"crypto/tls"
+	"fmt"
 	"strings"
 
 	"github.com/go-gost/core/chain"
@@ -19,6 +20,7 @@ import (
 	"github.com/go-gost/x/internal/plugin"
 	"github.com/go-gost/x/metadata"
 	mdutil "github.com/go-gost/x/metadata/util"
+	"github.com/go-gost/x/registry"
 )
 
 func ParseHop(cfg *config.HopConfig, log logger.Logger) (hop.Hop, error) {
@@ -70,8 +72,21 @@ func ParseHop(cfg *config.HopConfig, log logger.Logger) (hop.Hop, error) {
 		netns = mdutil.GetString(md, parsing.MDKeyNetns)
 	}
 
+	ncs := cfg.Nodes
+	if cfg.DNS != nil {
+		if cfg.DNS.Node == nil {
+			cfg.DNS.Node = &config.NodeConfig{}
+		}
+		// the template inherits from the hop as the nodes.
+		ncs = append(append([]*config.NodeConfig{}, cfg.Nodes...), cfg.DNS.Node)
+	}
+	// the discovery is checked before the nodes are parsed, nothing is left to release.
+	if err := ValidateDNS(cfg); err != nil {
+		return nil, err
+	}
+
 	var nodes []*chain.Node
-	for _, v := range cfg.Nodes {
+	for _, v := range ncs {
 		if v == nil {
 			continue
 		}
@@ -139,6 +154,10 @@ func ParseHop(cfg *config.HopConfig, log logger.Logger) (hop.Hop, error) {
 			v.Dialer.Type = "tcp"
 		}
 
+		if cfg.DNS != nil && v == cfg.DNS.Node {
+			continue
+		}
+
 		node, err := node_parser.ParseNode(cfg.Name, v, log)
 		if err != nil {
 			return nil, err
@@ -148,7 +167,7 @@ func ParseHop(cfg *config.HopConfig, log logger.Logger) (hop.Hop, error) {
 		}
 	}
 
-	sel := selector_parser.ParseNodeSelector(cfg.Selector)
+	sel := selector_parser.ParseHopSelector(cfg.Name, cfg.Selector)
 	if sel == nil {
 		sel = selector_parser.DefaultNodeSelector()
 	}
@@ -165,6 +184,31 @@ func ParseHop(cfg *config.HopConfig, log logger.Logger) (hop.Hop, error) {
 		})),
 	}
 
+	if hc := cfg.HealthCheck; hc != nil {
+		opts = append(opts, xhop.HealthCheckOption(&xhop.HealthCheckOptions{
+			Type:     hc.Type,
+			Interval: hc.Interval,
+			Timeout:  hc.Timeout,
+			Rise:     hc.Rise,
+			Fall:     hc.Fall,
+			URL:      hc.URL,
+			Target:   hc.Target,
+		}))
+	}
+
+	if sc := cfg.Sticky; sc != nil {
+		opts = append(opts, xhop.StickyOption(&xhop.StickyOptions{
+			Key:        sc.Key,
+			Name:       sc.Name,
+			TTL:        sc.TTL,
+			MaxEntries: sc.MaxEntries,
+		}))
+	}
+
+	if dns := parseDNS(cfg); dns != nil {
+		opts = append(opts, xhop.DNSOption(dns))
+	}
+
 	if cfg.File != nil && cfg.File.Path != "" {
 		opts = append(opts, xhop.FileLoaderOption(loader.FileLoader(cfg.File.Path)))
 	}
@@ -185,3 +229,32 @@ func ParseHop(cfg *config.HopConfig, log logger.Logger) (hop.Hop, error) {
 	}
 	return xhop.NewHop(opts...), nil
 }
+
+// ValidateDNS checks the node discovery of the hop without starting it.
+func ValidateDNS(cfg *config.HopConfig) error {
+	if cfg == nil || cfg.Plugin != nil {
+		return nil
+	}
+	if dns := parseDNS(cfg); dns != nil {
+		if err := xhop.ValidateDNSOptions(*dns); err != nil {
+			return fmt.Errorf("dns: %w", err)
+		}
+	}
+	return nil
+}
+
+func parseDNS(cfg *config.HopConfig) *xhop.DNSOptions {
+	if cfg.DNS == nil || cfg.DNS.Name == "" {
+		return nil
+	}
+	return &xhop.DNSOptions{
+		Name:     cfg.DNS.Name,
+		Type:     cfg.DNS.Type,
+		Port:     cfg.DNS.Port,
+		Server:   cfg.DNS.Server,
+		Resolver: registry.ResolverRegistry().Get(cfg.Resolver),
+		Interval: cfg.DNS.Interval,
+		Timeout:  cfg.DNS.Timeout,
+		Node:     cfg.DNS.Node,
+	}
+}
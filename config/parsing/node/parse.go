@@ -1,6 +1,8 @@
 package node
 
 import (
+	"crypto/sha256"
+	"encoding/json"
 	"fmt"
 	"net"
 	"regexp"
@@ -138,6 +140,17 @@ func ParseNode(hop string, cfg *config.NodeConfig, log logger.Logger) (*chain.No
 		chain.NetnsTransportOption(mdutil.GetString(md, parsing.MDKeyNetns)),
 		chain.SockOptsTransportOption(sockOpts),
 	)
+	if size := mdutil.GetInt(md, parsing.MDKeyPoolSize); size > 0 {
+		tr.WithPool(&xchain.PoolOptions{
+			Size:        size,
+			MaxAge:      mdutil.GetDuration(md, parsing.MDKeyPoolMaxAge),
+			IdleTimeout: mdutil.GetDuration(md, parsing.MDKeyPoolIdleTimeout),
+			Key:         poolKey(hop, cfg),
+			Resolver:    registry.ResolverRegistry().Get(cfg.Resolver),
+			HostMapper:  registry.HostsRegistry().Get(cfg.Hosts),
+			Logger:      nodeLogger.WithFields(map[string]any{"kind": "pool"}),
+		})
+	}
 
 	opts := []chain.NodeOption{
 		chain.TransportNodeOption(tr),
@@ -246,3 +259,14 @@ func ParseNode(hop string, cfg *config.NodeConfig, log logger.Logger) (*chain.No
 	}
 	return chain.NewNode(cfg.Name, cfg.Addr, opts...), nil
 }
+
+// poolKey returns the key of the connection pool of the node, the pool is kept
+// across the reloads as long as the node config is unchanged.
+func poolKey(hop string, cfg *config.NodeConfig) string {
+	b, err := json.Marshal(cfg)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(b)
+	return fmt.Sprintf("%s/%s/%x", hop, cfg.Name, sum)
+}
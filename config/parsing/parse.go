@@ -29,4 +29,9 @@ const (
 	MDKeyNetnsOut = "netns.out"
 
 	MDKeyDialTimeout = "dialTimeout"
+	MDKeyDialRace    = "dialRace"
+
+	MDKeyPoolSize        = "pool.size"
+	MDKeyPoolMaxAge      = "pool.maxAge"
+	MDKeyPoolIdleTimeout = "pool.idleTimeout"
 )
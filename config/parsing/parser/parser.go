@@ -3,12 +3,15 @@ package parser
 import (
 	"bufio"
 	"encoding/json"
+	"fmt"
+	"io"
 	"os"
 	"strings"
 
 	"github.com/go-gost/core/logger"
 	"github.com/go-gost/x/config"
 	"github.com/go-gost/x/config/cmd"
+	"github.com/go-gost/x/config/validator"
 	xmd "github.com/go-gost/x/metadata"
 	mdutil "github.com/go-gost/x/metadata/util"
 )
@@ -27,6 +30,24 @@ func Parse() (*config.Config, error) {
 	return defaultParser.Parse()
 }
 
+// Validate parses and validates the config without loading it, for the -validate mode.
+// The errors are written to w, one per line.
+func Validate(w io.Writer) error {
+	cfg, err := defaultParser.Parse()
+	if err != nil {
+		return err
+	}
+
+	errs := validator.Validate(cfg)
+	for _, e := range errs {
+		fmt.Fprintln(w, e)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("config is invalid: %d error(s)", len(errs))
+	}
+	return nil
+}
+
 type Args struct {
 	CfgFile     string
 	Services    []string
@@ -193,6 +214,7 @@ func mergeConfig(cfg1, cfg2 *config.Config) *config.Config {
 		RLimiters:  append(cfg1.RLimiters, cfg2.RLimiters...),
 		Loggers:    append(cfg1.Loggers, cfg2.Loggers...),
 		Routers:    append(cfg1.Routers, cfg2.Routers...),
+		Decisions:  append(cfg1.Decisions, cfg2.Decisions...),
 		Observers:  append(cfg1.Observers, cfg2.Observers...),
 		TLS:        cfg1.TLS,
 		Log:        cfg1.Log,
@@ -22,6 +22,12 @@ func ParseChainSelector(cfg *config.SelectorConfig) selector.Selector[chain.Chai
 		strategy = xs.FIFOStrategy[chain.Chainer]()
 	case "hash":
 		strategy = xs.HashStrategy[chain.Chainer]()
+	case "leastconn", "least-conn":
+		strategy = xs.LeastConnStrategy[chain.Chainer]()
+	case "lowest-latency", "latency":
+		strategy = xs.LowestLatencyStrategy[chain.Chainer](cfg.Tolerance)
+	case "consistent-hash", "chash", "ring":
+		strategy = xs.ConsistentHashStrategy[chain.Chainer](cfg.HashKeys, cfg.Replicas)
 	default:
 		strategy = xs.RoundRobinStrategy[chain.Chainer]()
 	}
@@ -33,6 +39,11 @@ func ParseChainSelector(cfg *config.SelectorConfig) selector.Selector[chain.Chai
 }
 
 func ParseNodeSelector(cfg *config.SelectorConfig) selector.Selector[*chain.Node] {
+	return ParseHopSelector("", cfg)
+}
+
+// ParseHopSelector parses the node selector of the hop, the hop name labels the metrics of the circuit breaker.
+func ParseHopSelector(hop string, cfg *config.SelectorConfig) selector.Selector[*chain.Node] {
 	if cfg == nil {
 		return nil
 	}
@@ -47,13 +58,32 @@ func ParseNodeSelector(cfg *config.SelectorConfig) selector.Selector[*chain.Node
 		strategy = xs.FIFOStrategy[*chain.Node]()
 	case "hash":
 		strategy = xs.HashStrategy[*chain.Node]()
+	case "leastconn", "least-conn":
+		strategy = xs.LeastConnStrategy[*chain.Node]()
+	case "lowest-latency", "latency":
+		strategy = xs.LowestLatencyStrategy[*chain.Node](cfg.Tolerance)
+	case "consistent-hash", "chash", "ring":
+		strategy = xs.ConsistentHashStrategy[*chain.Node](cfg.HashKeys, cfg.Replicas)
 	default:
 		strategy = xs.RoundRobinStrategy[*chain.Node]()
 	}
 
+	failFilter := xs.FailFilter[*chain.Node](cfg.MaxFails, cfg.FailTimeout)
+	if b := cfg.Breaker; b != nil {
+		failFilter = xs.BreakerFilter[*chain.Node](xs.BreakerOptions{
+			Hop:              hop,
+			MaxFails:         b.MaxFails,
+			ErrorRate:        b.ErrorRate,
+			Window:           b.Window,
+			MinRequests:      b.MinRequests,
+			OpenTimeout:      b.OpenTimeout,
+			HalfOpenRequests: b.HalfOpenRequests,
+		})
+	}
+
 	return xs.NewSelector(
 		strategy,
-		xs.FailFilter[*chain.Node](cfg.MaxFails, cfg.FailTimeout),
+		failFilter,
 		xs.BackupFilter[*chain.Node](),
 	)
 }
@@ -2,6 +2,7 @@ package service
 
 import (
 	"fmt"
+	"io"
 	"runtime"
 	"strings"
 	"time"
@@ -12,6 +13,7 @@ import (
 	"github.com/go-gost/core/hop"
 	"github.com/go-gost/core/listener"
 	"github.com/go-gost/core/logger"
+	mdata "github.com/go-gost/core/metadata"
 	"github.com/go-gost/core/observer/stats"
 	"github.com/go-gost/core/recorder"
 	"github.com/go-gost/core/selector"
@@ -38,39 +40,38 @@ import (
 	"github.com/vishvananda/netns"
 )
 
+// serviceOptions are the settings of the service shared by its listener and handler.
+type serviceOptions struct {
+	ppv                    int
+	ifce                   string
+	sockOpts               *chain.SockOpts
+	preUp                  []string
+	preDown                []string
+	postUp                 []string
+	postDown               []string
+	ignoreChain            bool
+	stats                  stats.Stats
+	observerPeriod         time.Duration
+	netnsIn                string
+	netnsOut               string
+	dialTimeout            time.Duration
+	dialRace               time.Duration
+	limiterRefreshInterval time.Duration
+	limiterCleanupInterval time.Duration
+	limiterScope           string
+}
+
 func ParseService(cfg *config.ServiceConfig) (service.Service, error) {
 	if cfg == nil {
 		return nil, fmt.Errorf("service config is nil")
 	}
-	if cfg.Listener == nil {
-		cfg.Listener = &config.ListenerConfig{}
-	}
-	if strings.TrimSpace(cfg.Listener.Type) == "" {
-		cfg.Listener.Type = "tcp"
-	}
+	setDefaults(cfg)
 
-	if cfg.Handler == nil {
-		cfg.Handler = &config.HandlerConfig{}
-	}
-	if strings.TrimSpace(cfg.Handler.Type) == "" {
-		cfg.Handler.Type = "auto"
-	}
-
-	log := logger.Default()
-	if log == nil {
-		return nil, fmt.Errorf("default logger is nil")
-	}
-	if loggers := logger_parser.List(cfg.Logger, cfg.Loggers...); len(loggers) > 0 {
-		log = logger.LoggerGroup(loggers...)
+	log, serviceLogger, err := parseLogger(cfg)
+	if err != nil {
+		return nil, err
 	}
 
-	serviceLogger := log.WithFields(map[string]any{
-		"kind":     "service",
-		"service":  cfg.Name,
-		"listener": cfg.Listener.Type,
-		"handler":  cfg.Handler.Type,
-	})
-
 	tlsCfg := cfg.Listener.TLS
 	if tlsCfg == nil {
 		tlsCfg = &config.TLSConfig{}
@@ -98,72 +99,22 @@ func ParseService(cfg *config.ServiceConfig) (service.Service, error) {
 
 	admissions := admission_parser.List(cfg.Admission, cfg.Admissions...)
 
-	var sockOpts *chain.SockOpts
-	if cfg.SockOpts != nil {
-		sockOpts = &chain.SockOpts{
-			Mark: cfg.SockOpts.Mark,
-		}
-	}
-
-	var ppv int
-	ifce := cfg.Interface
-	var preUp, preDown, postUp, postDown []string
-	var ignoreChain bool
-	var pStats stats.Stats
-	var observerPeriod time.Duration
-	var netnsIn, netnsOut string
-	var dialTimeout time.Duration
-
-	var limiterRefreshInterval time.Duration
-	var limiterCleanupInterval time.Duration
-	var limiterScope string
-
-	if cfg.Metadata != nil {
-		md := metadata.NewMetadata(cfg.Metadata)
-		ppv = mdutil.GetInt(md, parsing.MDKeyProxyProtocol)
-		if v := mdutil.GetString(md, parsing.MDKeyInterface); v != "" {
-			ifce = v
-		}
-		if v := mdutil.GetInt(md, parsing.MDKeySoMark); v > 0 {
-			sockOpts = &chain.SockOpts{
-				Mark: v,
-			}
-		}
-		preUp = mdutil.GetStrings(md, parsing.MDKeyPreUp)
-		preDown = mdutil.GetStrings(md, parsing.MDKeyPreDown)
-		postUp = mdutil.GetStrings(md, parsing.MDKeyPostUp)
-		postDown = mdutil.GetStrings(md, parsing.MDKeyPostDown)
-		ignoreChain = mdutil.GetBool(md, parsing.MDKeyIgnoreChain)
-
-		if mdutil.GetBool(md, parsing.MDKeyEnableStats) {
-			pStats = xstats.NewStats(mdutil.GetBool(md, parsing.MDKeyObserverResetTraffic))
-		}
-		observerPeriod = mdutil.GetDuration(md, parsing.MDKeyObserverPeriod, "observePeriod")
-
-		netnsIn = mdutil.GetString(md, parsing.MDKeyNetns)
-		netnsOut = mdutil.GetString(md, parsing.MDKeyNetnsOut)
-
-		dialTimeout = mdutil.GetDuration(md, parsing.MDKeyDialTimeout)
-
-		limiterRefreshInterval = mdutil.GetDuration(md, parsing.MDKeyLimiterRefreshInterval)
-		limiterCleanupInterval = mdutil.GetDuration(md, parsing.MDKeyLimiterCleanupInterval)
-		limiterScope = mdutil.GetString(md, parsing.MDKeyLimiterScope)
-	}
+	opts := parseOptions(cfg)
 
 	listenerLogger := serviceLogger.WithFields(map[string]any{
 		"kind": "listener",
 	})
 
 	routerOpts := []chain.RouterOption{
-		chain.TimeoutRouterOption(dialTimeout),
-		chain.InterfaceRouterOption(ifce),
-		chain.NetnsRouterOption(netnsOut),
-		chain.SockOptsRouterOption(sockOpts),
+		chain.TimeoutRouterOption(opts.dialTimeout),
+		chain.InterfaceRouterOption(opts.ifce),
+		chain.NetnsRouterOption(opts.netnsOut),
+		chain.SockOptsRouterOption(opts.sockOpts),
 		chain.ResolverRouterOption(registry.ResolverRegistry().Get(cfg.Resolver)),
 		chain.HostMapperRouterOption(registry.HostsRegistry().Get(cfg.Hosts)),
 		chain.LoggerRouterOption(listenerLogger),
 	}
-	if !ignoreChain {
+	if !opts.ignoreChain {
 		routerOpts = append(routerOpts,
 			chain.ChainRouterOption(chainGroup(cfg.Listener.Chain, cfg.Listener.ChainGroup)),
 		)
@@ -179,44 +130,25 @@ func ParseService(cfg *config.ServiceConfig) (service.Service, error) {
 		listener.TrafficLimiterOption(
 			cache_limiter.NewCachedTrafficLimiter(
 				registry.TrafficLimiterRegistry().Get(cfg.Limiter),
-				cache_limiter.RefreshIntervalOption(limiterRefreshInterval),
-				cache_limiter.CleanupIntervalOption(limiterCleanupInterval),
-				cache_limiter.ScopeOption(limiterScope),
+				cache_limiter.RefreshIntervalOption(opts.limiterRefreshInterval),
+				cache_limiter.CleanupIntervalOption(opts.limiterCleanupInterval),
+				cache_limiter.ScopeOption(opts.limiterScope),
 			),
 		),
 		listener.ConnLimiterOption(registry.ConnLimiterRegistry().Get(cfg.CLimiter)),
 		listener.ServiceOption(cfg.Name),
-		listener.ProxyProtocolOption(ppv),
-		listener.StatsOption(pStats),
-		listener.NetnsOption(netnsIn),
+		listener.ProxyProtocolOption(opts.ppv),
+		listener.StatsOption(opts.stats),
+		listener.NetnsOption(opts.netnsIn),
 		listener.LoggerOption(listenerLogger),
 	}
 
-	if netnsIn != "" {
-		runtime.LockOSThread()
-		defer runtime.UnlockOSThread()
-
-		originNs, err := netns.Get()
-		if err != nil {
-			return nil, fmt.Errorf("netns.Get(): %v", err)
-		}
-		defer netns.Set(originNs)
-
-		var ns netns.NsHandle
-
-		if strings.HasPrefix(netnsIn, "/") {
-			ns, err = netns.GetFromPath(netnsIn)
-		} else {
-			ns, err = netns.GetFromName(netnsIn)
-		}
+	if opts.netnsIn != "" {
+		restore, err := enterNetns(opts.netnsIn)
 		if err != nil {
-			return nil, fmt.Errorf("netns.Get(%s): %v", netnsIn, err)
-		}
-		defer ns.Close()
-
-		if err := netns.Set(ns); err != nil {
-			return nil, fmt.Errorf("netns.Set(%s): %v", netnsIn, err)
+			return nil, err
 		}
+		defer restore()
 	}
 
 	var ln listener.Listener
@@ -235,32 +167,110 @@ func ParseService(cfg *config.ServiceConfig) (service.Service, error) {
 		return nil, err
 	}
 
+	h, recorders, err := parseHandler(cfg, opts, log, serviceLogger, false)
+	if err != nil {
+		ln.Close()
+		return nil, err
+	}
+
+	s := xservice.NewService(cfg.Name, ln, h,
+		xservice.AdmissionOption(xadmission.AdmissionGroup(admissions...)),
+		xservice.PreUpOption(opts.preUp),
+		xservice.PreDownOption(opts.preDown),
+		xservice.PostUpOption(opts.postUp),
+		xservice.PostDownOption(opts.postDown),
+		xservice.RecordersOption(recorders...),
+		xservice.StatsOption(opts.stats),
+		xservice.ObserverOption(registry.ObserverRegistry().Get(cfg.Observer)),
+		xservice.ObserverPeriodOption(opts.observerPeriod),
+		xservice.LoggerOption(serviceLogger),
+	)
+
+	serviceLogger.Infof("listening on %s/%s", s.Addr().String(), s.Addr().Network())
+	return s, nil
+}
+
+// ParseHandler creates the handler of the service, so that it can be replaced
+// in the running service without recreating the listener.
+func ParseHandler(cfg *config.ServiceConfig) (handler.Handler, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("service config is nil")
+	}
+	setDefaults(cfg)
+
+	log, serviceLogger, err := parseLogger(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := parseOptions(cfg)
+	if opts.netnsIn != "" {
+		restore, err := enterNetns(opts.netnsIn)
+		if err != nil {
+			return nil, err
+		}
+		defer restore()
+	}
+
+	h, _, err := parseHandler(cfg, opts, log, serviceLogger, false)
+	return h, err
+}
+
+// metadataValidator is implemented by the handlers whose Init has side effects,
+// such as listening on an entrypoint, to check their metadata without them.
+type metadataValidator interface {
+	ValidateMetadata(md mdata.Metadata) error
+}
+
+// ValidateHandler parses the handler of the service and checks its metadata,
+// the handler is closed at once.
+func ValidateHandler(cfg *config.ServiceConfig) error {
+	if cfg == nil {
+		return fmt.Errorf("service config is nil")
+	}
+	setDefaults(cfg)
+
+	log, serviceLogger, err := parseLogger(cfg)
+	if err != nil {
+		return err
+	}
+
+	h, _, err := parseHandler(cfg, parseOptions(cfg), log, serviceLogger, true)
+	if closer, ok := h.(io.Closer); ok {
+		closer.Close()
+	}
+	return err
+}
+
+// parseHandler creates the handler of the service and initializes it,
+// only its metadata are checked if validate is true.
+func parseHandler(cfg *config.ServiceConfig, opts *serviceOptions, log, serviceLogger logger.Logger, validate bool) (handler.Handler, []recorder.RecorderObject, error) {
 	handlerLogger := serviceLogger.WithFields(map[string]any{
 		"kind": "handler",
 	})
 
-	tlsCfg = cfg.Handler.TLS
+	tlsCfg := cfg.Handler.TLS
 	if tlsCfg == nil {
 		tlsCfg = &config.TLSConfig{}
 	}
-	tlsConfig, err = tls_util.LoadServerConfig(tlsCfg)
+	tlsConfig, err := tls_util.LoadServerConfig(tlsCfg)
 	if err != nil {
 		handlerLogger.Error(err)
-		return nil, err
+		return nil, nil, err
 	}
 	if tlsConfig == nil {
 		tlsConfig = parsing.DefaultTLSConfig().Clone()
 		tls_util.SetTLSOptions(tlsConfig, tlsCfg.Options)
 	}
 
-	authers = auth_parser.List(cfg.Handler.Auther, cfg.Handler.Authers...)
+	authers := auth_parser.List(cfg.Handler.Auther, cfg.Handler.Authers...)
 	if len(authers) == 0 {
 		if auther := auth_parser.ParseAutherFromAuth(cfg.Handler.Auth); auther != nil {
 			authers = append(authers, auther)
 		}
 	}
 
-	auther = nil
+	var auther auth.Authenticator
 	if len(authers) > 0 {
 		auther = xauth.AuthenticatorGroup(authers...)
 	}
@@ -281,18 +291,18 @@ func ParseService(cfg *config.ServiceConfig) (service.Service, error) {
 		})
 	}
 
-	routerOpts = []chain.RouterOption{
+	routerOpts := []chain.RouterOption{
 		chain.RetriesRouterOption(cfg.Handler.Retries),
-		chain.TimeoutRouterOption(dialTimeout),
-		chain.InterfaceRouterOption(ifce),
-		chain.NetnsRouterOption(netnsOut),
-		chain.SockOptsRouterOption(sockOpts),
+		chain.TimeoutRouterOption(opts.dialTimeout),
+		chain.InterfaceRouterOption(opts.ifce),
+		chain.NetnsRouterOption(opts.netnsOut),
+		chain.SockOptsRouterOption(opts.sockOpts),
 		chain.ResolverRouterOption(registry.ResolverRegistry().Get(cfg.Resolver)),
 		chain.HostMapperRouterOption(registry.HostsRegistry().Get(cfg.Hosts)),
 		chain.RecordersRouterOption(recorders...),
 		chain.LoggerRouterOption(handlerLogger),
 	}
-	if !ignoreChain {
+	if !opts.ignoreChain {
 		routerOpts = append(routerOpts,
 			chain.ChainRouterOption(chainGroup(cfg.Handler.Chain, cfg.Handler.ChainGroup)),
 		)
@@ -301,7 +311,7 @@ func ParseService(cfg *config.ServiceConfig) (service.Service, error) {
 	var h handler.Handler
 	if rf := registry.HandlerRegistry().Get(cfg.Handler.Type); rf != nil {
 		h = rf(
-			handler.RouterOption(xchain.NewRouter(routerOpts...)),
+			handler.RouterOption(xchain.NewRouter(routerOpts...).WithRace(opts.dialRace)),
 			handler.AutherOption(auther),
 			handler.AuthOption(auth_parser.Info(cfg.Handler.Auth)),
 			handler.BypassOption(xbypass.BypassGroup(bypass_parser.List(cfg.Bypass, cfg.Bypasses...)...)),
@@ -312,16 +322,17 @@ func ParseService(cfg *config.ServiceConfig) (service.Service, error) {
 			handler.RecordersOption(recorders...),
 			handler.LoggerOption(handlerLogger),
 			handler.ServiceOption(cfg.Name),
-			handler.NetnsOption(netnsIn),
+			handler.NetnsOption(opts.netnsIn),
 		)
 	} else {
-		return nil, fmt.Errorf("unknown handler: %s", cfg.Handler.Type)
+		return nil, nil, fmt.Errorf("unknown handler: %s", cfg.Handler.Type)
 	}
 
-	if forwarder, ok := h.(handler.Forwarder); ok {
-		hop, err := parseForwarder(cfg.Forwarder, log)
+	// the forwarder is checked on its own by the validation.
+	if forwarder, ok := h.(handler.Forwarder); ok && !validate {
+		hop, err := ParseForwarder(cfg.Forwarder, log)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		forwarder.Forward(hop)
 	}
@@ -330,29 +341,141 @@ func ParseService(cfg *config.ServiceConfig) (service.Service, error) {
 		cfg.Handler.Metadata = make(map[string]any)
 	}
 	handlerLogger.Debugf("metadata: %v", cfg.Handler.Metadata)
+	if v, ok := h.(metadataValidator); ok && validate {
+		return h, recorders, v.ValidateMetadata(metadata.NewMetadata(cfg.Handler.Metadata))
+	}
 	if err := h.Init(metadata.NewMetadata(cfg.Handler.Metadata)); err != nil {
+		if validate {
+			return h, recorders, err
+		}
 		handlerLogger.Error("init: ", err)
-		return nil, err
+		return nil, nil, err
 	}
 
-	s := xservice.NewService(cfg.Name, ln, h,
-		xservice.AdmissionOption(xadmission.AdmissionGroup(admissions...)),
-		xservice.PreUpOption(preUp),
-		xservice.PreDownOption(preDown),
-		xservice.PostUpOption(postUp),
-		xservice.PostDownOption(postDown),
-		xservice.RecordersOption(recorders...),
-		xservice.StatsOption(pStats),
-		xservice.ObserverOption(registry.ObserverRegistry().Get(cfg.Observer)),
-		xservice.ObserverPeriodOption(observerPeriod),
-		xservice.LoggerOption(serviceLogger),
-	)
+	return h, recorders, nil
+}
 
-	serviceLogger.Infof("listening on %s/%s", s.Addr().String(), s.Addr().Network())
-	return s, nil
+func setDefaults(cfg *config.ServiceConfig) {
+	if cfg.Listener == nil {
+		cfg.Listener = &config.ListenerConfig{}
+	}
+	if strings.TrimSpace(cfg.Listener.Type) == "" {
+		cfg.Listener.Type = "tcp"
+	}
+
+	if cfg.Handler == nil {
+		cfg.Handler = &config.HandlerConfig{}
+	}
+	if strings.TrimSpace(cfg.Handler.Type) == "" {
+		cfg.Handler.Type = "auto"
+	}
+}
+
+func parseLogger(cfg *config.ServiceConfig) (log, serviceLogger logger.Logger, err error) {
+	log = logger.Default()
+	if log == nil {
+		return nil, nil, fmt.Errorf("default logger is nil")
+	}
+	if loggers := logger_parser.List(cfg.Logger, cfg.Loggers...); len(loggers) > 0 {
+		log = logger.LoggerGroup(loggers...)
+	}
+
+	serviceLogger = log.WithFields(map[string]any{
+		"kind":     "service",
+		"service":  cfg.Name,
+		"listener": cfg.Listener.Type,
+		"handler":  cfg.Handler.Type,
+	})
+	return
+}
+
+func parseOptions(cfg *config.ServiceConfig) *serviceOptions {
+	opts := &serviceOptions{
+		ifce: cfg.Interface,
+	}
+	if cfg.SockOpts != nil {
+		opts.sockOpts = &chain.SockOpts{
+			Mark: cfg.SockOpts.Mark,
+		}
+	}
+
+	if cfg.Metadata != nil {
+		md := metadata.NewMetadata(cfg.Metadata)
+		opts.ppv = mdutil.GetInt(md, parsing.MDKeyProxyProtocol)
+		if v := mdutil.GetString(md, parsing.MDKeyInterface); v != "" {
+			opts.ifce = v
+		}
+		if v := mdutil.GetInt(md, parsing.MDKeySoMark); v > 0 {
+			opts.sockOpts = &chain.SockOpts{
+				Mark: v,
+			}
+		}
+		opts.preUp = mdutil.GetStrings(md, parsing.MDKeyPreUp)
+		opts.preDown = mdutil.GetStrings(md, parsing.MDKeyPreDown)
+		opts.postUp = mdutil.GetStrings(md, parsing.MDKeyPostUp)
+		opts.postDown = mdutil.GetStrings(md, parsing.MDKeyPostDown)
+		opts.ignoreChain = mdutil.GetBool(md, parsing.MDKeyIgnoreChain)
+
+		if mdutil.GetBool(md, parsing.MDKeyEnableStats) {
+			opts.stats = xstats.NewStats(mdutil.GetBool(md, parsing.MDKeyObserverResetTraffic))
+		}
+		opts.observerPeriod = mdutil.GetDuration(md, parsing.MDKeyObserverPeriod, "observePeriod")
+
+		opts.netnsIn = mdutil.GetString(md, parsing.MDKeyNetns)
+		opts.netnsOut = mdutil.GetString(md, parsing.MDKeyNetnsOut)
+
+		opts.dialTimeout = mdutil.GetDuration(md, parsing.MDKeyDialTimeout)
+		opts.dialRace = mdutil.GetDuration(md, parsing.MDKeyDialRace)
+
+		opts.limiterRefreshInterval = mdutil.GetDuration(md, parsing.MDKeyLimiterRefreshInterval)
+		opts.limiterCleanupInterval = mdutil.GetDuration(md, parsing.MDKeyLimiterCleanupInterval)
+		opts.limiterScope = mdutil.GetString(md, parsing.MDKeyLimiterScope)
+	}
+
+	return opts
+}
+
+// enterNetns switches the current thread to the network namespace,
+// restore switches it back.
+func enterNetns(name string) (restore func(), err error) {
+	runtime.LockOSThread()
+	defer func() {
+		if err != nil {
+			runtime.UnlockOSThread()
+		}
+	}()
+
+	originNs, err := netns.Get()
+	if err != nil {
+		return nil, fmt.Errorf("netns.Get(): %v", err)
+	}
+
+	var ns netns.NsHandle
+	if strings.HasPrefix(name, "/") {
+		ns, err = netns.GetFromPath(name)
+	} else {
+		ns, err = netns.GetFromName(name)
+	}
+	if err != nil {
+		originNs.Close()
+		return nil, fmt.Errorf("netns.Get(%s): %v", name, err)
+	}
+	defer ns.Close()
+
+	if err := netns.Set(ns); err != nil {
+		originNs.Close()
+		return nil, fmt.Errorf("netns.Set(%s): %v", name, err)
+	}
+
+	return func() {
+		netns.Set(originNs)
+		originNs.Close()
+		runtime.UnlockOSThread()
+	}, nil
 }
 
-func parseForwarder(cfg *config.ForwarderConfig, log logger.Logger) (hop.Hop, error) {
+// ParseForwarder creates the hop of the forwarder, the hop referred to by name is looked up in the registry.
+func ParseForwarder(cfg *config.ForwarderConfig, log logger.Logger) (hop.Hop, error) {
 	if cfg == nil {
 		return nil, nil
 	}
@@ -0,0 +1,589 @@
+package validator
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/go-gost/core/logger"
+	"github.com/go-gost/x/config"
+	"github.com/go-gost/x/config/parsing"
+	admission_parser "github.com/go-gost/x/config/parsing/admission"
+	auth_parser "github.com/go-gost/x/config/parsing/auth"
+	bypass_parser "github.com/go-gost/x/config/parsing/bypass"
+	chain_parser "github.com/go-gost/x/config/parsing/chain"
+	decision_parser "github.com/go-gost/x/config/parsing/decision"
+	hop_parser "github.com/go-gost/x/config/parsing/hop"
+	hosts_parser "github.com/go-gost/x/config/parsing/hosts"
+	ingress_parser "github.com/go-gost/x/config/parsing/ingress"
+	limiter_parser "github.com/go-gost/x/config/parsing/limiter"
+	logger_parser "github.com/go-gost/x/config/parsing/logger"
+	observer_parser "github.com/go-gost/x/config/parsing/observer"
+	recorder_parser "github.com/go-gost/x/config/parsing/recorder"
+	resolver_parser "github.com/go-gost/x/config/parsing/resolver"
+	router_parser "github.com/go-gost/x/config/parsing/router"
+	sd_parser "github.com/go-gost/x/config/parsing/sd"
+	service_parser "github.com/go-gost/x/config/parsing/service"
+	tls_util "github.com/go-gost/x/internal/util/tls"
+	xlogger "github.com/go-gost/x/logger"
+	"github.com/go-gost/x/metadata"
+	mdutil "github.com/go-gost/x/metadata/util"
+	"github.com/go-gost/x/registry"
+)
+
+// Error is an error of the config object at the JSON path, such as $.services[0].handler.chain.
+type Error struct {
+	Path string `json:"path"`
+	Msg  string `json:"msg"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Path, e.Msg)
+}
+
+// Errors is the list of the errors of a config.
+type Errors []*Error
+
+func (errs Errors) Error() string {
+	var lines []string
+	for _, e := range errs {
+		lines = append(lines, e.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Validate checks the config without loading it. The objects are parsed by the config parsers
+// and closed at once, nothing is registered and no service is listening. It reports the parse
+// errors, the names not resolved by the config, the duplicate names and the services listening
+// on the same port. The defaults of cfg are filled in by the parsers as by loading it.
+func Validate(cfg *config.Config) Errors {
+	if cfg == nil {
+		return nil
+	}
+	if logger.Default() == nil {
+		logger.SetDefault(xlogger.Nop())
+	}
+
+	v := &validator{
+		cfg:   cfg,
+		names: make(map[string]map[string]bool),
+	}
+	v.checkNames()
+	v.checkTLS()
+	v.checkObjects()
+	v.checkHops()
+	v.checkChains()
+	v.checkServices()
+	v.checkPorts()
+
+	return v.errs
+}
+
+type validator struct {
+	cfg *config.Config
+	// names are the names of the objects by kind.
+	names map[string]map[string]bool
+	errs  Errors
+}
+
+func (v *validator) errorf(path string, format string, args ...any) {
+	v.errs = append(v.errs, &Error{
+		Path: path,
+		Msg:  fmt.Sprintf(format, args...),
+	})
+}
+
+// checkNames collects the names of the objects and reports the missing and duplicate names.
+func (v *validator) checkNames() {
+	cfg := v.cfg
+	collect(v, "service", "services", cfg.Services)
+	collect(v, "chain", "chains", cfg.Chains)
+	collect(v, "hop", "hops", cfg.Hops)
+	collect(v, "auther", "authers", cfg.Authers)
+	collect(v, "admission", "admissions", cfg.Admissions)
+	collect(v, "bypass", "bypasses", cfg.Bypasses)
+	collect(v, "resolver", "resolvers", cfg.Resolvers)
+	collect(v, "hosts", "hosts", cfg.Hosts)
+	collect(v, "ingress", "ingresses", cfg.Ingresses)
+	collect(v, "router", "routers", cfg.Routers)
+	collect(v, "decision", "decisions", cfg.Decisions)
+	collect(v, "sd", "sds", cfg.SDs)
+	collect(v, "recorder", "recorders", cfg.Recorders)
+	collect(v, "limiter", "limiters", cfg.Limiters)
+	collect(v, "climiter", "climiters", cfg.CLimiters)
+	collect(v, "rlimiter", "rlimiters", cfg.RLimiters)
+	collect(v, "observer", "observers", cfg.Observers)
+	collect(v, "logger", "loggers", cfg.Loggers)
+}
+
+// collect collects the names of the objects of a kind at $.<field>[i].
+func collect[C any](v *validator, kind, field string, cfgs []*C) {
+	names := make(map[string]bool)
+	v.names[kind] = names
+
+	for i, cfg := range cfgs {
+		if cfg == nil {
+			continue
+		}
+		name := configName(cfg)
+		path := fmt.Sprintf("$.%s[%d].name", field, i)
+		switch {
+		case name == "":
+			v.errorf(path, "%s name is required", kind)
+		case names[name]:
+			v.errorf(path, "duplicate %s name %s", kind, name)
+		default:
+			names[name] = true
+		}
+	}
+}
+
+// ref reports the name not resolved to an object of the kind.
+func (v *validator) ref(path, kind, name string) {
+	if name != "" && !v.names[kind][name] {
+		v.errorf(path, "%s %s not found", kind, name)
+	}
+}
+
+func (v *validator) refs(path, kind, name string, names []string) {
+	v.ref(path+"."+kind, kind, name)
+	for i, s := range names {
+		v.ref(fmt.Sprintf("%s.%s[%d]", path, plural(kind), i), kind, s)
+	}
+}
+
+func (v *validator) checkTLS() {
+	tc := v.cfg.TLS
+	if tc == nil || (tc.CertFile == "" && tc.KeyFile == "") {
+		return
+	}
+	if _, err := tls_util.LoadDefaultConfig(tc.CertFile, tc.KeyFile, tc.CAFile); err != nil {
+		v.errorf("$.tls", "%v", err)
+	}
+}
+
+// checkObjects parses the objects which do not refer to other objects.
+func (v *validator) checkObjects() {
+	cfg := v.cfg
+	for _, c := range cfg.Authers {
+		release(auth_parser.ParseAuther(c))
+	}
+	for _, c := range cfg.Admissions {
+		release(admission_parser.ParseAdmission(c))
+	}
+	for _, c := range cfg.Bypasses {
+		release(bypass_parser.ParseBypass(c))
+	}
+	for i, c := range cfg.Resolvers {
+		r, err := resolver_parser.ParseResolver(c)
+		if err != nil {
+			v.errorf(fmt.Sprintf("$.resolvers[%d]", i), "%v", err)
+		}
+		release(r)
+	}
+	for _, c := range cfg.Hosts {
+		release(hosts_parser.ParseHostMapper(c))
+	}
+	for _, c := range cfg.Ingresses {
+		release(ingress_parser.ParseIngress(c))
+	}
+	for _, c := range cfg.Routers {
+		release(router_parser.ParseRouter(c))
+	}
+	for _, c := range cfg.Decisions {
+		release(decision_parser.ParseDecision(c))
+	}
+	for _, c := range cfg.SDs {
+		release(sd_parser.ParseSD(c))
+	}
+	for _, c := range cfg.Observers {
+		release(observer_parser.ParseObserver(c))
+	}
+	for _, c := range cfg.Limiters {
+		release(limiter_parser.ParseTrafficLimiter(c))
+	}
+	for _, c := range cfg.CLimiters {
+		release(limiter_parser.ParseConnLimiter(c))
+	}
+	for _, c := range cfg.RLimiters {
+		release(limiter_parser.ParseRateLimiter(c))
+	}
+	// the recorders and loggers writing to files are not parsed, they would create the files,
+	// the directories of the files are checked instead.
+	for i, c := range cfg.Recorders {
+		if c == nil {
+			continue
+		}
+		if c.File != nil && c.File.Path != "" {
+			v.checkFile(fmt.Sprintf("$.recorders[%d].file.path", i), c.File.Path)
+			continue
+		}
+		release(recorder_parser.ParseRecorder(c))
+	}
+	for i, c := range cfg.Loggers {
+		if c == nil || c.Log == nil {
+			continue
+		}
+		lc := *c
+		if !isStdOutput(c.Log.Output) {
+			v.checkFile(fmt.Sprintf("$.loggers[%d].log.output", i), c.Log.Output)
+			log := *c.Log
+			log.Output = "none"
+			lc.Log = &log
+		}
+		release(logger_parser.ParseLogger(&lc))
+	}
+}
+
+// checkFile reports the file path whose directory does not exist.
+func (v *validator) checkFile(path string, file string) {
+	fi, err := os.Stat(filepath.Dir(file))
+	if err != nil {
+		v.errorf(path, "%v", err)
+		return
+	}
+	if !fi.IsDir() {
+		v.errorf(path, "%s is not a directory", filepath.Dir(file))
+	}
+}
+
+func (v *validator) checkHops() {
+	for i, c := range v.cfg.Hops {
+		if c != nil {
+			v.checkHop(fmt.Sprintf("$.hops[%d]", i), c)
+		}
+	}
+}
+
+// checkHop checks the references of the hop and its nodes and parses it.
+func (v *validator) checkHop(path string, c *config.HopConfig) {
+	v.checkHopRefs(path, c)
+
+	if err := hop_parser.ValidateDNS(c); err != nil {
+		v.errorf(path+".dns", "%v", err)
+	}
+	h, err := hop_parser.ParseHop(inertHop(c), logger.Default())
+	if err != nil {
+		v.errorf(path, "%v", err)
+	}
+	release(h)
+}
+
+func (v *validator) checkHopRefs(path string, c *config.HopConfig) {
+	v.refs(path, "bypass", c.Bypass, c.Bypasses)
+	v.ref(path+".resolver", "resolver", c.Resolver)
+	v.ref(path+".hosts", "hosts", c.Hosts)
+
+	nodes := make(map[string]bool)
+	for i, node := range c.Nodes {
+		if node == nil {
+			continue
+		}
+		np := fmt.Sprintf("%s.nodes[%d]", path, i)
+		if node.Name != "" {
+			if nodes[node.Name] {
+				v.errorf(np+".name", "duplicate node name %s", node.Name)
+			}
+			nodes[node.Name] = true
+		}
+		v.refs(np, "bypass", node.Bypass, node.Bypasses)
+		v.ref(np+".resolver", "resolver", node.Resolver)
+		v.ref(np+".hosts", "hosts", node.Hosts)
+	}
+}
+
+// inertHop returns a copy of the hop config without the parts running in the background:
+// the health check, the node discovery and the connection pools of the nodes. The health checker
+// is kept by the hop name, the one of the loaded hop must not be replaced. The discovery is
+// checked by hop_parser.ValidateDNS instead.
+func inertHop(c *config.HopConfig) *config.HopConfig {
+	hc := *c
+	hc.HealthCheck = nil
+	hc.DNS = nil
+	if c.Nodes != nil {
+		hc.Nodes = make([]*config.NodeConfig, 0, len(c.Nodes))
+	}
+	for _, node := range c.Nodes {
+		if node != nil {
+			node = withoutPool(node)
+		}
+		hc.Nodes = append(hc.Nodes, node)
+	}
+	return &hc
+}
+
+// withoutPool returns a copy of the node config without the connection pool metadata.
+func withoutPool(c *config.NodeConfig) *config.NodeConfig {
+	nc := *c
+	if c.Metadata == nil {
+		return &nc
+	}
+	nc.Metadata = make(map[string]any, len(c.Metadata))
+	for k, v := range c.Metadata {
+		if strings.EqualFold(k, parsing.MDKeyPoolSize) ||
+			strings.EqualFold(k, parsing.MDKeyPoolMaxAge) ||
+			strings.EqualFold(k, parsing.MDKeyPoolIdleTimeout) {
+			continue
+		}
+		nc.Metadata[k] = v
+	}
+	return &nc
+}
+
+func (v *validator) checkChains() {
+	for i, c := range v.cfg.Chains {
+		if c == nil {
+			continue
+		}
+		path := fmt.Sprintf("$.chains[%d]", i)
+
+		cc := *c
+		cc.Hops = nil
+		for j, hc := range c.Hops {
+			if hc == nil {
+				continue
+			}
+			hp := fmt.Sprintf("%s.hops[%d]", path, j)
+			// an inline hop as the chain parser does, otherwise a reference by name.
+			if hc.Nodes != nil || hc.Plugin != nil {
+				v.checkHopRefs(hp, hc)
+				if err := hop_parser.ValidateDNS(hc); err != nil {
+					v.errorf(hp+".dns", "%v", err)
+				}
+				hc = inertHop(hc)
+			} else {
+				v.ref(hp+".name", "hop", hc.Name)
+			}
+			cc.Hops = append(cc.Hops, hc)
+		}
+
+		ch, err := chain_parser.ParseChain(&cc, logger.Default())
+		if err != nil {
+			v.errorf(path, "%v", err)
+		}
+		release(ch)
+	}
+}
+
+func (v *validator) checkServices() {
+	for i, c := range v.cfg.Services {
+		if c != nil {
+			v.checkService(fmt.Sprintf("$.services[%d]", i), c)
+		}
+	}
+}
+
+// checkService checks the references and the settings of the service. The service is not
+// parsed as a whole, since its listener would listen, only its handler is.
+func (v *validator) checkService(path string, c *config.ServiceConfig) {
+	v.refs(path, "admission", c.Admission, c.Admissions)
+	v.refs(path, "bypass", c.Bypass, c.Bypasses)
+	v.ref(path+".resolver", "resolver", c.Resolver)
+	v.ref(path+".hosts", "hosts", c.Hosts)
+	v.ref(path+".limiter", "limiter", c.Limiter)
+	v.ref(path+".climiter", "climiter", c.CLimiter)
+	v.ref(path+".rlimiter", "rlimiter", c.RLimiter)
+	v.refs(path, "logger", c.Logger, c.Loggers)
+	v.ref(path+".observer", "observer", c.Observer)
+	for i, r := range c.Recorders {
+		if r != nil {
+			v.ref(fmt.Sprintf("%s.recorders[%d].name", path, i), "recorder", r.Name)
+		}
+	}
+
+	if ln := c.Listener; ln != nil {
+		lp := path + ".listener"
+		if t := strings.TrimSpace(ln.Type); t != "" && !registry.ListenerRegistry().IsRegistered(t) {
+			v.errorf(lp+".type", "unknown listener %s", t)
+		}
+		v.ref(lp+".chain", "chain", ln.Chain)
+		v.checkChainGroup(lp+".chainGroup", ln.ChainGroup)
+		v.refs(lp, "auther", ln.Auther, ln.Authers)
+		v.checkServerTLS(lp+".tls", ln.TLS)
+	}
+
+	if h := c.Handler; h != nil {
+		hp := path + ".handler"
+		parse := true
+		if t := strings.TrimSpace(h.Type); t != "" && !registry.HandlerRegistry().IsRegistered(t) {
+			v.errorf(hp+".type", "unknown handler %s", t)
+			parse = false
+		}
+		v.ref(hp+".chain", "chain", h.Chain)
+		v.checkChainGroup(hp+".chainGroup", h.ChainGroup)
+		v.refs(hp, "auther", h.Auther, h.Authers)
+		v.ref(hp+".limiter", "limiter", h.Limiter)
+		v.ref(hp+".observer", "observer", h.Observer)
+		if !v.checkServerTLS(hp+".tls", h.TLS) {
+			parse = false
+		}
+
+		// the handler metadata are parsed, the handlers listening on Init only check them.
+		if parse {
+			if err := service_parser.ValidateHandler(c); err != nil {
+				v.errorf(hp, "%v", err)
+			}
+		}
+	}
+
+	if f := c.Forwarder; f != nil {
+		fp := path + ".forwarder"
+		switch {
+		case f.Hop != "":
+			v.ref(fp+".hop", "hop", f.Hop)
+		case f.Name != "":
+			v.ref(fp+".name", "hop", f.Name)
+		default:
+			for i, node := range f.Nodes {
+				if node != nil {
+					v.refs(fmt.Sprintf("%s.nodes[%d]", fp, i), "bypass", node.Bypass, node.Bypasses)
+				}
+			}
+			h, err := service_parser.ParseForwarder(f, logger.Default())
+			if err != nil {
+				v.errorf(fp, "%v", err)
+			}
+			release(h)
+		}
+	}
+}
+
+func (v *validator) checkChainGroup(path string, cg *config.ChainGroupConfig) {
+	if cg == nil {
+		return
+	}
+	for i, name := range cg.Chains {
+		v.ref(fmt.Sprintf("%s.chains[%d]", path, i), "chain", name)
+	}
+}
+
+// checkServerTLS reports whether the TLS settings are valid.
+func (v *validator) checkServerTLS(path string, tc *config.TLSConfig) bool {
+	if tc == nil {
+		return true
+	}
+	if _, err := tls_util.LoadServerConfig(tc); err != nil {
+		v.errorf(path, "%v", err)
+		return false
+	}
+	return true
+}
+
+// checkPorts reports the services listening on the same port of the same network,
+// if their hosts are the same or either of them listens on all the addresses.
+func (v *validator) checkPorts() {
+	type binding struct {
+		path    string
+		name    string
+		network string
+		host    string
+		port    string
+	}
+
+	var bindings []binding
+	for i, c := range v.cfg.Services {
+		if c == nil {
+			continue
+		}
+		network := listenNetwork(c)
+		if network == "" {
+			continue
+		}
+		host, port, err := net.SplitHostPort(c.Addr)
+		if err != nil || port == "" || port == "0" {
+			continue
+		}
+		b := binding{
+			path:    fmt.Sprintf("$.services[%d].addr", i),
+			name:    c.Name,
+			network: network,
+			host:    host,
+			port:    port,
+		}
+		for _, o := range bindings {
+			if o.network != b.network || o.port != b.port {
+				continue
+			}
+			if o.host == b.host || isUnspecified(o.host) || isUnspecified(b.host) {
+				v.errorf(b.path, "%s/%s conflicts with service %s", c.Addr, network, o.name)
+				break
+			}
+		}
+		bindings = append(bindings, b)
+	}
+}
+
+// listenNetwork returns the network of the local port the service listens on,
+// or an empty string if the listener does not listen on a local port.
+func listenNetwork(c *config.ServiceConfig) string {
+	t := "tcp"
+	var md map[string]any
+	if c.Listener != nil {
+		if s := strings.TrimSpace(c.Listener.Type); s != "" {
+			t = s
+		}
+		md = c.Listener.Metadata
+	}
+
+	switch t {
+	case "rtcp", "rudp", "tun", "tap", "tungo", "serial", "unix", "icmp", "icmp6":
+		return ""
+	case "udp", "kcp", "quic", "http3", "h3", "wt", "dtls", "redu":
+		return "udp"
+	case "dns":
+		switch strings.ToLower(mdutil.GetString(metadata.NewMetadata(md), "mode")) {
+		case "tcp", "tls", "https":
+			return "tcp"
+		}
+		return "udp"
+	default:
+		return "tcp"
+	}
+}
+
+func isUnspecified(host string) bool {
+	if host == "" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsUnspecified()
+}
+
+func configName(cfg any) string {
+	v := reflect.Indirect(reflect.ValueOf(cfg))
+	if v.Kind() != reflect.Struct {
+		return ""
+	}
+	if f := v.FieldByName("Name"); f.Kind() == reflect.String {
+		return f.String()
+	}
+	return ""
+}
+
+func isStdOutput(output string) bool {
+	switch output {
+	case "", "none", "null", "stdout", "stderr":
+		return true
+	}
+	return false
+}
+
+// release closes the parsed object, such as its background reloading.
+func release(v any) {
+	if closer, ok := v.(io.Closer); ok {
+		closer.Close()
+	}
+}
+
+func plural(kind string) string {
+	switch kind {
+	case "bypass":
+		return "bypasses"
+	default:
+		return kind + "s"
+	}
+}
@@ -0,0 +1,203 @@
+package validator
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/go-gost/core/connector"
+	"github.com/go-gost/core/dialer"
+	"github.com/go-gost/core/handler"
+	"github.com/go-gost/core/metadata"
+	"github.com/go-gost/x/config"
+	_ "github.com/go-gost/x/handler/tun"
+	_ "github.com/go-gost/x/listener/tcp"
+	"github.com/go-gost/x/registry"
+)
+
+type testHandler struct{}
+
+func (h *testHandler) Init(metadata.Metadata) error { return nil }
+
+func (h *testHandler) Handle(ctx context.Context, conn net.Conn, opts ...handler.HandleOption) error {
+	return conn.Close()
+}
+
+type testConnector struct{}
+
+func (c *testConnector) Init(metadata.Metadata) error { return nil }
+
+func (c *testConnector) Connect(ctx context.Context, conn net.Conn, network, address string, opts ...connector.ConnectOption) (net.Conn, error) {
+	return conn, nil
+}
+
+type testDialer struct{}
+
+func (d *testDialer) Init(metadata.Metadata) error { return nil }
+
+func (d *testDialer) Dial(ctx context.Context, addr string, opts ...dialer.DialOption) (net.Conn, error) {
+	return nil, net.ErrClosed
+}
+
+func init() {
+	registry.HandlerRegistry().Register("validatortest", func(opts ...handler.Option) handler.Handler { return &testHandler{} })
+	registry.ConnectorRegistry().Register("validatortest", func(opts ...connector.Option) connector.Connector { return &testConnector{} })
+	registry.DialerRegistry().Register("validatortest", func(opts ...dialer.Option) dialer.Dialer { return &testDialer{} })
+}
+
+func testNode(name string) *config.NodeConfig {
+	return &config.NodeConfig{
+		Name:      name,
+		Addr:      "127.0.0.1:1080",
+		Connector: &config.ConnectorConfig{Type: "validatortest"},
+		Dialer:    &config.DialerConfig{Type: "validatortest"},
+	}
+}
+
+func testService(name, addr string) *config.ServiceConfig {
+	return &config.ServiceConfig{
+		Name:     name,
+		Addr:     addr,
+		Handler:  &config.HandlerConfig{Type: "validatortest", Chain: "chain-0"},
+		Listener: &config.ListenerConfig{Type: "tcp"},
+	}
+}
+
+func testConfig() *config.Config {
+	return &config.Config{
+		Services: []*config.ServiceConfig{
+			testService("service-0", ":8080"),
+			testService("service-1", "127.0.0.1:8081"),
+		},
+		Chains: []*config.ChainConfig{
+			{
+				Name: "chain-0",
+				Hops: []*config.HopConfig{
+					{Name: "hop-0"},
+					{Name: "hop-1", Nodes: []*config.NodeConfig{testNode("node-0")}},
+				},
+			},
+		},
+		Hops: []*config.HopConfig{
+			{Name: "hop-0", Bypass: "bypass-0", Nodes: []*config.NodeConfig{testNode("node-0"), testNode("node-1")}},
+		},
+		Bypasses: []*config.BypassConfig{
+			{Name: "bypass-0", Matchers: []string{"example.com"}},
+		},
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if errs := Validate(testConfig()); errs != nil {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	cfg := testConfig()
+	cfg.Services = append(cfg.Services,
+		testService("service-1", "127.0.0.1:8080"),
+		testService("service-3", "127.0.0.2:8081"),
+	)
+	cfg.Services[1].Handler.Type = "unknown"
+	cfg.Services[1].Bypasses = []string{"bypass-0", "bypass-1"}
+	cfg.Services[3].Handler.Chain = "chain-1"
+	cfg.Chains[0].Hops[0].Name = "hop-2"
+	cfg.Hops[0].Nodes[1].Name = "node-0"
+	cfg.Hops[0].Nodes[1].Dialer.Type = "unknown"
+
+	var errs []string
+	for _, e := range Validate(cfg) {
+		errs = append(errs, e.Path)
+	}
+	sort.Strings(errs)
+
+	expected := []string{
+		"$.chains[0].hops[0].name",
+		"$.hops[0]",
+		"$.hops[0].nodes[1].name",
+		"$.services[1].bypasses[1]",
+		"$.services[1].handler.type",
+		"$.services[2].addr",
+		"$.services[2].name",
+		"$.services[3].handler.chain",
+	}
+	if len(errs) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, errs)
+	}
+	for i := range expected {
+		if errs[i] != expected[i] {
+			t.Fatalf("expected %v, got %v", expected, errs)
+		}
+	}
+}
+
+func TestValidate_Handler(t *testing.T) {
+	cfg := testConfig()
+	cfg.Services[0].Handler = &config.HandlerConfig{
+		Type:     "tun",
+		Metadata: map[string]any{"tun.ipam.pool": "10.0.0.0/33"},
+	}
+	cfg.Services[1].Handler.Metadata = map[string]any{"tun.ipam.pool": "10.0.0.0/33"}
+	cfg.Recorders = []*config.RecorderConfig{
+		{Name: "recorder-0", File: &config.FileRecorder{Path: filepath.Join(t.TempDir(), "missing", "recorder.log")}},
+	}
+
+	var errs []string
+	for _, e := range Validate(cfg) {
+		errs = append(errs, e.Path)
+	}
+	sort.Strings(errs)
+
+	// the metadata of the validatortest handler are not checked by it.
+	expected := []string{
+		"$.recorders[0].file.path",
+		"$.services[0].handler",
+	}
+	if len(errs) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, errs)
+	}
+	for i := range expected {
+		if errs[i] != expected[i] {
+			t.Fatalf("expected %v, got %v", expected, errs)
+		}
+	}
+}
+
+func TestValidate_Hop(t *testing.T) {
+	cfg := testConfig()
+	cfg.Hops[0].Nodes[0].Metadata = map[string]any{"pool.size": 2}
+	cfg.Hops[0].DNS = &config.DNSLoader{Name: "example.com", Type: "a"}
+	cfg.Chains[0].Hops[1].DNS = &config.DNSLoader{Name: "example.com", Type: "txt"}
+
+	var errs []string
+	for _, e := range Validate(cfg) {
+		errs = append(errs, e.Path)
+	}
+	sort.Strings(errs)
+
+	// the A records need a port, the TXT records are not supported.
+	expected := []string{
+		"$.chains[0].hops[1].dns",
+		"$.hops[0].dns",
+	}
+	if len(errs) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, errs)
+	}
+	for i := range expected {
+		if errs[i] != expected[i] {
+			t.Fatalf("expected %v, got %v", expected, errs)
+		}
+	}
+
+	// the discovery and the pools are stripped from the parsed copies.
+	if cfg.Hops[0].DNS.Node != nil {
+		t.Fatal("the node template of the discovery is filled in")
+	}
+	if cfg.Hops[0].Nodes[0].Metadata["pool.size"] != 2 {
+		t.Fatal("the pool metadata of the node is changed")
+	}
+	if got := inertHop(cfg.Hops[0]).Nodes[0].Metadata; len(got) != 0 {
+		t.Fatalf("expected no pool metadata, got %v", got)
+	}
+}
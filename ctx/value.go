@@ -90,3 +90,15 @@ func ClientIDFromContext(ctx context.Context) ClientID {
 	v, _ := ctx.Value(clientIDKey{}).(ClientID)
 	return v
 }
+
+// targetKey saves the target host of the connection being routed.
+type targetKey struct{}
+
+func ContextWithTarget(ctx context.Context, host string) context.Context {
+	return context.WithValue(ctx, targetKey{}, host)
+}
+
+func TargetFromContext(ctx context.Context) string {
+	v, _ := ctx.Value(targetKey{}).(string)
+	return v
+}
@@ -0,0 +1,365 @@
+// Package decision implements a rule based traffic DecisionEvaluator for the tun and tungo handlers.
+package decision
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	avdecision "github.com/AeroCore-IO/avionics/pkg/decision"
+	"github.com/go-gost/core/logger"
+	"github.com/go-gost/x/internal/loader"
+	xlogger "github.com/go-gost/x/logger"
+	"gopkg.in/yaml.v3"
+)
+
+// versions is shared by all evaluators, so that the rules version changes
+// when an evaluator is replaced by another one.
+var versions atomic.Uint64
+
+type options struct {
+	rules       []*Rule
+	fileLoader  loader.Loader
+	redisLoader loader.Loader
+	httpLoader  loader.Loader
+	period      time.Duration
+	logger      logger.Logger
+}
+
+type Option func(opts *options)
+
+func RulesOption(rules []*Rule) Option {
+	return func(opts *options) {
+		opts.rules = rules
+	}
+}
+
+func ReloadPeriodOption(period time.Duration) Option {
+	return func(opts *options) {
+		opts.period = period
+	}
+}
+
+func FileLoaderOption(fileLoader loader.Loader) Option {
+	return func(opts *options) {
+		opts.fileLoader = fileLoader
+	}
+}
+
+func RedisLoaderOption(redisLoader loader.Loader) Option {
+	return func(opts *options) {
+		opts.redisLoader = redisLoader
+	}
+}
+
+func HTTPLoaderOption(httpLoader loader.Loader) Option {
+	return func(opts *options) {
+		opts.httpLoader = httpLoader
+	}
+}
+
+func LoggerOption(logger logger.Logger) Option {
+	return func(opts *options) {
+		opts.logger = logger
+	}
+}
+
+// Evaluator is a DecisionEvaluator evaluating an ordered list of rules,
+// the first matching rule makes the decision.
+type Evaluator struct {
+	rules      []*compiledRule
+	version    uint64
+	cancelFunc context.CancelFunc
+	options    options
+	mu         sync.RWMutex
+}
+
+// NewEvaluator creates and initializes a new rule based Evaluator.
+func NewEvaluator(opts ...Option) *Evaluator {
+	var options options
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if options.logger == nil {
+		options.logger = xlogger.Nop()
+	}
+
+	ctx, cancel := context.WithCancel(context.TODO())
+
+	e := &Evaluator{
+		cancelFunc: cancel,
+		options:    options,
+	}
+
+	if err := e.reload(ctx); err != nil {
+		options.logger.Warnf("reload: %v", err)
+	}
+	if e.options.period > 0 {
+		go e.periodReload(ctx)
+	}
+
+	return e
+}
+
+func (e *Evaluator) periodReload(ctx context.Context) error {
+	period := e.options.period
+	if period < time.Second {
+		period = time.Second
+	}
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := e.reload(ctx); err != nil {
+				e.options.logger.Warnf("reload: %v", err)
+				// return err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (e *Evaluator) reload(ctx context.Context) error {
+	rules := append([]*Rule{}, e.options.rules...)
+
+	v, err := e.load(ctx)
+	if err != nil {
+		return err
+	}
+	rules = append(rules, v...)
+
+	var compiled []*compiledRule
+	names := make(map[string]struct{})
+	for i, rule := range rules {
+		if rule == nil {
+			continue
+		}
+		cr, err := compileRule(rule, i)
+		if err != nil {
+			e.options.logger.Warnf("rule %d: %v", i, err)
+			continue
+		}
+		// the decisions refer to the rules by name, it must identify a single rule.
+		if _, ok := names[cr.name]; ok {
+			e.options.logger.Warnf("rule %d: duplicate rule name %s", i, cr.name)
+			continue
+		}
+		names[cr.name] = struct{}{}
+		compiled = append(compiled, cr)
+	}
+
+	e.options.logger.Debugf("load items %d", len(compiled))
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.version > 0 && equalRules(e.rules, compiled) {
+		return nil
+	}
+	e.rules = compiled
+	e.version = versions.Add(1)
+
+	return nil
+}
+
+func (e *Evaluator) load(ctx context.Context) (rules []*Rule, err error) {
+	if e.options.fileLoader != nil {
+		r, er := e.options.fileLoader.Load(ctx)
+		if er != nil {
+			e.options.logger.Warnf("file loader: %v", er)
+		}
+		if v, er := e.parseRules(r); er != nil {
+			e.options.logger.Warnf("file loader: %v", er)
+		} else {
+			rules = append(rules, v...)
+		}
+	}
+	if e.options.redisLoader != nil {
+		if lister, ok := e.options.redisLoader.(loader.Lister); ok {
+			list, er := lister.List(ctx)
+			if er != nil {
+				e.options.logger.Warnf("redis loader: %v", er)
+			}
+			for _, s := range list {
+				rule := &Rule{}
+				if er := yaml.Unmarshal([]byte(s), rule); er != nil {
+					e.options.logger.Warnf("redis loader: %v", er)
+					continue
+				}
+				rules = append(rules, rule)
+			}
+		} else {
+			r, er := e.options.redisLoader.Load(ctx)
+			if er != nil {
+				e.options.logger.Warnf("redis loader: %v", er)
+			}
+			if v, er := e.parseRules(r); er != nil {
+				e.options.logger.Warnf("redis loader: %v", er)
+			} else {
+				rules = append(rules, v...)
+			}
+		}
+	}
+	if e.options.httpLoader != nil {
+		r, er := e.options.httpLoader.Load(ctx)
+		if er != nil {
+			e.options.logger.Warnf("http loader: %v", er)
+		}
+		if v, er := e.parseRules(r); er != nil {
+			e.options.logger.Warnf("http loader: %v", er)
+		} else {
+			rules = append(rules, v...)
+		}
+	}
+
+	return
+}
+
+// parseRules parses a YAML (or JSON) document, which is either a list of rules
+// or an object with a rules field.
+func (e *Evaluator) parseRules(r io.Reader) (rules []*Rule, err error) {
+	if r == nil {
+		return
+	}
+
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return
+	}
+	if len(bytes.TrimSpace(b)) == 0 {
+		return
+	}
+
+	if err = yaml.Unmarshal(b, &rules); err == nil {
+		return
+	}
+
+	var doc struct {
+		Rules []*Rule `yaml:"rules"`
+	}
+	if err = yaml.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+	return doc.Rules, nil
+}
+
+// CheckTrafficRules returns the decision of the first rule matching input,
+// or nil if no rule matches.
+func (e *Evaluator) CheckTrafficRules(input avdecision.RuleInput) *avdecision.TrafficDecision {
+	if e == nil {
+		return nil
+	}
+
+	e.mu.RLock()
+	rules := e.rules
+	e.mu.RUnlock()
+
+	for _, rule := range rules {
+		if rule.Match(&input) {
+			return &avdecision.TrafficDecision{
+				Action:   rule.action,
+				RuleName: rule.name,
+			}
+		}
+	}
+	return nil
+}
+
+// ResolveMetadata does not resolve any metadata, the flows are attributed by the handler.
+func (e *Evaluator) ResolveMetadata(srcIP, dstIP string, srcPort, dstPort int, proto string) (appID string, hostname string) {
+	return
+}
+
+// ProxyHost returns the proxy host of the rule which made the decision d,
+// or an empty string if the rule has been removed or changed by a reload.
+func (e *Evaluator) ProxyHost(d *avdecision.TrafficDecision) string {
+	if rule := e.ruleOf(d); rule != nil {
+		return rule.proxy
+	}
+	return ""
+}
+
+// BandwidthClass returns the bandwidth class of the rule which made the decision d.
+func (e *Evaluator) BandwidthClass(d *avdecision.TrafficDecision) string {
+	if rule := e.ruleOf(d); rule != nil {
+		return rule.class
+	}
+	return ""
+}
+
+// RuleID returns the name of the rule which made the decision d, the rule names are unique.
+func (e *Evaluator) RuleID(d *avdecision.TrafficDecision) string {
+	if rule := e.ruleOf(d); rule != nil {
+		return rule.name
+	}
+	return ""
+}
+
+// ruleOf returns the rule which made the decision d, nil if the rule has been removed or changed by a reload.
+func (e *Evaluator) ruleOf(d *avdecision.TrafficDecision) *compiledRule {
+	if e == nil || d == nil {
+		return nil
+	}
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	for _, rule := range e.rules {
+		if rule.name == d.RuleName {
+			if rule.action != d.Action {
+				return nil
+			}
+			return rule
+		}
+	}
+	return nil
+}
+
+// RulesVersion returns the rules generation, it changes each time a reload updates the rules.
+func (e *Evaluator) RulesVersion() uint64 {
+	if e == nil {
+		return 0
+	}
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.version
+}
+
+func (e *Evaluator) Close() error {
+	e.cancelFunc()
+	if e.options.fileLoader != nil {
+		e.options.fileLoader.Close()
+	}
+	if e.options.redisLoader != nil {
+		e.options.redisLoader.Close()
+	}
+	if e.options.httpLoader != nil {
+		e.options.httpLoader.Close()
+	}
+	return nil
+}
+
+func equalRules(a, b []*compiledRule) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].source != b[i].source || a[i].name != b[i].name {
+			return false
+		}
+	}
+	return true
+}
+
+func ruleSource(r *Rule) string {
+	b, _ := yaml.Marshal(r)
+	return strings.TrimSpace(string(b))
+}
@@ -0,0 +1,174 @@
+package decision
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	avdecision "github.com/AeroCore-IO/avionics/pkg/decision"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testRules = `
+rules:
+- name: block-ads
+  domains: ["*.ads.example.com"]
+  action: block
+- name: games
+  appIDs: ["570", "730"]
+  protocol: udp
+  ports: ["27000-27100"]
+  action: proxy
+  proxy: relay.example.com
+  class: games
+- domains: [example.com]
+  action: proxy
+- cidrs: [10.0.0.0/8, 192.168.1.1]
+  action: direct
+`
+
+func TestEvaluator_CheckTrafficRules(t *testing.T) {
+	e := NewEvaluator()
+	defer e.Close()
+
+	rules, err := e.parseRules(strings.NewReader(testRules))
+	require.NoError(t, err)
+	require.Len(t, rules, 4)
+
+	e.options.rules = rules
+	require.NoError(t, e.reload(context.Background()))
+
+	testCases := []struct {
+		desc   string
+		input  avdecision.RuleInput
+		rule   string
+		action avdecision.Action
+		proxy  string
+	}{
+		{
+			desc:   "wildcard domain, first rule wins",
+			input:  avdecision.RuleInput{DestDomain: "x.ads.example.com", Protocol: "TCP", DestPort: 443},
+			rule:   "block-ads",
+			action: avdecision.ActionBlock,
+		},
+		{
+			desc:   "app, protocol and port range",
+			input:  avdecision.RuleInput{SteamAppID: "730", Protocol: "UDP", DestPort: 27015, DestHost: "1.2.3.4"},
+			rule:   "games",
+			action: avdecision.ActionProxy,
+			proxy:  "relay.example.com",
+		},
+		{
+			desc:  "port out of range",
+			input: avdecision.RuleInput{SteamAppID: "730", Protocol: "UDP", DestPort: 443, DestHost: "1.2.3.4"},
+			rule:  "",
+		},
+		{
+			desc:   "domain suffix",
+			input:  avdecision.RuleInput{DestDomain: "www.example.com.", Protocol: "TCP", DestPort: 443},
+			rule:   "rule-2",
+			action: avdecision.ActionProxy,
+		},
+		{
+			desc:  "domain suffix does not match other domains",
+			input: avdecision.RuleInput{DestDomain: "notexample.com", Protocol: "TCP", DestPort: 443},
+			rule:  "",
+		},
+		{
+			desc:   "cidr",
+			input:  avdecision.RuleInput{DestHost: "10.1.2.3", Protocol: "TCP", DestPort: 80},
+			rule:   "rule-3",
+			action: avdecision.ActionDirect,
+		},
+		{
+			desc:   "single ip",
+			input:  avdecision.RuleInput{DestHost: "192.168.1.1", Protocol: "TCP", DestPort: 80},
+			rule:   "rule-3",
+			action: avdecision.ActionDirect,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			d := e.CheckTrafficRules(tc.input)
+			if tc.rule == "" {
+				assert.Nil(t, d)
+				return
+			}
+			require.NotNil(t, d)
+			assert.Equal(t, tc.rule, d.RuleName)
+			assert.Equal(t, tc.action, d.Action)
+			assert.Equal(t, tc.proxy, e.ProxyHost(d))
+		})
+	}
+}
+
+func TestEvaluator_RuleMetadata(t *testing.T) {
+	e := NewEvaluator()
+	defer e.Close()
+
+	rules, err := e.parseRules(strings.NewReader(testRules))
+	require.NoError(t, err)
+	e.options.rules = rules
+	require.NoError(t, e.reload(context.Background()))
+
+	d := e.CheckTrafficRules(avdecision.RuleInput{SteamAppID: "570", Protocol: "UDP", DestPort: 27015})
+	require.NotNil(t, d)
+	assert.Equal(t, "games", e.BandwidthClass(d))
+	assert.Equal(t, "games", e.RuleID(d))
+
+	d = e.CheckTrafficRules(avdecision.RuleInput{DestDomain: "example.com"})
+	require.NotNil(t, d)
+	assert.Empty(t, e.BandwidthClass(d))
+	assert.Equal(t, "rule-2", e.RuleID(d))
+}
+
+func TestEvaluator_DuplicateNames(t *testing.T) {
+	e := NewEvaluator(RulesOption([]*Rule{
+		{Name: "rule-1", Domains: []string{"a.example.com"}, Action: "proxy", Proxy: "a.relay.example.com"},
+		{Domains: []string{"b.example.com"}, Action: "proxy", Proxy: "b.relay.example.com"},
+		{Name: "rule-1", Domains: []string{"c.example.com"}, Action: "proxy", Proxy: "c.relay.example.com"},
+	}))
+	defer e.Close()
+
+	// the unnamed rule and the second rule-1 collide with the first rule-1.
+	assert.Nil(t, e.CheckTrafficRules(avdecision.RuleInput{DestDomain: "b.example.com"}))
+	assert.Nil(t, e.CheckTrafficRules(avdecision.RuleInput{DestDomain: "c.example.com"}))
+
+	d := e.CheckTrafficRules(avdecision.RuleInput{DestDomain: "a.example.com"})
+	require.NotNil(t, d)
+	assert.Equal(t, "a.relay.example.com", e.ProxyHost(d))
+
+	// the rule changed by a reload does not give its proxy to the previous decision.
+	e.options.rules = []*Rule{{Name: "rule-1", Domains: []string{"a.example.com"}, Action: "direct", Proxy: "x.relay.example.com"}}
+	require.NoError(t, e.reload(context.Background()))
+	assert.Empty(t, e.ProxyHost(d))
+}
+
+func TestEvaluator_RulesVersion(t *testing.T) {
+	e := NewEvaluator(RulesOption([]*Rule{{Domains: []string{"example.com"}, Action: "direct"}}))
+	defer e.Close()
+
+	v := e.RulesVersion()
+	require.NotZero(t, v)
+
+	require.NoError(t, e.reload(context.Background()))
+	assert.Equal(t, v, e.RulesVersion())
+
+	e.options.rules = append(e.options.rules, &Rule{CIDRs: []string{"10.0.0.0/8"}, Action: "block"})
+	require.NoError(t, e.reload(context.Background()))
+	assert.Greater(t, e.RulesVersion(), v)
+}
+
+func TestCompileRule_Invalid(t *testing.T) {
+	for _, r := range []*Rule{
+		{Action: "allow"},
+		{Action: "proxy", Protocol: "sctp"},
+		{Action: "proxy", CIDRs: []string{"10.0.0.0/33"}},
+		{Action: "proxy", Ports: []string{"http"}},
+	} {
+		_, err := compileRule(r, 0)
+		assert.Error(t, err, "%+v", r)
+	}
+}
@@ -0,0 +1,217 @@
+package decision
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	avdecision "github.com/AeroCore-IO/avionics/pkg/decision"
+	"github.com/go-gost/x/internal/matcher"
+	xnet "github.com/go-gost/x/internal/net"
+	"github.com/gobwas/glob"
+)
+
+// Rule is a traffic rule. The non-empty conditions of a rule must all match,
+// a condition with multiple values matches if any of them matches.
+type Rule struct {
+	Name string `yaml:",omitempty" json:"name,omitempty"`
+	// Domains are domain suffixes such as example.com, which matches example.com and its subdomains,
+	// or wildcards such as *.example.com.
+	Domains []string `yaml:",omitempty" json:"domains,omitempty"`
+	// CIDRs are destination IP addresses or networks.
+	CIDRs []string `yaml:"cidrs,omitempty" json:"cidrs,omitempty"`
+	// Ports are destination ports or port ranges such as 8000-9000.
+	Ports []string `yaml:",omitempty" json:"ports,omitempty"`
+	// Protocol is the L4 protocol, tcp, udp or icmp.
+	Protocol string `yaml:",omitempty" json:"protocol,omitempty"`
+	// AppIDs are application IDs or wildcards.
+	AppIDs []string `yaml:"appIDs,omitempty" json:"appIDs,omitempty"`
+	// Action is one of proxy, direct or block.
+	Action string `json:"action"`
+	// Proxy is the host dialed through the proxy for the proxy action, optional.
+	Proxy string `yaml:",omitempty" json:"proxy,omitempty"`
+	// Class is the bandwidth class of the matching flows, the name of a traffic limiter, optional.
+	Class string `yaml:",omitempty" json:"class,omitempty"`
+}
+
+type compiledRule struct {
+	// source is the canonical form of the rule, used to detect rules changes.
+	source   string
+	name     string
+	action   avdecision.Action
+	proxy    string
+	class    string
+	domains  []matcher.Matcher
+	cidrs    matcher.Matcher
+	ports    []*xnet.PortRange
+	protocol string
+	appIDs   []glob.Glob
+}
+
+func parseAction(s string) (avdecision.Action, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "proxy":
+		return avdecision.ActionProxy, nil
+	case "direct":
+		return avdecision.ActionDirect, nil
+	case "block", "reject":
+		return avdecision.ActionBlock, nil
+	default:
+		return "", fmt.Errorf("invalid action %q", s)
+	}
+}
+
+func compileRule(r *Rule, index int) (*compiledRule, error) {
+	action, err := parseAction(r.Action)
+	if err != nil {
+		return nil, err
+	}
+
+	cr := &compiledRule{
+		source:   ruleSource(r),
+		name:     r.Name,
+		action:   action,
+		proxy:    strings.TrimSpace(r.Proxy),
+		class:    strings.TrimSpace(r.Class),
+		protocol: strings.ToLower(strings.TrimSpace(r.Protocol)),
+	}
+	if cr.name == "" {
+		cr.name = fmt.Sprintf("rule-%d", index)
+	}
+
+	switch cr.protocol {
+	case "", "tcp", "udp", "icmp":
+	default:
+		return nil, fmt.Errorf("invalid protocol %q", r.Protocol)
+	}
+
+	var domains, wildcards []string
+	for _, domain := range r.Domains {
+		domain = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(domain), "."))
+		if domain == "" {
+			continue
+		}
+		if strings.ContainsAny(domain, "*?") {
+			if _, err := glob.Compile(domain); err != nil {
+				return nil, fmt.Errorf("invalid domain %q: %v", domain, err)
+			}
+			wildcards = append(wildcards, domain)
+			continue
+		}
+		if !strings.HasPrefix(domain, ".") {
+			domain = "." + domain
+		}
+		domains = append(domains, domain)
+	}
+	if len(domains) > 0 {
+		cr.domains = append(cr.domains, matcher.DomainMatcher(domains))
+	}
+	if len(wildcards) > 0 {
+		cr.domains = append(cr.domains, matcher.WildcardMatcher(wildcards))
+	}
+
+	var inets []*net.IPNet
+	for _, s := range r.CIDRs {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		if ip := net.ParseIP(s); ip != nil {
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			inets = append(inets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, inet, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cidr %q", s)
+		}
+		inets = append(inets, inet)
+	}
+	if len(inets) > 0 {
+		cr.cidrs = matcher.CIDRMatcher(inets)
+	}
+
+	for _, s := range r.Ports {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		pr := &xnet.PortRange{}
+		if err := pr.Parse(s); err != nil {
+			return nil, fmt.Errorf("invalid port %q", s)
+		}
+		cr.ports = append(cr.ports, pr)
+	}
+
+	for _, s := range r.AppIDs {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		g, err := glob.Compile(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid appID %q: %v", s, err)
+		}
+		cr.appIDs = append(cr.appIDs, g)
+	}
+
+	return cr, nil
+}
+
+func (r *compiledRule) Match(input *avdecision.RuleInput) bool {
+	if r.protocol != "" && !strings.EqualFold(r.protocol, input.Protocol) {
+		return false
+	}
+
+	if len(r.ports) > 0 {
+		matched := false
+		for _, pr := range r.ports {
+			if pr.Contains(int(input.DestPort)) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	if r.cidrs != nil && !r.cidrs.Match(input.DestHost) {
+		return false
+	}
+
+	if len(r.domains) > 0 {
+		domain := strings.ToLower(strings.TrimSuffix(input.DestDomain, "."))
+		if domain == "" {
+			return false
+		}
+		matched := false
+		for _, m := range r.domains {
+			if m.Match(domain) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	if len(r.appIDs) > 0 {
+		if input.SteamAppID == "" {
+			return false
+		}
+		matched := false
+		for _, g := range r.appIDs {
+			if g.Match(input.SteamAppID) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	return true
+}
@@ -76,6 +76,23 @@ func (h *autoHandler) Init(md md.Metadata) error {
 	return nil
 }
 
+type metadataValidator interface {
+	ValidateMetadata(md md.Metadata) error
+}
+
+// ValidateMetadata validates the metadata with each of the sub-handlers
+// that support it, none of them is initialized.
+func (h *autoHandler) ValidateMetadata(md md.Metadata) error {
+	for _, hd := range []handler.Handler{h.httpHandler, h.socks4Handler, h.socks5Handler} {
+		if v, ok := hd.(metadataValidator); ok {
+			if err := v.ValidateMetadata(md); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 func (h *autoHandler) Handle(ctx context.Context, conn net.Conn, opts ...handler.HandleOption) error {
 	log := h.options.Logger.WithFields(map[string]any{
 		"remote": conn.RemoteAddr().String(),
@@ -129,6 +129,11 @@ func (h *dnsHandler) Init(md md.Metadata) (err error) {
 	return
 }
 
+// ValidateMetadata checks the metadata only, Init also creates the hop and the exchangers of the upstream servers.
+func (h *dnsHandler) ValidateMetadata(md md.Metadata) error {
+	return h.parseMetadata(md)
+}
+
 // Forward implements handler.Forwarder.
 func (h *dnsHandler) Forward(hop hop.Hop) {
 	h.hop = hop
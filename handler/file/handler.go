@@ -68,6 +68,11 @@ func (h *fileHandler) Init(md md.Metadata) (err error) {
 	return
 }
 
+// ValidateMetadata checks the metadata only, Init also starts the file server.
+func (h *fileHandler) ValidateMetadata(md md.Metadata) error {
+	return h.parseMetadata(md)
+}
+
 func (h *fileHandler) Handle(ctx context.Context, conn net.Conn, opts ...handler.HandleOption) error {
 	var clientAddr string
 	if srcAddr := xctx.SrcAddrFromContext(ctx); srcAddr != nil {
@@ -26,6 +26,7 @@ import (
 	stats_wrapper "github.com/go-gost/x/observer/stats/wrapper"
 	xrecorder "github.com/go-gost/x/recorder"
 	"github.com/go-gost/x/registry"
+	xselector "github.com/go-gost/x/selector"
 )
 
 func init() {
@@ -72,6 +73,11 @@ func (h *forwardHandler) Init(md md.Metadata) (err error) {
 	return
 }
 
+// ValidateMetadata checks the metadata only, Init also creates the certificate pool for the sniffed TLS traffic.
+func (h *forwardHandler) ValidateMetadata(md md.Metadata) error {
+	return h.parseMetadata(md)
+}
+
 // Forward implements handler.Forwarder.
 func (h *forwardHandler) Forward(hop hop.Hop) {
 	h.hop = hop
@@ -235,6 +241,8 @@ func (h *forwardHandler) Handle(ctx context.Context, conn net.Conn, opts ...hand
 	log.Debugf("%s >> %s", conn.RemoteAddr(), addr)
 
 	var buf bytes.Buffer
+	breaker := xselector.LookupBreaker(target)
+	breaker.Begin()
 	cc, err := h.options.Router.Dial(ictx.ContextWithBuffer(ctx, &buf), network, addr)
 	ro.Route = buf.String()
 	if err != nil {
@@ -244,11 +252,13 @@ func (h *forwardHandler) Handle(ctx context.Context, conn net.Conn, opts ...hand
 		if marker := target.Marker(); marker != nil {
 			marker.Mark()
 		}
+		breaker.Done(err)
 		return err
 	}
 	if marker := target.Marker(); marker != nil {
 		marker.Reset()
 	}
+	breaker.Done(nil)
 	defer cc.Close()
 
 	cc = proxyproto.WrapClientConn(
@@ -27,6 +27,7 @@ import (
 	stats_wrapper "github.com/go-gost/x/observer/stats/wrapper"
 	xrecorder "github.com/go-gost/x/recorder"
 	"github.com/go-gost/x/registry"
+	xselector "github.com/go-gost/x/selector"
 )
 
 func init() {
@@ -72,6 +73,11 @@ func (h *forwardHandler) Init(md mdata.Metadata) (err error) {
 	return
 }
 
+// ValidateMetadata parses the metadata without the certificate pool of the TLS sniffing.
+func (h *forwardHandler) ValidateMetadata(md mdata.Metadata) error {
+	return h.parseMetadata(md)
+}
+
 // Forward implements handler.Forwarder.
 func (h *forwardHandler) Forward(hop hop.Hop) {
 	h.hop = hop
@@ -236,6 +242,8 @@ func (h *forwardHandler) Handle(ctx context.Context, conn net.Conn, opts ...hand
 	log.Debugf("%s >> %s", conn.RemoteAddr(), target.Addr)
 
 	var buf bytes.Buffer
+	breaker := xselector.LookupBreaker(target)
+	breaker.Begin()
 	cc, err := h.options.Router.Dial(ictx.ContextWithBuffer(ctx, &buf), network, target.Addr)
 	ro.Route = buf.String()
 	if err != nil {
@@ -245,12 +253,14 @@ func (h *forwardHandler) Handle(ctx context.Context, conn net.Conn, opts ...hand
 		if marker := target.Marker(); marker != nil {
 			marker.Mark()
 		}
+		breaker.Done(err)
 		return err
 	}
 	defer cc.Close()
 	if marker := target.Marker(); marker != nil {
 		marker.Reset()
 	}
+	breaker.Done(nil)
 
 	log = log.WithFields(map[string]any{"src": cc.LocalAddr().String(), "dst": cc.RemoteAddr().String()})
 	ro.SrcAddr = cc.LocalAddr().String()
@@ -121,6 +121,11 @@ func (h *httpHandler) Init(md md.Metadata) error {
 	return nil
 }
 
+// ValidateMetadata checks the metadata only, Init also starts the stats observer, the traffic limiter and the certificate pool.
+func (h *httpHandler) ValidateMetadata(md md.Metadata) error {
+	return h.parseMetadata(md)
+}
+
 func (h *httpHandler) Handle(ctx context.Context, conn net.Conn, opts ...handler.HandleOption) (err error) {
 	defer conn.Close()
 
@@ -98,6 +98,11 @@ func (h *http2Handler) Init(md md.Metadata) error {
 	return nil
 }
 
+// ValidateMetadata checks the metadata only, Init also starts the stats observer and the traffic limiter.
+func (h *http2Handler) ValidateMetadata(md md.Metadata) error {
+	return h.parseMetadata(md)
+}
+
 func (h *http2Handler) Handle(ctx context.Context, conn net.Conn, opts ...handler.HandleOption) (err error) {
 	defer conn.Close()
 
@@ -52,6 +52,11 @@ func (h *metricsHandler) Init(md md.Metadata) (err error) {
 	return
 }
 
+// ValidateMetadata checks the metadata only, Init also enables the metrics globally.
+func (h *metricsHandler) ValidateMetadata(md md.Metadata) error {
+	return h.parseMetadata(md)
+}
+
 func (h *metricsHandler) Handle(ctx context.Context, conn net.Conn, opts ...handler.HandleOption) error {
 	l := &singleConnListener{
 		conn: make(chan net.Conn, 1),
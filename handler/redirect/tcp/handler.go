@@ -70,6 +70,11 @@ func (h *redirectHandler) Init(md md.Metadata) (err error) {
 	return
 }
 
+// ValidateMetadata checks the metadata, the recorder and the certificate pool are left to Init.
+func (h *redirectHandler) ValidateMetadata(md md.Metadata) error {
+	return h.parseMetadata(md)
+}
+
 func (h *redirectHandler) Handle(ctx context.Context, conn net.Conn, opts ...handler.HandleOption) (err error) {
 	defer conn.Close()
 
@@ -17,6 +17,7 @@ import (
 	"github.com/go-gost/x/limiter/traffic/wrapper"
 	stats_wrapper "github.com/go-gost/x/observer/stats/wrapper"
 	xrecorder "github.com/go-gost/x/recorder"
+	xselector "github.com/go-gost/x/selector"
 )
 
 func (h *relayHandler) handleForward(ctx context.Context, conn net.Conn, network string, ro *xrecorder.HandlerRecorderObject, log logger.Logger) error {
@@ -64,6 +65,8 @@ func (h *relayHandler) handleForward(ctx context.Context, conn net.Conn, network
 	}
 
 	var buf bytes.Buffer
+	breaker := xselector.LookupBreaker(target)
+	breaker.Begin()
 	cc, err := h.options.Router.Dial(ictx.ContextWithBuffer(ctx, &buf), network, target.Addr)
 	ro.Route = buf.String()
 	if err != nil {
@@ -72,6 +75,7 @@ func (h *relayHandler) handleForward(ctx context.Context, conn net.Conn, network
 		if marker := target.Marker(); marker != nil {
 			marker.Mark()
 		}
+		breaker.Done(err)
 
 		resp.Status = relay.StatusHostUnreachable
 		resp.WriteTo(conn)
@@ -88,6 +92,7 @@ func (h *relayHandler) handleForward(ctx context.Context, conn net.Conn, network
 	if marker := target.Marker(); marker != nil {
 		marker.Reset()
 	}
+	breaker.Done(nil)
 
 	if h.md.noDelay {
 		if _, err := resp.WriteTo(conn); err != nil {
@@ -95,6 +95,11 @@ func (h *relayHandler) Init(md md.Metadata) (err error) {
 	return nil
 }
 
+// ValidateMetadata parses the metadata, the stats observer and the limiter are started by Init only.
+func (h *relayHandler) ValidateMetadata(md md.Metadata) error {
+	return h.parseMetadata(md)
+}
+
 // Forward implements handler.Forwarder.
 func (h *relayHandler) Forward(hop hop.Hop) {
 	h.hop = hop
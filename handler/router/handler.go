@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"net"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-gost/core/auth"
@@ -37,6 +39,13 @@ func init() {
 	registry.HandlerRegistry().Register("router", NewHandler)
 }
 
+var (
+	handlersMu sync.Mutex
+	// handlers are the running handlers by service. The handler replacing another one in
+	// the same service takes over its connector pool and listens on its entrypoint.
+	handlers = map[string]*routerHandler{}
+)
+
 type routerHandler struct {
 	id         string
 	options    handler.Options
@@ -49,6 +58,8 @@ type routerHandler struct {
 	cancel     context.CancelFunc
 	sdCache    *cache.Cache
 	routeCache *cache.Cache
+	// replaced is set once the pool is taken over by the handler replacing this one.
+	replaced atomic.Bool
 }
 
 func NewHandler(opts ...handler.Option) handler.Handler {
@@ -69,21 +80,38 @@ func (h *routerHandler) Init(md md.Metadata) (err error) {
 		return err
 	}
 
-	uuid, err := uuid.NewRandom()
-	if err != nil {
-		return err
+	// the routers of the replaced handler are kept, the connections of
+	// their clients are drained on the replaced handler.
+	prev := getHandler(h.options.Service)
+	if prev != nil {
+		h.id = prev.id
+		h.pool = prev.pool
+		prev.closeEntrypoint()
+	} else {
+		uuid, err := uuid.NewRandom()
+		if err != nil {
+			return err
+		}
+		h.id = uuid.String()
+		h.pool = NewConnectorPool(h.id)
 	}
-	h.id = uuid.String()
 
 	h.log = h.options.Logger.WithFields(map[string]any{
 		"node": h.id,
 	})
-	h.pool = NewConnectorPool(h.id)
 
 	if err = h.initEntrypoint(); err != nil {
+		if prev == nil {
+			h.pool.Close()
+		}
 		return
 	}
 
+	if prev != nil {
+		prev.replaced.Store(true)
+	}
+	setHandler(h.options.Service, h)
+
 	ctx, cancel := context.WithCancel(context.Background())
 	h.cancel = cancel
 
@@ -103,6 +131,11 @@ func (h *routerHandler) Init(md md.Metadata) (err error) {
 	return nil
 }
 
+// ValidateMetadata checks the metadata only, Init also listens on the entrypoint.
+func (h *routerHandler) ValidateMetadata(md md.Metadata) (err error) {
+	return h.parseMetadata(md)
+}
+
 func (h *routerHandler) initEntrypoint() (err error) {
 	if h.md.entryPoint == "" {
 		return
@@ -236,10 +269,12 @@ func (h *routerHandler) Handle(ctx context.Context, conn net.Conn, opts ...handl
 
 // Close implements io.Closer interface.
 func (h *routerHandler) Close() error {
-	if h.epConn != nil {
-		h.epConn.Close()
+	removeHandler(h.options.Service, h)
+
+	h.closeEntrypoint()
+	if !h.replaced.Load() {
+		h.pool.Close()
 	}
-	h.pool.Close()
 
 	if h.cancel != nil {
 		h.cancel()
@@ -248,6 +283,41 @@ func (h *routerHandler) Close() error {
 	return nil
 }
 
+func (h *routerHandler) closeEntrypoint() {
+	if h.epConn != nil {
+		h.epConn.Close()
+	}
+}
+
+func getHandler(service string) *routerHandler {
+	if service == "" {
+		return nil
+	}
+	handlersMu.Lock()
+	defer handlersMu.Unlock()
+	return handlers[service]
+}
+
+func setHandler(service string, h *routerHandler) {
+	if service == "" {
+		return
+	}
+	handlersMu.Lock()
+	handlers[service] = h
+	handlersMu.Unlock()
+}
+
+func removeHandler(service string, h *routerHandler) {
+	if service == "" {
+		return
+	}
+	handlersMu.Lock()
+	if handlers[service] == h {
+		delete(handlers, service)
+	}
+	handlersMu.Unlock()
+}
+
 func (h *routerHandler) checkRateLimit(addr net.Addr) bool {
 	if h.options.RateLimiter == nil {
 		return true
@@ -68,6 +68,11 @@ func (h *sniHandler) Init(md md.Metadata) (err error) {
 	return nil
 }
 
+// ValidateMetadata checks the metadata only, Init also creates the certificate pool.
+func (h *sniHandler) ValidateMetadata(md md.Metadata) error {
+	return h.parseMetadata(md)
+}
+
 func (h *sniHandler) Handle(ctx context.Context, conn net.Conn, opts ...handler.HandleOption) (err error) {
 	defer conn.Close()
 
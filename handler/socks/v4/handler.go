@@ -101,6 +101,11 @@ func (h *socks4Handler) Init(md md.Metadata) (err error) {
 	return nil
 }
 
+// ValidateMetadata checks the metadata only, Init also starts the stats observer, the traffic limiter and the certificate pool.
+func (h *socks4Handler) ValidateMetadata(md md.Metadata) error {
+	return h.parseMetadata(md)
+}
+
 func (h *socks4Handler) Handle(ctx context.Context, conn net.Conn, opts ...handler.HandleOption) (err error) {
 	defer conn.Close()
 
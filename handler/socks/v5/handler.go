@@ -100,6 +100,11 @@ func (h *socks5Handler) Init(md md.Metadata) (err error) {
 	return
 }
 
+// ValidateMetadata checks the metadata without starting the stats observer and the limiter.
+func (h *socks5Handler) ValidateMetadata(md md.Metadata) error {
+	return h.parseMetadata(md)
+}
+
 func (h *socks5Handler) Handle(ctx context.Context, conn net.Conn, opts ...handler.HandleOption) (err error) {
 	defer conn.Close()
 
@@ -85,6 +85,22 @@ func (h *ssuHandler) Init(md md.Metadata) (err error) {
 	return
 }
 
+// ValidateMetadata checks the metadata and the cipher of the server
+// without creating the UDP session manager.
+func (h *ssuHandler) ValidateMetadata(md md.Metadata) error {
+	if err := h.parseMetadata(md); err != nil {
+		return err
+	}
+	if h.options.Auth != nil {
+		method := h.options.Auth.Username()
+		password, _ := h.options.Auth.Password()
+		if _, err := utils.NewServerConfig(method, password, h.md.users); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (h *ssuHandler) Handle(ctx context.Context, conn net.Conn, opts ...handler.HandleOption) (err error) {
 	defer conn.Close()
 
@@ -79,6 +79,11 @@ func (h *forwardHandler) Init(md md.Metadata) (err error) {
 	return nil
 }
 
+// ValidateMetadata checks the metadata only, Init also creates the certificate pool.
+func (h *forwardHandler) ValidateMetadata(md md.Metadata) error {
+	return h.parseMetadata(md)
+}
+
 func (h *forwardHandler) Handle(ctx context.Context, conn net.Conn, opts ...handler.HandleOption) (err error) {
 	defer conn.Close()
 
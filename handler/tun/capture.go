@@ -0,0 +1,36 @@
+package tun
+
+import (
+	"strings"
+
+	"github.com/go-gost/x/internal/util/pcap"
+)
+
+// capture writes the packet b to the running capture of the service, if any.
+// comment is called only if the packet is captured.
+func (h *tunHandler) capture(b []byte, dir pcap.Direction, comment func() string) {
+	if !h.tap.Enabled() {
+		return
+	}
+	h.tap.Capture(b, dir, comment)
+}
+
+func directComment() string {
+	return "action=direct"
+}
+
+// decisionComment describes the decision of a packet in the capture.
+func decisionComment(action, appID, hostname string) string {
+	var sb strings.Builder
+	sb.WriteString("action=")
+	sb.WriteString(strings.ToLower(action))
+	if appID != "" {
+		sb.WriteString(" app=")
+		sb.WriteString(appID)
+	}
+	if hostname != "" {
+		sb.WriteString(" host=")
+		sb.WriteString(hostname)
+	}
+	return sb.String()
+}
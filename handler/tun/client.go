@@ -8,6 +8,7 @@ import (
 	"io"
 	"net"
 	"net/url"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -19,6 +20,7 @@ import (
 	"github.com/go-gost/core/logger"
 	xnet "github.com/go-gost/x/internal/net"
 	xip "github.com/go-gost/x/internal/net/ip"
+	"github.com/go-gost/x/internal/util/pcap"
 	tun_util "github.com/go-gost/x/internal/util/tun"
 	"github.com/songgao/water/waterutil"
 	"golang.org/x/net/ipv4"
@@ -54,7 +56,9 @@ func normalizeRelayTarget(addr string) string {
 	return ""
 }
 
-func (h *tunHandler) keepalive(ctx context.Context, conn net.Conn, ips []net.IP) {
+func (h *tunHandler) keepalive(ctx context.Context, s *clientSession) {
+	conn, ips := s.conn, s.ips
+
 	// handshake
 	keepAliveData := bufpool.Get(keepAliveHeaderLength + len(ips)*net.IPv6len)
 	defer bufpool.Put(keepAliveData)
@@ -69,21 +73,28 @@ func (h *tunHandler) keepalive(ctx context.Context, conn net.Conn, ips []net.IP)
 		copy(keepAliveData[pos:pos+net.IPv6len], ip.To16())
 		pos += net.IPv6len
 	}
+	if h.md.ipam {
+		// the address requests renew the lease and keep the session alive.
+		keepAliveData = h.ipamRequestMessage(ips)
+	}
+	s.health.probe(time.Now())
 	if _, err := conn.Write(keepAliveData); err != nil {
 		return
 	}
 
-	if h.md.keepAlivePeriod <= 0 {
+	period := h.keepAlivePeriod()
+	if period <= 0 {
 		return
 	}
-	conn.SetReadDeadline(time.Now().Add(h.md.keepAlivePeriod * 3))
+	conn.SetReadDeadline(time.Now().Add(period * 3))
 
-	ticker := time.NewTicker(h.md.keepAlivePeriod)
+	ticker := time.NewTicker(period)
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ticker.C:
+			s.health.probe(time.Now())
 			if _, err := conn.Write(keepAliveData); err != nil {
 				return
 			}
@@ -99,12 +110,18 @@ type clientSession struct {
 	node    *chain.Node
 	network string
 	raddr   string
+	ips     []net.IP
 	conn    net.Conn
 	writeCh chan []byte
 	log     logger.Logger
 	cancel  context.CancelFunc
 	closed  chan struct{}
 	once    sync.Once
+	health  *sessionHealth
+	// isolated sessions are closed on the transport errors without stopping the client.
+	isolated bool
+	// tap captures the packets received from the node.
+	tap *pcap.Tap
 }
 
 func (s *clientSession) close() {
@@ -120,10 +137,26 @@ func (s *clientSession) close() {
 	})
 }
 
+func (s *clientSession) isClosed() bool {
+	select {
+	case <-s.closed:
+		return true
+	default:
+		return false
+	}
+}
+
 func (s *clientSession) reportError(errc chan<- error, err error) {
 	if err == nil {
 		return
 	}
+	if s.isolated && !errors.Is(err, ErrTun) {
+		if !s.isClosed() {
+			s.log.Warnf("session closed: %v", err)
+		}
+		s.close()
+		return
+	}
 	select {
 	case errc <- err:
 	default:
@@ -168,9 +201,27 @@ func (s *clientSession) readLoop(ctx context.Context, tun io.Writer, tunMu *sync
 			return
 		}
 
+		if n > len(ipamMagicHeader) && bytes.Equal(b[:4], ipamMagicHeader) {
+			offer, err := parseIPAMOffer(b[:n])
+			if err != nil {
+				s.log.Warnf("address offer via %s: %v", s.raddr, err)
+				continue
+			}
+			s.health.echo(time.Now())
+			if keepAlivePeriod > 0 {
+				_ = s.conn.SetReadDeadline(time.Now().Add(keepAlivePeriod * 3))
+			}
+			if ips := offer.ips(); !slices.EqualFunc(ips, s.ips, net.IP.Equal) {
+				s.reportError(errc, fmt.Errorf("%w: %v", errIPAMLeaseChanged, offer.Net))
+				return
+			}
+			continue
+		}
+
 		if n == keepAliveHeaderLength && bytes.Equal(b[:4], magicHeader) {
 			ip := net.IP(b[4:20])
 			s.log.Debugf("keepalive received at %v via %s", ip, s.raddr)
+			s.health.echo(time.Now())
 
 			if keepAlivePeriod > 0 {
 				_ = s.conn.SetReadDeadline(time.Now().Add(keepAlivePeriod * 3))
@@ -195,6 +246,12 @@ func (s *clientSession) readLoop(ctx context.Context, tun io.Writer, tunMu *sync
 			}
 		}
 
+		if s.tap.Enabled() {
+			s.tap.Capture(b[:n], pcap.DirectionOutbound, func() string {
+				return "node=" + s.node.Name
+			})
+		}
+
 		tunMu.Lock()
 		_, err = tun.Write(b[:n])
 		tunMu.Unlock()
@@ -205,9 +262,9 @@ func (s *clientSession) readLoop(ctx context.Context, tun io.Writer, tunMu *sync
 	}
 }
 
-func (h *tunHandler) handleClient(ctx context.Context, conn net.Conn, config *tun_util.Config, log logger.Logger) error {
+func (h *tunHandler) handleClient(ctx context.Context, conn net.Conn, config *tun_util.Config, configurator tun_util.Configurator, log logger.Logger) error {
 	ips := collectIPs(config.Net)
-	if len(ips) == 0 {
+	if len(ips) == 0 && !h.md.ipam {
 		return ErrInvalidNet
 	}
 
@@ -216,7 +273,25 @@ func (h *tunHandler) handleClient(ctx context.Context, conn net.Conn, config *tu
 		fallback = h.hop.Select(ctx)
 	}
 
+	var lease *ipamOffer
 	for {
+		if h.md.ipam {
+			offer, err := h.requestLease(ctx, fallback, ips, log)
+			if err == nil && !offer.equal(lease) {
+				if ips, err = h.applyLease(config, configurator, offer, log); err == nil {
+					lease = offer
+				}
+			}
+			if err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				log.Errorf("address request: %v", err)
+				time.Sleep(time.Second)
+				continue
+			}
+		}
+
 		err := h.runClient(ctx, conn, ips, fallback, log, config)
 		if errors.Is(err, ErrTun) {
 			return err
@@ -239,6 +314,9 @@ func (h *tunHandler) runClient(ctx context.Context, tun net.Conn, ips []net.IP,
 	tunMu := &sync.Mutex{}
 
 	go h.dispatchPackets(ctx, tun, ips, fallback, log, tunMu, sessions, &sessMu, errc, config)
+	if h.failover != nil {
+		go h.failover.run(ctx, h, ips, tun, tunMu, log, sessions, &sessMu, errc)
+	}
 
 	err := <-errc
 	cancel()
@@ -265,6 +343,8 @@ func (h *tunHandler) dispatchPackets(
 	errc chan<- error,
 	config *tun_util.Config,
 ) {
+	records := newFlowRecords(flowRecordInterval)
+
 	var b [MaxMessageSize]byte
 	for {
 		n, err := tun.Read(b[:])
@@ -281,7 +361,13 @@ func (h *tunHandler) dispatchPackets(
 		var appID, hostname string
 		if h.dec != nil {
 			appID, hostname = h.dec.ResolveMetadata(src.String(), dst.String(), sport, dport, strings.ToUpper(proto))
+			if appID == "" && h.sockOwner != nil {
+				appID, _ = h.sockOwner.ResolveMetadata(src.String(), dst.String(), sport, dport, strings.ToUpper(proto))
+			}
+		}
 
+		action := decision.ActionProxy
+		if h.dec != nil {
 			// If we don't have a hostname (e.g., no SNI), try to enrich it from domain mappings for logging/decisions.
 			if hostname == "" {
 				type domainLookup interface{ GetDomainsForIP(string) []string }
@@ -292,55 +378,83 @@ func (h *tunHandler) dispatchPackets(
 				}
 			}
 
-			deci := h.dec.CheckTrafficRules(decision.RuleInput{
+			in := decision.RuleInput{
 				SteamAppID: appID,
 				DestHost:   dst.String(),
 				DestPort:   int32(dport),
 				Protocol:   strings.ToUpper(proto),
 				DestDomain: hostname,
-			})
-
-			log.Debugf("decision result: action=%s appID=%s host=%s domain=%s proto=%s", deci.Action, appID, dst, hostname, proto)
+			}
+			deci := h.dec.CheckTrafficRules(in)
+			if deci != nil {
+				log.Debugf("decision result: action=%s appID=%s host=%s domain=%s proto=%s", deci.Action, appID, dst, hostname, proto)
+				action = deci.Action
+			}
 
-			switch deci.Action {
-			case decision.ActionBlock:
-				log.Debugf("packet blocked by decision: %s:%d/%s", dst, dport, proto)
-				continue
-			case decision.ActionDirect:
-				log.Debugf("packet allowed direct (not proxied): %s:%d/%s", dst, dport, proto)
-				pkt := make([]byte, n)
-				copy(pkt, b[:n])
-				if err := h.forwardDirect(ctx, tun, config, log, tunMu, pkt); err != nil {
-					log.Errorf("direct forward failed: %v", err)
+			if h.recorder.Recorder != nil {
+				if f := newPacketFlow(src, dst, sport, dport, proto); records.allow(time.Now(), f) {
+					if err := h.decisionRecord(f, in, deci).Record(ctx, h.recorder.Recorder); err != nil {
+						log.Errorf("record: %v", err)
+					}
 				}
-				continue
-			case decision.ActionProxy:
-				// proceed to proxy
-			default:
-				// default fallthrough to proxy
 			}
 		}
 
-		node := h.selectTargetForPacket(ctx, dst, proto)
-		if node == nil {
-			node = fallback
-		}
-		if node == nil {
-			errc <- errors.New("tun: no available node for packet")
-			return
-		}
+		h.capture(b[:n], pcap.DirectionInbound, func() string {
+			return decisionComment(string(action), appID, hostname)
+		})
 
-		log.Debugf("proxying packet: host=%s proto=%s appID=%s domain=%s node=%s addr=%s", dst, proto, appID, hostname, node.Name, node.Addr)
+		switch action {
+		case decision.ActionBlock:
+			log.Debugf("packet blocked by decision: %s:%d/%s", dst, dport, proto)
+			continue
+		case decision.ActionDirect:
+			log.Debugf("packet allowed direct (not proxied): %s:%d/%s", dst, dport, proto)
+			pkt := make([]byte, n)
+			copy(pkt, b[:n])
+			if err := h.forwardDirect(ctx, tun, config, log, tunMu, pkt); err != nil {
+				log.Errorf("direct forward failed: %v", err)
+			}
+			continue
+		case decision.ActionProxy:
+			// proceed to proxy
+		default:
+			// default fallthrough to proxy
+		}
 
 		overlayNetwork := strings.ToLower(strings.TrimSpace(proto))
 		if overlayNetwork != "tcp" && overlayNetwork != "udp" {
 			overlayNetwork = "tcp"
 		}
-		session, er := h.ensureSession(ctx, node, overlayNetwork, ips, tun, tunMu, log, sessions, sessMu, errc)
-		if er != nil {
-			errc <- er
-			return
+
+		var session *clientSession
+		if h.failover != nil {
+			session = h.failover.session(overlayNetwork)
+		}
+		if session == nil {
+			node := h.selectTargetForPacket(ctx, dst, proto)
+			if node == nil {
+				node = fallback
+			}
+			if node == nil {
+				errc <- errors.New("tun: no available node for packet")
+				return
+			}
+
+			var er error
+			session, er = h.ensureSession(ctx, node, overlayNetwork, ips, tun, tunMu, log, sessions, sessMu, errc)
+			if er != nil {
+				if h.failover != nil && ctx.Err() == nil {
+					log.Warnf("session to node %s: %v, packet discarded", node.Name, er)
+					continue
+				}
+				errc <- er
+				return
+			}
 		}
+		node := session.node
+
+		log.Debugf("proxying packet: host=%s proto=%s appID=%s domain=%s node=%s addr=%s", dst, proto, appID, hostname, node.Name, node.Addr)
 
 		pkt := make([]byte, n)
 		copy(pkt, b[:n])
@@ -381,35 +495,104 @@ func (h *tunHandler) ensureSession(
 	sessMu.Lock()
 	s := sessions[key]
 	sessMu.Unlock()
-	if s != nil {
+	if s != nil && !s.isClosed() {
 		return s, nil
 	}
 
 	cctx, cancel := context.WithCancel(ctx)
 
-	cc, err := func() (net.Conn, error) {
-		if node == nil {
-			return nil, errors.New("tun: nil node")
+	cc, err := h.dialNode(cctx, node, network, log)
+	if err != nil {
+		cancel()
+
+		if ctx.Err() != nil {
+			return nil, err
 		}
 
+		// If UDP path fails, try a TCP fallback node (e.g., relay+wss) before giving up.
+		// The failover keeps its own sessions to the TCP nodes.
+		if network == "udp" && h.hop != nil && h.failover == nil {
+			if alt := h.hop.Select(ctx, hop.NetworkSelectOption("tcp")); alt != nil && alt.Name != node.Name {
+				log.Warnf("udp dial failed, falling back to tcp node %s (current: %s)", alt.Name, node.Name)
+				return h.ensureSession(ctx, alt, "tcp", ips, tun, tunMu, log, sessions, sessMu, errc)
+			}
+		}
+		return nil, err
+	}
+
+	s = &clientSession{
+		key:     key,
+		node:    node,
+		network: network,
+		raddr:   raddr,
+		ips:     ips,
+		conn:    cc,
+		writeCh: make(chan []byte, 64),
+		log: log.WithFields(map[string]any{
+			"dst":  fmt.Sprintf("%s/%s", raddr, network),
+			"node": node.Name,
+		}),
+		cancel:   cancel,
+		closed:   make(chan struct{}),
+		health:   &sessionHealth{window: defaultFailoverWindow},
+		isolated: h.failover != nil,
+		tap:      h.tap,
+	}
+	if h.failover != nil {
+		s.health.window = h.failover.cfg.window
+	}
+
+	// the failover and the packets may race to create the session.
+	sessMu.Lock()
+	if other := sessions[key]; other != nil && !other.isClosed() {
+		sessMu.Unlock()
+		cancel()
+		cc.Close()
+		return other, nil
+	}
+	sessions[key] = s
+	sessMu.Unlock()
+
+	if network == "udp" {
+		go h.keepalive(cctx, s)
+	} else {
+		// TCP/WSS overlay also needs the same initial handshake payload that advertises
+		// the local tunnel IPs; without it the server may close immediately.
+		go h.keepalive(cctx, s)
+	}
+
+	go s.writeLoop(cctx, errc)
+	go s.readLoop(cctx, tun, tunMu, h.keepAlivePeriod(), errc)
+
+	return s, nil
+}
+
+// dialNode establishes the overlay connection to the server of node.
+func (h *tunHandler) dialNode(ctx context.Context, node *chain.Node, network string, log logger.Logger) (net.Conn, error) {
+	if node == nil {
+		return nil, errors.New("tun: nil node")
+	}
+	raddr := node.Addr
+
+	conn, err := func() (net.Conn, error) {
 		tr := node.Options().Transport
 		if tr == nil {
 			// Fallback: no transport configured on the node (unexpected for forwarder nodes).
-			return h.options.Router.Dial(cctx, network, raddr)
+			return h.options.Router.Dial(ctx, network, raddr)
 		}
 
 		// Establish the overlay connection using the node transport (WSS/DTLS/etc) and then
 		// run the node connector (relay protocol) to create a tunnel/association. The server
 		// expects the relay CONNECT header before any tun keepalive/payload bytes.
-		ipAddr, err := xnet.Resolve(cctx, "ip", raddr, node.Options().Resolver, node.Options().HostMapper, log)
+		ipAddr, err := xnet.Resolve(ctx, "ip", raddr, node.Options().Resolver, node.Options().HostMapper, log)
 		if err != nil {
 			return nil, err
 		}
-		raw, err := tr.Dial(cctx, ipAddr)
+		raw, err := tr.Dial(ctx, ipAddr)
 		if err != nil {
 			return nil, err
 		}
-		raw, err = tr.Handshake(cctx, raw)
+		raw, err = tr.Handshake(ctx, raw)
 		if err != nil {
 			raw.Close()
 			return nil, err
@@ -430,7 +613,7 @@ func (h *tunHandler) ensureSession(
 		if log != nil {
 			log.Debugf("relay connect target: %q", connectAddr)
 		}
-		conn, err := tr.Connect(cctx, raw, network, connectAddr)
+		conn, err := tr.Connect(ctx, raw, network, connectAddr)
 		if err != nil {
 			raw.Close()
 			return nil, err
@@ -438,53 +621,21 @@ func (h *tunHandler) ensureSession(
 		return conn, nil
 	}()
 	if err != nil {
-		cancel()
-
-		if ctx.Err() != nil {
-			return nil, err
-		}
-
-		// If UDP path fails, try a TCP fallback node (e.g., relay+wss) before giving up.
-		if network == "udp" && h.hop != nil {
-			if alt := h.hop.Select(ctx, hop.NetworkSelectOption("tcp")); alt != nil && alt.Name != node.Name {
-				log.Warnf("udp dial failed, falling back to tcp node %s (current: %s)", alt.Name, node.Name)
-				return h.ensureSession(ctx, alt, "tcp", ips, tun, tunMu, log, sessions, sessMu, errc)
-			}
-		}
 		return nil, err
 	}
 
-	s = &clientSession{
-		key:     key,
-		node:    node,
-		network: network,
-		raddr:   raddr,
-		conn:    cc,
-		writeCh: make(chan []byte, 64),
-		log: log.WithFields(map[string]any{
-			"dst":  fmt.Sprintf("%s/%s", raddr, network),
-			"node": node.Name,
-		}),
-		cancel: cancel,
-		closed: make(chan struct{}),
+	if h.secure != nil {
+		conn = newSecureConn(conn, h.secure, log)
 	}
+	return conn, nil
+}
 
-	if network == "udp" {
-		go h.keepalive(cctx, cc, ips)
-	} else {
-		// TCP/WSS overlay also needs the same initial handshake payload that advertises
-		// the local tunnel IPs; without it the server may close immediately.
-		go h.keepalive(cctx, cc, ips)
+// keepAlivePeriod returns the period of the keepalives, the failover probes the sessions more often.
+func (h *tunHandler) keepAlivePeriod() time.Duration {
+	if h.failover != nil {
+		return h.failover.cfg.interval
 	}
-
-	go s.writeLoop(cctx, errc)
-	go s.readLoop(cctx, tun, tunMu, h.md.keepAlivePeriod, errc)
-
-	sessMu.Lock()
-	sessions[key] = s
-	sessMu.Unlock()
-
-	return s, nil
+	return h.md.keepAlivePeriod
 }
 
 func (h *tunHandler) selectTargetForPacket(ctx context.Context, dst net.IP, proto string) *chain.Node {
@@ -537,7 +688,7 @@ func (h *tunHandler) forwardDirect(ctx context.Context, tun net.Conn, config *tu
 	if h.direct == nil {
 		h.direct = newDirectForwarder()
 	}
-	if err := h.direct.start(ctx, tun, config.MTU, log, &h.options, tunMu); err != nil {
+	if err := h.direct.start(ctx, tun, config.MTU, log, &h.options, tunMu, h.tap); err != nil {
 		return err
 	}
 	if ok := h.direct.inject(pkt); !ok {
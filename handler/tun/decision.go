@@ -1,6 +1,15 @@
 package tun
 
-import "github.com/AeroCore-IO/avionics/pkg/decision"
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AeroCore-IO/avionics/pkg/decision"
+	"github.com/go-gost/x/internal/util/sockowner"
+	"github.com/go-gost/x/registry"
+)
 
 // DecisionEvaluator abstracts rule evaluation; implementations can be injected by callers.
 type DecisionEvaluator interface {
@@ -15,3 +24,210 @@ type DecisionRuleInput = decision.RuleInput
 
 // TrafficDecision re-exports the shared traffic decision type for convenience.
 type TrafficDecision = decision.TrafficDecision
+
+// ProxyHoster is optionally implemented by a DecisionEvaluator to override the host
+// dialed through the proxy for a proxy decision.
+type ProxyHoster interface {
+	ProxyHost(d *decision.TrafficDecision) string
+}
+
+// RuleIDer is optionally implemented by a DecisionEvaluator to identify the rule matched by a decision,
+// the ID is recorded with the flows for auditing.
+type RuleIDer interface {
+	RuleID(d *decision.TrafficDecision) string
+}
+
+// BandwidthClasser is optionally implemented by a DecisionEvaluator to assign the flows of a decision
+// to a bandwidth class. The class is the name of the traffic limiter the flows are limited by.
+type BandwidthClasser interface {
+	BandwidthClass(d *decision.TrafficDecision) string
+}
+
+// RulesVersioner is optionally implemented by a DecisionEvaluator whose rules can change at runtime.
+// RulesVersion returns the current rules generation, it must change whenever the rules are updated.
+type RulesVersioner interface {
+	RulesVersion() uint64
+}
+
+// RulesNotifier signals the rules changes of a DecisionEvaluator.
+type RulesNotifier interface {
+	// RulesChanged returns a channel that is closed on the next rules change.
+	RulesChanged() <-chan struct{}
+}
+
+// RegisteredDecisionEvaluator returns the DecisionEvaluator registered by the name.
+// The evaluator is looked up on each call, so that a reloaded evaluator is used by the running handlers.
+func RegisteredDecisionEvaluator(name string) DecisionEvaluator {
+	if name == "" {
+		return nil
+	}
+	return &decisionRef{name: name}
+}
+
+type decisionRef struct {
+	name string
+}
+
+func (r *decisionRef) get() DecisionEvaluator {
+	v, _ := registry.DecisionRegistry().Get(r.name).(DecisionEvaluator)
+	return v
+}
+
+func (r *decisionRef) CheckTrafficRules(input decision.RuleInput) *decision.TrafficDecision {
+	v := r.get()
+	if v == nil {
+		return nil
+	}
+	return v.CheckTrafficRules(input)
+}
+
+func (r *decisionRef) ResolveMetadata(srcIP, dstIP string, srcPort, dstPort int, proto string) (appID string, hostname string) {
+	v := r.get()
+	if v == nil {
+		return
+	}
+	return v.ResolveMetadata(srcIP, dstIP, srcPort, dstPort, proto)
+}
+
+func (r *decisionRef) ProxyHost(d *decision.TrafficDecision) string {
+	if v, ok := r.get().(ProxyHoster); ok {
+		return v.ProxyHost(d)
+	}
+	return ""
+}
+
+func (r *decisionRef) RuleID(d *decision.TrafficDecision) string {
+	if v, ok := r.get().(RuleIDer); ok {
+		return v.RuleID(d)
+	}
+	return ""
+}
+
+func (r *decisionRef) BandwidthClass(d *decision.TrafficDecision) string {
+	if v, ok := r.get().(BandwidthClasser); ok {
+		return v.BandwidthClass(d)
+	}
+	return ""
+}
+
+func (r *decisionRef) RulesVersion() uint64 {
+	if v, ok := r.get().(RulesVersioner); ok {
+		return v.RulesVersion()
+	}
+	return 0
+}
+
+// MetadataResolver resolves the application context (AppID, Hostname) of a flow from its 5-tuple.
+// It is used to attribute the flows that the DecisionEvaluator does not attribute.
+type MetadataResolver interface {
+	ResolveMetadata(srcIP, dstIP string, srcPort, dstPort int, proto string) (appID string, hostname string)
+}
+
+// parseSockOwnerMode returns the appID mode of the socket owner resolver,
+// or an empty string if the resolver is disabled, which is the default.
+func parseSockOwnerMode(s string) string {
+	switch s = strings.ToLower(strings.TrimSpace(s)); s {
+	case "", "none", "off", "false":
+		return ""
+	case "true", sockowner.ModeExe:
+		return sockowner.ModeExe
+	default:
+		return s
+	}
+}
+
+// NewSockOwnerResolver creates the default MetadataResolver from the sockowner metadata value,
+// it returns nil if the resolver is disabled.
+func NewSockOwnerResolver(mode string) MetadataResolver {
+	if mode = parseSockOwnerMode(mode); mode == "" {
+		return nil
+	}
+	return sockowner.NewResolver(sockowner.ModeOption(mode))
+}
+
+const (
+	asyncResolveTTL     = time.Minute
+	asyncResolveWorkers = 4
+	asyncResolveMaxSize = 8192
+)
+
+// asyncResolver runs the lookups of a MetadataResolver in the background, so that
+// the packet loop never waits for them. A lookup is started once for each new flow,
+// the appID of the flow is empty until the lookup completes.
+type asyncResolver struct {
+	resolver MetadataResolver
+	ttl      time.Duration
+	sem      chan struct{}
+	mu       sync.Mutex
+	flows    map[string]*asyncEntry
+}
+
+type asyncEntry struct {
+	appID     string
+	done      bool
+	expiresAt time.Time
+}
+
+func newAsyncResolver(r MetadataResolver) *asyncResolver {
+	return &asyncResolver{
+		resolver: r,
+		ttl:      asyncResolveTTL,
+		sem:      make(chan struct{}, asyncResolveWorkers),
+		flows:    make(map[string]*asyncEntry),
+	}
+}
+
+func (r *asyncResolver) ResolveMetadata(srcIP, dstIP string, srcPort, dstPort int, proto string) (string, string) {
+	key := strings.Join([]string{proto, srcIP, strconv.Itoa(srcPort), dstIP, strconv.Itoa(dstPort)}, "|")
+	now := time.Now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if e := r.flows[key]; e != nil && now.Before(e.expiresAt) {
+		e.expiresAt = now.Add(r.ttl)
+		return e.appID, ""
+	}
+
+	select {
+	case r.sem <- struct{}{}:
+	default:
+		// too many lookups in flight, the next packet of the flow tries again.
+		return "", ""
+	}
+
+	if len(r.flows) >= asyncResolveMaxSize {
+		r.cleanup(now)
+	}
+	e := &asyncEntry{expiresAt: now.Add(r.ttl)}
+	r.flows[key] = e
+
+	go func() {
+		defer func() { <-r.sem }()
+
+		appID, _ := r.resolver.ResolveMetadata(srcIP, dstIP, srcPort, dstPort, proto)
+
+		r.mu.Lock()
+		e.appID = appID
+		e.done = true
+		r.mu.Unlock()
+	}()
+
+	return "", ""
+}
+
+// cleanup removes the expired entries, or all the completed entries if none has expired. r.mu must be held.
+func (r *asyncResolver) cleanup(now time.Time) {
+	for k, e := range r.flows {
+		if !now.Before(e.expiresAt) {
+			delete(r.flows, k)
+		}
+	}
+	if len(r.flows) >= asyncResolveMaxSize {
+		for k, e := range r.flows {
+			if e.done {
+				delete(r.flows, k)
+			}
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package tun
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/AeroCore-IO/avionics/pkg/decision"
+	"github.com/go-gost/x/registry"
+)
+
+type slowResolver struct {
+	release chan struct{}
+	calls   atomic.Int32
+}
+
+func (r *slowResolver) ResolveMetadata(srcIP, dstIP string, srcPort, dstPort int, proto string) (string, string) {
+	r.calls.Add(1)
+	<-r.release
+	return "/usr/bin/curl", ""
+}
+
+type testEvaluator struct {
+	action decision.Action
+}
+
+func (e *testEvaluator) CheckTrafficRules(input decision.RuleInput) *decision.TrafficDecision {
+	return &decision.TrafficDecision{Action: e.action, RuleName: "rule-1"}
+}
+
+func (e *testEvaluator) ResolveMetadata(srcIP, dstIP string, srcPort, dstPort int, proto string) (string, string) {
+	return "", ""
+}
+
+func (e *testEvaluator) RuleID(d *decision.TrafficDecision) string {
+	return d.RuleName + "-" + string(e.action)
+}
+
+func TestRegisteredDecisionEvaluator(t *testing.T) {
+	dec := RegisteredDecisionEvaluator("tun-decision-test")
+	if d := dec.CheckTrafficRules(decision.RuleInput{}); d != nil {
+		t.Fatalf("unexpected decision %+v without an evaluator", d)
+	}
+
+	registry.DecisionRegistry().Register("tun-decision-test", &testEvaluator{action: decision.ActionProxy})
+	defer registry.DecisionRegistry().Unregister("tun-decision-test")
+
+	d := dec.CheckTrafficRules(decision.RuleInput{})
+	if d == nil || d.Action != decision.ActionProxy || dec.(RuleIDer).RuleID(d) != "rule-1-PROXY" {
+		t.Fatalf("unexpected decision %+v", d)
+	}
+	if host := dec.(ProxyHoster).ProxyHost(d); host != "" {
+		t.Fatalf("unexpected proxy host %s", host)
+	}
+
+	// the replaced evaluator is used by the next calls.
+	registry.Swap(registry.DecisionRegistry(), "tun-decision-test", registry.DecisionEvaluator(&testEvaluator{action: decision.ActionBlock}))
+	if d := dec.CheckTrafficRules(decision.RuleInput{}); d == nil || d.Action != decision.ActionBlock {
+		t.Fatalf("unexpected decision %+v after the swap", d)
+	}
+}
+
+func TestParseSockOwnerMode(t *testing.T) {
+	for s, mode := range map[string]string{
+		"":       "",
+		"none":   "",
+		"off":    "",
+		"true":   "exe",
+		"exe":    "exe",
+		"CGroup": "cgroup",
+	} {
+		if v := parseSockOwnerMode(s); v != mode {
+			t.Errorf("%q: got mode %q, want %q", s, v, mode)
+		}
+	}
+	if NewSockOwnerResolver("") != nil {
+		t.Error("the resolver should be disabled by default")
+	}
+}
+
+func TestAsyncResolver(t *testing.T) {
+	sr := &slowResolver{release: make(chan struct{})}
+	r := newAsyncResolver(sr)
+
+	// the lookup runs in the background, the caller is not blocked by it.
+	done := make(chan string)
+	go func() {
+		appID, _ := r.ResolveMetadata("10.0.0.2", "1.1.1.1", 40000, 443, "TCP")
+		done <- appID
+	}()
+	select {
+	case appID := <-done:
+		if appID != "" {
+			t.Fatalf("unexpected appID %q before the lookup completes", appID)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("ResolveMetadata blocked on the lookup")
+	}
+
+	for i := 0; i < 10; i++ {
+		r.ResolveMetadata("10.0.0.2", "1.1.1.1", 40000, 443, "TCP")
+	}
+	close(sr.release)
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		appID, _ := r.ResolveMetadata("10.0.0.2", "1.1.1.1", 40000, 443, "TCP")
+		if appID == "/usr/bin/curl" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the appID of the flow is not resolved")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := sr.calls.Load(); n != 1 {
+		t.Fatalf("the flow is looked up %d times, want once", n)
+	}
+}
@@ -13,6 +13,7 @@ import (
 	"github.com/go-gost/core/handler"
 	"github.com/go-gost/core/logger"
 	xnet "github.com/go-gost/x/internal/net"
+	"github.com/go-gost/x/internal/util/pcap"
 	"github.com/xjasonlyu/tun2socks/v2/core"
 	"github.com/xjasonlyu/tun2socks/v2/core/adapter"
 	"gvisor.dev/gvisor/pkg/buffer"
@@ -39,14 +40,14 @@ func newDirectForwarder() *directForwarder {
 	return &directForwarder{in: make(chan []byte, 256)}
 }
 
-func (d *directForwarder) start(ctx context.Context, tun net.Conn, mtu int, log logger.Logger, opts *handler.Options, tunMu *sync.Mutex) error {
+func (d *directForwarder) start(ctx context.Context, tun net.Conn, mtu int, log logger.Logger, opts *handler.Options, tunMu *sync.Mutex, tap *pcap.Tap) error {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 	if d.started {
 		return nil
 	}
 
-	rw := &packetRW{in: d.in, tun: tun, tunMu: tunMu}
+	rw := &packetRW{in: d.in, tun: tun, tunMu: tunMu, tap: tap}
 	ep := newDirectEndpoint(rw, mtu, log)
 	th := newDirectTransport(opts, log)
 
@@ -86,6 +87,8 @@ type packetRW struct {
 	in    <-chan []byte
 	tun   io.Writer
 	tunMu *sync.Mutex
+	// tap captures the packets of the direct flows written to the TUN device.
+	tap *pcap.Tap
 }
 
 func (p *packetRW) Read(b []byte) (int, error) {
@@ -103,6 +106,10 @@ func (p *packetRW) Read(b []byte) (int, error) {
 }
 
 func (p *packetRW) Write(b []byte) (int, error) {
+	if p.tap.Enabled() {
+		p.tap.Capture(b, pcap.DirectionOutbound, directComment)
+	}
+
 	p.tunMu.Lock()
 	defer p.tunMu.Unlock()
 	return p.tun.Write(b)
@@ -0,0 +1,318 @@
+package tun
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-gost/core/chain"
+	"github.com/go-gost/core/hop"
+	"github.com/go-gost/core/logger"
+	"github.com/go-gost/core/observer"
+	"github.com/go-gost/x/registry"
+	xservice "github.com/go-gost/x/service"
+)
+
+const (
+	defaultFailoverStandby  = 1
+	defaultFailoverInterval = time.Second
+	defaultFailoverWindow   = 3 * time.Second
+	defaultFailoverMaxLoss  = 0.5
+
+	// healthSamples is the number of the latest probes the loss is computed from.
+	healthSamples = 16
+)
+
+// sessionHealth measures the RTT and the loss of a session from the keepalive echoes.
+// The server echoes every keepalive, the echoes are matched with the probes in order.
+type sessionHealth struct {
+	// window is the time a probe is waited for before it is lost.
+	window   time.Duration
+	mu       sync.Mutex
+	pending  []time.Time
+	samples  [healthSamples]bool
+	nsamples int
+	next     int
+	rtt      time.Duration
+	lastEcho time.Time
+}
+
+// probe records a keepalive sent at t.
+func (hs *sessionHealth) probe(t time.Time) {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	hs.pending = append(hs.pending, t)
+}
+
+// echo records a keepalive echo received at t.
+func (hs *sessionHealth) echo(t time.Time) {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	hs.expire(t)
+	if len(hs.pending) == 0 {
+		return
+	}
+
+	rtt := t.Sub(hs.pending[0])
+	hs.pending = hs.pending[1:]
+	if hs.rtt == 0 {
+		hs.rtt = rtt
+	} else {
+		hs.rtt = (hs.rtt*7 + rtt) / 8
+	}
+	hs.lastEcho = t
+	hs.record(false)
+}
+
+// expire counts the probes unanswered within the window as lost, hs.mu must be held.
+func (hs *sessionHealth) expire(t time.Time) {
+	for len(hs.pending) > 0 && t.Sub(hs.pending[0]) > hs.window {
+		hs.pending = hs.pending[1:]
+		hs.record(true)
+	}
+}
+
+func (hs *sessionHealth) record(lost bool) {
+	hs.samples[hs.next] = lost
+	hs.next = (hs.next + 1) % healthSamples
+	if hs.nsamples < healthSamples {
+		hs.nsamples++
+	}
+}
+
+// stats returns the smoothed RTT, the loss ratio of the latest probes and
+// whether an echo was received within the window.
+func (hs *sessionHealth) stats(t time.Time) (rtt time.Duration, loss float64, alive bool) {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	hs.expire(t)
+
+	lost := 0
+	for i := 0; i < hs.nsamples; i++ {
+		if hs.samples[i] {
+			lost++
+		}
+	}
+	if hs.nsamples > 0 {
+		loss = float64(lost) / float64(hs.nsamples)
+	}
+	alive = !hs.lastEcho.IsZero() && t.Sub(hs.lastEcho) <= hs.window
+	return hs.rtt, loss, alive
+}
+
+type failoverConfig struct {
+	// standby is the number of the warm sessions kept to the lower priority nodes.
+	standby int
+	// interval is the keepalive period of the sessions.
+	interval time.Duration
+	// window is the time a session is considered unhealthy after its last echo.
+	window  time.Duration
+	maxLoss float64
+}
+
+// failover keeps a session per node to the nodes of highest priority of each overlay network,
+// and switches the traffic to the healthy session of highest priority.
+type failover struct {
+	cfg failoverConfig
+
+	mu     sync.RWMutex
+	active map[string]*clientSession
+	// degraded marks the overlay networks left without a healthy session.
+	degraded map[string]bool
+}
+
+func newFailover(cfg failoverConfig) *failover {
+	if cfg.standby < 0 {
+		cfg.standby = 0
+	}
+	if cfg.interval <= 0 {
+		cfg.interval = defaultFailoverInterval
+	}
+	if cfg.window <= 0 {
+		cfg.window = defaultFailoverWindow
+	}
+	if cfg.maxLoss <= 0 || cfg.maxLoss > 1 {
+		cfg.maxLoss = defaultFailoverMaxLoss
+	}
+	return &failover{
+		cfg:      cfg,
+		active:   make(map[string]*clientSession),
+		degraded: make(map[string]bool),
+	}
+}
+
+// session returns the active session of the overlay network, nil if none is healthy.
+func (f *failover) session(network string) *clientSession {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if s := f.active[network]; s != nil && !s.isClosed() {
+		return s
+	}
+	return nil
+}
+
+func (f *failover) healthy(s *clientSession, t time.Time) bool {
+	if s.isClosed() {
+		return false
+	}
+	_, loss, alive := s.health.stats(t)
+	return alive && loss < f.cfg.maxLoss
+}
+
+func (f *failover) run(
+	ctx context.Context,
+	h *tunHandler,
+	ips []net.IP,
+	tun io.Writer,
+	tunMu *sync.Mutex,
+	log logger.Logger,
+	sessions map[string]*clientSession,
+	sessMu *sync.Mutex,
+	errc chan<- error,
+) {
+	defer func() {
+		f.mu.Lock()
+		clear(f.active)
+		clear(f.degraded)
+		f.mu.Unlock()
+	}()
+
+	ticker := time.NewTicker(f.cfg.interval)
+	defer ticker.Stop()
+
+	for {
+		for _, network := range []string{"udp", "tcp"} {
+			nodes := failoverNodes(h.hop, network)
+			if len(nodes) == 0 {
+				continue
+			}
+
+			var candidates []*clientSession
+			for _, node := range nodes {
+				if len(candidates) > f.cfg.standby {
+					break
+				}
+				s, err := h.ensureSession(ctx, node, network, ips, tun, tunMu, log, sessions, sessMu, errc)
+				if err != nil {
+					if ctx.Err() != nil {
+						return
+					}
+					log.Debugf("failover: session to node %s: %v", node.Name, err)
+					continue
+				}
+				candidates = append(candidates, s)
+			}
+
+			f.update(h, network, candidates, log)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// update activates the first healthy session of candidates.
+func (f *failover) update(h *tunHandler, network string, candidates []*clientSession, log logger.Logger) {
+	now := time.Now()
+
+	var next *clientSession
+	for _, s := range candidates {
+		if f.healthy(s, now) {
+			next = s
+			break
+		}
+	}
+
+	f.mu.Lock()
+	prev := f.active[network]
+	degraded := f.degraded[network]
+	if next != nil {
+		f.active[network] = next
+		f.degraded[network] = false
+	} else {
+		delete(f.active, network)
+		f.degraded[network] = prev != nil || degraded
+	}
+	f.mu.Unlock()
+
+	if prev == next {
+		return
+	}
+
+	from, to := "none", "none"
+	if prev != nil {
+		from = f.describe(prev, now)
+	}
+	if next != nil {
+		to = f.describe(next, now)
+	}
+	msg := fmt.Sprintf("tun: %s session switched from %s to %s", network, from, to)
+	if prev == nil && !degraded {
+		// the first session of the network is not a failover.
+		log.Info(msg)
+		return
+	}
+	log.Warn(msg)
+	h.reportEvent(msg)
+}
+
+func (f *failover) describe(s *clientSession, t time.Time) string {
+	rtt, loss, _ := s.health.stats(t)
+	return fmt.Sprintf("%s(%s, rtt=%s, loss=%.0f%%)", s.node.Name, s.raddr, rtt.Round(time.Millisecond), loss*100)
+}
+
+// failoverNodes returns the nodes of the overlay network in the order of priority.
+func failoverNodes(hp hop.Hop, network string) []*chain.Node {
+	nl, ok := hp.(hop.NodeList)
+	if !ok {
+		return nil
+	}
+
+	var nodes []*chain.Node
+	for _, node := range nl.Nodes() {
+		if node == nil {
+			continue
+		}
+		if strings.ToLower(strings.TrimSpace(node.Options().Network)) == network {
+			nodes = append(nodes, node)
+		}
+	}
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return nodes[i].Options().Priority > nodes[j].Options().Priority
+	})
+	return nodes
+}
+
+// reportEvent records msg in the events of the service and notifies the observer.
+func (h *tunHandler) reportEvent(msg string) {
+	state := xservice.StateRunning
+	if svc, ok := registry.ServiceRegistry().Get(h.options.Service).(interface{ Status() *xservice.Status }); ok {
+		status := svc.Status()
+		status.AddEvent(xservice.Event{
+			Time:    time.Now(),
+			Message: msg,
+		})
+		state = status.State()
+	}
+
+	if obs := h.options.Observer; obs != nil {
+		obs.Observe(context.Background(), []observer.Event{xservice.ServiceEvent{
+			Kind:    "handler",
+			Service: h.options.Service,
+			State:   state,
+			Msg:     msg,
+		}})
+	}
+}
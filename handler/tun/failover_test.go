@@ -0,0 +1,125 @@
+package tun
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-gost/core/chain"
+	"github.com/go-gost/core/handler"
+	"github.com/go-gost/core/observer"
+	xlogger "github.com/go-gost/x/logger"
+	xservice "github.com/go-gost/x/service"
+)
+
+func TestSessionHealth(t *testing.T) {
+	hs := &sessionHealth{window: time.Second}
+	now := time.Now()
+
+	if _, _, alive := hs.stats(now); alive {
+		t.Fatal("a session without echo should not be alive")
+	}
+
+	for i := 0; i < 4; i++ {
+		hs.probe(now)
+		now = now.Add(20 * time.Millisecond)
+		hs.echo(now)
+	}
+	rtt, loss, alive := hs.stats(now)
+	if !alive || loss != 0 || rtt != 20*time.Millisecond {
+		t.Fatalf("unexpected stats: rtt=%s loss=%v alive=%v", rtt, loss, alive)
+	}
+
+	// the unanswered probes are lost after the window.
+	for i := 0; i < 4; i++ {
+		hs.probe(now)
+	}
+	now = now.Add(2 * time.Second)
+	_, loss, alive = hs.stats(now)
+	if alive || loss != 0.5 {
+		t.Fatalf("unexpected stats: loss=%v alive=%v", loss, alive)
+	}
+
+	// an echo matches the oldest pending probe.
+	hs.probe(now)
+	hs.probe(now.Add(100 * time.Millisecond))
+	hs.echo(now.Add(300 * time.Millisecond))
+	if rtt, _, _ := hs.stats(now.Add(300 * time.Millisecond)); rtt <= 20*time.Millisecond {
+		t.Fatalf("rtt not updated: %s", rtt)
+	}
+}
+
+type testObserver struct {
+	events []observer.Event
+}
+
+func (o *testObserver) Observe(ctx context.Context, events []observer.Event, opts ...observer.Option) error {
+	o.events = append(o.events, events...)
+	return nil
+}
+
+func TestFailover_Update(t *testing.T) {
+	obs := &testObserver{}
+	h := &tunHandler{
+		options: handler.Options{Observer: obs, Service: "tun-client"},
+	}
+	f := newFailover(failoverConfig{window: time.Second})
+	log := xlogger.Nop()
+
+	newSession := func(name string) *clientSession {
+		return &clientSession{
+			node:    &chain.Node{Name: name},
+			raddr:   name + ":8421",
+			closed:  make(chan struct{}),
+			writeCh: make(chan []byte),
+			health:  &sessionHealth{window: time.Second},
+		}
+	}
+	primary, standby := newSession("primary"), newSession("standby")
+	now := time.Now()
+	for _, s := range []*clientSession{primary, standby} {
+		s.health.probe(now)
+		s.health.echo(now)
+	}
+
+	f.update(h, "udp", []*clientSession{primary, standby}, log)
+	if f.session("udp") != primary {
+		t.Fatal("the primary session should be active")
+	}
+	if len(obs.events) != 0 {
+		t.Fatalf("unexpected events: %v", obs.events)
+	}
+
+	// the primary session dies.
+	primary.close()
+	f.update(h, "udp", []*clientSession{primary, standby}, log)
+	if f.session("udp") != standby {
+		t.Fatal("the standby session should be active")
+	}
+	if len(obs.events) != 1 {
+		t.Fatalf("expected 1 event, got %d", len(obs.events))
+	}
+	ev := obs.events[0].(xservice.ServiceEvent)
+	if ev.Service != "tun-client" || !strings.Contains(ev.Msg, "from primary") || !strings.Contains(ev.Msg, "to standby") {
+		t.Fatalf("unexpected event %+v", ev)
+	}
+
+	// no healthy session is left, then the primary comes back.
+	standby.close()
+	f.update(h, "udp", []*clientSession{primary, standby}, log)
+	if f.session("udp") != nil {
+		t.Fatal("no session should be active")
+	}
+
+	primary = newSession("primary")
+	primary.health.probe(time.Now())
+	primary.health.echo(time.Now())
+	f.update(h, "udp", []*clientSession{primary}, log)
+	if f.session("udp") != primary {
+		t.Fatal("the primary session should be active")
+	}
+	if len(obs.events) != 3 {
+		t.Fatalf("expected 3 events, got %d", len(obs.events))
+	}
+}
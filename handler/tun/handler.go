@@ -10,9 +10,13 @@ import (
 	"github.com/go-gost/core/handler"
 	"github.com/go-gost/core/hop"
 	md "github.com/go-gost/core/metadata"
+	"github.com/go-gost/core/recorder"
 	xctx "github.com/go-gost/x/ctx"
 	ictx "github.com/go-gost/x/internal/ctx"
+	"github.com/go-gost/x/internal/util/pcap"
 	tun_util "github.com/go-gost/x/internal/util/tun"
+	xlogger "github.com/go-gost/x/logger"
+	xrecorder "github.com/go-gost/x/recorder"
 	"github.com/go-gost/x/registry"
 )
 
@@ -32,6 +36,19 @@ type tunHandler struct {
 	dec     DecisionEvaluator
 	direct  *directForwarder
 	options handler.Options
+
+	// sockOwner attributes the flows to the local processes when dec does not.
+	sockOwner MetadataResolver
+	// secure is the configuration of the encrypted transport, nil if the transport is plaintext.
+	secure *secureConfig
+	// ipam allocates the client addresses on the server, nil if the addresses are static.
+	ipam *ipamPool
+	// failover keeps the standby sessions of the client, nil if it is disabled.
+	failover *failover
+	// tap is the capture point of the service.
+	tap *pcap.Tap
+	// recorder records the decisions of the packet flows of the client.
+	recorder recorder.RecorderObject
 }
 
 func NewHandler(opts ...handler.Option) handler.Handler {
@@ -50,6 +67,29 @@ func (h *tunHandler) Init(md md.Metadata) (err error) {
 		return
 	}
 
+	if cfg := h.md.ipamPool; cfg != nil {
+		log := h.options.Logger
+		if log == nil {
+			log = xlogger.Nop()
+		}
+		h.ipam = newIPAMPool(cfg, log)
+	}
+	if cfg := h.md.failover; cfg != nil {
+		h.failover = newFailover(*cfg)
+	}
+	// the lookups run in the background, the packets of the client are not held up by them.
+	if r := NewSockOwnerResolver(h.md.sockOwner); r != nil {
+		h.sockOwner = newAsyncResolver(r)
+	}
+	h.tap = pcap.GetTap(h.options.Service)
+
+	for _, ro := range h.options.Recorders {
+		if ro.Record == xrecorder.RecorderServiceHandler {
+			h.recorder = ro
+			break
+		}
+	}
+
 	if h.options.Logger != nil {
 		rt0 := md.Get("tun.relayTarget")
 		rt1 := md.Get("relayTarget")
@@ -68,6 +108,18 @@ func (h *tunHandler) Init(md md.Metadata) (err error) {
 	return
 }
 
+// ValidateMetadata checks the metadata only, Init also loads the address leases
+// and attaches the handler to the packet capture of the service.
+func (h *tunHandler) ValidateMetadata(md md.Metadata) error {
+	return h.parseMetadata(md)
+}
+
+// Close releases the capture point of the service.
+func (h *tunHandler) Close() error {
+	h.tap.Release()
+	return nil
+}
+
 // Forward implements handler.Forwarder.
 func (h *tunHandler) Forward(hop hop.Hop) {
 	h.hop = hop
@@ -79,8 +131,10 @@ func (h *tunHandler) Handle(ctx context.Context, conn net.Conn, opts ...handler.
 	log := h.options.Logger
 
 	var config *tun_util.Config
+	var configurator tun_util.Configurator
 	if md := ictx.MetadataFromContext(ctx); md != nil {
 		config, _ = md.Get("config").(*tun_util.Config)
+		configurator, _ = md.Get("configurator").(tun_util.Configurator)
 		if dec, ok := md.Get("decisionEvaluator").(DecisionEvaluator); ok {
 			h.dec = dec
 		}
@@ -106,7 +160,7 @@ func (h *tunHandler) Handle(ctx context.Context, conn net.Conn, opts ...handler.
 	}()
 
 	if h.hop != nil {
-		if err := h.handleClient(ctx, conn, config, log); err != nil {
+		if err := h.handleClient(ctx, conn, config, configurator, log); err != nil {
 			log.Error(err)
 		}
 		return nil
@@ -0,0 +1,622 @@
+package tun
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-gost/core/auth"
+	"github.com/go-gost/core/chain"
+	"github.com/go-gost/core/logger"
+	"github.com/go-gost/core/router"
+	tun_util "github.com/go-gost/x/internal/util/tun"
+	xrouter "github.com/go-gost/x/router"
+)
+
+// The address allocation extends the keepalive handshake, a client without static addresses
+// sends requests instead of keepalives and configures its TUN device from the offers:
+//
+//	request: "GIPM" | key(16) | JSON ipamRequest
+//	offer:   "GIPM" | JSON ipamOffer
+//
+// Every request renews the lease of the client. The requests are authenticated with the key,
+// by the Auther for the client ID or else against the token. The clients sharing the token
+// are trusted alike, their leases are bound to the client ID of the request, so a client keeps
+// its addresses across reconnects. Use an Auther if the client IDs must be proven.
+var (
+	ipamMagicHeader = []byte("GIPM")
+)
+
+const (
+	defaultIPAMLeaseTime = time.Hour
+	// ipamMaxScan bounds the search of a free address in large pools.
+	ipamMaxScan = 1 << 16
+
+	ipamRequestRetry   = time.Second
+	ipamRequestTimeout = 10 * time.Second
+)
+
+var (
+	errIPAMExhausted    = errors.New("tun: address pool exhausted")
+	errIPAMLeaseChanged = errors.New("tun: address lease changed")
+	errIPAMAuthRequired = errors.New("tun: address allocation requires an auther or a token")
+)
+
+type ipamRequest struct {
+	ID string `json:"id,omitempty"`
+	// IPs are the addresses in use by the client, they are kept if still available.
+	IPs []string `json:"ips,omitempty"`
+}
+
+type ipamOffer struct {
+	Net    []string `json:"net,omitempty"`
+	Routes []string `json:"routes,omitempty"`
+	DNS    []string `json:"dns,omitempty"`
+	Error  string   `json:"error,omitempty"`
+}
+
+func (o *ipamOffer) equal(other *ipamOffer) bool {
+	if o == nil || other == nil {
+		return o == other
+	}
+	return slices.Equal(o.Net, other.Net) &&
+		slices.Equal(o.Routes, other.Routes) &&
+		slices.Equal(o.DNS, other.DNS)
+}
+
+func (o *ipamOffer) ips() (ips []net.IP) {
+	for _, s := range o.Net {
+		if ip, _, err := net.ParseCIDR(s); err == nil {
+			ips = append(ips, ip)
+		}
+	}
+	return
+}
+
+type ipamConfig struct {
+	// pools are the prefixes the addresses are allocated from, one address per prefix.
+	pools []netip.Prefix
+	// static pins addresses to the client IDs.
+	static    map[string][]netip.Addr
+	routes    []string
+	dns       []string
+	leaseTime time.Duration
+	leaseFile string
+}
+
+// parseIPAMConfig parses the pools, the static entries in the form of "ID IP[,IP]", the routes and the DNS servers.
+func parseIPAMConfig(pools []string, static []string, routes []string, dns []string) (*ipamConfig, error) {
+	cfg := &ipamConfig{
+		static: make(map[string][]netip.Addr),
+	}
+
+	for _, s := range pools {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address pool %s: %w", s, err)
+		}
+		cfg.pools = append(cfg.pools, prefix.Masked())
+	}
+
+	for _, s := range static {
+		ss := strings.Fields(s)
+		if len(ss) != 2 {
+			return nil, fmt.Errorf("invalid static address %q", s)
+		}
+		for _, v := range strings.Split(ss[1], ",") {
+			addr, err := netip.ParseAddr(strings.TrimSpace(v))
+			if err != nil {
+				return nil, fmt.Errorf("invalid static address %q: %w", s, err)
+			}
+			if cfg.prefixOf(addr) == nil {
+				return nil, fmt.Errorf("static address %s of %s is not in the pools", addr, ss[0])
+			}
+			cfg.static[ss[0]] = append(cfg.static[ss[0]], addr)
+		}
+	}
+
+	for _, s := range routes {
+		if _, err := netip.ParsePrefix(s); err != nil {
+			return nil, fmt.Errorf("invalid route %s: %w", s, err)
+		}
+		cfg.routes = append(cfg.routes, s)
+	}
+	for _, s := range dns {
+		if _, err := netip.ParseAddr(s); err != nil {
+			return nil, fmt.Errorf("invalid dns server %s: %w", s, err)
+		}
+		cfg.dns = append(cfg.dns, s)
+	}
+
+	return cfg, nil
+}
+
+func (c *ipamConfig) prefixOf(addr netip.Addr) *netip.Prefix {
+	for i := range c.pools {
+		if c.pools[i].Contains(addr) {
+			return &c.pools[i]
+		}
+	}
+	return nil
+}
+
+type ipamLease struct {
+	ID      string       `json:"id"`
+	IPs     []netip.Addr `json:"ips"`
+	Expires time.Time    `json:"expires"`
+}
+
+// ipamPool allocates the client addresses. An expired lease is kept for its client
+// until the address is needed by another one.
+type ipamPool struct {
+	cfg *ipamConfig
+	log logger.Logger
+
+	mu       sync.Mutex
+	reserved map[netip.Addr]struct{}
+	leases   map[string]*ipamLease
+	owners   map[netip.Addr]string
+}
+
+func newIPAMPool(cfg *ipamConfig, log logger.Logger) *ipamPool {
+	if cfg.leaseTime <= 0 {
+		cfg.leaseTime = defaultIPAMLeaseTime
+	}
+
+	p := &ipamPool{
+		cfg:      cfg,
+		log:      log,
+		reserved: make(map[netip.Addr]struct{}),
+		leases:   make(map[string]*ipamLease),
+		owners:   make(map[netip.Addr]string),
+	}
+	for id, addrs := range cfg.static {
+		for _, addr := range addrs {
+			p.owners[addr] = id
+		}
+	}
+
+	if err := p.load(); err != nil {
+		log.Warnf("load address leases from %s: %v", cfg.leaseFile, err)
+	}
+	return p
+}
+
+// reserve excludes the addresses of the server from the allocation.
+func (p *ipamPool) reserve(nets []net.IPNet) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, n := range nets {
+		if addr, ok := netip.AddrFromSlice(n.IP); ok {
+			p.reserved[addr.Unmap()] = struct{}{}
+		}
+	}
+}
+
+// allocate returns the renewed lease of the client, the addresses in prefer are kept if available.
+func (p *ipamPool) allocate(id string, prefer []netip.Addr) (*ipamLease, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	lease := p.leases[id]
+
+	var ips []netip.Addr
+	for _, prefix := range p.cfg.pools {
+		addr, ok := p.pick(id, prefix, lease, prefer, now)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", errIPAMExhausted, prefix)
+		}
+		ips = append(ips, addr)
+	}
+
+	changed := lease == nil || !slices.Equal(lease.IPs, ips)
+	if lease != nil {
+		for _, addr := range lease.IPs {
+			if !slices.Contains(ips, addr) && p.owners[addr] == id {
+				delete(p.owners, addr)
+			}
+		}
+	}
+	lease = &ipamLease{
+		ID:      id,
+		IPs:     ips,
+		Expires: now.Add(p.cfg.leaseTime),
+	}
+	p.leases[id] = lease
+	for _, addr := range ips {
+		p.owners[addr] = id
+	}
+
+	if changed {
+		p.log.Infof("address lease %s: %v", id, ips)
+		if err := p.save(); err != nil {
+			p.log.Warnf("save address leases to %s: %v", p.cfg.leaseFile, err)
+		}
+	}
+
+	l := *lease
+	return &l, nil
+}
+
+func (p *ipamPool) pick(id string, prefix netip.Prefix, lease *ipamLease, prefer []netip.Addr, now time.Time) (netip.Addr, bool) {
+	for _, addr := range p.cfg.static[id] {
+		if prefix.Contains(addr) {
+			return addr, true
+		}
+	}
+	if lease != nil {
+		for _, addr := range lease.IPs {
+			if prefix.Contains(addr) && p.owners[addr] == id {
+				return addr, true
+			}
+		}
+	}
+	for _, addr := range prefer {
+		if prefix.Contains(addr) && p.usable(prefix, addr) && p.available(id, addr) {
+			return addr, true
+		}
+	}
+
+	addr := prefix.Addr()
+	for i := 0; i < ipamMaxScan && addr.IsValid() && prefix.Contains(addr); i++ {
+		if p.usable(prefix, addr) && p.available(id, addr) {
+			return addr, true
+		}
+		addr = addr.Next()
+	}
+
+	// reclaim the address of the least recently renewed expired lease.
+	var oldest *ipamLease
+	var reclaim netip.Addr
+	for _, l := range p.leases {
+		if l.ID == id || !l.Expires.Before(now) || (oldest != nil && !l.Expires.Before(oldest.Expires)) {
+			continue
+		}
+		for _, addr := range l.IPs {
+			if prefix.Contains(addr) && p.owners[addr] == l.ID && !p.isStatic(addr) {
+				oldest, reclaim = l, addr
+				break
+			}
+		}
+	}
+	if oldest == nil {
+		return netip.Addr{}, false
+	}
+
+	p.log.Debugf("address %s reclaimed from the expired lease of %s", reclaim, oldest.ID)
+	oldest.IPs = slices.DeleteFunc(oldest.IPs, func(addr netip.Addr) bool { return addr == reclaim })
+	if len(oldest.IPs) == 0 {
+		delete(p.leases, oldest.ID)
+	}
+	delete(p.owners, reclaim)
+	return reclaim, true
+}
+
+// usable reports whether the address can be assigned to a host, the network and broadcast addresses are not.
+func (p *ipamPool) usable(prefix netip.Prefix, addr netip.Addr) bool {
+	if addr == prefix.Addr() && prefix.Bits() < addr.BitLen()-1 {
+		return false
+	}
+	if addr.Is4() && prefix.Bits() < 31 && !prefix.Contains(addr.Next()) {
+		return false
+	}
+	_, reserved := p.reserved[addr]
+	return !reserved
+}
+
+func (p *ipamPool) available(id string, addr netip.Addr) bool {
+	owner, ok := p.owners[addr]
+	return !ok || owner == id
+}
+
+func (p *ipamPool) isStatic(addr netip.Addr) bool {
+	for _, addrs := range p.cfg.static {
+		if slices.Contains(addrs, addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *ipamPool) offer(lease *ipamLease) *ipamOffer {
+	offer := &ipamOffer{
+		Routes: p.cfg.routes,
+		DNS:    p.cfg.dns,
+	}
+	for _, addr := range lease.IPs {
+		if prefix := p.cfg.prefixOf(addr); prefix != nil {
+			offer.Net = append(offer.Net, netip.PrefixFrom(addr, prefix.Bits()).String())
+		}
+	}
+	return offer
+}
+
+type ipamLeaseFile struct {
+	Leases []*ipamLease `json:"leases"`
+}
+
+func (p *ipamPool) load() error {
+	if p.cfg.leaseFile == "" {
+		return nil
+	}
+
+	b, err := os.ReadFile(p.cfg.leaseFile)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	var f ipamLeaseFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return err
+	}
+
+	for _, lease := range f.Leases {
+		if lease == nil || lease.ID == "" {
+			continue
+		}
+		// the pools may have changed since the leases were saved.
+		lease.IPs = slices.DeleteFunc(lease.IPs, func(addr netip.Addr) bool {
+			prefix := p.cfg.prefixOf(addr)
+			return prefix == nil || !p.usable(*prefix, addr) || !p.available(lease.ID, addr)
+		})
+		if len(lease.IPs) == 0 {
+			continue
+		}
+		p.leases[lease.ID] = lease
+		for _, addr := range lease.IPs {
+			p.owners[addr] = lease.ID
+		}
+	}
+	return nil
+}
+
+// save writes the leases to the lease file, p.mu must be held.
+func (p *ipamPool) save() error {
+	if p.cfg.leaseFile == "" {
+		return nil
+	}
+
+	f := ipamLeaseFile{}
+	for _, lease := range p.leases {
+		f.Leases = append(f.Leases, lease)
+	}
+	sort.Slice(f.Leases, func(i, j int) bool { return f.Leases[i].ID < f.Leases[j].ID })
+
+	b, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(p.cfg.leaseFile), filepath.Base(p.cfg.leaseFile)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), p.cfg.leaseFile)
+}
+
+// handleIPAMRequest answers the address request of a client and routes the leased addresses to it.
+func (h *tunHandler) handleIPAMRequest(ctx context.Context, conn net.PacketConn, b []byte, addr net.Addr, log logger.Logger) {
+	if h.ipam == nil {
+		log.Debugf("address request from %v, address allocation is disabled", addr)
+		return
+	}
+
+	var req ipamRequest
+	if err := json.Unmarshal(b[keepAliveHeaderLength:], &req); err != nil {
+		log.Warnf("address request from %v: %v", addr, err)
+		return
+	}
+
+	id, err := h.ipamIdentity(ctx, &req, bytes.TrimRight(b[4:20], "\x00"), addr)
+	if err != nil {
+		log.Warnf("address request from %v (%s): %v", addr, req.ID, err)
+		return
+	}
+
+	var prefer []netip.Addr
+	for _, s := range req.IPs {
+		if v, err := netip.ParseAddr(s); err == nil {
+			prefer = append(prefer, v.Unmap())
+		}
+	}
+
+	offer := &ipamOffer{}
+	lease, err := h.ipam.allocate(id, prefer)
+	if err != nil {
+		log.Warnf("address request from %v (%s): %v", addr, id, err)
+		offer.Error = err.Error()
+	} else {
+		log.Debugf("address request from %v (%s) => %v", addr, id, lease.IPs)
+		offer = h.ipam.offer(lease)
+		for _, ip := range lease.IPs {
+			h.updateRoute(net.IP(ip.AsSlice()), addr, log)
+		}
+	}
+
+	data, _ := json.Marshal(offer)
+	msg := make([]byte, 0, len(ipamMagicHeader)+len(data))
+	msg = append(msg, ipamMagicHeader...)
+	msg = append(msg, data...)
+	if _, err := conn.WriteTo(msg, addr); err != nil {
+		log.Warnf("address offer to %v: %v", addr, err)
+	}
+}
+
+// ipamIdentity authenticates the address request and returns the ID the lease is bound to.
+func (h *tunHandler) ipamIdentity(ctx context.Context, req *ipamRequest, key []byte, addr net.Addr) (string, error) {
+	if auther := h.options.Auther; auther != nil {
+		id, ok := auther.Authenticate(ctx, req.ID, string(key), auth.WithService(h.options.Service))
+		if !ok {
+			return "", errors.New("auth FAILED")
+		}
+		if id == "" {
+			id = req.ID
+		}
+		if id == "" {
+			id = addr.String()
+		}
+		return id, nil
+	}
+
+	if h.md.passphrase == "" {
+		return "", errIPAMAuthRequired
+	}
+	token := []byte(h.md.passphrase)
+	if len(token) > 16 {
+		token = token[:16]
+	}
+	if subtle.ConstantTimeCompare(key, bytes.TrimRight(token, "\x00")) != 1 {
+		return "", errors.New("invalid token")
+	}
+	// the clients sharing the token are trusted alike, the lease is bound to the client ID
+	// so that it is kept when the client reconnects from another address.
+	if req.ID != "" {
+		return req.ID, nil
+	}
+	return addr.String(), nil
+}
+
+// ipamRequestMessage builds the address request of the client, ips are the addresses in use.
+func (h *tunHandler) ipamRequestMessage(ips []net.IP) []byte {
+	req := ipamRequest{
+		ID: h.md.ipamID,
+	}
+	for _, ip := range ips {
+		req.IPs = append(req.IPs, ip.String())
+	}
+	data, _ := json.Marshal(req)
+
+	msg := make([]byte, keepAliveHeaderLength, keepAliveHeaderLength+len(data))
+	copy(msg[:4], ipamMagicHeader)
+	copy(msg[4:20], []byte(h.md.passphrase))
+	return append(msg, data...)
+}
+
+func parseIPAMOffer(b []byte) (*ipamOffer, error) {
+	offer := &ipamOffer{}
+	if err := json.Unmarshal(b[len(ipamMagicHeader):], offer); err != nil {
+		return nil, err
+	}
+	if offer.Error != "" {
+		return nil, errors.New(offer.Error)
+	}
+	if len(offer.Net) == 0 {
+		return nil, errors.New("tun: empty address offer")
+	}
+	return offer, nil
+}
+
+// requestLease requests the addresses of the client from the server of node.
+func (h *tunHandler) requestLease(ctx context.Context, node *chain.Node, ips []net.IP, log logger.Logger) (*ipamOffer, error) {
+	if node == nil {
+		return nil, errors.New("tun: no available node for address request")
+	}
+
+	network := strings.ToLower(strings.TrimSpace(node.Options().Network))
+	if network == "" {
+		network = "udp"
+	}
+	if _, _, err := net.SplitHostPort(node.Addr); err != nil {
+		network = "ip"
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, ipamRequestTimeout)
+	defer cancel()
+
+	conn, err := h.dialNode(ctx, node, network, log)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	msg := h.ipamRequestMessage(ips)
+	deadline, _ := ctx.Deadline()
+
+	var b [MaxMessageSize]byte
+	for time.Now().Before(deadline) {
+		if _, err := conn.Write(msg); err != nil {
+			return nil, err
+		}
+
+		conn.SetReadDeadline(time.Now().Add(ipamRequestRetry))
+		for {
+			n, err := conn.Read(b[:])
+			if err != nil {
+				if ne, ok := err.(net.Error); ok && ne.Timeout() {
+					break
+				}
+				return nil, err
+			}
+			if n > len(ipamMagicHeader) && bytes.Equal(b[:4], ipamMagicHeader) {
+				return parseIPAMOffer(b[:n])
+			}
+		}
+	}
+	return nil, errors.New("tun: address request timeout")
+}
+
+// applyLease configures the TUN device from the offer and returns the leased addresses.
+func (h *tunHandler) applyLease(config *tun_util.Config, configurator tun_util.Configurator, offer *ipamOffer, log logger.Logger) ([]net.IP, error) {
+	var nets []net.IPNet
+	for _, s := range offer.Net {
+		ip, ipNet, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address offer %s: %w", s, err)
+		}
+		nets = append(nets, net.IPNet{IP: ip, Mask: ipNet.Mask})
+	}
+
+	var routes []*router.Route
+	for _, s := range offer.Routes {
+		if route := xrouter.ParseRoute(s, ""); route != nil && route.Net != nil {
+			routes = append(routes, route)
+		}
+	}
+
+	var dns []net.IP
+	for _, s := range offer.DNS {
+		if ip := net.ParseIP(s); ip != nil {
+			dns = append(dns, ip)
+		}
+	}
+
+	if configurator == nil {
+		return nil, errors.New("tun: the device can not be configured")
+	}
+	if err := configurator.Configure(nets, routes, dns); err != nil {
+		return nil, err
+	}
+	log.Infof("address lease: net %v, routes %v, dns %v", offer.Net, offer.Routes, offer.DNS)
+
+	config.Net = nets
+	if len(dns) > 0 {
+		config.DNS = dns
+	}
+	return collectIPs(nets), nil
+}
@@ -0,0 +1,237 @@
+package tun
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-gost/core/auth"
+	"github.com/go-gost/core/handler"
+	xlogger "github.com/go-gost/x/logger"
+)
+
+func testIPAMPool(t *testing.T, pools []string, static []string, leaseFile string) *ipamPool {
+	cfg, err := parseIPAMConfig(pools, static, []string{"192.168.0.0/16"}, []string{"10.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.leaseFile = leaseFile
+	return newIPAMPool(cfg, xlogger.Nop())
+}
+
+func TestIPAMPool_Allocate(t *testing.T) {
+	p := testIPAMPool(t, []string{"10.0.0.0/24", "fd00::/64"}, []string{"bob 10.0.0.2"}, "")
+	_, ipNet, _ := net.ParseCIDR("10.0.0.1/24")
+	p.reserve([]net.IPNet{{IP: net.ParseIP("10.0.0.1"), Mask: ipNet.Mask}})
+
+	alice, err := p.allocate("alice", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the network address, the server address and the pinned address are skipped.
+	expected := []netip.Addr{netip.MustParseAddr("10.0.0.3"), netip.MustParseAddr("fd00::1")}
+	if len(alice.IPs) != 2 || alice.IPs[0] != expected[0] || alice.IPs[1] != expected[1] {
+		t.Fatalf("expected %v, got %v", expected, alice.IPs)
+	}
+
+	offer := p.offer(alice)
+	if len(offer.Net) != 2 || offer.Net[0] != "10.0.0.3/24" || offer.Net[1] != "fd00::1/64" {
+		t.Fatalf("unexpected offer %+v", offer)
+	}
+	if len(offer.Routes) != 1 || len(offer.DNS) != 1 {
+		t.Fatalf("unexpected offer %+v", offer)
+	}
+
+	bob, err := p.allocate("bob", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bob.IPs[0] != netip.MustParseAddr("10.0.0.2") {
+		t.Fatalf("pinned address not allocated: %v", bob.IPs)
+	}
+
+	// the lease is renewed with the same addresses.
+	if again, _ := p.allocate("alice", nil); again.IPs[0] != alice.IPs[0] {
+		t.Fatalf("lease changed: %v -> %v", alice.IPs, again.IPs)
+	}
+
+	// a client keeps its addresses if they are available.
+	carol, err := p.allocate("carol", []netip.Addr{netip.MustParseAddr("10.0.0.100"), netip.MustParseAddr("10.0.0.3")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if carol.IPs[0] != netip.MustParseAddr("10.0.0.100") {
+		t.Fatalf("preferred address not allocated: %v", carol.IPs)
+	}
+	dave, _ := p.allocate("dave", []netip.Addr{netip.MustParseAddr("10.0.0.3")})
+	if dave.IPs[0] == alice.IPs[0] {
+		t.Fatal("address leased twice")
+	}
+}
+
+func TestIPAMPool_Exhausted(t *testing.T) {
+	p := testIPAMPool(t, []string{"10.0.0.0/30"}, nil, "")
+
+	// only 10.0.0.1 and 10.0.0.2 can be assigned.
+	for _, id := range []string{"a", "b"} {
+		if _, err := p.allocate(id, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := p.allocate("c", nil); err == nil {
+		t.Fatal("pool should be exhausted")
+	}
+
+	// the address of an expired lease is reclaimed.
+	p.leases["a"].Expires = time.Now().Add(-time.Second)
+	lease, err := p.allocate("c", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lease.IPs[0] != netip.MustParseAddr("10.0.0.1") {
+		t.Fatalf("expected the address of a, got %v", lease.IPs)
+	}
+	if _, ok := p.leases["a"]; ok {
+		t.Fatal("the reclaimed lease should be removed")
+	}
+}
+
+func TestIPAMPool_LeaseFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "leases.json")
+
+	p := testIPAMPool(t, []string{"10.0.0.0/24"}, nil, file)
+	for _, id := range []string{"a", "b", "c"} {
+		if _, err := p.allocate(id, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b, _ := p.allocate("b", nil)
+
+	// the leases survive a restart in the reverse order of the requests.
+	p = testIPAMPool(t, []string{"10.0.0.0/24"}, nil, file)
+	for _, id := range []string{"d", "c", "b"} {
+		if _, err := p.allocate(id, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if lease := p.leases["b"]; lease.IPs[0] != b.IPs[0] {
+		t.Fatalf("expected %v, got %v", b.IPs, lease.IPs)
+	}
+	if lease := p.leases["d"]; lease.IPs[0] != netip.MustParseAddr("10.0.0.4") {
+		t.Fatalf("unexpected address %v", lease.IPs)
+	}
+}
+
+type testAuther map[string]string
+
+func (a testAuther) Authenticate(ctx context.Context, user, password string, opts ...auth.Option) (string, bool) {
+	key, ok := a[user]
+	return "", ok && key == password
+}
+
+func testIPAMRequest(id, key string) []byte {
+	h := &tunHandler{md: metadata{ipamID: id, passphrase: key}}
+	return h.ipamRequestMessage(nil)
+}
+
+func TestHandleIPAMRequest_Auth(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	h := &tunHandler{
+		options: handler.Options{Auther: testAuther{"alice": "alice-key", "mallory": "mallory-key"}},
+		ipam:    testIPAMPool(t, []string{"10.0.0.0/24"}, []string{"alice 10.0.0.10"}, ""),
+	}
+	route := func(ip string) net.Addr {
+		v, _ := h.routes.Load(ipToTunRouteKey(net.ParseIP(ip)))
+		addr, _ := v.(net.Addr)
+		return addr
+	}
+
+	alice := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 10001}
+	mallory := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 10002}
+
+	h.handleIPAMRequest(context.Background(), pc, testIPAMRequest("alice", "alice-key"), alice, xlogger.Nop())
+	if addr := route("10.0.0.10"); addr == nil || addr.String() != alice.String() {
+		t.Fatalf("the static address of alice is routed to %v", addr)
+	}
+
+	// a second peer claiming the ID of alice is refused, with its own key or a guessed one.
+	for _, key := range []string{"mallory-key", "guess", ""} {
+		h.handleIPAMRequest(context.Background(), pc, testIPAMRequest("alice", key), mallory, xlogger.Nop())
+		if addr := route("10.0.0.10"); addr.String() != alice.String() {
+			t.Fatalf("the address of alice is routed to %v", addr)
+		}
+	}
+
+	h.handleIPAMRequest(context.Background(), pc, testIPAMRequest("mallory", "mallory-key"), mallory, xlogger.Nop())
+	lease := h.ipam.leases["mallory"]
+	if lease == nil || lease.IPs[0] == netip.MustParseAddr("10.0.0.10") {
+		t.Fatalf("unexpected lease of mallory %v", lease)
+	}
+}
+
+func TestIPAMIdentity_Token(t *testing.T) {
+	h := &tunHandler{}
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 10001}
+
+	req := &ipamRequest{ID: "alice"}
+	if _, err := h.ipamIdentity(context.Background(), req, nil, addr); err != errIPAMAuthRequired {
+		t.Fatalf("request without auth accepted: %v", err)
+	}
+
+	h.md.passphrase = "secret"
+	if _, err := h.ipamIdentity(context.Background(), req, []byte("other"), addr); err == nil {
+		t.Fatal("request with a wrong token accepted")
+	}
+	// the lease is bound to the client ID, not to the address the request is sent from.
+	id, err := h.ipamIdentity(context.Background(), req, []byte("secret"), addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "alice" {
+		t.Fatalf("unexpected identity %s", id)
+	}
+	other := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 2), Port: 20001}
+	if id, _ := h.ipamIdentity(context.Background(), req, []byte("secret"), other); id != "alice" {
+		t.Fatalf("unexpected identity %s from another address", id)
+	}
+
+	// the request without a client ID is bound to the address.
+	if id, _ := h.ipamIdentity(context.Background(), &ipamRequest{}, []byte("secret"), addr); id != addr.String() {
+		t.Fatalf("unexpected identity %s", id)
+	}
+}
+
+func TestHandleIPAMRequest_Token(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	h := &tunHandler{
+		md:   metadata{passphrase: "secret"},
+		ipam: testIPAMPool(t, []string{"10.0.0.0/24"}, nil, ""),
+	}
+
+	// the client reconnecting from another port keeps its address.
+	h.handleIPAMRequest(context.Background(), pc, testIPAMRequest("alice", "secret"),
+		&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 10001}, xlogger.Nop())
+	lease := h.ipam.leases["alice"]
+	if lease == nil {
+		t.Fatal("no lease of alice")
+	}
+	ip := lease.IPs[0]
+	h.handleIPAMRequest(context.Background(), pc, testIPAMRequest("alice", "secret"),
+		&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 10002}, xlogger.Nop())
+	if len(h.ipam.leases) != 1 || h.ipam.leases["alice"].IPs[0] != ip {
+		t.Fatalf("unexpected leases %v", h.ipam.leases)
+	}
+}
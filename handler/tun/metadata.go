@@ -1,7 +1,10 @@
 package tun
 
 import (
+	"errors"
 	"math"
+	"os"
+	"strings"
 	"time"
 
 	mdata "github.com/go-gost/core/metadata"
@@ -18,11 +21,23 @@ type metadata struct {
 	passphrase      string
 	relayTarget     string
 	p2p             bool
+
+	// ipam requests the addresses from the server, ipamID identifies the client.
+	ipam   bool
+	ipamID string
+
+	// ipamPool and failover are the settings of the address pool and of the failover,
+	// nil if disabled. sockOwner is the mode of the socket owner resolver.
+	ipamPool  *ipamConfig
+	failover  *failoverConfig
+	sockOwner string
 }
 
 func (h *tunHandler) parseMetadata(md mdata.Metadata) (err error) {
 	if dec, ok := md.Get("decisionEvaluator").(DecisionEvaluator); ok {
 		h.dec = dec
+	} else if name := mdutil.GetString(md, "tun.decision", "decision"); name != "" {
+		h.dec = RegisteredDecisionEvaluator(name)
 	}
 
 	if mdutil.GetBool(md, "tun.keepalive", "keepalive") {
@@ -37,5 +52,72 @@ func (h *tunHandler) parseMetadata(md mdata.Metadata) (err error) {
 	// request with a non-empty destination address.
 	h.md.relayTarget = mdutil.GetString(md, "tun.relayTarget", "relayTarget", "relay_target")
 	h.md.p2p = mdutil.GetBool(md, "tun.p2p", "p2p")
+
+	if mdutil.GetBool(md, "tun.secure", "secure") {
+		h.secure, err = newSecureConfig(
+			h.md.passphrase,
+			mdutil.GetString(md, "tun.secure.privateKey", "secure.privateKey"),
+			mdutil.GetString(md, "tun.secure.peerKey", "secure.peerKey"),
+			mdutil.GetStrings(md, "tun.secure.peerKeys", "secure.peerKeys"),
+			mdutil.GetDuration(md, "tun.secure.rekey", "secure.rekey"),
+		)
+		if err != nil {
+			return
+		}
+	}
+
+	h.md.ipam = mdutil.GetBool(md, "tun.ipam", "ipam")
+	h.md.ipamID = mdutil.GetString(md, "tun.ipam.id", "ipam.id")
+	if h.md.ipam && h.md.ipamID == "" {
+		h.md.ipamID, _ = os.Hostname()
+	}
+
+	if v := mdutil.GetString(md, "tun.ipam.pool", "ipam.pool"); v != "" {
+		var cfg *ipamConfig
+		cfg, err = parseIPAMConfig(
+			splitList(v),
+			mdutil.GetStrings(md, "tun.ipam.static", "ipam.static"),
+			splitList(mdutil.GetString(md, "tun.ipam.routes", "ipam.routes")),
+			splitList(mdutil.GetString(md, "tun.ipam.dns", "ipam.dns")),
+		)
+		if err != nil {
+			return
+		}
+		if h.options.Auther == nil {
+			if h.md.passphrase == "" {
+				return errIPAMAuthRequired
+			}
+			if len(cfg.static) > 0 {
+				return errors.New("tun: static addresses require an auther")
+			}
+		}
+		cfg.leaseTime = mdutil.GetDuration(md, "tun.ipam.leaseTime", "ipam.leaseTime")
+		cfg.leaseFile = mdutil.GetString(md, "tun.ipam.leaseFile", "ipam.leaseFile")
+		h.md.ipamPool = cfg
+	}
+
+	if mdutil.GetBool(md, "tun.failover", "failover") {
+		cfg := failoverConfig{
+			standby:  defaultFailoverStandby,
+			interval: mdutil.GetDuration(md, "tun.failover.interval", "failover.interval"),
+			window:   mdutil.GetDuration(md, "tun.failover.window", "failover.window"),
+			maxLoss:  mdutil.GetFloat(md, "tun.failover.loss", "failover.loss"),
+		}
+		if mdutil.IsExists(md, "tun.failover.standby", "failover.standby") {
+			cfg.standby = mdutil.GetInt(md, "tun.failover.standby", "failover.standby")
+		}
+		h.md.failover = &cfg
+	}
+
+	h.md.sockOwner = mdutil.GetString(md, "tun.sockowner", "sockowner")
+	return
+}
+
+func splitList(s string) (ss []string) {
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			ss = append(ss, v)
+		}
+	}
 	return
 }
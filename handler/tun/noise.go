@@ -0,0 +1,371 @@
+package tun
+
+import (
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// noise implements the subset of the Noise protocol framework (revision 34) used by the
+// secure mode: the 25519 DH functions, the ChaChaPoly cipher and the SHA256 hash,
+// with the NNpsk0, IK and IKpsk2 handshake patterns.
+
+const (
+	noiseKeyLen = 32
+	noiseTagLen = chacha20poly1305.Overhead
+
+	noisePrologue = "gost tun secure v1"
+)
+
+var (
+	errNoiseDecrypt  = errors.New("noise: decryption failed")
+	errNoiseShortMsg = errors.New("noise: message too short")
+)
+
+type noiseToken uint8
+
+const (
+	noiseTokenE noiseToken = iota
+	noiseTokenS
+	noiseTokenEE
+	noiseTokenES
+	noiseTokenSE
+	noiseTokenSS
+	noiseTokenPSK
+)
+
+type noisePattern struct {
+	id   uint8
+	name string
+	// responderStatic is true if the initiator knows the static key of the responder (pre-message).
+	responderStatic bool
+	psk             bool
+	messages        [2][]noiseToken
+}
+
+var (
+	noiseNNpsk0 = &noisePattern{
+		id:   1,
+		name: "Noise_NNpsk0_25519_ChaChaPoly_SHA256",
+		psk:  true,
+		messages: [2][]noiseToken{
+			{noiseTokenPSK, noiseTokenE},
+			{noiseTokenE, noiseTokenEE},
+		},
+	}
+	noiseIK = &noisePattern{
+		id:              2,
+		name:            "Noise_IK_25519_ChaChaPoly_SHA256",
+		responderStatic: true,
+		messages: [2][]noiseToken{
+			{noiseTokenE, noiseTokenES, noiseTokenS, noiseTokenSS},
+			{noiseTokenE, noiseTokenEE, noiseTokenSE},
+		},
+	}
+	noiseIKpsk2 = &noisePattern{
+		id:              3,
+		name:            "Noise_IKpsk2_25519_ChaChaPoly_SHA256",
+		responderStatic: true,
+		psk:             true,
+		messages: [2][]noiseToken{
+			{noiseTokenE, noiseTokenES, noiseTokenS, noiseTokenSS},
+			{noiseTokenE, noiseTokenEE, noiseTokenSE, noiseTokenPSK},
+		},
+	}
+)
+
+func noisePatternByID(id uint8) *noisePattern {
+	for _, p := range []*noisePattern{noiseNNpsk0, noiseIK, noiseIKpsk2} {
+		if p.id == id {
+			return p
+		}
+	}
+	return nil
+}
+
+type noiseCipherState struct {
+	k   [noiseKeyLen]byte
+	set bool
+	n   uint64
+}
+
+func (cs *noiseCipherState) encrypt(dst, ad, plaintext []byte) []byte {
+	if !cs.set {
+		return append(dst, plaintext...)
+	}
+	aead, _ := chacha20poly1305.New(cs.k[:])
+	dst = aead.Seal(dst, noiseNonce(cs.n), plaintext, ad)
+	cs.n++
+	return dst
+}
+
+func (cs *noiseCipherState) decrypt(dst, ad, ciphertext []byte) ([]byte, error) {
+	if !cs.set {
+		return append(dst, ciphertext...), nil
+	}
+	aead, _ := chacha20poly1305.New(cs.k[:])
+	dst, err := aead.Open(dst, noiseNonce(cs.n), ciphertext, ad)
+	if err != nil {
+		return nil, errNoiseDecrypt
+	}
+	cs.n++
+	return dst, nil
+}
+
+func noiseNonce(n uint64) []byte {
+	var nonce [chacha20poly1305.NonceSize]byte
+	binary.LittleEndian.PutUint64(nonce[4:], n)
+	return nonce[:]
+}
+
+type noiseSymmetricState struct {
+	cs noiseCipherState
+	ck [sha256.Size]byte
+	h  [sha256.Size]byte
+}
+
+func (ss *noiseSymmetricState) init(protocolName string) {
+	if len(protocolName) <= sha256.Size {
+		copy(ss.h[:], protocolName)
+	} else {
+		ss.h = sha256.Sum256([]byte(protocolName))
+	}
+	ss.ck = ss.h
+}
+
+func (ss *noiseSymmetricState) mixHash(data []byte) {
+	h := sha256.New()
+	h.Write(ss.h[:])
+	h.Write(data)
+	h.Sum(ss.h[:0])
+}
+
+func (ss *noiseSymmetricState) mixKey(ikm []byte) {
+	var k [noiseKeyLen]byte
+	noiseHKDF(ss.ck[:], ikm, ss.ck[:], k[:])
+	ss.cs = noiseCipherState{k: k, set: true}
+}
+
+func (ss *noiseSymmetricState) mixKeyAndHash(ikm []byte) {
+	var th [sha256.Size]byte
+	var k [noiseKeyLen]byte
+	noiseHKDF(ss.ck[:], ikm, ss.ck[:], th[:], k[:])
+	ss.mixHash(th[:])
+	ss.cs = noiseCipherState{k: k, set: true}
+}
+
+func (ss *noiseSymmetricState) encryptAndHash(dst, plaintext []byte) []byte {
+	n := len(dst)
+	dst = ss.cs.encrypt(dst, ss.h[:], plaintext)
+	ss.mixHash(dst[n:])
+	return dst
+}
+
+func (ss *noiseSymmetricState) decryptAndHash(dst, ciphertext []byte) ([]byte, error) {
+	dst, err := ss.cs.decrypt(dst, ss.h[:], ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	ss.mixHash(ciphertext)
+	return dst, nil
+}
+
+func (ss *noiseSymmetricState) split() (k1, k2 [noiseKeyLen]byte) {
+	noiseHKDF(ss.ck[:], nil, k1[:], k2[:])
+	return
+}
+
+// noiseHKDF is the HKDF function of the Noise specification, the outputs are written to outs.
+func noiseHKDF(ck, ikm []byte, outs ...[]byte) {
+	mac := hmac.New(sha256.New, ck)
+	mac.Write(ikm)
+	temp := mac.Sum(nil)
+
+	var prev []byte
+	for i, out := range outs {
+		mac = hmac.New(sha256.New, temp)
+		mac.Write(prev)
+		mac.Write([]byte{byte(i + 1)})
+		prev = mac.Sum(nil)
+		copy(out, prev)
+	}
+}
+
+// noiseHandshake is the handshake state of one side.
+type noiseHandshake struct {
+	ss        noiseSymmetricState
+	pattern   *noisePattern
+	initiator bool
+	psk       []byte
+
+	s  *ecdh.PrivateKey
+	e  *ecdh.PrivateKey
+	rs *ecdh.PublicKey
+	re *ecdh.PublicKey
+
+	msg int
+}
+
+// newNoiseHandshake creates the handshake state. s is the local static key, rs is the static key of
+// the responder for the initiator of the IK patterns.
+func newNoiseHandshake(pattern *noisePattern, initiator bool, s *ecdh.PrivateKey, rs *ecdh.PublicKey, psk []byte) (*noiseHandshake, error) {
+	if pattern.psk && len(psk) != noiseKeyLen {
+		return nil, errors.New("noise: invalid pre-shared key")
+	}
+	if pattern.responderStatic {
+		if s == nil {
+			return nil, errors.New("noise: missing static key")
+		}
+		if initiator && rs == nil {
+			return nil, errors.New("noise: missing remote static key")
+		}
+	}
+
+	hs := &noiseHandshake{
+		pattern:   pattern,
+		initiator: initiator,
+		psk:       psk,
+		s:         s,
+		rs:        rs,
+	}
+	hs.ss.init(pattern.name)
+	hs.ss.mixHash([]byte(noisePrologue))
+	if pattern.responderStatic {
+		if initiator {
+			hs.ss.mixHash(rs.Bytes())
+		} else {
+			hs.ss.mixHash(s.PublicKey().Bytes())
+		}
+	}
+	return hs, nil
+}
+
+// writeMessage appends the next handshake message carrying payload to dst.
+func (hs *noiseHandshake) writeMessage(dst, payload []byte) ([]byte, error) {
+	for _, token := range hs.pattern.messages[hs.msg] {
+		switch token {
+		case noiseTokenE:
+			e, err := ecdh.X25519().GenerateKey(rand.Reader)
+			if err != nil {
+				return nil, err
+			}
+			hs.e = e
+			dst = append(dst, e.PublicKey().Bytes()...)
+			hs.ss.mixHash(e.PublicKey().Bytes())
+			if hs.pattern.psk {
+				hs.ss.mixKey(e.PublicKey().Bytes())
+			}
+		case noiseTokenS:
+			dst = hs.ss.encryptAndHash(dst, hs.s.PublicKey().Bytes())
+		case noiseTokenPSK:
+			hs.ss.mixKeyAndHash(hs.psk)
+		default:
+			if err := hs.mixDH(token); err != nil {
+				return nil, err
+			}
+		}
+	}
+	hs.msg++
+	return hs.ss.encryptAndHash(dst, payload), nil
+}
+
+// readMessage processes the next handshake message and returns its payload.
+func (hs *noiseHandshake) readMessage(msg []byte) ([]byte, error) {
+	for _, token := range hs.pattern.messages[hs.msg] {
+		switch token {
+		case noiseTokenE:
+			if len(msg) < noiseKeyLen {
+				return nil, errNoiseShortMsg
+			}
+			re, err := ecdh.X25519().NewPublicKey(msg[:noiseKeyLen])
+			if err != nil {
+				return nil, err
+			}
+			hs.re = re
+			msg = msg[noiseKeyLen:]
+			hs.ss.mixHash(re.Bytes())
+			if hs.pattern.psk {
+				hs.ss.mixKey(re.Bytes())
+			}
+		case noiseTokenS:
+			n := noiseKeyLen
+			if hs.ss.cs.set {
+				n += noiseTagLen
+			}
+			if len(msg) < n {
+				return nil, errNoiseShortMsg
+			}
+			b, err := hs.ss.decryptAndHash(nil, msg[:n])
+			if err != nil {
+				return nil, err
+			}
+			rs, err := ecdh.X25519().NewPublicKey(b)
+			if err != nil {
+				return nil, err
+			}
+			hs.rs = rs
+			msg = msg[n:]
+		case noiseTokenPSK:
+			hs.ss.mixKeyAndHash(hs.psk)
+		default:
+			if err := hs.mixDH(token); err != nil {
+				return nil, err
+			}
+		}
+	}
+	hs.msg++
+	return hs.ss.decryptAndHash(nil, msg)
+}
+
+func (hs *noiseHandshake) mixDH(token noiseToken) error {
+	var local *ecdh.PrivateKey
+	var remote *ecdh.PublicKey
+
+	switch token {
+	case noiseTokenEE:
+		local, remote = hs.e, hs.re
+	case noiseTokenES:
+		if hs.initiator {
+			local, remote = hs.e, hs.rs
+		} else {
+			local, remote = hs.s, hs.re
+		}
+	case noiseTokenSE:
+		if hs.initiator {
+			local, remote = hs.s, hs.re
+		} else {
+			local, remote = hs.e, hs.rs
+		}
+	case noiseTokenSS:
+		local, remote = hs.s, hs.rs
+	}
+	if local == nil || remote == nil {
+		return errors.New("noise: missing key")
+	}
+
+	secret, err := local.ECDH(remote)
+	if err != nil {
+		return err
+	}
+	hs.ss.mixKey(secret)
+	return nil
+}
+
+// complete reports whether all the handshake messages are processed.
+func (hs *noiseHandshake) complete() bool {
+	return hs.msg == len(hs.pattern.messages)
+}
+
+// keys returns the transport keys of the completed handshake.
+func (hs *noiseHandshake) keys() (send, recv [noiseKeyLen]byte) {
+	k1, k2 := hs.ss.split()
+	if hs.initiator {
+		return k1, k2
+	}
+	return k2, k1
+}
@@ -0,0 +1,122 @@
+package tun
+
+import (
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AeroCore-IO/avionics/pkg/decision"
+	xrecorder "github.com/go-gost/x/recorder"
+	"github.com/rs/xid"
+)
+
+// flowRecordInterval is the interval of the decision records of a packet flow.
+const flowRecordInterval = time.Minute
+
+// packetFlow identifies the flow of a packet read from the TUN device.
+type packetFlow struct {
+	proto string
+	src   netip.Addr
+	dst   netip.Addr
+	sport int
+	dport int
+}
+
+func newPacketFlow(src, dst net.IP, sport, dport int, proto string) packetFlow {
+	f := packetFlow{
+		proto: strings.ToLower(proto),
+		sport: sport,
+		dport: dport,
+	}
+	f.src, _ = netip.AddrFromSlice(src)
+	f.dst, _ = netip.AddrFromSlice(dst)
+	f.src = f.src.Unmap()
+	f.dst = f.dst.Unmap()
+	return f
+}
+
+// flowRecords limits the decision records of the packet flows. The decision is taken
+// for each packet, a flow is recorded at most once per interval.
+// It is used by the packet dispatcher only and is not safe for concurrent use.
+type flowRecords struct {
+	interval time.Duration
+	last     map[packetFlow]time.Time
+	swept    time.Time
+}
+
+func newFlowRecords(interval time.Duration) *flowRecords {
+	return &flowRecords{
+		interval: interval,
+		last:     make(map[packetFlow]time.Time),
+	}
+}
+
+// allow reports whether the flow is due for a record at now. The flows not recorded
+// for an interval are swept, at most once per interval.
+func (r *flowRecords) allow(now time.Time, f packetFlow) bool {
+	if now.Sub(r.swept) >= r.interval {
+		for k, t := range r.last {
+			if now.Sub(t) >= r.interval {
+				delete(r.last, k)
+			}
+		}
+		r.swept = now
+	}
+
+	if t, ok := r.last[f]; ok && now.Sub(t) < r.interval {
+		return false
+	}
+	r.last[f] = now
+	return true
+}
+
+// decisionRecord returns the record of the decision d of a packet flow, in the form
+// of the flow records of the tungo handler. d is nil if no rule is matched.
+func (h *tunHandler) decisionRecord(f packetFlow, in decision.RuleInput, d *decision.TrafficDecision) *xrecorder.HandlerRecorderObject {
+	o := &xrecorder.DecisionRecorderObject{
+		Input: &xrecorder.DecisionInputRecorderObject{
+			AppID:      in.SteamAppID,
+			DestHost:   in.DestHost,
+			DestPort:   int(in.DestPort),
+			Protocol:   in.Protocol,
+			DestDomain: in.DestDomain,
+		},
+		AppID:    in.SteamAppID,
+		Hostname: in.DestDomain,
+		Action:   strings.ToLower(string(decision.ActionProxy)),
+	}
+	if d != nil {
+		o.Action = strings.ToLower(strings.TrimSpace(string(d.Action)))
+		o.Rule = d.RuleName
+		if ri, ok := h.dec.(RuleIDer); ok {
+			o.RuleID = ri.RuleID(d)
+		}
+		if bc, ok := h.dec.(BandwidthClasser); ok {
+			o.Class = bc.BandwidthClass(d)
+		}
+		if ph, ok := h.dec.(ProxyHoster); ok && d.Action == decision.ActionProxy {
+			o.ProxyHost = ph.ProxyHost(d)
+		}
+	}
+
+	src := netip.AddrPortFrom(f.src, uint16(f.sport)).String()
+	dst := netip.AddrPortFrom(f.dst, uint16(f.dport)).String()
+	host := dst
+	if in.DestDomain != "" {
+		host = net.JoinHostPort(in.DestDomain, strconv.Itoa(f.dport))
+	}
+
+	return &xrecorder.HandlerRecorderObject{
+		Service:    h.options.Service,
+		Network:    f.proto,
+		RemoteAddr: src,
+		ClientAddr: src,
+		DstAddr:    dst,
+		Host:       host,
+		Decision:   o,
+		SID:        xid.New().String(),
+		Time:       time.Now(),
+	}
+}
@@ -0,0 +1,65 @@
+package tun
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/AeroCore-IO/avionics/pkg/decision"
+	"github.com/go-gost/core/handler"
+)
+
+func TestFlowRecords(t *testing.T) {
+	r := newFlowRecords(time.Minute)
+	f0 := newPacketFlow(net.ParseIP("10.0.0.2"), net.ParseIP("1.1.1.1"), 40000, 443, "TCP")
+	f1 := newPacketFlow(net.ParseIP("10.0.0.2"), net.ParseIP("1.1.1.1"), 40001, 443, "TCP")
+
+	now := time.Now()
+	if !r.allow(now, f0) || !r.allow(now, f1) {
+		t.Fatal("the first packet of a flow is not recorded")
+	}
+	for i := 0; i < 10; i++ {
+		if r.allow(now.Add(time.Duration(i)*time.Second), f0) {
+			t.Fatal("the flow is recorded per packet")
+		}
+	}
+
+	// the flows are recorded again after the interval, the idle ones are swept.
+	now = now.Add(time.Minute)
+	if !r.allow(now, f0) {
+		t.Fatal("the flow is not recorded after the interval")
+	}
+	if len(r.last) != 1 {
+		t.Fatalf("expected 1 flow, got %d", len(r.last))
+	}
+}
+
+func TestDecisionRecord(t *testing.T) {
+	h := NewHandler(handler.ServiceOption("tun-0")).(*tunHandler)
+	h.dec = &testEvaluator{action: decision.ActionBlock}
+
+	f := newPacketFlow(net.ParseIP("10.0.0.2"), net.ParseIP("1.1.1.1"), 40000, 443, "TCP")
+	in := decision.RuleInput{
+		SteamAppID: "730",
+		DestHost:   "1.1.1.1",
+		DestPort:   443,
+		Protocol:   "TCP",
+		DestDomain: "example.com",
+	}
+	ro := h.decisionRecord(f, in, h.dec.CheckTrafficRules(in))
+
+	if ro.Service != "tun-0" || ro.Network != "tcp" || ro.ClientAddr != "10.0.0.2:40000" ||
+		ro.DstAddr != "1.1.1.1:443" || ro.Host != "example.com:443" {
+		t.Fatalf("unexpected record %+v", ro)
+	}
+	o := ro.Decision
+	if o == nil || o.Action != "block" || o.Rule != "rule-1" || o.RuleID != "rule-1-BLOCK" ||
+		o.AppID != "730" || o.Hostname != "example.com" || o.Input == nil || o.Input.DestPort != 443 {
+		t.Fatalf("unexpected decision %+v", o)
+	}
+
+	// no rule is matched, the packet is proxied.
+	if o := h.decisionRecord(f, in, nil).Decision; o.Action != "proxy" || o.Rule != "" {
+		t.Fatalf("unexpected decision %+v", o)
+	}
+}
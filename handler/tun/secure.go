@@ -0,0 +1,754 @@
+package tun
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-gost/core/logger"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/time/rate"
+)
+
+// The secure mode wraps the tun transport: a Noise handshake keyed by the shared token
+// and/or static keys establishes a session, then every datagram is sealed with the session keys.
+//
+//	initiation: 0x01 | pattern(1) | sender index(4) | handshake message
+//	response:   0x02 | sender index(4) | receiver index(4) | handshake message
+//	data:       0x03 | receiver index(4) | counter(8) | sealed packet
+//
+// The payload of the initiation is the TAI64N timestamp of the client followed by its
+// random client ID: the server only accepts an initiation newer than the last one it
+// accepted from the same client, identified by its static key, or by its client ID
+// within the token for the NNpsk0 pattern.
+//
+// None of the types collides with the first byte of an IP packet or of the GOST keepalive header.
+const (
+	secureMsgInitiation = 0x01
+	secureMsgResponse   = 0x02
+	secureMsgData       = 0x03
+
+	secureInitiationHeaderLen = 6
+	secureResponseHeaderLen   = 9
+	secureDataHeaderLen       = 13
+
+	defaultSecureRekeyAfter = 2 * time.Minute
+	// secureRejectGrace is how long a session is still accepted after it should have been rekeyed.
+	secureRejectGrace = time.Minute
+
+	secureHandshakeRetry   = time.Second
+	secureHandshakeTimeout = 10 * time.Second
+
+	// secureRejectAfterMessages is the counter limit of a session.
+	secureRejectAfterMessages = 1<<60 - 1
+
+	replayWindowSize = 2048
+
+	secureTimestampLen         = 12
+	secureClientIDLen          = 8
+	secureInitiationPayloadLen = secureTimestampLen + secureClientIDLen
+
+	// secureMaxClients is the number of clients whose last initiation timestamp is kept.
+	secureMaxClients = 4096
+
+	// the initiations accepted from a source address, per second and in a burst.
+	secureInitiationRate  = 5
+	secureInitiationBurst = 10
+	secureLimiterIdle     = time.Minute
+)
+
+var (
+	errSecureHandshakeTimeout = errors.New("tun: secure handshake timeout")
+	errSecureClosed           = errors.New("tun: secure connection closed")
+	errSecureReplay           = errors.New("tun: replayed or too old packet")
+	errSecureStaleInitiation  = errors.New("tun: replayed or stale handshake initiation")
+	errSecureRateLimited      = errors.New("tun: too many handshake initiations")
+)
+
+type secureConfig struct {
+	// psk is derived from the token, nil if no token is set.
+	psk []byte
+	// privateKey is the local static key, nil if static keys are not used.
+	privateKey *ecdh.PrivateKey
+	// peerKey is the static key of the server, set on the client side.
+	peerKey *ecdh.PublicKey
+	// peerKeys are the static keys of the clients allowed by the server, any client is allowed if empty.
+	peerKeys   [][]byte
+	rekeyAfter time.Duration
+}
+
+// newSecureConfig builds the secure mode configuration from the token and the base64 encoded X25519 keys.
+func newSecureConfig(token, privateKey, peerKey string, peerKeys []string, rekeyAfter time.Duration) (*secureConfig, error) {
+	cfg := &secureConfig{
+		rekeyAfter: rekeyAfter,
+	}
+	if cfg.rekeyAfter <= 0 {
+		cfg.rekeyAfter = defaultSecureRekeyAfter
+	}
+
+	if token != "" {
+		psk := sha256.Sum256([]byte(noisePrologue + "\x00" + token))
+		cfg.psk = psk[:]
+	}
+
+	if privateKey != "" {
+		b, err := base64.StdEncoding.DecodeString(privateKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid private key: %w", err)
+		}
+		if cfg.privateKey, err = ecdh.X25519().NewPrivateKey(b); err != nil {
+			return nil, fmt.Errorf("invalid private key: %w", err)
+		}
+	}
+	if peerKey != "" {
+		key, err := parseSecurePublicKey(peerKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid peer key: %w", err)
+		}
+		cfg.peerKey = key
+	}
+	for _, s := range peerKeys {
+		key, err := parseSecurePublicKey(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid peer key %s: %w", s, err)
+		}
+		cfg.peerKeys = append(cfg.peerKeys, key.Bytes())
+	}
+
+	if cfg.psk == nil && cfg.privateKey == nil {
+		return nil, errors.New("secure mode requires a token or a private key")
+	}
+	return cfg, nil
+}
+
+func parseSecurePublicKey(s string) (*ecdh.PublicKey, error) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return ecdh.X25519().NewPublicKey(b)
+}
+
+// clientPattern returns the handshake pattern initiated by the client.
+func (c *secureConfig) clientPattern() (*noisePattern, error) {
+	switch {
+	case c.privateKey != nil && c.peerKey != nil && c.psk != nil:
+		return noiseIKpsk2, nil
+	case c.privateKey != nil && c.peerKey != nil:
+		return noiseIK, nil
+	case c.psk != nil:
+		return noiseNNpsk0, nil
+	default:
+		return nil, errors.New("tun: secure client requires a token or the server key")
+	}
+}
+
+// acceptPattern reports whether the server accepts the handshake pattern.
+// A server with a static key or allowed client keys only accepts the patterns
+// authenticating the client by its static key, the token alone is not enough.
+func (c *secureConfig) acceptPattern(p *noisePattern) bool {
+	if p.responderStatic && c.privateKey == nil {
+		return false
+	}
+	if !p.responderStatic && (c.privateKey != nil || len(c.peerKeys) > 0) {
+		return false
+	}
+	if p.psk != (c.psk != nil) {
+		return false
+	}
+	return true
+}
+
+func (c *secureConfig) allowPeer(key *ecdh.PublicKey) bool {
+	if len(c.peerKeys) == 0 {
+		return true
+	}
+	for _, k := range c.peerKeys {
+		if key != nil && string(k) == string(key.Bytes()) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *secureConfig) rejectAfter() time.Duration {
+	return c.rekeyAfter + secureRejectGrace
+}
+
+// replayWindow is a sliding window of the received packet counters.
+type replayWindow struct {
+	mu     sync.Mutex
+	top    uint64
+	seen   bool
+	bitmap [replayWindowSize / 64]uint64
+}
+
+// accept reports whether the counter is not a replay and records it,
+// it must be called once the packet is authenticated.
+func (w *replayWindow) accept(counter uint64) bool {
+	if counter >= secureRejectAfterMessages {
+		return false
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.seen || counter > w.top {
+		if !w.seen || counter-w.top >= replayWindowSize {
+			w.bitmap = [replayWindowSize / 64]uint64{}
+		} else {
+			for i := w.top + 1; i < counter; i++ {
+				w.clear(i)
+			}
+		}
+		w.top = counter
+		w.seen = true
+		w.set(counter)
+		return true
+	}
+
+	if w.top-counter >= replayWindowSize {
+		return false
+	}
+	i := counter % replayWindowSize
+	if w.bitmap[i/64]&(1<<(i%64)) != 0 {
+		return false
+	}
+	w.set(counter)
+	return true
+}
+
+func (w *replayWindow) set(counter uint64) {
+	i := counter % replayWindowSize
+	w.bitmap[i/64] |= 1 << (i % 64)
+}
+
+func (w *replayWindow) clear(counter uint64) {
+	i := counter % replayWindowSize
+	w.bitmap[i/64] &^= 1 << (i % 64)
+}
+
+type secureSession struct {
+	localIndex  uint32
+	remoteIndex uint32
+	send        cipher.AEAD
+	recv        cipher.AEAD
+	counter     atomic.Uint64
+	replay      replayWindow
+	created     time.Time
+}
+
+func newSecureSession(hs *noiseHandshake, localIndex, remoteIndex uint32) (*secureSession, error) {
+	sk, rk := hs.keys()
+	send, err := chacha20poly1305.New(sk[:])
+	if err != nil {
+		return nil, err
+	}
+	recv, err := chacha20poly1305.New(rk[:])
+	if err != nil {
+		return nil, err
+	}
+	return &secureSession{
+		localIndex:  localIndex,
+		remoteIndex: remoteIndex,
+		send:        send,
+		recv:        recv,
+		created:     time.Now(),
+	}, nil
+}
+
+func (s *secureSession) expired(cfg *secureConfig) bool {
+	return time.Since(s.created) > cfg.rejectAfter()
+}
+
+// seal returns the data packet carrying b.
+func (s *secureSession) seal(b []byte) []byte {
+	counter := s.counter.Add(1) - 1
+
+	pkt := make([]byte, secureDataHeaderLen, secureDataHeaderLen+len(b)+chacha20poly1305.Overhead)
+	pkt[0] = secureMsgData
+	binary.BigEndian.PutUint32(pkt[1:5], s.remoteIndex)
+	binary.BigEndian.PutUint64(pkt[5:13], counter)
+	return s.send.Seal(pkt, noiseNonce(counter), b, pkt[:secureDataHeaderLen])
+}
+
+// open authenticates and decrypts the data packet in place.
+func (s *secureSession) open(pkt []byte) ([]byte, error) {
+	counter := binary.BigEndian.Uint64(pkt[5:13])
+	b, err := s.recv.Open(pkt[secureDataHeaderLen:secureDataHeaderLen], noiseNonce(counter), pkt[secureDataHeaderLen:], pkt[:secureDataHeaderLen])
+	if err != nil {
+		return nil, errNoiseDecrypt
+	}
+	if !s.replay.accept(counter) {
+		return nil, errSecureReplay
+	}
+	return b, nil
+}
+
+// secureTimestamp returns the TAI64N label of t.
+func secureTimestamp(t time.Time) [secureTimestampLen]byte {
+	var b [secureTimestampLen]byte
+	binary.BigEndian.PutUint64(b[:8], 1<<62+uint64(t.Unix()))
+	binary.BigEndian.PutUint32(b[8:], uint32(t.Nanosecond()))
+	return b
+}
+
+func newSessionIndex() uint32 {
+	var b [4]byte
+	rand.Read(b[:])
+	return binary.BigEndian.Uint32(b[:])
+}
+
+// secureConn is the client side of the secure mode. The handshake is initiated by Write,
+// and completed by Read when the response is received.
+type secureConn struct {
+	net.Conn
+	cfg *secureConfig
+	log logger.Logger
+	// id identifies the client to the server along with the token.
+	id [secureClientIDLen]byte
+
+	mu       sync.Mutex
+	hs       *noiseHandshake
+	hsIndex  uint32
+	hsPacket []byte
+	hsTime   time.Time
+	current  *secureSession
+	previous *secureSession
+	// established is closed and replaced when a session is established.
+	established chan struct{}
+
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	rbuf []byte
+}
+
+func newSecureConn(conn net.Conn, cfg *secureConfig, log logger.Logger) *secureConn {
+	c := &secureConn{
+		Conn:        conn,
+		cfg:         cfg,
+		log:         log,
+		established: make(chan struct{}),
+		closed:      make(chan struct{}),
+	}
+	rand.Read(c.id[:])
+	return c
+}
+
+func (c *secureConn) Write(b []byte) (int, error) {
+	s, err := c.session()
+	if err != nil {
+		return 0, err
+	}
+	if _, err := c.Conn.Write(s.seal(b)); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// session returns the current session, a new handshake is initiated if it needs to be rekeyed.
+// It blocks until the first session is established.
+func (c *secureConn) session() (*secureSession, error) {
+	deadline := time.Now().Add(secureHandshakeTimeout)
+	for {
+		c.mu.Lock()
+		s := c.current
+		if s == nil || time.Since(s.created) > c.cfg.rekeyAfter {
+			if c.hs == nil || time.Since(c.hsTime) > secureHandshakeRetry {
+				if err := c.initiate(); err != nil {
+					c.mu.Unlock()
+					return nil, err
+				}
+			}
+		}
+		established := c.established
+		c.mu.Unlock()
+
+		if s != nil && !s.expired(c.cfg) {
+			return s, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, errSecureHandshakeTimeout
+		}
+
+		select {
+		case <-established:
+		case <-time.After(secureHandshakeRetry):
+		case <-c.closed:
+			return nil, errSecureClosed
+		}
+	}
+}
+
+// initiate sends a new handshake initiation, which replaces the pending handshake:
+// the server does not accept the same initiation twice. c.mu must be held.
+func (c *secureConn) initiate() error {
+	pattern, err := c.cfg.clientPattern()
+	if err != nil {
+		return err
+	}
+	hs, err := newNoiseHandshake(pattern, true, c.cfg.privateKey, c.cfg.peerKey, c.cfg.psk)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	ts := secureTimestamp(now)
+	payload := append(ts[:], c.id[:]...)
+
+	index := newSessionIndex()
+	pkt := make([]byte, secureInitiationHeaderLen, 256)
+	pkt[0] = secureMsgInitiation
+	pkt[1] = pattern.id
+	binary.BigEndian.PutUint32(pkt[2:6], index)
+	if pkt, err = hs.writeMessage(pkt, payload); err != nil {
+		return err
+	}
+	c.hs, c.hsIndex, c.hsPacket = hs, index, pkt
+
+	c.hsTime = now
+	_, err = c.Conn.Write(c.hsPacket)
+	return err
+}
+
+func (c *secureConn) Read(b []byte) (int, error) {
+	if c.rbuf == nil {
+		c.rbuf = make([]byte, MaxMessageSize)
+	}
+
+	for {
+		n, err := c.Conn.Read(c.rbuf)
+		if err != nil {
+			return 0, err
+		}
+		pkt := c.rbuf[:n]
+		if n == 0 {
+			continue
+		}
+
+		switch pkt[0] {
+		case secureMsgResponse:
+			if err := c.handleResponse(pkt); err != nil {
+				c.log.Debugf("secure handshake: %v", err)
+			}
+		case secureMsgData:
+			if n < secureDataHeaderLen+chacha20poly1305.Overhead {
+				continue
+			}
+			s := c.lookup(binary.BigEndian.Uint32(pkt[1:5]))
+			if s == nil {
+				continue
+			}
+			p, err := s.open(pkt)
+			if err != nil {
+				c.log.Debugf("secure: %v", err)
+				continue
+			}
+			return copy(b, p), nil
+		default:
+			c.log.Debugf("secure: unexpected packet type %#x, discarded(%d)", pkt[0], n)
+		}
+	}
+}
+
+func (c *secureConn) handleResponse(pkt []byte) error {
+	if len(pkt) < secureResponseHeaderLen {
+		return errNoiseShortMsg
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.hs == nil || binary.BigEndian.Uint32(pkt[5:9]) != c.hsIndex {
+		return errors.New("unexpected response")
+	}
+	// the response is processed on a copy of the handshake state, a forged response
+	// must not break the pending handshake.
+	hs := *c.hs
+	if _, err := hs.readMessage(pkt[secureResponseHeaderLen:]); err != nil {
+		return err
+	}
+	s, err := newSecureSession(&hs, c.hsIndex, binary.BigEndian.Uint32(pkt[1:5]))
+	if err != nil {
+		return err
+	}
+
+	c.previous, c.current = c.current, s
+	c.hs, c.hsPacket = nil, nil
+	close(c.established)
+	c.established = make(chan struct{})
+
+	c.log.Debugf("secure session %08x established", s.localIndex)
+	return nil
+}
+
+func (c *secureConn) lookup(index uint32) *secureSession {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, s := range []*secureSession{c.current, c.previous} {
+		if s != nil && s.localIndex == index && !s.expired(c.cfg) {
+			return s
+		}
+	}
+	return nil
+}
+
+func (c *secureConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+	})
+	return c.Conn.Close()
+}
+
+// securePacketConn is the server side of the secure mode, it keeps a session per client.
+type securePacketConn struct {
+	net.PacketConn
+	cfg *secureConfig
+	log logger.Logger
+
+	mu       sync.RWMutex
+	sessions map[uint32]*secureSession
+	// peers maps the client addresses to their latest authenticated session.
+	peers map[string]*secureSession
+	// timestamps are the last accepted initiation timestamps of the clients.
+	timestamps map[string]*secureTimestampEntry
+	// limiters rate limit the initiations of the source addresses.
+	limiters     map[string]*secureLimiterEntry
+	limiterSweep time.Time
+
+	rbuf []byte
+}
+
+type secureTimestampEntry struct {
+	ts       [secureTimestampLen]byte
+	accepted time.Time
+}
+
+type secureLimiterEntry struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+func newSecurePacketConn(pc net.PacketConn, cfg *secureConfig, log logger.Logger) *securePacketConn {
+	return &securePacketConn{
+		PacketConn: pc,
+		cfg:        cfg,
+		log:        log,
+		sessions:   make(map[uint32]*secureSession),
+		peers:      make(map[string]*secureSession),
+		timestamps: make(map[string]*secureTimestampEntry),
+		limiters:   make(map[string]*secureLimiterEntry),
+	}
+}
+
+func (c *securePacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	if c.rbuf == nil {
+		c.rbuf = make([]byte, MaxMessageSize)
+	}
+
+	for {
+		n, addr, err := c.PacketConn.ReadFrom(c.rbuf)
+		if err != nil {
+			return 0, addr, err
+		}
+		if n == 0 {
+			continue
+		}
+		pkt := c.rbuf[:n]
+
+		switch pkt[0] {
+		case secureMsgInitiation:
+			if err := c.handleInitiation(pkt, addr); err != nil {
+				c.log.Debugf("secure handshake from %v: %v", addr, err)
+			}
+		case secureMsgData:
+			if n < secureDataHeaderLen+chacha20poly1305.Overhead {
+				continue
+			}
+			c.mu.RLock()
+			s := c.sessions[binary.BigEndian.Uint32(pkt[1:5])]
+			c.mu.RUnlock()
+			if s == nil || s.expired(c.cfg) {
+				continue
+			}
+			p, err := s.open(pkt)
+			if err != nil {
+				c.log.Debugf("secure: %v: %v", addr, err)
+				continue
+			}
+
+			// the client may roam, the replies follow its latest authenticated address.
+			c.mu.Lock()
+			if old := c.peers[addr.String()]; old == nil || old.created.Before(s.created) || old.expired(c.cfg) {
+				c.peers[addr.String()] = s
+			}
+			c.mu.Unlock()
+
+			return copy(b, p), addr, nil
+		default:
+			c.log.Debugf("secure: unexpected packet type %#x from %v, discarded(%d)", pkt[0], addr, n)
+		}
+	}
+}
+
+func (c *securePacketConn) handleInitiation(pkt []byte, addr net.Addr) error {
+	if len(pkt) < secureInitiationHeaderLen {
+		return errNoiseShortMsg
+	}
+	pattern := noisePatternByID(pkt[1])
+	if pattern == nil || !c.cfg.acceptPattern(pattern) {
+		return fmt.Errorf("handshake pattern %d not accepted", pkt[1])
+	}
+	// the limit is checked before the DH operations of the handshake.
+	if !c.allowInitiation(addr, time.Now()) {
+		return errSecureRateLimited
+	}
+
+	hs, err := newNoiseHandshake(pattern, false, c.cfg.privateKey, nil, c.cfg.psk)
+	if err != nil {
+		return err
+	}
+	payload, err := hs.readMessage(pkt[secureInitiationHeaderLen:])
+	if err != nil {
+		return err
+	}
+	if len(payload) < secureInitiationPayloadLen {
+		return errNoiseShortMsg
+	}
+	if pattern.responderStatic && !c.cfg.allowPeer(hs.rs) {
+		return errors.New("client key not allowed")
+	}
+
+	var client string
+	if pattern.responderStatic {
+		client = string(hs.rs.Bytes())
+	} else {
+		client = string(payload[secureTimestampLen:secureInitiationPayloadLen])
+	}
+	var ts [secureTimestampLen]byte
+	copy(ts[:], payload)
+	if !c.acceptTimestamp(client, ts, time.Now()) {
+		return errSecureStaleInitiation
+	}
+
+	index := newSessionIndex()
+	resp := make([]byte, secureResponseHeaderLen, 256)
+	resp[0] = secureMsgResponse
+	binary.BigEndian.PutUint32(resp[1:5], index)
+	binary.BigEndian.PutUint32(resp[5:9], binary.BigEndian.Uint32(pkt[2:6]))
+	if resp, err = hs.writeMessage(resp, nil); err != nil {
+		return err
+	}
+
+	s, err := newSecureSession(hs, index, binary.BigEndian.Uint32(pkt[2:6]))
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	for k, v := range c.sessions {
+		if v.expired(c.cfg) {
+			delete(c.sessions, k)
+		}
+	}
+	for k, v := range c.peers {
+		if v.expired(c.cfg) {
+			delete(c.peers, k)
+		}
+	}
+	c.sessions[index] = s
+	c.mu.Unlock()
+
+	if _, err := c.PacketConn.WriteTo(resp, addr); err != nil {
+		return err
+	}
+
+	c.log.Debugf("secure session %08x established with %v", index, addr)
+	return nil
+}
+
+// allowInitiation reports whether an initiation from the source host of addr is allowed by the rate limit.
+func (c *securePacketConn) allowInitiation(addr net.Addr, now time.Time) bool {
+	host := addr.String()
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if now.Sub(c.limiterSweep) > secureLimiterIdle {
+		for k, v := range c.limiters {
+			if now.Sub(v.lastSeen) > secureLimiterIdle {
+				delete(c.limiters, k)
+			}
+		}
+		c.limiterSweep = now
+	}
+
+	e := c.limiters[host]
+	if e == nil {
+		e = &secureLimiterEntry{limiter: rate.NewLimiter(secureInitiationRate, secureInitiationBurst)}
+		c.limiters[host] = e
+	}
+	e.lastSeen = now
+	return e.limiter.AllowN(now, 1)
+}
+
+// acceptTimestamp records the initiation timestamp ts of the client, it reports false
+// if ts is not newer than the last timestamp accepted from the client.
+func (c *securePacketConn) acceptTimestamp(client string, ts [secureTimestampLen]byte, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e := c.timestamps[client]; e != nil {
+		if bytes.Compare(ts[:], e.ts[:]) <= 0 {
+			return false
+		}
+		e.ts, e.accepted = ts, now
+		return true
+	}
+
+	if len(c.timestamps) >= secureMaxClients {
+		// the clients without a live session are forgotten first.
+		for k, v := range c.timestamps {
+			if now.Sub(v.accepted) > c.cfg.rejectAfter() {
+				delete(c.timestamps, k)
+			}
+		}
+		if len(c.timestamps) >= secureMaxClients {
+			return false
+		}
+	}
+	c.timestamps[client] = &secureTimestampEntry{ts: ts, accepted: now}
+	return true
+}
+
+// WriteTo seals b with the session of the client at addr. The packet is discarded
+// if the client has no session.
+func (c *securePacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	c.mu.RLock()
+	s := c.peers[addr.String()]
+	c.mu.RUnlock()
+
+	if s == nil || s.expired(c.cfg) {
+		c.log.Debugf("secure: no session for %v, packet discarded", addr)
+		return len(b), nil
+	}
+	if _, err := c.PacketConn.WriteTo(s.seal(b), addr); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
@@ -0,0 +1,419 @@
+package tun
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"net"
+	"testing"
+	"time"
+
+	xlogger "github.com/go-gost/x/logger"
+)
+
+func testSecureKey(t *testing.T) (private, public string) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(key.Bytes()),
+		base64.StdEncoding.EncodeToString(key.PublicKey().Bytes())
+}
+
+// testSecurePair connects a secure client and a secure server over UDP.
+func testSecurePair(t *testing.T, client, server *secureConfig) (*secureConn, *securePacketConn) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.Dial("udp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		pc.Close()
+		conn.Close()
+	})
+
+	return newSecureConn(conn, client, xlogger.Nop()), newSecurePacketConn(pc, server, xlogger.Nop())
+}
+
+func TestSecureTransport(t *testing.T) {
+	serverPriv, serverPub := testSecureKey(t)
+	clientPriv, clientPub := testSecureKey(t)
+
+	testCases := []struct {
+		name           string
+		client, server func() (*secureConfig, error)
+		pattern        *noisePattern
+	}{
+		{
+			name:    "token",
+			client:  func() (*secureConfig, error) { return newSecureConfig("secret", "", "", nil, 0) },
+			server:  func() (*secureConfig, error) { return newSecureConfig("secret", "", "", nil, 0) },
+			pattern: noiseNNpsk0,
+		},
+		{
+			name:    "static keys",
+			client:  func() (*secureConfig, error) { return newSecureConfig("", clientPriv, serverPub, nil, 0) },
+			server:  func() (*secureConfig, error) { return newSecureConfig("", serverPriv, "", []string{clientPub}, 0) },
+			pattern: noiseIK,
+		},
+		{
+			name:    "static keys and token",
+			client:  func() (*secureConfig, error) { return newSecureConfig("secret", clientPriv, serverPub, nil, 0) },
+			server:  func() (*secureConfig, error) { return newSecureConfig("secret", serverPriv, "", nil, 0) },
+			pattern: noiseIKpsk2,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ccfg, err := tc.client()
+			if err != nil {
+				t.Fatal(err)
+			}
+			scfg, err := tc.server()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p, _ := ccfg.clientPattern(); p != tc.pattern {
+				t.Fatalf("expected pattern %s, got %s", tc.pattern.name, p.name)
+			}
+
+			client, server := testSecurePair(t, ccfg, scfg)
+
+			// the server completes the handshake while reading.
+			type result struct {
+				b    []byte
+				addr net.Addr
+				err  error
+			}
+			rc := make(chan result, 1)
+			go func() {
+				b := make([]byte, MaxMessageSize)
+				n, addr, err := server.ReadFrom(b)
+				rc <- result{b[:n], addr, err}
+			}()
+			// the client completes the handshake while reading.
+			go func() {
+				client.Read(make([]byte, MaxMessageSize))
+			}()
+
+			if _, err := client.Write([]byte("ping")); err != nil {
+				t.Fatal(err)
+			}
+			r := <-rc
+			if r.err != nil {
+				t.Fatal(r.err)
+			}
+			if string(r.b) != "ping" {
+				t.Fatalf("unexpected packet %q", r.b)
+			}
+		})
+	}
+}
+
+func TestSecureTransport_Reply(t *testing.T) {
+	ccfg, _ := newSecureConfig("secret", "", "", nil, 0)
+	scfg, _ := newSecureConfig("secret", "", "", nil, 0)
+	client, server := testSecurePair(t, ccfg, scfg)
+
+	cc := make(chan []byte, 1)
+	go func() {
+		b := make([]byte, MaxMessageSize)
+		for {
+			n, err := client.Read(b)
+			if err != nil {
+				return
+			}
+			cc <- append([]byte(nil), b[:n]...)
+		}
+	}()
+
+	// the server replies to the first packet.
+	errc := make(chan error, 1)
+	go func() {
+		b := make([]byte, MaxMessageSize)
+		_, addr, err := server.ReadFrom(b)
+		if err == nil {
+			_, err = server.WriteTo([]byte("pong"), addr)
+		}
+		errc <- err
+	}()
+
+	if _, err := client.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case b := <-cc:
+		if string(b) != "pong" {
+			t.Fatalf("unexpected packet %q", b)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reply")
+	}
+}
+
+func TestSecureTransport_WrongToken(t *testing.T) {
+	ccfg, _ := newSecureConfig("secret", "", "", nil, 0)
+	scfg, _ := newSecureConfig("other", "", "", nil, 0)
+	client, server := testSecurePair(t, ccfg, scfg)
+
+	go func() {
+		b := make([]byte, MaxMessageSize)
+		server.ReadFrom(b)
+	}()
+
+	client.mu.Lock()
+	err := client.initiate()
+	client.mu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+	if _, err := client.Read(make([]byte, MaxMessageSize)); err == nil {
+		t.Fatal("handshake with a wrong token should fail")
+	}
+	if client.current != nil {
+		t.Fatal("unexpected session")
+	}
+}
+
+func TestSecureTransport_TokenOnly(t *testing.T) {
+	serverPriv, _ := testSecureKey(t)
+	_, clientPub := testSecureKey(t)
+
+	ccfg, _ := newSecureConfig("secret", "", "", nil, 0)
+	scfg, err := newSecureConfig("secret", serverPriv, "", []string{clientPub}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scfg.acceptPattern(noiseNNpsk0) {
+		t.Fatal("the token only pattern should be refused by a server with peer keys")
+	}
+	client, server := testSecurePair(t, ccfg, scfg)
+
+	go func() {
+		b := make([]byte, MaxMessageSize)
+		server.ReadFrom(b)
+	}()
+
+	client.mu.Lock()
+	err = client.initiate()
+	client.mu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+	if _, err := client.Read(make([]byte, MaxMessageSize)); err == nil {
+		t.Fatal("handshake without the client static key should fail")
+	}
+	if client.current != nil {
+		t.Fatal("unexpected session")
+	}
+}
+
+func TestSecureTransport_ReplayedInitiation(t *testing.T) {
+	serverPriv, serverPub := testSecureKey(t)
+	clientPriv, _ := testSecureKey(t)
+
+	testCases := []struct {
+		name           string
+		client, server func() (*secureConfig, error)
+	}{
+		{
+			name:   "token",
+			client: func() (*secureConfig, error) { return newSecureConfig("secret", "", "", nil, 0) },
+			server: func() (*secureConfig, error) { return newSecureConfig("secret", "", "", nil, 0) },
+		},
+		{
+			name:   "static keys",
+			client: func() (*secureConfig, error) { return newSecureConfig("", clientPriv, serverPub, nil, 0) },
+			server: func() (*secureConfig, error) { return newSecureConfig("", serverPriv, "", nil, 0) },
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ccfg, err := tc.client()
+			if err != nil {
+				t.Fatal(err)
+			}
+			scfg, err := tc.server()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			initiate := func(c *secureConn) []byte {
+				c.mu.Lock()
+				defer c.mu.Unlock()
+				if err := c.initiate(); err != nil {
+					t.Fatal(err)
+				}
+				return append([]byte(nil), c.hsPacket...)
+			}
+
+			client, server := testSecurePair(t, ccfg, scfg)
+			pkt := initiate(client)
+			if err := server.handleInitiation(pkt, client.LocalAddr()); err != nil {
+				t.Fatal(err)
+			}
+			if err := server.handleInitiation(pkt, client.LocalAddr()); err != errSecureStaleInitiation {
+				t.Fatalf("replayed initiation accepted: %v", err)
+			}
+
+			// the newer initiations are accepted, the clients sharing the token have their own timestamps.
+			other := newSecureConn(client.Conn, ccfg, xlogger.Nop())
+			if err := server.handleInitiation(initiate(other), client.LocalAddr()); err != nil {
+				t.Fatal(err)
+			}
+
+			time.Sleep(time.Millisecond)
+			if err := server.handleInitiation(initiate(client), client.LocalAddr()); err != nil {
+				t.Fatalf("new initiation refused: %v", err)
+			}
+		})
+	}
+}
+
+func TestSecurePacketConn_InitiationRate(t *testing.T) {
+	cfg, _ := newSecureConfig("secret", "", "", nil, 0)
+	c := newSecurePacketConn(nil, cfg, xlogger.Nop())
+
+	now := time.Now()
+	a := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 1000}
+	for i := 0; i < secureInitiationBurst; i++ {
+		// the source port does not matter.
+		a.Port++
+		if !c.allowInitiation(a, now) {
+			t.Fatalf("initiation %d refused", i)
+		}
+	}
+	if c.allowInitiation(a, now) {
+		t.Fatal("initiation over the burst allowed")
+	}
+	if !c.allowInitiation(&net.UDPAddr{IP: net.IPv4(192, 0, 2, 2), Port: 1000}, now) {
+		t.Fatal("initiation from another source refused")
+	}
+	if !c.allowInitiation(a, now.Add(time.Second)) {
+		t.Fatal("initiation refused after the limit refills")
+	}
+}
+
+func TestSecureSession_Replay(t *testing.T) {
+	cfg, _ := newSecureConfig("secret", "", "", nil, 0)
+
+	initiator, _ := newNoiseHandshake(noiseNNpsk0, true, nil, nil, cfg.psk)
+	responder, _ := newNoiseHandshake(noiseNNpsk0, false, nil, nil, cfg.psk)
+
+	msg, err := initiator.writeMessage(nil, []byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p, err := responder.readMessage(msg); err != nil || string(p) != "hello" {
+		t.Fatalf("readMessage: %q %v", p, err)
+	}
+	if msg, err = responder.writeMessage(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := initiator.readMessage(msg); err != nil {
+		t.Fatal(err)
+	}
+	if !initiator.complete() || !responder.complete() {
+		t.Fatal("handshake not complete")
+	}
+
+	a, _ := newSecureSession(initiator, 1, 2)
+	b, _ := newSecureSession(responder, 2, 1)
+
+	var pkts [][]byte
+	for i := 0; i < 3; i++ {
+		pkts = append(pkts, a.seal([]byte{byte(i)}))
+	}
+
+	open := func(pkt []byte) error {
+		_, err := b.open(append([]byte(nil), pkt...))
+		return err
+	}
+	// out of order delivery is accepted, replays are not.
+	for _, i := range []int{2, 0, 1} {
+		if err := open(pkts[i]); err != nil {
+			t.Fatalf("packet %d: %v", i, err)
+		}
+	}
+	for i := range pkts {
+		if err := open(pkts[i]); err != errSecureReplay {
+			t.Fatalf("replayed packet %d accepted: %v", i, err)
+		}
+	}
+
+	tampered := append([]byte(nil), a.seal([]byte("data"))...)
+	tampered[len(tampered)-1] ^= 1
+	if err := open(tampered); err != errNoiseDecrypt {
+		t.Fatalf("tampered packet accepted: %v", err)
+	}
+
+	// packets older than the window are rejected.
+	late := a.seal([]byte("late"))
+	for i := 0; i < replayWindowSize; i++ {
+		a.seal(nil)
+	}
+	if err := open(a.seal([]byte("new"))); err != nil {
+		t.Fatal(err)
+	}
+	if err := open(late); err != errSecureReplay {
+		t.Fatalf("packet outside of the window accepted: %v", err)
+	}
+}
+
+func TestSecureConn_Rekey(t *testing.T) {
+	ccfg, _ := newSecureConfig("secret", "", "", nil, 0)
+	scfg, _ := newSecureConfig("secret", "", "", nil, 0)
+	client, server := testSecurePair(t, ccfg, scfg)
+
+	go func() {
+		b := make([]byte, MaxMessageSize)
+		for {
+			if _, _, err := server.ReadFrom(b); err != nil {
+				return
+			}
+		}
+	}()
+	go func() {
+		client.Read(make([]byte, MaxMessageSize))
+	}()
+
+	if _, err := client.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	client.mu.Lock()
+	first := client.current
+	// age the session so that it is rekeyed by the next write.
+	first.created = time.Now().Add(-ccfg.rekeyAfter - time.Second)
+	client.mu.Unlock()
+
+	if _, err := client.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		client.mu.Lock()
+		current, previous := client.current, client.previous
+		client.mu.Unlock()
+		if current != first {
+			if previous != first {
+				t.Fatal("the previous session should be kept")
+			}
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("session not rekeyed")
+}
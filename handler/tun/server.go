@@ -12,6 +12,7 @@ import (
 	"github.com/go-gost/core/logger"
 	"github.com/go-gost/core/router"
 	xip "github.com/go-gost/x/internal/net/ip"
+	"github.com/go-gost/x/internal/util/pcap"
 	tun_util "github.com/go-gost/x/internal/util/tun"
 	"github.com/songgao/water/waterutil"
 	"golang.org/x/net/ipv4"
@@ -19,6 +20,10 @@ import (
 )
 
 func (h *tunHandler) handleServer(ctx context.Context, conn net.Conn, config *tun_util.Config, log logger.Logger) error {
+	if h.ipam != nil {
+		h.ipam.reserve(config.Net)
+	}
+
 	for {
 		err := func() error {
 			pc, err := net.ListenPacket(conn.LocalAddr().Network(), conn.LocalAddr().String())
@@ -27,6 +32,10 @@ func (h *tunHandler) handleServer(ctx context.Context, conn net.Conn, config *tu
 			}
 			defer pc.Close()
 
+			if h.secure != nil {
+				pc = newSecurePacketConn(pc, h.secure, log)
+			}
+
 			return h.transportServer(ctx, conn, pc, config, log)
 		}()
 		if err == ErrTun {
@@ -87,6 +96,12 @@ func (h *tunHandler) transportServer(ctx context.Context, tun io.ReadWriter, con
 				}
 
 				addr := h.findRouteFor(ctx, dst, config.Router)
+				h.capture(b[:n], pcap.DirectionInbound, func() string {
+					if addr == nil {
+						return "action=drop"
+					}
+					return "peer=" + addr.String()
+				})
 				if addr == nil {
 					log.Debugf("no route for %s -> %s, packet discarded", src, dst)
 					return nil
@@ -118,6 +133,10 @@ func (h *tunHandler) transportServer(ctx context.Context, tun io.ReadWriter, con
 				if n == 0 {
 					return nil
 				}
+				if n > keepAliveHeaderLength && bytes.Equal(b[:4], ipamMagicHeader) {
+					h.handleIPAMRequest(ctx, conn, b[:n], addr, log)
+					return nil
+				}
 				if n > keepAliveHeaderLength && bytes.Equal(b[:4], magicHeader) {
 					var peerIPs []net.IP
 					data := b[keepAliveHeaderLength:n]
@@ -218,6 +237,9 @@ func (h *tunHandler) transportServer(ctx context.Context, tun io.ReadWriter, con
 					}
 				}
 
+				h.capture(b[:n], pcap.DirectionOutbound, func() string {
+					return "peer=" + addr.String()
+				})
 				if _, err := tun.Write(b[:n]); err != nil {
 					return ErrTun
 				}
@@ -0,0 +1,247 @@
+package tungo
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-gost/core/limiter"
+	"github.com/go-gost/core/limiter/traffic"
+	"github.com/go-gost/core/observer/stats"
+	xstats "github.com/go-gost/x/observer/stats"
+	"github.com/go-gost/x/registry"
+)
+
+// bandwidthRule assigns the flows of an application or of a domain to a bandwidth class, in the form of
+//
+//	app:<appID> <class>
+//	host:<domain> <class>
+//
+// The domain matches its subdomains. The class is the name of a traffic limiter.
+type bandwidthRule struct {
+	appID string
+	host  string
+	class string
+}
+
+func parseBandwidthRule(s string) (r bandwidthRule, err error) {
+	fields := strings.Fields(s)
+	if len(fields) != 2 {
+		return r, fmt.Errorf("invalid bandwidth rule %q", s)
+	}
+
+	kind, v, _ := strings.Cut(fields[0], ":")
+	switch strings.ToLower(kind) {
+	case "app":
+		r.appID = strings.TrimSpace(v)
+	case "host":
+		r.host = normalizeDNSName(v)
+	default:
+		return r, fmt.Errorf("invalid bandwidth rule selector %q", fields[0])
+	}
+	if r.appID == "" && r.host == "" {
+		return r, fmt.Errorf("invalid bandwidth rule selector %q", fields[0])
+	}
+	r.class = fields[1]
+	return
+}
+
+func (r bandwidthRule) match(appID, hostname string) bool {
+	if r.appID != "" {
+		return r.appID == appID
+	}
+	hostname = normalizeDNSName(hostname)
+	return hostname == r.host || strings.HasSuffix(hostname, "."+r.host)
+}
+
+// matchBandwidthRule returns the class of the first rule matching the flow.
+func matchBandwidthRule(rules []bandwidthRule, appID, hostname string) string {
+	for _, r := range rules {
+		if r.match(appID, hostname) {
+			return r.class
+		}
+	}
+	return ""
+}
+
+// bandwidthClasses counts the bytes of the flows per bandwidth class.
+type bandwidthClasses struct {
+	mu      sync.Mutex
+	classes map[string]*bandwidthClass
+}
+
+type bandwidthClass struct {
+	stats xstats.Stats
+	// rx and tx are the counters at the previous report.
+	rx, tx uint64
+}
+
+func newBandwidthClasses() *bandwidthClasses {
+	return &bandwidthClasses{
+		classes: make(map[string]*bandwidthClass),
+	}
+}
+
+func (c *bandwidthClasses) get(name string) *bandwidthClass {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	bc := c.classes[name]
+	if bc == nil {
+		bc = &bandwidthClass{}
+		c.classes[name] = bc
+	}
+	return bc
+}
+
+// report calls fn with the bytes transferred by each class since the previous report.
+func (c *bandwidthClasses) report(fn func(class string, rxBytes, txBytes int64)) {
+	c.mu.Lock()
+	names := make([]string, 0, len(c.classes))
+	for name := range c.classes {
+		names = append(names, name)
+	}
+	c.mu.Unlock()
+	sort.Strings(names)
+
+	for _, name := range names {
+		bc := c.get(name)
+		in := bc.stats.Get(stats.KindInputBytes)
+		out := bc.stats.Get(stats.KindOutputBytes)
+		rx, tx := int64(in-bc.rx), int64(out-bc.tx)
+		bc.rx, bc.tx = in, out
+		if rx > 0 || tx > 0 {
+			fn(name, rx, tx)
+		}
+	}
+}
+
+// observe periodically reports the class counters to the ClassStatsReporter registered for guid.
+func (c *bandwidthClasses) observe(ctx context.Context, guid string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if reporter := getClassStatsReporter(guid); reporter != nil {
+				c.report(reporter.OnClassStats)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// flowClass is the bandwidth class of a flow.
+type flowClass struct {
+	name string
+	// key is the key of the per-flow limits of the class, the appID or the hostname of the flow.
+	key     string
+	limiter traffic.TrafficLimiter
+	class   *bandwidthClass
+}
+
+// wait waits for the limits of the class as a whole and of the flows with the key of fc,
+// or until ctx is done.
+func (fc *flowClass) wait(ctx context.Context, in bool, n int) {
+	if fc.limiter == nil {
+		return
+	}
+
+	waitN(ctx, fc.limit(ctx, in, "", limiter.ScopeOption(limiter.ScopeService)), n)
+	waitN(ctx, fc.limit(ctx, in, fc.key), n)
+}
+
+func (fc *flowClass) limit(ctx context.Context, in bool, key string, opts ...limiter.Option) traffic.Limiter {
+	if in {
+		return fc.limiter.In(ctx, key, opts...)
+	}
+	return fc.limiter.Out(ctx, key, opts...)
+}
+
+// waitN waits until n bytes are allowed by lim, in bursts of at most the limit.
+func waitN(ctx context.Context, lim traffic.Limiter, n int) {
+	if lim == nil || lim.Limit() <= 0 {
+		return
+	}
+	for n > 0 && ctx.Err() == nil {
+		n -= lim.Wait(ctx, n)
+	}
+}
+
+// classConn enforces the limits of the bandwidth class of a flow on the client side of the flow,
+// and counts its bytes in the class. The flow is not limited until the class is set.
+// The reads and writes are not split, so the datagrams of the UDP flows are kept intact.
+// Closing the connection releases the reads and writes waiting for the limits.
+type classConn struct {
+	net.Conn
+	ctx    context.Context
+	cancel context.CancelFunc
+	class  atomic.Pointer[flowClass]
+}
+
+func newClassConn(ctx context.Context, conn net.Conn) *classConn {
+	ctx, cancel := context.WithCancel(ctx)
+	return &classConn{
+		Conn:   conn,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+func (c *classConn) setClass(fc *flowClass) {
+	c.class.Store(fc)
+}
+
+func (c *classConn) Read(b []byte) (n int, err error) {
+	n, err = c.Conn.Read(b)
+	if fc := c.class.Load(); fc != nil && n > 0 {
+		fc.class.stats.Add(stats.KindInputBytes, int64(n))
+		fc.wait(c.ctx, true, n)
+	}
+	return
+}
+
+func (c *classConn) Write(b []byte) (n int, err error) {
+	fc := c.class.Load()
+	if fc != nil && len(b) > 0 {
+		fc.wait(c.ctx, false, len(b))
+	}
+	n, err = c.Conn.Write(b)
+	if fc != nil && n > 0 {
+		fc.class.stats.Add(stats.KindOutputBytes, int64(n))
+	}
+	return
+}
+
+func (c *classConn) Close() error {
+	c.cancel()
+	return c.Conn.Close()
+}
+
+// flowClass returns the bandwidth class of the flow with the policy p, nil if the flow has no class.
+func (h *transportHandler) flowClass(p flowPolicy, dst string) *flowClass {
+	if p.class == "" || h.classes == nil {
+		return nil
+	}
+
+	key := p.appID
+	if key == "" {
+		key = p.hostname
+	}
+	if key == "" {
+		key = dst
+	}
+	return &flowClass{
+		name:    p.class,
+		key:     key,
+		limiter: registry.TrafficLimiterRegistry().Get(p.class),
+		class:   h.classes.get(p.class),
+	}
+}
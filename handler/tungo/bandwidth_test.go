@@ -0,0 +1,253 @@
+package tungo
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/AeroCore-IO/avionics/pkg/decision"
+	"github.com/go-gost/core/handler"
+	"github.com/go-gost/core/limiter"
+	"github.com/go-gost/core/limiter/traffic"
+	xlogger "github.com/go-gost/x/logger"
+	"github.com/go-gost/x/registry"
+)
+
+func TestParseBandwidthRule(t *testing.T) {
+	r, err := parseBandwidthRule("app:570  backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.appID != "570" || r.class != "backup" || !r.match("570", "") || r.match("571", "") {
+		t.Fatalf("unexpected rule %+v", r)
+	}
+
+	r, err = parseBandwidthRule("host:Backup.Example. backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.match("", "backup.example") || !r.match("", "eu.backup.example.") || r.match("", "notbackup.example") {
+		t.Fatalf("unexpected rule %+v", r)
+	}
+
+	for _, s := range []string{"app:570", "app: backup", "ip:1.2.3.4 backup", "570 backup"} {
+		if _, err := parseBandwidthRule(s); err == nil {
+			t.Errorf("%q: expected error", s)
+		}
+	}
+}
+
+type testClassEvaluator struct {
+	testDecisionEvaluator
+}
+
+func (e *testClassEvaluator) BandwidthClass(d *decision.TrafficDecision) string {
+	if d.Action == decision.ActionProxy {
+		return "proxied"
+	}
+	return ""
+}
+
+func TestEvaluatePolicy_BandwidthClass(t *testing.T) {
+	dec := &testClassEvaluator{}
+	dec.actions = map[string]decision.Action{
+		"a.example":      decision.ActionProxy,
+		"backup.example": decision.ActionDirect,
+	}
+	rules := []bandwidthRule{{host: "backup.example", class: "backup"}}
+	h := &transportHandler{
+		dec:            dec,
+		conntrack:      newConntrackTable(),
+		opts:           &handler.Options{Logger: xlogger.Nop()},
+		bandwidthRules: rules,
+	}
+	k := flowKey{
+		proto:   flowProtoTCP,
+		srcIP:   netip.MustParseAddr("10.0.0.2"),
+		dstIP:   netip.MustParseAddr("1.2.3.4"),
+		srcPort: 5000,
+		dstPort: 443,
+	}
+
+	for host, class := range map[string]string{
+		"a.example":      "proxied",
+		"backup.example": "backup",
+		"c.example":      "",
+	} {
+		p := h.evaluatePolicy(k, "TCP", host, h.opts.Logger)
+		if p.class != class {
+			t.Errorf("%s: expected class %q, got %q", host, class, p.class)
+		}
+		if o := p.recorderObject(false); o.Class != class {
+			t.Errorf("%s: expected recorded class %q, got %q", host, class, o.Class)
+		}
+	}
+
+	// the rules apply without an evaluator.
+	h.dec = nil
+	if p := h.evaluatePolicy(k, "TCP", "backup.example", h.opts.Logger); p.class != "backup" {
+		t.Fatalf("expected class backup, got %q", p.class)
+	}
+}
+
+type testLimiter struct {
+	limit int
+	n     atomic.Int64
+}
+
+func (l *testLimiter) Wait(ctx context.Context, n int) int {
+	if n > l.limit {
+		n = l.limit
+	}
+	l.n.Add(int64(n))
+	return n
+}
+
+func (l *testLimiter) Limit() int     { return l.limit }
+func (l *testLimiter) Set(n int)      {}
+func (l *testLimiter) String() string { return "" }
+
+// testTrafficLimiter limits the class as a whole in and the flows of the key 570 out.
+type testTrafficLimiter struct {
+	service, conn testLimiter
+}
+
+func (l *testTrafficLimiter) In(ctx context.Context, key string, opts ...limiter.Option) traffic.Limiter {
+	var options limiter.Options
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if options.Scope == limiter.ScopeService {
+		return &l.service
+	}
+	return nil
+}
+
+func (l *testTrafficLimiter) Out(ctx context.Context, key string, opts ...limiter.Option) traffic.Limiter {
+	var options limiter.Options
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if options.Scope == "" && key == "570" {
+		return &l.conn
+	}
+	return nil
+}
+
+func TestClassConn(t *testing.T) {
+	lim := &testTrafficLimiter{
+		service: testLimiter{limit: 4},
+		conn:    testLimiter{limit: 3},
+	}
+	registry.TrafficLimiterRegistry().Register("classconn-backup", lim)
+	defer registry.TrafficLimiterRegistry().Unregister("classconn-backup")
+
+	h := &transportHandler{classes: newBandwidthClasses()}
+	if fc := h.flowClass(flowPolicy{appID: "570"}, "1.2.3.4:443"); fc != nil {
+		t.Fatalf("unexpected class %+v", fc)
+	}
+
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+
+	conn := newClassConn(context.Background(), c1)
+	go func() {
+		b := make([]byte, 16)
+		for {
+			n, err := c2.Read(b)
+			if err != nil {
+				return
+			}
+			c2.Write(b[:n])
+		}
+	}()
+
+	// the flow is not limited nor counted until the class is set.
+	b := make([]byte, 16)
+	conn.Write([]byte("hello"))
+	conn.Read(b)
+
+	conn.setClass(h.flowClass(flowPolicy{appID: "570", class: "classconn-backup"}, "1.2.3.4:443"))
+	conn.Write([]byte("0123456789"))
+	if n, _ := conn.Read(b); n != 10 {
+		t.Fatalf("expected 10 bytes, got %d", n)
+	}
+
+	if n := lim.service.n.Load(); n != 10 {
+		t.Errorf("expected 10 bytes waited in, got %d", n)
+	}
+	if n := lim.conn.n.Load(); n != 10 {
+		t.Errorf("expected 10 bytes waited out, got %d", n)
+	}
+
+	var reports int
+	h.classes.report(func(class string, rx, tx int64) {
+		reports++
+		if class != "classconn-backup" || rx != 10 || tx != 10 {
+			t.Errorf("unexpected report %s rx=%d tx=%d", class, rx, tx)
+		}
+	})
+	// only the bytes since the previous report are reported.
+	h.classes.report(func(class string, rx, tx int64) {
+		reports++
+	})
+	if reports != 1 {
+		t.Fatalf("expected 1 report, got %d", reports)
+	}
+}
+
+// blockingLimiter holds the waits until their context is done.
+type blockingLimiter struct{}
+
+func (blockingLimiter) Wait(ctx context.Context, n int) int {
+	<-ctx.Done()
+	return n
+}
+
+func (blockingLimiter) Limit() int     { return 1 }
+func (blockingLimiter) Set(n int)      {}
+func (blockingLimiter) String() string { return "" }
+
+type blockingTrafficLimiter struct{}
+
+func (blockingTrafficLimiter) In(ctx context.Context, key string, opts ...limiter.Option) traffic.Limiter {
+	return blockingLimiter{}
+}
+
+func (blockingTrafficLimiter) Out(ctx context.Context, key string, opts ...limiter.Option) traffic.Limiter {
+	return blockingLimiter{}
+}
+
+func TestClassConn_Close(t *testing.T) {
+	registry.TrafficLimiterRegistry().Register("classconn-blocked", blockingTrafficLimiter{})
+	defer registry.TrafficLimiterRegistry().Unregister("classconn-blocked")
+
+	h := &transportHandler{classes: newBandwidthClasses()}
+
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	go io.Copy(io.Discard, c2)
+
+	conn := newClassConn(context.Background(), c1)
+	conn.setClass(h.flowClass(flowPolicy{appID: "570", class: "classconn-blocked"}, "1.2.3.4:443"))
+
+	done := make(chan struct{})
+	go func() {
+		conn.Write([]byte("hello"))
+		close(done)
+	}()
+
+	// the throttled write is released when the flow is closed.
+	time.Sleep(10 * time.Millisecond)
+	conn.Close()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the write is still waiting for the limit")
+	}
+}
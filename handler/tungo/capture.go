@@ -0,0 +1,48 @@
+package tungo
+
+import (
+	"strings"
+	"time"
+
+	"github.com/go-gost/x/internal/util/pcap"
+)
+
+// annotateCapture returns the comment of a captured packet with the decision of its flow.
+func (h *transportHandler) annotateCapture(b []byte, dir pcap.Direction) string {
+	t, ok := pcap.Parse(b)
+	if !ok {
+		return ""
+	}
+
+	// the flows are keyed by the client side of the TUN device.
+	k := flowKey{proto: flowProto(t.Proto), srcIP: t.Src, dstIP: t.Dst, srcPort: t.SrcPort, dstPort: t.DstPort}
+	if dir == pcap.DirectionOutbound {
+		k.srcIP, k.dstIP = t.Dst, t.Src
+		k.srcPort, k.dstPort = t.DstPort, t.SrcPort
+	}
+
+	p, ok := h.getCachedPolicy(time.Now(), k)
+	if !ok {
+		return ""
+	}
+	return capturePolicyComment(p)
+}
+
+func capturePolicyComment(p flowPolicy) string {
+	var sb strings.Builder
+	sb.WriteString("action=")
+	sb.WriteString(p.action)
+	if p.appID != "" {
+		sb.WriteString(" app=")
+		sb.WriteString(p.appID)
+	}
+	if p.hostname != "" {
+		sb.WriteString(" host=")
+		sb.WriteString(p.hostname)
+	}
+	if p.useProxy && p.proxyHost != "" && p.proxyHost != p.hostname {
+		sb.WriteString(" proxy=")
+		sb.WriteString(p.proxyHost)
+	}
+	return sb.String()
+}
@@ -0,0 +1,47 @@
+package tungo
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/go-gost/x/internal/util/pcap"
+)
+
+func testUDPPacket(src, dst string, sport, dport uint16) []byte {
+	b := make([]byte, 28)
+	b[0] = 0x45
+	binary.BigEndian.PutUint16(b[2:4], uint16(len(b)))
+	b[9] = byte(flowProtoUDP)
+	s, d := netip.MustParseAddr(src).As4(), netip.MustParseAddr(dst).As4()
+	copy(b[12:16], s[:])
+	copy(b[16:20], d[:])
+	binary.BigEndian.PutUint16(b[20:22], sport)
+	binary.BigEndian.PutUint16(b[22:24], dport)
+	return b
+}
+
+func TestTransportHandler_AnnotateCapture(t *testing.T) {
+	h := &transportHandler{conntrack: newConntrackTable()}
+	k := h.flowKeyUDP(netip.MustParseAddr("10.0.0.2"), netip.MustParseAddr("1.1.1.1"), 5353, 53)
+	h.putCachedPolicy(time.Now(), k, flowPolicy{
+		action:    flowActionProxy,
+		useProxy:  true,
+		proxyHost: "one.one.one.one",
+		appID:     "570",
+		hostname:  "one.one.one.one",
+	}, time.Minute)
+
+	expected := "action=proxy app=570 host=one.one.one.one"
+	if s := h.annotateCapture(testUDPPacket("10.0.0.2", "1.1.1.1", 5353, 53), pcap.DirectionInbound); s != expected {
+		t.Fatalf("inbound: expected %q, got %q", expected, s)
+	}
+	// the replies are matched with the flow of the request.
+	if s := h.annotateCapture(testUDPPacket("1.1.1.1", "10.0.0.2", 53, 5353), pcap.DirectionOutbound); s != expected {
+		t.Fatalf("outbound: expected %q, got %q", expected, s)
+	}
+	if s := h.annotateCapture(testUDPPacket("10.0.0.2", "1.1.1.1", 5354, 53), pcap.DirectionInbound); s != "" {
+		t.Fatalf("untracked flow annotated: %q", s)
+	}
+}
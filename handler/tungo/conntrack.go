@@ -1,17 +1,28 @@
 package tungo
 
 import (
+	"errors"
+	"io"
+	"net/netip"
+	"strings"
 	"sync"
 	"time"
 
-	"net/netip"
+	"github.com/AeroCore-IO/avionics/pkg/decision"
+	"github.com/go-gost/core/observer/stats"
+)
+
+var (
+	errFlowBlocked = errors.New("flow blocked by traffic decision")
 )
 
 type flowProto uint8
 
 const (
-	flowProtoTCP flowProto = 6
-	flowProtoUDP flowProto = 17
+	flowProtoICMP   flowProto = 1
+	flowProtoTCP    flowProto = 6
+	flowProtoUDP    flowProto = 17
+	flowProtoICMPv6 flowProto = 58
 )
 
 type flowKey struct {
@@ -22,10 +33,43 @@ type flowKey struct {
 	dstPort uint16
 }
 
+// flow actions, reported to the FlowStatsReporter.
+const (
+	flowActionProxy  = "proxy"
+	flowActionDirect = "direct"
+	flowActionBlock  = "block"
+)
+
+func (p flowProto) String() string {
+	switch p {
+	case flowProtoTCP:
+		return "tcp"
+	case flowProtoUDP:
+		return "udp"
+	case flowProtoICMP:
+		return "icmp"
+	case flowProtoICMPv6:
+		return "icmp6"
+	default:
+		return ""
+	}
+}
+
 type flowPolicy struct {
 	action    string
 	useProxy  bool
 	proxyHost string
+	appID     string
+	// hostname is the hostname the flow was attributed to, proxyHost may be
+	// overridden by the decision.
+	hostname string
+	// input is the input of the decision evaluator, nil if there is no evaluator.
+	input *decision.RuleInput
+	// rule and ruleID identify the rule matched by the decision.
+	rule   string
+	ruleID string
+	// class is the bandwidth class of the flow, empty if the flow is not limited.
+	class string
 }
 
 type conntrackEntry struct {
@@ -33,13 +77,164 @@ type conntrackEntry struct {
 	expiresAt time.Time
 }
 
+// trackedFlow is a live flow, it can be closed through the conntrack table.
+type trackedFlow struct {
+	id    string
+	key   flowKey
+	start time.Time
+	stats stats.Stats
+
+	mu      sync.Mutex
+	closers []io.Closer
+	closed  bool
+	// proto and policy are the rule protocol and the decision of the flow,
+	// valid once decided is set.
+	proto   string
+	policy  flowPolicy
+	decided bool
+}
+
+func newTrackedFlow(id string, key flowKey, st stats.Stats, closers ...io.Closer) *trackedFlow {
+	return &trackedFlow{
+		id:      id,
+		key:     key,
+		start:   time.Now(),
+		stats:   st,
+		closers: closers,
+	}
+}
+
+// AddCloser adds c to the resources released by Close.
+// If the flow is already closed, c is closed immediately.
+func (f *trackedFlow) AddCloser(c io.Closer) {
+	if f == nil || c == nil {
+		return
+	}
+	f.mu.Lock()
+	closed := f.closed
+	if !closed {
+		f.closers = append(f.closers, c)
+	}
+	f.mu.Unlock()
+
+	if closed {
+		c.Close()
+	}
+}
+
+// SetPolicy records the decision of the flow.
+func (f *trackedFlow) SetPolicy(proto string, p flowPolicy) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.proto = proto
+	f.policy = p
+	f.decided = true
+}
+
+// Policy returns the decision of the flow, ok is false if the flow is not decided yet or closed.
+func (f *trackedFlow) Policy() (proto string, p flowPolicy, ok bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.proto, f.policy, f.decided && !f.closed
+}
+
+func (f *trackedFlow) Close() error {
+	f.mu.Lock()
+	if f.closed {
+		f.mu.Unlock()
+		return nil
+	}
+	f.closed = true
+	closers := f.closers
+	f.closers = nil
+	f.mu.Unlock()
+
+	for _, c := range closers {
+		c.Close()
+	}
+	return nil
+}
+
+// FlowState is a snapshot of a live flow of the tungo handler.
+type FlowState struct {
+	ID       string `json:"id"`
+	Network  string `json:"network"`
+	SrcAddr  string `json:"src"`
+	DstAddr  string `json:"dst"`
+	Action   string `json:"action,omitempty"`
+	UseProxy bool   `json:"useProxy"`
+	// Hostname is the hostname the flow was attributed to, ProxyHost is the host
+	// dialed through the proxy, which may be overridden by the decision.
+	Hostname  string `json:"hostname,omitempty"`
+	ProxyHost string `json:"proxyHost,omitempty"`
+	AppID     string `json:"appID,omitempty"`
+	// Age is the time since the flow started.
+	Age time.Duration `json:"age"`
+	// TTL is the time left before the cached policy expires, 0 if the policy is not cached.
+	TTL         time.Duration `json:"ttl"`
+	InputBytes  uint64        `json:"inputBytes"`
+	OutputBytes uint64        `json:"outputBytes"`
+}
+
+// FlowFilter selects live flows, empty fields match any flow.
+type FlowFilter struct {
+	// ID is the flow ID.
+	ID string
+	// Network is tcp or udp.
+	Network string
+	// DstNet matches the destination IP address.
+	DstNet netip.Prefix
+	// Hostname matches the hostname of the flow policy, or any of its subdomains.
+	Hostname string
+	// AppID is the application ID resolved for the flow.
+	AppID string
+}
+
+// IsZero reports whether the filter matches all flows.
+func (ff *FlowFilter) IsZero() bool {
+	return ff == nil ||
+		(ff.ID == "" && ff.Network == "" && !ff.DstNet.IsValid() && ff.Hostname == "" && ff.AppID == "")
+}
+
+// Match reports whether the flow matches the filter.
+func (ff *FlowFilter) Match(fs *FlowState, dstIP netip.Addr) bool {
+	if ff == nil {
+		return true
+	}
+	if ff.ID != "" && ff.ID != fs.ID {
+		return false
+	}
+	if ff.Network != "" && !strings.EqualFold(ff.Network, fs.Network) {
+		return false
+	}
+	if ff.DstNet.IsValid() && !ff.DstNet.Contains(dstIP.Unmap()) {
+		return false
+	}
+	if ff.Hostname != "" {
+		name := strings.TrimSuffix(strings.ToLower(ff.Hostname), ".")
+		host := strings.TrimSuffix(strings.ToLower(fs.Hostname), ".")
+		if host != name && !strings.HasSuffix(host, "."+name) {
+			return false
+		}
+	}
+	if ff.AppID != "" && ff.AppID != fs.AppID {
+		return false
+	}
+	return true
+}
+
 type conntrackTable struct {
 	mu sync.RWMutex
 	m  map[flowKey]conntrackEntry
+	// flows are the live flows, keyed by flow ID.
+	flows map[string]*trackedFlow
 }
 
 func newConntrackTable() *conntrackTable {
-	return &conntrackTable{m: make(map[flowKey]conntrackEntry)}
+	return &conntrackTable{
+		m:     make(map[flowKey]conntrackEntry),
+		flows: make(map[string]*trackedFlow),
+	}
 }
 
 func (t *conntrackTable) Get(now time.Time, k flowKey) (flowPolicy, bool) {
@@ -110,3 +305,120 @@ func (t *conntrackTable) Cleanup(now time.Time) (removed int) {
 	}
 	return removed
 }
+
+// Flush removes all the cached policies, the live flows are kept.
+func (t *conntrackTable) Flush() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.m = make(map[flowKey]conntrackEntry)
+	t.mu.Unlock()
+}
+
+// Track adds a live flow to the table.
+func (t *conntrackTable) Track(f *trackedFlow) {
+	if t == nil || f == nil {
+		return
+	}
+	t.mu.Lock()
+	t.flows[f.id] = f
+	t.mu.Unlock()
+}
+
+// Untrack removes a live flow from the table.
+func (t *conntrackTable) Untrack(f *trackedFlow) {
+	if t == nil || f == nil {
+		return
+	}
+	t.mu.Lock()
+	if t.flows[f.id] == f {
+		delete(t.flows, f.id)
+	}
+	t.mu.Unlock()
+}
+
+// Flows returns the snapshots of the live flows matching filter.
+func (t *conntrackTable) Flows(now time.Time, filter *FlowFilter) (states []FlowState) {
+	if t == nil {
+		return nil
+	}
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	for _, f := range t.flows {
+		fs := t.flowState(now, f)
+		if filter.Match(&fs, f.key.dstIP) {
+			states = append(states, fs)
+		}
+	}
+	return
+}
+
+// CloseFlows closes the live flows matching filter and returns the number of closed flows.
+func (t *conntrackTable) CloseFlows(now time.Time, filter *FlowFilter) int {
+	if t == nil {
+		return 0
+	}
+
+	var flows []*trackedFlow
+
+	t.mu.RLock()
+	for _, f := range t.flows {
+		fs := t.flowState(now, f)
+		if filter.Match(&fs, f.key.dstIP) {
+			flows = append(flows, f)
+		}
+	}
+	t.mu.RUnlock()
+
+	for _, f := range flows {
+		f.Close()
+	}
+	return len(flows)
+}
+
+// trackedFlows returns the live flows.
+func (t *conntrackTable) trackedFlows() []*trackedFlow {
+	if t == nil {
+		return nil
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	flows := make([]*trackedFlow, 0, len(t.flows))
+	for _, f := range t.flows {
+		flows = append(flows, f)
+	}
+	return flows
+}
+
+// flowState builds the snapshot of a live flow. t.mu must be held.
+func (t *conntrackTable) flowState(now time.Time, f *trackedFlow) FlowState {
+	fs := FlowState{
+		ID:      f.id,
+		Network: f.key.proto.String(),
+		SrcAddr: netip.AddrPortFrom(f.key.srcIP, f.key.srcPort).String(),
+		DstAddr: netip.AddrPortFrom(f.key.dstIP, f.key.dstPort).String(),
+		Age:     now.Sub(f.start),
+	}
+	if f.stats != nil {
+		fs.InputBytes = f.stats.Get(stats.KindInputBytes)
+		fs.OutputBytes = f.stats.Get(stats.KindOutputBytes)
+	}
+	entry, cached := t.m[f.key]
+	_, p, ok := f.Policy()
+	if !ok && cached {
+		p = entry.policy
+	}
+	fs.Action = p.action
+	fs.UseProxy = p.useProxy
+	fs.Hostname = p.hostname
+	fs.ProxyHost = p.proxyHost
+	fs.AppID = p.appID
+	if cached && !entry.expiresAt.IsZero() && entry.expiresAt.After(now) {
+		fs.TTL = entry.expiresAt.Sub(now)
+	}
+	return fs
+}
@@ -4,6 +4,10 @@ import (
 	"net/netip"
 	"testing"
 	"time"
+
+	"github.com/AeroCore-IO/avionics/pkg/decision"
+	"github.com/go-gost/core/handler"
+	xlogger "github.com/go-gost/x/logger"
 )
 
 func TestConntrackTable_PutGetExpire(t *testing.T) {
@@ -78,3 +82,199 @@ func TestConntrackTable_Cleanup(t *testing.T) {
 		t.Fatalf("expected k2 still present")
 	}
 }
+
+type testCloser struct {
+	closed int
+}
+
+func (c *testCloser) Close() error {
+	c.closed++
+	return nil
+}
+
+func TestConntrackTable_FlowsAndClose(t *testing.T) {
+	ct := newConntrackTable()
+	k1 := flowKey{
+		proto:   flowProtoTCP,
+		srcIP:   netip.MustParseAddr("10.0.0.2"),
+		dstIP:   netip.MustParseAddr("1.2.3.4"),
+		srcPort: 12345,
+		dstPort: 443,
+	}
+	k2 := flowKey{
+		proto:   flowProtoUDP,
+		srcIP:   netip.MustParseAddr("10.0.0.2"),
+		dstIP:   netip.MustParseAddr("5.6.7.8"),
+		srcPort: 23456,
+		dstPort: 53,
+	}
+
+	now := time.Now()
+	ct.Put(now, k1, flowPolicy{useProxy: true, action: flowActionProxy, proxyHost: "www.example.com", hostname: "www.example.com", appID: "app1"}, time.Minute)
+
+	c1, c2 := &testCloser{}, &testCloser{}
+	f1 := newTrackedFlow("f1", k1, nil, c1)
+	f2 := newTrackedFlow("f2", k2, nil, c2)
+	ct.Track(f1)
+	ct.Track(f2)
+
+	if n := len(ct.Flows(now, nil)); n != 2 {
+		t.Fatalf("expected 2 flows, got %d", n)
+	}
+
+	states := ct.Flows(now, &FlowFilter{Hostname: "example.com"})
+	if len(states) != 1 || states[0].ID != "f1" || states[0].AppID != "app1" || !states[0].UseProxy {
+		t.Fatalf("unexpected hostname match %+v", states)
+	}
+	if states[0].TTL <= 0 {
+		t.Fatalf("expected TTL of the cached policy, got %v", states[0].TTL)
+	}
+
+	if n := len(ct.Flows(now, &FlowFilter{Network: "udp", DstNet: netip.MustParsePrefix("5.6.0.0/16")})); n != 1 {
+		t.Fatalf("expected 1 udp flow, got %d", n)
+	}
+	if n := len(ct.Flows(now, &FlowFilter{DstNet: netip.MustParsePrefix("9.9.9.0/24")})); n != 0 {
+		t.Fatalf("expected no flow, got %d", n)
+	}
+
+	if n := ct.CloseFlows(now, &FlowFilter{ID: "f2"}); n != 1 {
+		t.Fatalf("expected 1 closed flow, got %d", n)
+	}
+	if c2.closed != 1 || c1.closed != 0 {
+		t.Fatalf("unexpected closers c1=%d c2=%d", c1.closed, c2.closed)
+	}
+
+	// resources added after the flow is closed are released immediately.
+	c3 := &testCloser{}
+	f2.AddCloser(c3)
+	if c3.closed != 1 {
+		t.Fatalf("expected late closer to be closed")
+	}
+
+	ct.Untrack(f2)
+	if n := len(ct.Flows(now, nil)); n != 1 {
+		t.Fatalf("expected 1 flow after untrack, got %d", n)
+	}
+}
+
+type testRuleIDEvaluator struct {
+	testDecisionEvaluator
+}
+
+func (e *testRuleIDEvaluator) RuleID(d *decision.TrafficDecision) string {
+	return "rule-" + string(d.Action)
+}
+
+func TestFlowPolicy_RecorderObject(t *testing.T) {
+	dec := &testRuleIDEvaluator{}
+	dec.actions = map[string]decision.Action{"blocked.example": decision.ActionBlock}
+	h := &transportHandler{
+		dec:       dec,
+		conntrack: newConntrackTable(),
+		opts:      &handler.Options{Logger: xlogger.Nop()},
+	}
+	k := flowKey{
+		proto:   flowProtoUDP,
+		srcIP:   netip.MustParseAddr("10.0.0.2"),
+		dstIP:   netip.MustParseAddr("1.2.3.4"),
+		srcPort: 5000,
+		dstPort: 443,
+	}
+
+	o := h.evaluatePolicy(k, "UDP", "blocked.example", h.opts.Logger).recorderObject(true)
+	if o.Action != flowActionBlock || !o.Cached || o.RuleID != "rule-BLOCK" || o.Hostname != "blocked.example" {
+		t.Fatalf("unexpected decision %+v", o)
+	}
+	if o.Input == nil || o.Input.DestHost != "1.2.3.4" || o.Input.DestPort != 443 ||
+		o.Input.Protocol != "UDP" || o.Input.DestDomain != "blocked.example" {
+		t.Fatalf("unexpected input %+v", o.Input)
+	}
+	if o.ProxyHost != "" {
+		t.Fatalf("proxy host recorded for a blocked flow: %s", o.ProxyHost)
+	}
+
+	// without an evaluator the flow goes direct and no input is recorded.
+	h.dec = nil
+	if o := h.evaluatePolicy(k, "UDP", "", h.opts.Logger).recorderObject(false); o.Action != flowActionDirect || o.Input != nil {
+		t.Fatalf("unexpected decision %+v", o)
+	}
+}
+
+func TestEvaluatePolicy_Actions(t *testing.T) {
+	dec := &testDecisionEvaluator{
+		actions: map[string]decision.Action{
+			"blocked.example": decision.ActionBlock,
+			"proxied.example": decision.ActionProxy,
+			"direct.example":  decision.ActionDirect,
+			"other.example":   decision.Action("REJECT"),
+		},
+	}
+	h := &transportHandler{
+		dec:       dec,
+		conntrack: newConntrackTable(),
+		opts:      &handler.Options{Logger: xlogger.Nop()},
+	}
+	k := flowKey{
+		proto:   flowProtoTCP,
+		srcIP:   netip.MustParseAddr("10.0.0.2"),
+		dstIP:   netip.MustParseAddr("1.2.3.4"),
+		srcPort: 5000,
+		dstPort: 443,
+	}
+
+	for domain, action := range map[string]string{
+		"blocked.example": flowActionBlock,
+		"proxied.example": flowActionProxy,
+		"direct.example":  flowActionDirect,
+		"other.example":   flowActionDirect,
+		"none.example":    flowActionDirect,
+	} {
+		p := h.evaluatePolicy(k, "TCP", domain, h.opts.Logger)
+		if p.action != action || p.useProxy != (action == flowActionProxy) {
+			t.Errorf("%s: expected action %s, got %s (useProxy=%v)", domain, action, p.action, p.useProxy)
+		}
+	}
+}
+
+type testProxyHostEvaluator struct {
+	testDecisionEvaluator
+}
+
+func (e *testProxyHostEvaluator) ProxyHost(d *decision.TrafficDecision) string {
+	return "upstream.proxy"
+}
+
+func TestEvaluatePolicy_ProxyHost(t *testing.T) {
+	dec := &testProxyHostEvaluator{}
+	dec.actions = map[string]decision.Action{"proxied.example": decision.ActionProxy}
+	h := &transportHandler{
+		dec:       dec,
+		conntrack: newConntrackTable(),
+		opts:      &handler.Options{Logger: xlogger.Nop()},
+	}
+	k := flowKey{
+		proto:   flowProtoTCP,
+		srcIP:   netip.MustParseAddr("10.0.0.2"),
+		dstIP:   netip.MustParseAddr("1.2.3.4"),
+		srcPort: 5000,
+		dstPort: 443,
+	}
+
+	p := h.evaluatePolicy(k, "TCP", "proxied.example", h.opts.Logger)
+	if !p.useProxy || p.proxyHost != "upstream.proxy" || p.hostname != "proxied.example" {
+		t.Fatalf("unexpected policy %+v", p)
+	}
+
+	// the flow is reported and matched by its hostname, not by the proxy host.
+	f := newTrackedFlow("f1", k, nil)
+	f.SetPolicy("tcp", p)
+	h.conntrack.Track(f)
+
+	states := h.conntrack.Flows(time.Now(), &FlowFilter{Hostname: "proxied.example"})
+	if len(states) != 1 || states[0].Hostname != "proxied.example" || states[0].ProxyHost != "upstream.proxy" {
+		t.Fatalf("unexpected flows %+v", states)
+	}
+	if n := len(h.conntrack.Flows(time.Now(), &FlowFilter{Hostname: "upstream.proxy"})); n != 0 {
+		t.Fatalf("the proxy host should not match, got %d flows", n)
+	}
+}
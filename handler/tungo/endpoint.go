@@ -6,6 +6,7 @@ import (
 	"sync"
 
 	"github.com/go-gost/core/logger"
+	"github.com/go-gost/x/internal/util/pcap"
 	"gvisor.dev/gvisor/pkg/buffer"
 	"gvisor.dev/gvisor/pkg/tcpip"
 	"gvisor.dev/gvisor/pkg/tcpip/header"
@@ -38,6 +39,18 @@ type endpoint struct {
 	// wg keeps track of running goroutines.
 	wg sync.WaitGroup
 
+	// inbound intercepts the inbound packets before they are dispatched to the stack,
+	// the packet is consumed if it returns true.
+	inbound func(b []byte) bool
+
+	// wmu serializes the writes of the stack and of the packets written with WriteRaw.
+	wmu sync.Mutex
+
+	// tap captures the packets read from and written to the io.ReadWriter,
+	// annotate returns the comment of a captured packet.
+	tap      *pcap.Tap
+	annotate func(b []byte, dir pcap.Direction) string
+
 	log logger.Logger
 }
 
@@ -102,6 +115,12 @@ func (e *endpoint) dispatchLoop(cancel context.CancelFunc) {
 			continue /* unattached, drop packet */
 		}
 
+		e.capture(data[offset:offset+n], pcap.DirectionInbound)
+
+		if e.inbound != nil && e.inbound(data[offset:offset+n]) {
+			continue
+		}
+
 		pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{
 			Payload: buffer.MakeWithData(data[offset : offset+n]),
 		})
@@ -139,9 +158,43 @@ func (e *endpoint) writePacket(pkt *stack.PacketBuffer) tcpip.Error {
 		_ = buf.Prepend(v)
 	}
 
-	if _, err := e.rw.Write(buf.Flatten()); err != nil {
+	b := buf.Flatten()
+	e.capture(b[e.offset:], pcap.DirectionOutbound)
+
+	e.wmu.Lock()
+	_, err := e.rw.Write(b)
+	e.wmu.Unlock()
+	if err != nil {
 		return &tcpip.ErrInvalidEndpointState{}
 	}
 
 	return nil
 }
+
+// WriteRaw writes an IP packet built outside of the stack to the io.Writer.
+func (e *endpoint) WriteRaw(b []byte) error {
+	e.capture(b, pcap.DirectionOutbound)
+
+	if e.offset != 0 {
+		b = append(make([]byte, e.offset, e.offset+len(b)), b...)
+	}
+
+	e.wmu.Lock()
+	defer e.wmu.Unlock()
+
+	_, err := e.rw.Write(b)
+	return err
+}
+
+// capture writes the IP packet b to the running capture, if any.
+func (e *endpoint) capture(b []byte, dir pcap.Direction) {
+	if !e.tap.Enabled() {
+		return
+	}
+
+	var comment func() string
+	if e.annotate != nil {
+		comment = func() string { return e.annotate(b, dir) }
+	}
+	e.tap.Capture(b, dir, comment)
+}
@@ -0,0 +1,228 @@
+package tungo
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/go-gost/core/bypass"
+	"github.com/go-gost/core/common/bufpool"
+	"github.com/go-gost/core/logger"
+	"github.com/go-gost/core/observer/stats"
+	ictx "github.com/go-gost/x/internal/ctx"
+	xstats "github.com/go-gost/x/observer/stats"
+	stats_wrapper "github.com/go-gost/x/observer/stats/wrapper"
+	xrecorder "github.com/go-gost/x/recorder"
+	"github.com/miekg/dns"
+)
+
+const (
+	// defaultFakeDNSTTL is the TTL of the synthesized answers. It is kept short
+	// so that clients come back to us once the mapping has been recycled.
+	defaultFakeDNSTTL = 1
+)
+
+// lookupFakeIP reports whether ip belongs to the fake IP pool,
+// and if so returns the domain currently mapped to it.
+func (h *transportHandler) lookupFakeIP(ip netip.Addr) (domain string, fake bool) {
+	if h.fakeIP == nil || !h.fakeIP.Contains(ip) {
+		return "", false
+	}
+	domain, _ = h.fakeIP.LookupIP(time.Now(), ip)
+	return domain, true
+}
+
+// fakeDNSAnswer builds a response for the query from the fake IP pool.
+// It returns nil if the query should be sent to the real resolver.
+func (h *transportHandler) fakeDNSAnswer(ctx context.Context, mq *dns.Msg) *dns.Msg {
+	if h.fakeIP == nil || mq.Response || mq.Opcode != dns.OpcodeQuery || len(mq.Question) != 1 {
+		return nil
+	}
+
+	q := mq.Question[0]
+	if q.Qclass != dns.ClassINET || (q.Qtype != dns.TypeA && q.Qtype != dns.TypeAAAA) {
+		return nil
+	}
+
+	name := normalizeFakeIPDomain(q.Name)
+	if name == "" {
+		return nil
+	}
+	if h.fakeIPBypass != nil &&
+		h.fakeIPBypass.Contains(ctx, "udp", name, bypass.WithService(h.service)) {
+		return nil
+	}
+
+	mr := &dns.Msg{}
+	mr.SetReply(mq)
+	mr.RecursionAvailable = true
+
+	if q.Qtype == dns.TypeAAAA && !h.fakeIP.HasIPv6() {
+		// no IPv6 pool: answer with an empty NOERROR so that clients fall back to A records.
+		return mr
+	}
+
+	ip, ok := h.fakeIP.Lookup(time.Now(), name, q.Qtype == dns.TypeAAAA)
+	if !ok {
+		return nil
+	}
+
+	hdr := dns.RR_Header{
+		Name:   q.Name,
+		Rrtype: q.Qtype,
+		Class:  dns.ClassINET,
+		Ttl:    defaultFakeDNSTTL,
+	}
+	if q.Qtype == dns.TypeAAAA {
+		mr.Answer = append(mr.Answer, &dns.AAAA{Hdr: hdr, AAAA: ip.AsSlice()})
+	} else {
+		mr.Answer = append(mr.Answer, &dns.A{Hdr: hdr, A: ip.AsSlice()})
+	}
+
+	return mr
+}
+
+// handleDNS serves the DNS flow to dstAddr. A/AAAA queries are answered from
+// the fake IP pool, the queries to the split DNS addresses are answered by the split DNS,
+// other queries are relayed to the original resolver.
+func (h *transportHandler) handleDNS(ctx context.Context, conn net.Conn, dstAddr netip.AddrPort, ro *xrecorder.HandlerRecorderObject, log logger.Logger) {
+	start := ro.Time
+
+	var err error
+
+	pStats := xstats.Stats{}
+	conn = stats_wrapper.WrapConn(conn, &pStats)
+
+	ro.Proto = "dns"
+
+	defer func() {
+		if err != nil {
+			ro.Err = err.Error()
+		}
+		ro.InputBytes = pStats.Get(stats.KindInputBytes)
+		ro.OutputBytes = pStats.Get(stats.KindOutputBytes)
+		ro.Duration = time.Since(start)
+		if err := ro.Record(ctx, h.recorder.Recorder); err != nil {
+			log.Errorf("record: %v", err)
+		}
+
+		log.WithFields(map[string]any{
+			"src":         ro.SrcAddr,
+			"duration":    time.Since(start),
+			"inputBytes":  ro.InputBytes,
+			"outputBytes": ro.OutputBytes,
+		}).Debugf("%s >< %s", ro.RemoteAddr, ro.DstAddr)
+	}()
+
+	timeout := h.udpTimeout
+	if timeout <= 0 {
+		timeout = udpSessionTimeout
+	}
+
+	bufferSize := h.udpBufferSize
+	if bufferSize <= 0 {
+		bufferSize = defaultBufferSize
+	}
+
+	buf := bufpool.Get(bufferSize)
+	defer bufpool.Put(buf)
+
+	var cc net.Conn
+	var wg sync.WaitGroup
+	defer func() {
+		if cc != nil {
+			cc.Close()
+		}
+		wg.Wait()
+	}()
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(timeout))
+		n, er := conn.Read(buf)
+		if ne, ok := er.(net.Error); ok && ne.Timeout() {
+			return
+		} else if er == io.EOF {
+			return
+		} else if er != nil {
+			err = er
+			return
+		}
+		if n == 0 {
+			return
+		}
+
+		mq := &dns.Msg{}
+		if mq.Unpack(buf[:n]) == nil && len(mq.Question) > 0 {
+			ro.DNS = &xrecorder.DNSRecorderObject{
+				ID:       int(mq.Id),
+				Name:     mq.Question[0].Name,
+				Class:    dns.Class(mq.Question[0].Qclass).String(),
+				Type:     dns.Type(mq.Question[0].Qtype).String(),
+				Question: mq.String(),
+			}
+
+			if mr := h.fakeDNSAnswer(ctx, mq); mr != nil {
+				b, er := mr.Pack()
+				if er != nil {
+					err = er
+					return
+				}
+				ro.DNS.Answer = mr.String()
+				log.Debugf("fakeip: %s %s -> %v", ro.DNS.Type, ro.DNS.Name, mr.Answer)
+				if _, err = conn.Write(b); err != nil {
+					return
+				}
+				continue
+			}
+
+			if h.dns.intercepts(dstAddr) {
+				mr, er := h.dns.answer(ctx, mq, ro, log)
+				if er != nil {
+					log.Warnf("dns: %s %s: %v", ro.DNS.Type, ro.DNS.Name, er)
+					mr = (&dns.Msg{}).SetRcode(mq, dns.RcodeServerFailure)
+				}
+				if mr != nil {
+					b, er := mr.Pack()
+					if er != nil {
+						err = er
+						return
+					}
+					ro.DNS.Answer = mr.String()
+					if _, err = conn.Write(b); err != nil {
+						return
+					}
+					continue
+				}
+			}
+		}
+
+		if cc == nil {
+			var rbuf bytes.Buffer
+			cc, err = h.opts.Router.Dial(ictx.ContextWithBuffer(ctx, &rbuf), "udp", dstAddr.String())
+			ro.Route = rbuf.String()
+			if err != nil {
+				log.Errorf("dial %s: %v", dstAddr.String(), err)
+				return
+			}
+			ro.SrcAddr = cc.LocalAddr().String()
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				b := bufpool.Get(bufferSize)
+				defer bufpool.Put(b)
+
+				copyPacketData(conn, cc, b, false, true, ro, timeout, "")
+			}()
+		}
+
+		if _, err = cc.Write(buf[:n]); err != nil {
+			return
+		}
+	}
+}
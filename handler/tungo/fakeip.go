@@ -0,0 +1,302 @@
+package tungo
+
+import (
+	"container/list"
+	"errors"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultFakeIPCIDR = "198.18.0.0/15"
+	defaultFakeIPSize = 65535
+	defaultFakeIPTTL  = 10 * time.Minute
+)
+
+var (
+	errFakeIPPoolTooSmall = errors.New("fakeip: address pool is too small")
+	errFakeIPNotFound     = errors.New("fakeip: no domain mapped to the address")
+)
+
+type fakeIPEntry struct {
+	domain    string
+	ip        netip.Addr
+	expiresAt time.Time
+}
+
+// fakeIPRange is a single address family range of the fake IP pool.
+type fakeIPRange struct {
+	prefix netip.Prefix
+	first  netip.Addr
+	size   int
+	// next is the offset of the first address never allocated.
+	next int
+	// free are the released addresses, reused in the order they were released.
+	free []netip.Addr
+}
+
+func newFakeIPRange(prefix netip.Prefix) (*fakeIPRange, error) {
+	prefix = prefix.Masked()
+
+	hostBits := prefix.Addr().BitLen() - prefix.Bits()
+	if hostBits > 30 {
+		// large (IPv6) ranges are never used up to the end.
+		hostBits = 30
+	}
+	// skip the network address and the first host address (commonly used as gateway),
+	// and the broadcast address for IPv4.
+	size := 1<<hostBits - 2
+	if prefix.Addr().Is4() {
+		size--
+	}
+	if size <= 0 {
+		return nil, errFakeIPPoolTooSmall
+	}
+
+	return &fakeIPRange{
+		prefix: prefix,
+		first:  addrAdd(prefix.Addr(), 2),
+		size:   size,
+	}, nil
+}
+
+// alloc returns an unused address of the range. The addresses never allocated
+// are taken first, then the released ones, so the addresses in use are never reused.
+func (r *fakeIPRange) alloc() (netip.Addr, bool) {
+	if r.next < r.size {
+		ip := addrAdd(r.first, uint64(r.next))
+		r.next++
+		return ip, true
+	}
+	if len(r.free) == 0 {
+		return netip.Addr{}, false
+	}
+	ip := r.free[0]
+	r.free[0] = netip.Addr{}
+	r.free = r.free[1:]
+	return ip, true
+}
+
+// release returns the address to the range.
+func (r *fakeIPRange) release(ip netip.Addr) {
+	r.free = append(r.free, ip)
+}
+
+func addrAdd(ip netip.Addr, n uint64) netip.Addr {
+	b := ip.As16()
+	for i := len(b) - 1; i >= 0 && n > 0; i-- {
+		sum := uint64(b[i]) + n&0xff
+		b[i] = byte(sum)
+		n = n>>8 + sum>>8
+	}
+	if ip.Is4() {
+		return netip.AddrFrom16(b).Unmap()
+	}
+	return netip.AddrFrom16(b)
+}
+
+// fakeIPPool is a bounded bidirectional fake IP <-> domain table.
+// Entries expire after ttl of inactivity, and the least recently used entry
+// is recycled when the pool is full.
+type fakeIPPool struct {
+	v4       *fakeIPRange
+	v6       *fakeIPRange
+	size     int
+	ttl      time.Duration
+	byDomain map[string]*list.Element
+	byIP     map[netip.Addr]*list.Element
+	lru      *list.List
+	mu       sync.Mutex
+}
+
+func newFakeIPPool(prefixes []netip.Prefix, size int, ttl time.Duration) (*fakeIPPool, error) {
+	p := &fakeIPPool{
+		size:     size,
+		ttl:      ttl,
+		byDomain: make(map[string]*list.Element),
+		byIP:     make(map[netip.Addr]*list.Element),
+		lru:      list.New(),
+	}
+	if p.size <= 0 {
+		p.size = defaultFakeIPSize
+	}
+	if p.ttl <= 0 {
+		p.ttl = defaultFakeIPTTL
+	}
+
+	for _, prefix := range prefixes {
+		r, err := newFakeIPRange(prefix)
+		if err != nil {
+			return nil, err
+		}
+		if prefix.Addr().Is4() {
+			p.v4 = r
+		} else {
+			p.v6 = r
+		}
+	}
+	if p.v4 == nil && p.v6 == nil {
+		return nil, errFakeIPPoolTooSmall
+	}
+
+	// keep the table no larger than the smallest range, so once the LRU entry is
+	// evicted its range always has a released address to allocate.
+	for _, r := range []*fakeIPRange{p.v4, p.v6} {
+		if r != nil && r.size < p.size {
+			p.size = r.size
+		}
+	}
+
+	return p, nil
+}
+
+func normalizeFakeIPDomain(domain string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+}
+
+// Contains reports whether ip belongs to one of the fake IP ranges.
+func (p *fakeIPPool) Contains(ip netip.Addr) bool {
+	if p == nil {
+		return false
+	}
+	ip = ip.Unmap()
+	return (p.v4 != nil && p.v4.prefix.Contains(ip)) ||
+		(p.v6 != nil && p.v6.prefix.Contains(ip))
+}
+
+// HasIPv6 reports whether the pool can answer AAAA queries.
+func (p *fakeIPPool) HasIPv6() bool {
+	return p != nil && p.v6 != nil
+}
+
+// Lookup returns the fake IP of the given family mapped to domain,
+// allocating a new one if needed.
+func (p *fakeIPPool) Lookup(now time.Time, domain string, ipv6 bool) (netip.Addr, bool) {
+	if p == nil {
+		return netip.Addr{}, false
+	}
+	domain = normalizeFakeIPDomain(domain)
+	if domain == "" {
+		return netip.Addr{}, false
+	}
+
+	r := p.v4
+	if ipv6 {
+		r = p.v6
+	}
+	if r == nil {
+		return netip.Addr{}, false
+	}
+
+	key := p.domainKey(domain, ipv6)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if e, ok := p.byDomain[key]; ok {
+		entry := e.Value.(*fakeIPEntry)
+		entry.expiresAt = now.Add(p.ttl)
+		p.lru.MoveToFront(e)
+		return entry.ip, true
+	}
+
+	p.evict(now)
+
+	ip, ok := r.alloc()
+	if !ok {
+		return netip.Addr{}, false
+	}
+
+	entry := &fakeIPEntry{
+		domain:    domain,
+		ip:        ip,
+		expiresAt: now.Add(p.ttl),
+	}
+	e := p.lru.PushFront(entry)
+	p.byDomain[key] = e
+	p.byIP[ip] = e
+
+	return ip, true
+}
+
+// LookupIP returns the domain mapped to the fake IP, refreshing its expiration.
+func (p *fakeIPPool) LookupIP(now time.Time, ip netip.Addr) (string, bool) {
+	if p == nil {
+		return "", false
+	}
+	ip = ip.Unmap()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	e, ok := p.byIP[ip]
+	if !ok {
+		return "", false
+	}
+	entry := e.Value.(*fakeIPEntry)
+	entry.expiresAt = now.Add(p.ttl)
+	p.lru.MoveToFront(e)
+
+	return entry.domain, true
+}
+
+// Len returns the number of entries in the table.
+func (p *fakeIPPool) Len() int {
+	if p == nil {
+		return 0
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.lru.Len()
+}
+
+// Cleanup removes the expired entries.
+func (p *fakeIPPool) Cleanup(now time.Time) (removed int) {
+	if p == nil {
+		return 0
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for e := p.lru.Back(); e != nil; {
+		prev := e.Prev()
+		if now.After(e.Value.(*fakeIPEntry).expiresAt) {
+			p.remove(e)
+			removed++
+		}
+		e = prev
+	}
+	return
+}
+
+// evict drops expired entries from the tail and makes room for a new entry.
+func (p *fakeIPPool) evict(now time.Time) {
+	for e := p.lru.Back(); e != nil; e = p.lru.Back() {
+		if p.lru.Len() < p.size && !now.After(e.Value.(*fakeIPEntry).expiresAt) {
+			return
+		}
+		p.remove(e)
+	}
+}
+
+func (p *fakeIPPool) remove(e *list.Element) {
+	entry := e.Value.(*fakeIPEntry)
+	p.lru.Remove(e)
+	delete(p.byDomain, p.domainKey(entry.domain, entry.ip.Is6()))
+	delete(p.byIP, entry.ip)
+
+	if entry.ip.Is6() {
+		p.v6.release(entry.ip)
+	} else {
+		p.v4.release(entry.ip)
+	}
+}
+
+func (p *fakeIPPool) domainKey(domain string, ipv6 bool) string {
+	if ipv6 {
+		return domain + "/6"
+	}
+	return domain + "/4"
+}
@@ -0,0 +1,195 @@
+package tungo
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestFakeIPPool_LookupBidirectional(t *testing.T) {
+	p, err := newFakeIPPool([]netip.Prefix{netip.MustParsePrefix("198.18.0.0/15")}, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Unix(1000, 0)
+	ip, ok := p.Lookup(now, "Example.COM.", false)
+	if !ok {
+		t.Fatalf("expected allocation")
+	}
+	if ip != netip.MustParseAddr("198.18.0.2") {
+		t.Fatalf("unexpected first address %s", ip)
+	}
+	if !p.Contains(ip) {
+		t.Fatalf("expected %s in pool", ip)
+	}
+
+	if ip2, _ := p.Lookup(now, "example.com", false); ip2 != ip {
+		t.Fatalf("expected stable mapping, got %s and %s", ip, ip2)
+	}
+	if domain, ok := p.LookupIP(now, ip); !ok || domain != "example.com" {
+		t.Fatalf("expected example.com, got ok=%v domain=%q", ok, domain)
+	}
+
+	if _, ok := p.Lookup(now, "example.com", true); ok {
+		t.Fatalf("expected no IPv6 allocation without an IPv6 range")
+	}
+}
+
+func TestFakeIPPool_LRUEviction(t *testing.T) {
+	p, err := newFakeIPPool([]netip.Prefix{netip.MustParsePrefix("198.18.0.0/24")}, 2, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Unix(2000, 0)
+	ipA, _ := p.Lookup(now, "a.example", false)
+	ipB, _ := p.Lookup(now, "b.example", false)
+
+	// a.example becomes the most recently used entry.
+	p.LookupIP(now, ipA)
+
+	p.Lookup(now, "c.example", false)
+	if p.Len() != 2 {
+		t.Fatalf("expected 2 entries, got %d", p.Len())
+	}
+	if _, ok := p.LookupIP(now, ipB); ok {
+		t.Fatalf("expected b.example evicted")
+	}
+	if domain, ok := p.LookupIP(now, ipA); !ok || domain != "a.example" {
+		t.Fatalf("expected a.example kept, got ok=%v domain=%q", ok, domain)
+	}
+}
+
+func TestFakeIPPool_TTLExpire(t *testing.T) {
+	p, err := newFakeIPPool([]netip.Prefix{netip.MustParsePrefix("198.18.0.0/24")}, 0, 2*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Unix(3000, 0)
+	ip, _ := p.Lookup(now, "a.example", false)
+	p.Lookup(now.Add(time.Second), "b.example", false)
+
+	if removed := p.Cleanup(now.Add(2500 * time.Millisecond)); removed != 1 {
+		t.Fatalf("expected removed=1, got %d", removed)
+	}
+	if _, ok := p.LookupIP(now, ip); ok {
+		t.Fatalf("expected a.example expired")
+	}
+}
+
+func TestFakeIPPool_IPv6(t *testing.T) {
+	p, err := newFakeIPPool([]netip.Prefix{
+		netip.MustParsePrefix("198.18.0.0/15"),
+		netip.MustParsePrefix("fc00::/18"),
+	}, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Unix(4000, 0)
+	ip4, _ := p.Lookup(now, "example.com", false)
+	ip6, ok := p.Lookup(now, "example.com", true)
+	if !ok || !ip6.Is6() || ip6 != netip.MustParseAddr("fc00::2") {
+		t.Fatalf("unexpected IPv6 address %s", ip6)
+	}
+	if ip4 == ip6 {
+		t.Fatalf("expected distinct addresses per family")
+	}
+	if domain, _ := p.LookupIP(now, ip6); domain != "example.com" {
+		t.Fatalf("expected example.com, got %q", domain)
+	}
+}
+
+func TestFakeIPRange_Alloc(t *testing.T) {
+	r, err := newFakeIPRange(netip.MustParsePrefix("10.0.0.0/30"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 10.0.0.0/30: network .0, gateway .1, broadcast .3, only .2 is usable.
+	ip, ok := r.alloc()
+	if !ok || ip != netip.MustParseAddr("10.0.0.2") {
+		t.Fatalf("unexpected address %s", ip)
+	}
+	if ip, ok := r.alloc(); ok {
+		t.Fatalf("unexpected address %s of the used up range", ip)
+	}
+	r.release(ip)
+	if ip, ok := r.alloc(); !ok || ip != netip.MustParseAddr("10.0.0.2") {
+		t.Fatalf("expected the released address, got %s", ip)
+	}
+
+	if _, err := newFakeIPRange(netip.MustParsePrefix("10.0.0.0/31")); err == nil {
+		t.Fatalf("expected error for a too small range")
+	}
+}
+
+func TestFakeIPPool_Reuse(t *testing.T) {
+	// .2 to .6 are usable.
+	p, err := newFakeIPPool([]netip.Prefix{netip.MustParsePrefix("198.18.0.0/29")}, 0, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Unix(5000, 0)
+	ipA, _ := p.Lookup(now, "a.example", false)
+	for _, domain := range []string{"b.example", "c.example", "d.example", "e.example"} {
+		p.Lookup(now, domain, false)
+	}
+
+	// a.example is refreshed, b.example becomes the LRU entry.
+	p.LookupIP(now, ipA)
+
+	// the range is used up, the new entries take the addresses of the evicted ones.
+	for _, domain := range []string{"f.example", "g.example"} {
+		ip, ok := p.Lookup(now, domain, false)
+		if !ok || ip == ipA {
+			t.Fatalf("unexpected address %s for %s", ip, domain)
+		}
+	}
+	if domain, ok := p.LookupIP(now, ipA); !ok || domain != "a.example" {
+		t.Fatalf("expected a.example kept, got ok=%v domain=%q", ok, domain)
+	}
+	if p.Len() != 5 {
+		t.Fatalf("expected 5 entries, got %d", p.Len())
+	}
+}
+
+func TestFakeDNSAnswer(t *testing.T) {
+	p, err := newFakeIPPool([]netip.Prefix{netip.MustParsePrefix("198.18.0.0/15")}, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &transportHandler{fakeIP: p}
+
+	mq := &dns.Msg{}
+	mq.SetQuestion("example.com.", dns.TypeA)
+	mr := h.fakeDNSAnswer(context.Background(), mq)
+	if mr == nil || len(mr.Answer) != 1 {
+		t.Fatalf("expected one fake answer, got %v", mr)
+	}
+	a, ok := mr.Answer[0].(*dns.A)
+	if !ok {
+		t.Fatalf("expected A record, got %T", mr.Answer[0])
+	}
+	ip, _ := netip.AddrFromSlice(a.A)
+	if domain, fake := h.lookupFakeIP(ip.Unmap()); !fake || domain != "example.com" {
+		t.Fatalf("expected fake IP for example.com, got fake=%v domain=%q", fake, domain)
+	}
+
+	// no IPv6 range: empty answer so that clients fall back to A.
+	mq.SetQuestion("example.com.", dns.TypeAAAA)
+	if mr := h.fakeDNSAnswer(context.Background(), mq); mr == nil || len(mr.Answer) != 0 || mr.Rcode != dns.RcodeSuccess {
+		t.Fatalf("expected empty NOERROR answer, got %v", mr)
+	}
+
+	// other query types go to the real resolver.
+	mq.SetQuestion("example.com.", dns.TypeMX)
+	if mr := h.fakeDNSAnswer(context.Background(), mq); mr != nil {
+		t.Fatalf("expected nil answer for MX, got %v", mr)
+	}
+}
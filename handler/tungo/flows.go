@@ -0,0 +1,124 @@
+package tungo
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	ErrHandlerNotFound = errors.New("tungo: handler not found")
+)
+
+var (
+	// Global registry of running tungo handlers by service name.
+	handlers   = make(map[string]*tungoHandler)
+	handlersMu sync.RWMutex
+)
+
+func registerHandler(service string, h *tungoHandler) {
+	if service == "" || h == nil {
+		return
+	}
+	handlersMu.Lock()
+	handlers[service] = h
+	handlersMu.Unlock()
+}
+
+func unregisterHandler(service string, h *tungoHandler) {
+	if service == "" {
+		return
+	}
+	handlersMu.Lock()
+	if handlers[service] == h {
+		delete(handlers, service)
+	}
+	handlersMu.Unlock()
+}
+
+func getHandler(service string) *tungoHandler {
+	if service == "" {
+		return nil
+	}
+	handlersMu.RLock()
+	h := handlers[service]
+	handlersMu.RUnlock()
+	return h
+}
+
+// Services returns the names of the services running a tungo handler.
+func Services() []string {
+	handlersMu.RLock()
+	services := make([]string, 0, len(handlers))
+	for service := range handlers {
+		services = append(services, service)
+	}
+	handlersMu.RUnlock()
+
+	sort.Strings(services)
+	return services
+}
+
+// Flows returns the live flows of the tungo handler of service which match filter.
+func Flows(service string, filter *FlowFilter) ([]FlowState, error) {
+	h := getHandler(service)
+	if h == nil {
+		return nil, ErrHandlerNotFound
+	}
+
+	now := time.Now()
+	states := []FlowState{}
+	for _, th := range h.transportHandlers() {
+		states = append(states, th.conntrack.Flows(now, filter)...)
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].Age > states[j].Age
+	})
+
+	return states, nil
+}
+
+// CloseFlows closes the live flows of the tungo handler of service which match filter,
+// and returns the number of closed flows.
+func CloseFlows(service string, filter *FlowFilter) (int, error) {
+	h := getHandler(service)
+	if h == nil {
+		return 0, ErrHandlerNotFound
+	}
+
+	now := time.Now()
+	n := 0
+	for _, th := range h.transportHandlers() {
+		n += th.conntrack.CloseFlows(now, filter)
+	}
+	return n, nil
+}
+
+func (h *tungoHandler) addTransportHandler(th *transportHandler) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.transports == nil {
+		h.transports = make(map[*transportHandler]struct{})
+	}
+	h.transports[th] = struct{}{}
+}
+
+func (h *tungoHandler) removeTransportHandler(th *transportHandler) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.transports, th)
+}
+
+func (h *tungoHandler) transportHandlers() []*transportHandler {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	ths := make([]*transportHandler, 0, len(h.transports))
+	for th := range h.transports {
+		ths = append(ths, th)
+	}
+	return ths
+}
@@ -4,18 +4,22 @@ import (
 	"context"
 	"errors"
 	"net"
+	"net/netip"
+	"sync"
 	"time"
 
 	"github.com/go-gost/core/handler"
 	"github.com/go-gost/core/hop"
 	"github.com/go-gost/core/limiter"
 	"github.com/go-gost/core/limiter/traffic"
+	"github.com/go-gost/core/logger"
 	md "github.com/go-gost/core/metadata"
 	"github.com/go-gost/core/observer"
 	"github.com/go-gost/core/recorder"
 	xctx "github.com/go-gost/x/ctx"
 	tundec "github.com/go-gost/x/handler/tun"
 	ictx "github.com/go-gost/x/internal/ctx"
+	"github.com/go-gost/x/internal/util/pcap"
 	stats_util "github.com/go-gost/x/internal/util/stats"
 	tun_util "github.com/go-gost/x/internal/util/tun"
 	cache_limiter "github.com/go-gost/x/limiter/traffic/cache"
@@ -40,9 +44,20 @@ type tungoHandler struct {
 	recorder  recorder.RecorderObject
 	stack     *stack.Stack
 	forwarder hop.Hop
+	fakeIP    *fakeIPPool
+	sockOwner tundec.MetadataResolver
+
+	// transports are the transport handlers of the running TUN devices.
+	transports map[*transportHandler]struct{}
+	mu         sync.RWMutex
 
 	// udpSem limits concurrent UDP flow handling when non-nil.
 	udpSem chan struct{}
+
+	// classes counts the bytes of the flows per bandwidth class.
+	classes *bandwidthClasses
+	// tap is the capture point of the service.
+	tap *pcap.Tap
 }
 
 func NewHandler(opts ...handler.Option) handler.Handler {
@@ -64,6 +79,12 @@ func (h *tungoHandler) Init(md md.Metadata) (err error) {
 		h.udpSem = make(chan struct{}, h.md.udpMaxConcurrency)
 	}
 
+	if h.md.fakeIP {
+		if h.fakeIP, err = newFakeIPPool(h.md.fakeIPNets, h.md.fakeIPSize, h.md.fakeIPTTL); err != nil {
+			return
+		}
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	h.cancel = cancel
 
@@ -87,9 +108,62 @@ func (h *tungoHandler) Init(md md.Metadata) (err error) {
 		}
 	}
 
+	h.sockOwner = tundec.NewSockOwnerResolver(h.md.sockOwner)
+
+	h.classes = newBandwidthClasses()
+	if h.md.statsGUID != "" {
+		go h.classes.observe(ctx, h.md.statsGUID, h.md.statsInterval)
+	}
+
+	h.tap = pcap.GetTap(h.options.Service)
+	registerHandler(h.options.Service, h)
+
 	return
 }
 
+// ValidateMetadata checks the metadata only, Init also registers the handler of the service.
+func (h *tungoHandler) ValidateMetadata(md md.Metadata) (err error) {
+	if err = h.parseMetadata(md); err != nil {
+		return
+	}
+	if h.md.fakeIP {
+		_, err = newFakeIPPool(h.md.fakeIPNets, h.md.fakeIPSize, h.md.fakeIPTTL)
+	}
+	return
+}
+
+// newSplitDNS creates the split DNS of the transport handler th, the queries are answered
+// on the DNS addresses of the TUN device by default.
+func (h *tungoHandler) newSplitDNS(th *transportHandler, config *tun_util.Config, log logger.Logger) *splitDNS {
+	addrs := h.md.dnsAddrs
+	if len(addrs) == 0 && config != nil {
+		for _, ip := range config.DNS {
+			if addr, ok := netip.AddrFromSlice(ip); ok {
+				addrs = append(addrs, addr.Unmap())
+			}
+		}
+	}
+
+	hostMapper := h.md.dnsHosts
+	if hostMapper == nil && h.options.Router != nil {
+		hostMapper = h.options.Router.Options().HostMapper
+	}
+
+	opts := splitDNSOptions{
+		addrs:      addrs,
+		upstreams:  h.md.dnsUpstreams,
+		hostMapper: hostMapper,
+		ttl:        h.md.dnsTTL,
+		timeout:    h.md.dnsTimeout,
+		direct:     h.options.Router,
+		logger:     log,
+	}
+	if pr := th.getProxyRouter(); pr != nil {
+		opts.proxy = pr
+	}
+	return newSplitDNS(opts)
+}
+
 func (h *tungoHandler) Forward(forwarder hop.Hop) {
 	h.forwarder = forwarder
 	if h.options.Logger != nil {
@@ -104,9 +178,17 @@ func (h *tungoHandler) Handle(ctx context.Context, conn net.Conn, opts ...handle
 
 	var config *tun_util.Config
 	var dec tundec.DecisionEvaluator
+	var notifier tundec.RulesNotifier
 	if md := ictx.MetadataFromContext(ctx); md != nil {
 		config, _ = md.Get("config").(*tun_util.Config)
 		dec, _ = md.Get("decisionEvaluator").(tundec.DecisionEvaluator)
+		notifier, _ = md.Get("decisionRulesNotifier").(tundec.RulesNotifier)
+	}
+	if dec == nil && h.md.decision != "" {
+		dec = tundec.RegisteredDecisionEvaluator(h.md.decision)
+	}
+	if notifier == nil {
+		notifier, _ = dec.(tundec.RulesNotifier)
 	}
 	if config == nil {
 		err := errors.New("tun: wrong connection type")
@@ -133,8 +215,16 @@ func (h *tungoHandler) Handle(ctx context.Context, conn net.Conn, opts ...handle
 		dec:       dec,
 		forwarder: h.forwarder,
 		conntrack: newConntrackTable(),
+		sockOwner: h.sockOwner,
 		udpSem:    h.udpSem,
 
+		rulesNotifier:      notifier,
+		rulesCheckInterval: h.md.rulesCheckInterval,
+		reevaluateTeardown: h.md.reevaluateTeardown,
+
+		fakeIP:       h.fakeIP,
+		fakeIPBypass: h.md.fakeIPBypass,
+
 		conntrackCleanupInterval: 30 * time.Second,
 		udpConntrackTTL:          60 * time.Second,
 		tcpConntrackTTLShort:     60 * time.Second,
@@ -158,14 +248,29 @@ func (h *tungoHandler) Handle(ctx context.Context, conn net.Conn, opts ...handle
 
 		ipv6: h.md.ipv6,
 
+		icmpMode:    h.md.icmpMode,
+		icmpTimeout: h.md.icmpTimeout,
+		icmpSem:     make(chan struct{}, maxICMPInflight),
+
 		opts: &h.options,
 
-		statsGUID: h.md.statsGUID,
+		statsGUID:     h.md.statsGUID,
+		statsInterval: h.md.statsInterval,
+
+		bandwidthRules: h.md.bandwidthRules,
+		classes:        h.classes,
+	}
+
+	if h.md.dns {
+		th.dns = h.newSplitDNS(th, config, log)
 	}
 
 	th.ProcessAsync()
 	defer th.Close()
 
+	h.addTransportHandler(th)
+	defer h.removeTransportHandler(th)
+
 	var cOpts []option.Option
 	if h.md.tcpModerateReceiveBuffer {
 		cOpts = append(cOpts, option.WithTCPModerateReceiveBuffer(h.md.tcpModerateReceiveBuffer))
@@ -177,8 +282,16 @@ func (h *tungoHandler) Handle(ctx context.Context, conn net.Conn, opts ...handle
 		cOpts = append(cOpts, option.WithTCPReceiveBufferSize(h.md.tcpReceiveBufferSize))
 	}
 
+	ep := newEndpoint(conn, config.MTU, log)
+	ep.tap = h.tap
+	ep.annotate = th.annotateCapture
+	if th.icmpMode != icmpModeNone {
+		ep.inbound = th.handleICMP
+		th.icmpWrite = ep.WriteRaw
+	}
+
 	stack, err := core.CreateStack(&core.Config{
-		LinkEndpoint:     newEndpoint(conn, config.MTU, log),
+		LinkEndpoint:     ep,
 		TransportHandler: th,
 		MulticastGroups:  h.md.multicastGroups,
 		Options:          cOpts,
@@ -196,12 +309,15 @@ func (h *tungoHandler) Handle(ctx context.Context, conn net.Conn, opts ...handle
 
 // Close implements io.Closer interface.
 func (h *tungoHandler) Close() error {
+	unregisterHandler(h.options.Service, h)
+
 	if h.cancel != nil {
 		h.cancel()
 	}
 	if h.stack != nil {
 		h.stack.Close()
 	}
+	h.tap.Release()
 	return nil
 }
 
@@ -0,0 +1,401 @@
+package tungo
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/go-gost/core/logger"
+	xctx "github.com/go-gost/x/ctx"
+	xrecorder "github.com/go-gost/x/recorder"
+	"github.com/rs/xid"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// ICMP echo handling modes.
+const (
+	// icmpModeForward forwards the echo requests with unprivileged ICMP sockets,
+	// the requests are answered locally if such sockets are not available.
+	icmpModeForward = "forward"
+	// icmpModeLocal answers all echo requests locally.
+	icmpModeLocal = "local"
+	// icmpModeNone leaves the ICMP packets to the stack.
+	icmpModeNone = "none"
+)
+
+const (
+	defaultICMPTimeout = 5 * time.Second
+	// maxICMPInflight limits the concurrent echo requests, the others are dropped.
+	maxICMPInflight = 256
+
+	icmpHopLimit = 64
+)
+
+var (
+	errICMPTimeout     = errors.New("icmp: echo request timed out")
+	errICMPUnsupported = errors.New("icmp: unprivileged ICMP socket not available")
+)
+
+type icmpEcho struct {
+	src  netip.Addr
+	dst  netip.Addr
+	id   uint16
+	seq  uint16
+	data []byte
+	// packet is the original IP packet, quoted in the error messages.
+	packet []byte
+}
+
+func parseICMPMode(s string) string {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", icmpModeForward:
+		return icmpModeForward
+	case icmpModeLocal:
+		return icmpModeLocal
+	default:
+		return icmpModeNone
+	}
+}
+
+// handleICMP intercepts the ICMP echo requests read from the TUN device.
+// It reports whether the packet is consumed.
+func (h *transportHandler) handleICMP(b []byte) bool {
+	req, ok := parseICMPEcho(b)
+	if !ok {
+		return false
+	}
+
+	select {
+	case h.icmpSem <- struct{}{}:
+	default:
+		// too many requests in flight, drop it as a congested router would.
+		return true
+	}
+	go func() {
+		defer func() { <-h.icmpSem }()
+		h.handleICMPEcho(req)
+	}()
+
+	return true
+}
+
+func (h *transportHandler) handleICMPEcho(req *icmpEcho) {
+	start := time.Now()
+
+	sid := xid.New().String()
+	ctx := xctx.ContextWithSid(context.Background(), xctx.Sid(sid))
+
+	proto := flowProtoICMP
+	if req.src.Is6() {
+		proto = flowProtoICMPv6
+	}
+
+	ro := &xrecorder.HandlerRecorderObject{
+		Network:    proto.String(),
+		Service:    h.opts.Service,
+		RemoteAddr: req.src.String(),
+		DstAddr:    req.dst.String(),
+		ClientAddr: req.src.String(),
+		Host:       req.dst.String(),
+		SID:        sid,
+		Time:       start,
+		ICMP: &xrecorder.ICMPRecorderObject{
+			Type: "echo",
+			ID:   int(req.id),
+			Seq:  int(req.seq),
+			Size: len(req.data),
+		},
+	}
+
+	log := h.opts.Logger.WithFields(map[string]any{
+		"network": ro.Network,
+		"remote":  ro.RemoteAddr,
+		"dst":     ro.DstAddr,
+		"sid":     ro.SID,
+	})
+
+	var err error
+	defer func() {
+		if err != nil {
+			ro.Err = err.Error()
+		}
+		ro.Duration = time.Since(start)
+		if err := ro.Record(ctx, h.recorder.Recorder); err != nil {
+			log.Errorf("record: %v", err)
+		}
+	}()
+
+	key := flowKey{proto: proto, srcIP: req.src, dstIP: req.dst}
+	domain, fake := h.lookupFakeIP(req.dst)
+	if domain != "" {
+		ro.Host = domain
+	}
+
+	p := h.evaluatePolicy(key, "ICMP", domain, log)
+	ro.Decision = p.recorderObject(false)
+	if p.action == flowActionBlock {
+		log.Debugf("traffic blocked by decision: %s", req.dst)
+		err = errFlowBlocked
+		h.writeICMP(req.dst, req.src, icmpAdminProhibited(req), log)
+		return
+	}
+
+	// A fake IP does not exist and ICMP can not be sent through the proxy,
+	// such requests are answered locally.
+	reply := req.data
+	if h.icmpMode == icmpModeForward && !fake && !p.useProxy {
+		var rtt time.Duration
+		reply, rtt, err = h.forwardICMPEcho(req)
+		if err != nil {
+			if !errors.Is(err, errICMPUnsupported) {
+				log.Debugf("icmp: %s: %v", req.dst, err)
+				return
+			}
+			log.Debugf("%v, answer locally", err)
+			err = nil
+			reply = req.data
+			ro.ICMP.Local = true
+		} else {
+			ro.ICMP.RTT = rtt
+		}
+	} else {
+		ro.ICMP.Local = true
+	}
+
+	msg := &icmp.Message{
+		Type: ipv4.ICMPTypeEchoReply,
+		Body: &icmp.Echo{ID: int(req.id), Seq: int(req.seq), Data: reply},
+	}
+	if req.src.Is6() {
+		msg.Type = ipv6.ICMPTypeEchoReply
+	}
+	h.writeICMP(req.dst, req.src, msg, log)
+
+	log.Debugf("%s <> %s: icmp_seq=%d local=%t rtt=%s", req.src, req.dst, req.seq, ro.ICMP.Local, ro.ICMP.RTT)
+}
+
+// forwardICMPEcho sends the echo request to its destination with an unprivileged ICMP socket,
+// and waits for the reply.
+func (h *transportHandler) forwardICMPEcho(req *icmpEcho) (data []byte, rtt time.Duration, err error) {
+	network, address, proto := "udp4", "0.0.0.0", 1
+	typ := icmp.Type(ipv4.ICMPTypeEcho)
+	if req.dst.Is6() {
+		network, address, proto = "udp6", "::", 58
+		typ = ipv6.ICMPTypeEchoRequest
+	}
+
+	conn, err := icmp.ListenPacket(network, address)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: %v", errICMPUnsupported, err)
+	}
+	defer conn.Close()
+
+	b, err := (&icmp.Message{
+		Type: typ,
+		Body: &icmp.Echo{ID: int(req.id), Seq: int(req.seq), Data: req.data},
+	}).Marshal(nil)
+	if err != nil {
+		return
+	}
+
+	timeout := h.icmpTimeout
+	if timeout <= 0 {
+		timeout = defaultICMPTimeout
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	start := time.Now()
+	if _, err = conn.WriteTo(b, &net.UDPAddr{IP: req.dst.AsSlice()}); err != nil {
+		return
+	}
+
+	buf := make([]byte, 64*1024)
+	for {
+		n, _, er := conn.ReadFrom(buf)
+		if er != nil {
+			if ne, ok := er.(net.Error); ok && ne.Timeout() {
+				er = errICMPTimeout
+			}
+			return nil, 0, er
+		}
+		msg, er := icmp.ParseMessage(proto, buf[:n])
+		if er != nil {
+			continue
+		}
+		// the kernel rewrites the echo ID of unprivileged sockets, the replies are matched by sequence.
+		if echo, ok := msg.Body.(*icmp.Echo); ok &&
+			(msg.Type == ipv4.ICMPTypeEchoReply || msg.Type == ipv6.ICMPTypeEchoReply) &&
+			echo.Seq == int(req.seq) {
+			return echo.Data, time.Since(start), nil
+		}
+	}
+}
+
+func (h *transportHandler) writeICMP(src, dst netip.Addr, msg *icmp.Message, log logger.Logger) {
+	if h.icmpWrite == nil {
+		return
+	}
+	b, err := marshalICMPPacket(src, dst, msg)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	if err := h.icmpWrite(b); err != nil {
+		log.Debugf("icmp: write: %v", err)
+	}
+}
+
+// icmpAdminProhibited builds the destination unreachable message sent for a blocked echo request.
+func icmpAdminProhibited(req *icmpEcho) *icmp.Message {
+	if req.src.Is6() {
+		// as much of the invoking packet as possible without exceeding the minimum IPv6 MTU.
+		quote := req.packet
+		if n := 1280 - 40 - 8; len(quote) > n {
+			quote = quote[:n]
+		}
+		return &icmp.Message{
+			Type: ipv6.ICMPTypeDestinationUnreachable,
+			Code: 1, // communication with destination administratively prohibited
+			Body: &icmp.DstUnreach{Data: quote},
+		}
+	}
+
+	// the IP header and the first 8 bytes of the invoking datagram.
+	quote := req.packet
+	if n := int(quote[0]&0x0f)*4 + 8; len(quote) > n {
+		quote = quote[:n]
+	}
+	return &icmp.Message{
+		Type: ipv4.ICMPTypeDestinationUnreachable,
+		Code: 13, // communication administratively prohibited
+		Body: &icmp.DstUnreach{Data: quote},
+	}
+}
+
+// parseICMPEcho parses an IPv4 or IPv6 packet carrying an ICMP echo request.
+func parseICMPEcho(b []byte) (*icmpEcho, bool) {
+	if len(b) == 0 {
+		return nil, false
+	}
+
+	var proto int
+	var src, dst netip.Addr
+	var payload []byte
+
+	switch b[0] >> 4 {
+	case 4:
+		if len(b) < ipv4.HeaderLen {
+			return nil, false
+		}
+		hdrLen := int(b[0]&0x0f) * 4
+		total := int(binary.BigEndian.Uint16(b[2:4]))
+		if hdrLen < ipv4.HeaderLen || total < hdrLen || total > len(b) {
+			return nil, false
+		}
+		// fragments are reassembled by the stack.
+		if b[9] != 1 || binary.BigEndian.Uint16(b[6:8])&0x3fff != 0 {
+			return nil, false
+		}
+		src = netip.AddrFrom4([4]byte(b[12:16]))
+		dst = netip.AddrFrom4([4]byte(b[16:20]))
+		payload = b[hdrLen:total]
+		proto = 1
+		b = b[:total]
+	case 6:
+		if len(b) < ipv6.HeaderLen {
+			return nil, false
+		}
+		total := ipv6.HeaderLen + int(binary.BigEndian.Uint16(b[4:6]))
+		// packets with extension headers are left to the stack.
+		if b[6] != 58 || total > len(b) {
+			return nil, false
+		}
+		src = netip.AddrFrom16([16]byte(b[8:24]))
+		dst = netip.AddrFrom16([16]byte(b[24:40]))
+		payload = b[ipv6.HeaderLen:total]
+		proto = 58
+		b = b[:total]
+	default:
+		return nil, false
+	}
+
+	// link-local, multicast and broadcast destinations are left to the stack.
+	if !dst.IsGlobalUnicast() || dst == netip.AddrFrom4([4]byte{255, 255, 255, 255}) {
+		return nil, false
+	}
+
+	msg, err := icmp.ParseMessage(proto, payload)
+	if err != nil || (msg.Type != ipv4.ICMPTypeEcho && msg.Type != ipv6.ICMPTypeEchoRequest) {
+		return nil, false
+	}
+	echo, ok := msg.Body.(*icmp.Echo)
+	if !ok {
+		return nil, false
+	}
+
+	return &icmpEcho{
+		src:    src,
+		dst:    dst,
+		id:     uint16(echo.ID),
+		seq:    uint16(echo.Seq),
+		data:   echo.Data,
+		packet: b,
+	}, true
+}
+
+// marshalICMPPacket builds the IP packet carrying msg from src to dst.
+func marshalICMPPacket(src, dst netip.Addr, msg *icmp.Message) ([]byte, error) {
+	if src.Is4() {
+		body, err := msg.Marshal(nil)
+		if err != nil {
+			return nil, err
+		}
+		b := make([]byte, ipv4.HeaderLen+len(body))
+		b[0] = 4<<4 | ipv4.HeaderLen/4
+		binary.BigEndian.PutUint16(b[2:4], uint16(len(b)))
+		b[8] = icmpHopLimit
+		b[9] = 1
+		s, d := src.As4(), dst.As4()
+		copy(b[12:16], s[:])
+		copy(b[16:20], d[:])
+		binary.BigEndian.PutUint16(b[10:12], ipChecksum(b[:ipv4.HeaderLen]))
+		copy(b[ipv4.HeaderLen:], body)
+		return b, nil
+	}
+
+	body, err := msg.Marshal(icmp.IPv6PseudoHeader(src.AsSlice(), dst.AsSlice()))
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, ipv6.HeaderLen+len(body))
+	b[0] = 6 << 4
+	binary.BigEndian.PutUint16(b[4:6], uint16(len(body)))
+	b[6] = 58
+	b[7] = icmpHopLimit
+	s, d := src.As16(), dst.As16()
+	copy(b[8:24], s[:])
+	copy(b[24:40], d[:])
+	copy(b[ipv6.HeaderLen:], body)
+	return b, nil
+}
+
+func ipChecksum(b []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(b[i:]))
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
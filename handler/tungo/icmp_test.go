@@ -0,0 +1,138 @@
+package tungo
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/AeroCore-IO/avionics/pkg/decision"
+	"github.com/go-gost/core/handler"
+	xlogger "github.com/go-gost/x/logger"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+func TestHandleICMPEcho(t *testing.T) {
+	testCases := []struct {
+		name     string
+		src, dst string
+		action   decision.Action
+		typ      icmp.Type
+	}{
+		{name: "ipv4 local", src: "10.0.0.2", dst: "1.1.1.1", typ: ipv4.ICMPTypeEchoReply},
+		{name: "ipv6 local", src: "fd00::2", dst: "2001:db8::1", typ: ipv6.ICMPTypeEchoReply},
+		{name: "ipv4 blocked", src: "10.0.0.2", dst: "1.1.1.1", action: decision.ActionBlock, typ: ipv4.ICMPTypeDestinationUnreachable},
+		{name: "ipv6 blocked", src: "fd00::2", dst: "2001:db8::1", action: decision.ActionBlock, typ: ipv6.ICMPTypeDestinationUnreachable},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			src, dst := netip.MustParseAddr(tc.src), netip.MustParseAddr(tc.dst)
+
+			var written [][]byte
+			h := &transportHandler{
+				dec:       &testDecisionEvaluator{actions: map[string]decision.Action{}},
+				conntrack: newConntrackTable(),
+				icmpMode:  icmpModeLocal,
+				icmpSem:   make(chan struct{}, 1),
+				icmpWrite: func(b []byte) error {
+					written = append(written, b)
+					return nil
+				},
+				opts: &handler.Options{Logger: xlogger.Nop()},
+			}
+			if tc.action != "" {
+				h.dec.(*testDecisionEvaluator).actions[""] = tc.action
+			}
+
+			typ := icmp.Type(ipv4.ICMPTypeEcho)
+			if src.Is6() {
+				typ = ipv6.ICMPTypeEchoRequest
+			}
+			pkt, err := marshalICMPPacket(src, dst, &icmp.Message{
+				Type: typ,
+				Body: &icmp.Echo{ID: 7, Seq: 42, Data: []byte("ping")},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req, ok := parseICMPEcho(pkt)
+			if !ok {
+				t.Fatal("echo request not parsed")
+			}
+			if req.src != src || req.dst != dst || req.id != 7 || req.seq != 42 {
+				t.Fatalf("unexpected echo request %+v", req)
+			}
+
+			h.handleICMPEcho(req)
+			if len(written) != 1 {
+				t.Fatalf("expected 1 reply, got %d", len(written))
+			}
+
+			reply := written[0]
+			proto, payload := 1, reply[ipv4.HeaderLen:]
+			if src.Is6() {
+				proto, payload = 58, reply[ipv6.HeaderLen:]
+				if got := netip.AddrFrom16([16]byte(reply[24:40])); got != src {
+					t.Fatalf("reply sent to %s", got)
+				}
+			} else {
+				if ipChecksum(reply[:ipv4.HeaderLen]) != 0 {
+					t.Fatal("invalid IPv4 header checksum")
+				}
+				if got := netip.AddrFrom4([4]byte(reply[16:20])); got != src {
+					t.Fatalf("reply sent to %s", got)
+				}
+			}
+
+			msg, err := icmp.ParseMessage(proto, payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if msg.Type != tc.typ {
+				t.Fatalf("expected %v, got %v", tc.typ, msg.Type)
+			}
+			if echo, ok := msg.Body.(*icmp.Echo); ok {
+				if echo.ID != 7 || echo.Seq != 42 || string(echo.Data) != "ping" {
+					t.Fatalf("unexpected echo reply %+v", echo)
+				}
+			}
+		})
+	}
+}
+
+func TestParseICMPEcho_Ignored(t *testing.T) {
+	// multicast destinations and other ICMP messages are left to the stack.
+	for _, pkt := range [][2]string{
+		{"10.0.0.2", "224.0.0.1"},
+		{"fe80::1", "fe80::2"},
+	} {
+		src, dst := netip.MustParseAddr(pkt[0]), netip.MustParseAddr(pkt[1])
+		typ := icmp.Type(ipv4.ICMPTypeEcho)
+		if src.Is6() {
+			typ = ipv6.ICMPTypeEchoRequest
+		}
+		b, err := marshalICMPPacket(src, dst, &icmp.Message{
+			Type: typ,
+			Body: &icmp.Echo{ID: 1, Seq: 1},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := parseICMPEcho(b); ok {
+			t.Fatalf("%s -> %s: echo request should be ignored", pkt[0], pkt[1])
+		}
+	}
+
+	b, err := marshalICMPPacket(netip.MustParseAddr("10.0.0.2"), netip.MustParseAddr("1.1.1.1"), &icmp.Message{
+		Type: ipv4.ICMPTypeEchoReply,
+		Body: &icmp.Echo{ID: 1, Seq: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := parseICMPEcho(b); ok {
+		t.Fatal("echo reply should be ignored")
+	}
+}
@@ -2,16 +2,21 @@ package tungo
 
 import (
 	"fmt"
+	"net"
 	"net/netip"
 	"strings"
 	"time"
 
+	"github.com/go-gost/core/bypass"
+	"github.com/go-gost/core/hosts"
 	mdata "github.com/go-gost/core/metadata"
 	mdutil "github.com/go-gost/x/metadata/util"
+	"github.com/go-gost/x/registry"
 )
 
 const (
-	defaultBufferSize = 4096
+	defaultBufferSize    = 4096
+	defaultStatsInterval = 5 * time.Second
 )
 
 type metadata struct {
@@ -28,6 +33,42 @@ type metadata struct {
 	// to avoid requiring DNS resolution on the proxy/server side.
 	proxyDialByDomain bool
 
+	// fakeIP enables the FakeIP mode: DNS A/AAAA queries on the TUN are answered
+	// with addresses from fakeIPNets, and flows to these addresses are dialed by domain.
+	// The gost process itself must not resolve names through the TUN in this mode.
+	fakeIP       bool
+	fakeIPNets   []netip.Prefix
+	fakeIPSize   int
+	fakeIPTTL    time.Duration
+	fakeIPBypass bypass.Bypass
+
+	// dns enables the split DNS: the queries to dnsAddrs (all the addresses if empty) are answered
+	// from the hosts and the upstream of the longest matching domain suffix, the names of the
+	// answers are used as the hostnames of the flows.
+	dns          bool
+	dnsAddrs     []netip.Addr
+	dnsUpstreams []dnsUpstreamConfig
+	dnsHosts     hosts.HostMapper
+	dnsTTL       time.Duration
+	dnsTimeout   time.Duration
+
+	// bandwidthRules assign the flows to the bandwidth classes the decision evaluator does not assign.
+	bandwidthRules []bandwidthRule
+
+	// decision is the name of the registered DecisionEvaluator used when
+	// no evaluator is provided by the listener.
+	decision string
+
+	// sockOwner is the appID mode of the socket owner resolver, exe or cgroup.
+	// The resolver is disabled by default.
+	sockOwner string
+
+	// rulesCheckInterval is the period of the rules version checks of the decision evaluator.
+	rulesCheckInterval time.Duration
+	// reevaluateTeardown closes the live flows whose proxy/direct choice changed
+	// when the decision rules are re-evaluated. Blocked flows are always closed.
+	reevaluateTeardown bool
+
 	sniffing                bool
 	sniffingUDP             bool
 	sniffingTimeout         time.Duration
@@ -44,12 +85,19 @@ type metadata struct {
 
 	ipv6 bool
 
+	// icmpMode is the handling of the ICMP echo requests: forward (default) with unprivileged
+	// ICMP sockets, local to answer them locally, or none to leave them to the stack.
+	icmpMode    string
+	icmpTimeout time.Duration
+
 	tcpSendBufferSize        int
 	tcpReceiveBufferSize     int
 	tcpModerateReceiveBuffer bool
 
 	// statsGUID is the GUID used to look up the TrafficStatsReporter for UDP traffic metering.
 	statsGUID string
+	// statsInterval is the period of the TCP flow byte count reports to a FlowStatsReporter.
+	statsInterval time.Duration
 }
 
 func (h *tungoHandler) parseMetadata(md mdata.Metadata) (err error) {
@@ -73,6 +121,68 @@ func (h *tungoHandler) parseMetadata(md mdata.Metadata) (err error) {
 		"tungo.proxyDialByDomain",
 	)
 
+	h.md.fakeIP = mdutil.GetBool(md, "fakeip", "tungo.fakeip")
+	if h.md.fakeIP {
+		cidr := mdutil.GetString(md, "fakeip.cidr", "tungo.fakeip.cidr")
+		if cidr == "" {
+			cidr = defaultFakeIPCIDR
+		}
+		if v := mdutil.GetString(md, "fakeip.cidr6", "tungo.fakeip.cidr6"); v != "" {
+			cidr += "," + v
+		}
+		for _, v := range strings.Split(cidr, ",") {
+			if v = strings.TrimSpace(v); v == "" {
+				continue
+			}
+			prefix, err := netip.ParsePrefix(v)
+			if err != nil {
+				return err
+			}
+			h.md.fakeIPNets = append(h.md.fakeIPNets, prefix)
+		}
+		h.md.fakeIPSize = mdutil.GetInt(md, "fakeip.size", "tungo.fakeip.size")
+		h.md.fakeIPTTL = mdutil.GetDuration(md, "fakeip.ttl", "tungo.fakeip.ttl")
+		h.md.fakeIPBypass = registry.BypassRegistry().Get(mdutil.GetString(md, "fakeip.bypass", "tungo.fakeip.bypass"))
+	}
+
+	h.md.dns = mdutil.GetBool(md, "dns", "tungo.dns")
+	if h.md.dns {
+		for _, v := range strings.Split(mdutil.GetString(md, "dns.addr", "tungo.dns.addr"), ",") {
+			if v = strings.TrimSpace(v); v == "" {
+				continue
+			}
+			if host, _, err := net.SplitHostPort(v); err == nil {
+				v = host
+			}
+			addr, err := netip.ParseAddr(v)
+			if err != nil {
+				return err
+			}
+			h.md.dnsAddrs = append(h.md.dnsAddrs, addr.Unmap())
+		}
+		for _, v := range mdutil.GetStrings(md, "dns.upstreams", "tungo.dns.upstreams") {
+			cfg, err := parseDNSUpstream(v)
+			if err != nil {
+				return err
+			}
+			h.md.dnsUpstreams = append(h.md.dnsUpstreams, cfg)
+		}
+		if v := mdutil.GetString(md, "dns.resolver", "tungo.dns.resolver"); v != "" {
+			h.md.dnsUpstreams = append(h.md.dnsUpstreams, dnsUpstreamConfig{resolver: v})
+		}
+		if v := mdutil.GetString(md, "dns.hosts", "tungo.dns.hosts"); v != "" {
+			h.md.dnsHosts = registry.HostsRegistry().Get(v)
+		}
+		h.md.dnsTTL = mdutil.GetDuration(md, "dns.ttl", "tungo.dns.ttl")
+		h.md.dnsTimeout = mdutil.GetDuration(md, "dns.timeout", "tungo.dns.timeout")
+	}
+
+	h.md.decision = mdutil.GetString(md, "decision", "tungo.decision")
+	h.md.sockOwner = mdutil.GetString(md, "sockowner", "tungo.sockowner")
+
+	h.md.rulesCheckInterval = mdutil.GetDuration(md, "decision.checkInterval", "tungo.decision.checkInterval")
+	h.md.reevaluateTeardown = mdutil.GetBool(md, "decision.teardown", "tungo.decision.teardown")
+
 	h.md.sniffing = mdutil.GetBool(md, "sniffing")
 	h.md.sniffingUDP = mdutil.GetBool(md, "sniffing.udp")
 	h.md.sniffingTimeout = mdutil.GetDuration(md, "sniffing.timeout")
@@ -107,11 +217,26 @@ func (h *tungoHandler) parseMetadata(md mdata.Metadata) (err error) {
 
 	h.md.ipv6 = mdutil.GetBool(md, "ipv6")
 
+	h.md.icmpMode = parseICMPMode(mdutil.GetString(md, "icmp", "tungo.icmp"))
+	h.md.icmpTimeout = mdutil.GetDuration(md, "icmp.timeout", "tungo.icmp.timeout")
+
 	h.md.tcpSendBufferSize = mdutil.GetInt(md, "tcpSendBufferSize", "tungo.tcpSendBufferSize")
 	h.md.tcpReceiveBufferSize = mdutil.GetInt(md, "tcpReceiveBufferSize", "tungo.tcpReceiveBufferSize")
 	h.md.tcpModerateReceiveBuffer = mdutil.GetBool(md, "tcpModerateReceiveBuffer", "tungo.tcpModerateReceiveBuffer")
 
+	for _, v := range mdutil.GetStrings(md, "bandwidth.rules", "tungo.bandwidth.rules") {
+		r, err := parseBandwidthRule(v)
+		if err != nil {
+			return err
+		}
+		h.md.bandwidthRules = append(h.md.bandwidthRules, r)
+	}
+
 	h.md.statsGUID = mdutil.GetString(md, "statsGUID", "tungo.statsGUID", "stats.guid")
+	h.md.statsInterval = mdutil.GetDuration(md, "statsInterval", "tungo.statsInterval", "stats.interval")
+	if h.md.statsInterval <= 0 {
+		h.md.statsInterval = defaultStatsInterval
+	}
 
 	return
 }
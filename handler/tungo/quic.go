@@ -0,0 +1,72 @@
+package tungo
+
+import (
+	"encoding/hex"
+	"net"
+	"time"
+
+	"github.com/go-gost/core/logger"
+	"github.com/go-gost/x/internal/util/sniffing"
+	xrecorder "github.com/go-gost/x/recorder"
+)
+
+const (
+	// defaultQUICSniffingTimeout bounds the wait for the Initial packets when sniffing.timeout is not set.
+	defaultQUICSniffingTimeout = 200 * time.Millisecond
+	// maxQUICSniffingPackets is the maximum number of datagrams held back while sniffing.
+	maxQUICSniffingPackets = 8
+)
+
+// sniffQUIC reads the first datagrams of a UDP flow until the ClientHello carried by
+// the QUIC Initial packets is complete. The datagrams read are returned and must be
+// forwarded once the flow is dialed. The SNI is returned as hostname.
+func (h *transportHandler) sniffQUIC(conn net.Conn, ro *xrecorder.HandlerRecorderObject, log logger.Logger) (hostname string, pending [][]byte) {
+	timeout := h.sniffingTimeout
+	if timeout <= 0 {
+		timeout = defaultQUICSniffingTimeout
+	}
+	bufferSize := h.udpBufferSize
+	if bufferSize <= 0 {
+		bufferSize = defaultBufferSize
+	}
+
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	var sniffer sniffing.QUICSniffer
+	for i := 0; i < maxQUICSniffingPackets; i++ {
+		b := make([]byte, bufferSize)
+		n, err := conn.Read(b)
+		if err != nil {
+			if len(pending) > 0 {
+				log.Debugf("quic sniffing: %v", err)
+			}
+			return
+		}
+		pending = append(pending, b[:n])
+
+		clientHello, err := sniffer.Sniff(b[:n])
+		if err != nil {
+			if err != sniffing.ErrNotQUICInitial {
+				log.Debugf("quic sniffing: %v", err)
+			}
+			return
+		}
+		ro.Proto = sniffing.ProtoQUIC
+		if clientHello == nil {
+			continue
+		}
+
+		ro.TLS = &xrecorder.TLSRecorderObject{
+			ServerName:  clientHello.ServerName,
+			ClientHello: hex.EncodeToString(sniffer.ClientHello()),
+		}
+		if len(clientHello.SupportedProtos) > 0 {
+			ro.TLS.Proto = clientHello.SupportedProtos[0]
+		}
+		log.Debugf("quic sniffing: sni=%s alpn=%v", clientHello.ServerName, clientHello.SupportedProtos)
+
+		return clientHello.ServerName, pending
+	}
+	return
+}
@@ -0,0 +1,107 @@
+package tungo
+
+import (
+	"context"
+	"time"
+
+	tundec "github.com/go-gost/x/handler/tun"
+)
+
+const (
+	defaultRulesCheckInterval = time.Second
+)
+
+// watchRules re-evaluates the flow policies each time the rules of the decision evaluator change.
+// Changes are detected through the rules notifier, or by polling the rules version
+// if the evaluator implements RulesVersioner.
+func (h *transportHandler) watchRules(ctx context.Context) {
+	versioner, _ := any(h.dec).(tundec.RulesVersioner)
+	if h.dec == nil || (versioner == nil && h.rulesNotifier == nil) {
+		return
+	}
+
+	var version uint64
+	var tick <-chan time.Time
+	if versioner != nil {
+		version = versioner.RulesVersion()
+
+		interval := h.rulesCheckInterval
+		if interval <= 0 {
+			interval = defaultRulesCheckInterval
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		var notify <-chan struct{}
+		if h.rulesNotifier != nil {
+			notify = h.rulesNotifier.RulesChanged()
+		}
+
+		select {
+		case <-notify:
+		case <-tick:
+			if versioner.RulesVersion() == version {
+				continue
+			}
+		case <-ctx.Done():
+			return
+		}
+
+		if versioner != nil {
+			version = versioner.RulesVersion()
+		}
+
+		n, closed := h.reevaluateFlows()
+		h.opts.Logger.Infof("decision rules changed: %d flows re-evaluated, %d closed", n, closed)
+	}
+}
+
+// reevaluateFlows flushes the cached policies and runs the decision evaluator again for the live flows.
+// Flows that are now blocked are closed, as well as the flows whose proxy/direct choice changed
+// if reevaluateTeardown is set. The new policies of the remaining flows are cached.
+func (h *transportHandler) reevaluateFlows() (n int, closed int) {
+	h.conntrack.Flush()
+
+	log := h.opts.Logger
+
+	for _, f := range h.conntrack.trackedFlows() {
+		proto, old, ok := f.Policy()
+		if !ok {
+			// not decided yet, it will be evaluated with the new rules.
+			continue
+		}
+		n++
+
+		hostname := old.hostname
+		if hostname == "" {
+			hostname = old.proxyHost
+		}
+		p := h.evaluatePolicy(f.key, proto, hostname, log)
+		if p.action == flowActionBlock ||
+			(h.reevaluateTeardown && p.useProxy != old.useProxy) {
+			log.Debugf("flow %s closed by decision: action %s -> %s", f.id, old.action, p.action)
+			f.Close()
+			closed++
+			continue
+		}
+
+		f.SetPolicy(proto, p)
+
+		ttl := h.tcpConntrackTTLLong
+		if ttl <= 0 {
+			ttl = 5 * time.Minute
+		}
+		if f.key.proto == flowProtoUDP {
+			ttl = h.udpConntrackTTL
+			if ttl <= 0 {
+				ttl = 60 * time.Second
+			}
+		}
+		h.putCachedPolicy(time.Now(), f.key, p, ttl)
+	}
+
+	return
+}
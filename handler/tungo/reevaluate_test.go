@@ -0,0 +1,91 @@
+package tungo
+
+import (
+	"net/netip"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/AeroCore-IO/avionics/pkg/decision"
+	"github.com/go-gost/core/handler"
+	xlogger "github.com/go-gost/x/logger"
+)
+
+type testDecisionEvaluator struct {
+	actions map[string]decision.Action
+	version atomic.Uint64
+}
+
+func (e *testDecisionEvaluator) CheckTrafficRules(input decision.RuleInput) *decision.TrafficDecision {
+	action, ok := e.actions[input.DestDomain]
+	if !ok {
+		return nil
+	}
+	return &decision.TrafficDecision{Action: action}
+}
+
+func (e *testDecisionEvaluator) ResolveMetadata(srcIP, dstIP string, srcPort, dstPort int, proto string) (string, string) {
+	return "", ""
+}
+
+func (e *testDecisionEvaluator) RulesVersion() uint64 {
+	return e.version.Load()
+}
+
+func TestReevaluateFlows(t *testing.T) {
+	dec := &testDecisionEvaluator{
+		actions: map[string]decision.Action{
+			"a.example": decision.ActionProxy,
+			"b.example": decision.ActionProxy,
+			"c.example": decision.ActionProxy,
+		},
+	}
+	h := &transportHandler{
+		dec:       dec,
+		conntrack: newConntrackTable(),
+		opts:      &handler.Options{Logger: xlogger.Nop()},
+	}
+
+	now := time.Now()
+	flows := map[string]*testCloser{}
+	for i, host := range []string{"a.example", "b.example", "c.example"} {
+		k := flowKey{
+			proto:   flowProtoTCP,
+			srcIP:   netip.MustParseAddr("10.0.0.2"),
+			dstIP:   netip.AddrFrom4([4]byte{1, 1, 1, byte(i + 1)}),
+			srcPort: uint16(10000 + i),
+			dstPort: 443,
+		}
+		p := h.evaluatePolicy(k, "TCP", host, h.opts.Logger)
+		h.putCachedPolicy(now, k, p, time.Minute)
+
+		c := &testCloser{}
+		f := newTrackedFlow(host, k, nil, c)
+		f.SetPolicy("TCP", p)
+		h.conntrack.Track(f)
+		flows[host] = c
+	}
+
+	dec.actions["a.example"] = decision.ActionBlock
+	dec.actions["b.example"] = decision.ActionDirect
+
+	n, closed := h.reevaluateFlows()
+	if n != 3 || closed != 1 {
+		t.Fatalf("expected 3 flows re-evaluated and 1 closed, got %d and %d", n, closed)
+	}
+	if flows["a.example"].closed != 1 || flows["b.example"].closed != 0 {
+		t.Fatalf("expected only the blocked flow closed")
+	}
+
+	states := h.conntrack.Flows(now, &FlowFilter{Hostname: "b.example"})
+	if len(states) != 1 || states[0].UseProxy || states[0].Action != flowActionDirect {
+		t.Fatalf("expected b.example switched to direct, got %+v", states)
+	}
+
+	// proxy/direct changes tear down the flows when enabled.
+	h.reevaluateTeardown = true
+	dec.actions["c.example"] = decision.ActionDirect
+	if _, closed := h.reevaluateFlows(); closed != 1 || flows["c.example"].closed != 1 {
+		t.Fatalf("expected c.example closed, got %d", closed)
+	}
+}
@@ -0,0 +1,374 @@
+package tungo
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-gost/core/chain"
+	"github.com/go-gost/core/hosts"
+	"github.com/go-gost/core/logger"
+	"github.com/go-gost/core/resolver"
+	ictx "github.com/go-gost/x/internal/ctx"
+	resolver_util "github.com/go-gost/x/internal/util/resolver"
+	xrecorder "github.com/go-gost/x/recorder"
+	"github.com/go-gost/x/registry"
+	"github.com/go-gost/x/resolver/exchanger"
+	"github.com/miekg/dns"
+)
+
+const (
+	// defaultDNSAnswerTTL is the TTL of the answers built from the hosts and the resolvers.
+	defaultDNSAnswerTTL = 60 * time.Second
+	// minDNSNameTTL is the minimum time an address is attributed to the queried name.
+	minDNSNameTTL = 60 * time.Second
+)
+
+// dnsUpstreamConfig is an upstream of the split DNS, in the form of
+//
+//	<suffix> <upstream> [proxy]
+//
+// The suffix "." matches all names. The upstream is a nameserver URL (udp://, tcp://, tls://, https://)
+// or the name of a registered resolver. The nameserver is queried through the proxy if proxy is set.
+type dnsUpstreamConfig struct {
+	suffix   string
+	addr     string
+	resolver string
+	proxy    bool
+}
+
+func parseDNSUpstream(s string) (cfg dnsUpstreamConfig, err error) {
+	fields := strings.Fields(s)
+	if len(fields) < 2 || len(fields) > 3 {
+		return cfg, fmt.Errorf("invalid DNS upstream %q", s)
+	}
+
+	cfg.suffix = normalizeDNSName(fields[0])
+	if strings.Contains(fields[1], "://") {
+		cfg.addr = fields[1]
+	} else {
+		cfg.resolver = fields[1]
+	}
+	if len(fields) == 3 {
+		switch strings.ToLower(fields[2]) {
+		case "proxy":
+			cfg.proxy = true
+		case "direct":
+		default:
+			return cfg, fmt.Errorf("invalid DNS upstream route %q", fields[2])
+		}
+	}
+	return
+}
+
+func normalizeDNSName(s string) string {
+	return strings.Trim(strings.ToLower(strings.TrimSpace(s)), ".")
+}
+
+type dnsUpstream struct {
+	suffix   string
+	ex       exchanger.Exchanger
+	resolver resolver.Resolver
+	name     string
+}
+
+// match reports whether the normalized name is in the domain of the upstream.
+func (u *dnsUpstream) match(name string) bool {
+	return u.suffix == "" || name == u.suffix || strings.HasSuffix(name, "."+u.suffix)
+}
+
+// splitDNS answers the DNS queries sent to the TUN DNS addresses from the hosts
+// and the upstream of the longest matching domain suffix.
+type splitDNS struct {
+	addrs      []netip.Addr
+	upstreams  []*dnsUpstream
+	hostMapper hosts.HostMapper
+	cache      *resolver_util.Cache
+	ttl        time.Duration
+	names      *dnsNameCache
+}
+
+type splitDNSOptions struct {
+	addrs      []netip.Addr
+	upstreams  []dnsUpstreamConfig
+	hostMapper hosts.HostMapper
+	ttl        time.Duration
+	timeout    time.Duration
+	// direct and proxy are the routers the nameservers are queried through.
+	direct chain.Router
+	proxy  chain.Router
+	logger logger.Logger
+}
+
+func newSplitDNS(opts splitDNSOptions) *splitDNS {
+	d := &splitDNS{
+		addrs:      opts.addrs,
+		hostMapper: opts.hostMapper,
+		cache:      resolver_util.NewCache().WithLogger(opts.logger),
+		ttl:        opts.ttl,
+		names:      newDNSNameCache(),
+	}
+
+	for _, cfg := range opts.upstreams {
+		u := &dnsUpstream{
+			suffix: cfg.suffix,
+		}
+		if cfg.addr != "" {
+			router := opts.direct
+			if cfg.proxy && opts.proxy != nil {
+				router = opts.proxy
+			}
+			ex, err := exchanger.NewExchanger(
+				cfg.addr,
+				exchanger.RouterOption(router),
+				exchanger.TimeoutOption(opts.timeout),
+				exchanger.LoggerOption(opts.logger),
+			)
+			if err != nil {
+				opts.logger.Warnf("dns: parse %s: %v", cfg.addr, err)
+				continue
+			}
+			u.ex = ex
+			u.name = ex.String()
+		} else {
+			u.resolver = registry.ResolverRegistry().Get(cfg.resolver)
+			u.name = cfg.resolver
+		}
+		d.upstreams = append(d.upstreams, u)
+	}
+	// the longest suffix is matched first.
+	sort.SliceStable(d.upstreams, func(i, j int) bool {
+		return len(d.upstreams[i].suffix) > len(d.upstreams[j].suffix)
+	})
+
+	return d
+}
+
+// intercepts reports whether the DNS queries sent to addr are answered.
+func (d *splitDNS) intercepts(addr netip.AddrPort) bool {
+	if d == nil || addr.Port() != 53 {
+		return false
+	}
+	if len(d.addrs) == 0 {
+		return true
+	}
+	ip := addr.Addr().Unmap()
+	for _, v := range d.addrs {
+		if v == ip {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *splitDNS) upstream(name string) *dnsUpstream {
+	for _, u := range d.upstreams {
+		if u.match(name) {
+			return u
+		}
+	}
+	return nil
+}
+
+// answer answers the query mq. It returns nil if the query should be relayed to the original nameserver.
+func (d *splitDNS) answer(ctx context.Context, mq *dns.Msg, ro *xrecorder.HandlerRecorderObject, log logger.Logger) (*dns.Msg, error) {
+	if mq.Response || mq.Opcode != dns.OpcodeQuery || len(mq.Question) != 1 {
+		return nil, nil
+	}
+
+	q := mq.Question[0]
+	name := normalizeDNSName(q.Name)
+
+	if mr := d.lookupHosts(ctx, mq, name, log); mr != nil {
+		d.learn(name, mr)
+		return mr, nil
+	}
+
+	u := d.upstream(name)
+	if u == nil {
+		return nil, nil
+	}
+
+	key := resolver_util.NewCacheKey(&q)
+	if mr, ttl := d.cache.Load(ctx, key); mr != nil && int32(ttl.Seconds()) > 0 {
+		mr.Id = mq.Id
+		if ro.DNS != nil {
+			ro.DNS.Cached = true
+		}
+		log.Debugf("dns: message %d (cached): %s", mq.Id, q.String())
+		return mr, nil
+	}
+
+	var mr *dns.Msg
+	if u.ex != nil {
+		query, err := mq.Pack()
+		if err != nil {
+			return nil, err
+		}
+
+		var buf bytes.Buffer
+		reply, err := u.ex.Exchange(ictx.ContextWithBuffer(ctx, &buf), query)
+		ro.Route = buf.String()
+		if err != nil {
+			return nil, err
+		}
+		mr = &dns.Msg{}
+		if err := mr.Unpack(reply); err != nil {
+			return nil, err
+		}
+	} else {
+		if q.Qclass != dns.ClassINET || (q.Qtype != dns.TypeA && q.Qtype != dns.TypeAAAA) {
+			// a resolver only resolves addresses.
+			return nil, nil
+		}
+		network := "ip4"
+		if q.Qtype == dns.TypeAAAA {
+			network = "ip6"
+		}
+		ips, err := u.resolver.Resolve(ctx, network, name)
+		if err != nil {
+			return nil, err
+		}
+		mr = addressAnswer(mq, ips, defaultDNSAnswerTTL)
+	}
+	ro.Host = u.name
+	log.Debugf("dns: %s %s via %s", dns.Type(q.Qtype), name, u.name)
+
+	d.cache.Store(ctx, key, mr, d.ttl)
+	d.learn(name, mr)
+
+	return mr, nil
+}
+
+func (d *splitDNS) lookupHosts(ctx context.Context, mq *dns.Msg, name string, log logger.Logger) *dns.Msg {
+	q := mq.Question[0]
+	if d.hostMapper == nil || q.Qclass != dns.ClassINET ||
+		(q.Qtype != dns.TypeA && q.Qtype != dns.TypeAAAA) {
+		return nil
+	}
+
+	network := "ip4"
+	if q.Qtype == dns.TypeAAAA {
+		network = "ip6"
+	}
+	ips, _ := d.hostMapper.Lookup(ctx, network, name)
+	if len(ips) == 0 {
+		return nil
+	}
+	log.Debugf("dns: hit host mapper: %s -> %s", name, ips)
+
+	return addressAnswer(mq, ips, defaultDNSAnswerTTL)
+}
+
+// learn attributes the addresses of the answer to the queried name.
+func (d *splitDNS) learn(name string, mr *dns.Msg) {
+	now := time.Now()
+	for _, rr := range mr.Answer {
+		ttl := time.Duration(rr.Header().Ttl) * time.Second
+		var ip net.IP
+		switch v := rr.(type) {
+		case *dns.A:
+			ip = v.A
+		case *dns.AAAA:
+			ip = v.AAAA
+		default:
+			continue
+		}
+		if addr, ok := netip.AddrFromSlice(ip); ok {
+			d.names.Add(now, addr.Unmap(), name, ttl)
+		}
+	}
+}
+
+// addressAnswer builds the reply to the A/AAAA query mq with ips.
+func addressAnswer(mq *dns.Msg, ips []net.IP, ttl time.Duration) *dns.Msg {
+	q := mq.Question[0]
+
+	mr := &dns.Msg{}
+	mr.SetReply(mq)
+	mr.RecursionAvailable = true
+
+	hdr := dns.RR_Header{
+		Name:   q.Name,
+		Rrtype: q.Qtype,
+		Class:  dns.ClassINET,
+		Ttl:    uint32(ttl.Seconds()),
+	}
+	for _, ip := range ips {
+		if q.Qtype == dns.TypeAAAA {
+			if ip.To4() == nil && ip.To16() != nil {
+				mr.Answer = append(mr.Answer, &dns.AAAA{Hdr: hdr, AAAA: ip})
+			}
+		} else if ip4 := ip.To4(); ip4 != nil {
+			mr.Answer = append(mr.Answer, &dns.A{Hdr: hdr, A: ip4})
+		}
+	}
+	return mr
+}
+
+type dnsNameEntry struct {
+	name    string
+	expires time.Time
+}
+
+// dnsNameCache maps the addresses answered by the split DNS to the queried names,
+// the names are used as the hostnames of the flows to these addresses.
+type dnsNameCache struct {
+	mu sync.RWMutex
+	m  map[netip.Addr]dnsNameEntry
+}
+
+func newDNSNameCache() *dnsNameCache {
+	return &dnsNameCache{
+		m: make(map[netip.Addr]dnsNameEntry),
+	}
+}
+
+func (c *dnsNameCache) Add(now time.Time, addr netip.Addr, name string, ttl time.Duration) {
+	if ttl < minDNSNameTTL {
+		ttl = minDNSNameTTL
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.m[addr] = dnsNameEntry{name: name, expires: now.Add(ttl)}
+}
+
+func (c *dnsNameCache) Lookup(now time.Time, addr netip.Addr) string {
+	if c == nil {
+		return ""
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if e, ok := c.m[addr.Unmap()]; ok && now.Before(e.expires) {
+		return e.name
+	}
+	return ""
+}
+
+func (c *dnsNameCache) Cleanup(now time.Time) int {
+	if c == nil {
+		return 0
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := 0
+	for addr, e := range c.m {
+		if !now.Before(e.expires) {
+			delete(c.m, addr)
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,131 @@
+package tungo
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/go-gost/core/hosts"
+	"github.com/go-gost/core/resolver"
+	xlogger "github.com/go-gost/x/logger"
+	xrecorder "github.com/go-gost/x/recorder"
+	"github.com/go-gost/x/registry"
+	"github.com/miekg/dns"
+)
+
+type testResolver struct {
+	ips     []net.IP
+	queries int
+}
+
+func (r *testResolver) Resolve(ctx context.Context, network, host string, opts ...resolver.Option) ([]net.IP, error) {
+	r.queries++
+	return r.ips, nil
+}
+
+type testHostMapper map[string][]net.IP
+
+func (m testHostMapper) Lookup(ctx context.Context, network, host string, opts ...hosts.Option) ([]net.IP, bool) {
+	ips, ok := m[host]
+	return ips, ok
+}
+
+func TestParseDNSUpstream(t *testing.T) {
+	cfg, err := parseDNSUpstream("Corp.Example.  udp://10.1.0.53:53 proxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.suffix != "corp.example" || cfg.addr != "udp://10.1.0.53:53" || !cfg.proxy {
+		t.Fatalf("unexpected upstream %+v", cfg)
+	}
+
+	cfg, err = parseDNSUpstream(". resolver-0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.suffix != "" || cfg.resolver != "resolver-0" || cfg.proxy {
+		t.Fatalf("unexpected upstream %+v", cfg)
+	}
+
+	for _, s := range []string{"corp.example", "corp.example udp://10.1.0.53 via-proxy"} {
+		if _, err := parseDNSUpstream(s); err == nil {
+			t.Errorf("%q: expected error", s)
+		}
+	}
+}
+
+func TestSplitDNS(t *testing.T) {
+	corp := &testResolver{ips: []net.IP{net.ParseIP("10.1.2.3")}}
+	public := &testResolver{ips: []net.IP{net.ParseIP("93.184.216.34"), net.ParseIP("2606:2800::1")}}
+	registry.ResolverRegistry().Register("splitdns-corp", corp)
+	registry.ResolverRegistry().Register("splitdns-public", public)
+	defer registry.ResolverRegistry().Unregister("splitdns-corp")
+	defer registry.ResolverRegistry().Unregister("splitdns-public")
+
+	d := newSplitDNS(splitDNSOptions{
+		addrs: []netip.Addr{netip.MustParseAddr("198.18.0.2")},
+		upstreams: []dnsUpstreamConfig{
+			{resolver: "splitdns-public"},
+			{suffix: "corp.example", resolver: "splitdns-corp"},
+		},
+		hostMapper: testHostMapper{"printer.corp.example": {net.ParseIP("10.9.9.9")}},
+		logger:     xlogger.Nop(),
+	})
+
+	if !d.intercepts(netip.MustParseAddrPort("198.18.0.2:53")) ||
+		d.intercepts(netip.MustParseAddrPort("198.18.0.2:5353")) ||
+		d.intercepts(netip.MustParseAddrPort("8.8.8.8:53")) {
+		t.Fatal("unexpected interception")
+	}
+
+	query := func(name string, qtype uint16) *dns.Msg {
+		mq := &dns.Msg{}
+		mq.SetQuestion(dns.Fqdn(name), qtype)
+		ro := &xrecorder.HandlerRecorderObject{DNS: &xrecorder.DNSRecorderObject{}}
+		mr, err := d.answer(context.Background(), mq, ro, xlogger.Nop())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return mr
+	}
+
+	mr := query("git.corp.example", dns.TypeA)
+	if len(mr.Answer) != 1 || mr.Answer[0].(*dns.A).A.String() != "10.1.2.3" {
+		t.Fatalf("unexpected answer %v", mr.Answer)
+	}
+	mr = query("www.example.com", dns.TypeAAAA)
+	if len(mr.Answer) != 1 || mr.Answer[0].(*dns.AAAA).AAAA.String() != "2606:2800::1" {
+		t.Fatalf("unexpected answer %v", mr.Answer)
+	}
+	mr = query("printer.corp.example", dns.TypeA)
+	if len(mr.Answer) != 1 || mr.Answer[0].(*dns.A).A.String() != "10.9.9.9" {
+		t.Fatalf("unexpected answer %v", mr.Answer)
+	}
+
+	// the answers are cached.
+	query("git.corp.example", dns.TypeA)
+	if corp.queries != 1 {
+		t.Fatalf("expected 1 query, got %d", corp.queries)
+	}
+
+	// a resolver can not answer the other types.
+	if mr := query("corp.example", dns.TypeMX); mr != nil {
+		t.Fatalf("unexpected answer %v", mr)
+	}
+
+	now := time.Now()
+	for addr, name := range map[string]string{
+		"10.1.2.3":     "git.corp.example",
+		"2606:2800::1": "www.example.com",
+		"10.9.9.9":     "printer.corp.example",
+	} {
+		if v := d.names.Lookup(now, netip.MustParseAddr(addr)); v != name {
+			t.Errorf("%s: expected %s, got %q", addr, name, v)
+		}
+	}
+	if n := d.names.Cleanup(now.Add(2 * minDNSNameTTL)); n != 3 {
+		t.Fatalf("expected 3 names expired, got %d", n)
+	}
+}
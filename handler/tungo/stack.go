@@ -20,10 +20,12 @@ import (
 	"github.com/go-gost/core/common/bufpool"
 	"github.com/go-gost/core/handler"
 	"github.com/go-gost/core/hop"
+	"github.com/go-gost/core/logger"
 	"github.com/go-gost/core/observer/stats"
 	"github.com/go-gost/core/recorder"
 	xchain "github.com/go-gost/x/chain"
 	xctx "github.com/go-gost/x/ctx"
+	tundec "github.com/go-gost/x/handler/tun"
 	ictx "github.com/go-gost/x/internal/ctx"
 	xnet "github.com/go-gost/x/internal/net"
 	"github.com/go-gost/x/internal/util/sniffing"
@@ -105,6 +107,21 @@ type transportHandler struct {
 	}
 	forwarder hop.Hop
 	conntrack *conntrackTable
+	// sockOwner resolves the appID of the flows that dec does not attribute, nil if disabled.
+	sockOwner tundec.MetadataResolver
+
+	// rulesNotifier signals the rules changes of dec, nil if not supported.
+	rulesNotifier      tundec.RulesNotifier
+	rulesCheckInterval time.Duration
+	// reevaluateTeardown closes the live flows whose proxy/direct choice changed with the rules.
+	reevaluateTeardown bool
+
+	// fakeIP is the fake IP pool used to answer DNS queries, nil if FakeIP mode is disabled.
+	fakeIP       *fakeIPPool
+	fakeIPBypass bypass.Bypass
+
+	// dns answers the queries to the TUN DNS addresses, nil if the split DNS is disabled.
+	dns *splitDNS
 
 	proxyDialByDomain bool
 
@@ -138,6 +155,14 @@ type transportHandler struct {
 
 	ipv6 bool
 
+	// icmpMode is the handling of the ICMP echo requests, forward, local or none.
+	icmpMode    string
+	icmpTimeout time.Duration
+	// icmpSem limits the concurrent echo requests.
+	icmpSem chan struct{}
+	// icmpWrite writes the ICMP replies to the TUN device.
+	icmpWrite func(b []byte) error
+
 	proxyRouterOnce sync.Once
 	proxyRouter     *xchain.Router
 
@@ -145,6 +170,12 @@ type transportHandler struct {
 
 	// statsGUID is the GUID used to look up the TrafficStatsReporter.
 	statsGUID string
+	// statsInterval is the period of the TCP flow byte count reports.
+	statsInterval time.Duration
+
+	// bandwidthRules assign the flows to the bandwidth classes, classes counts the bytes per class.
+	bandwidthRules []bandwidthRule
+	classes        *bandwidthClasses
 }
 
 func (h *transportHandler) getProxyRouter() *xchain.Router {
@@ -226,6 +257,10 @@ func (h *transportHandler) process(ctx context.Context) {
 			return cleanupTicker.C
 		}():
 			_ = h.conntrack.Cleanup(time.Now())
+			_ = h.fakeIP.Cleanup(time.Now())
+			if h.dns != nil {
+				_ = h.dns.names.Cleanup(time.Now())
+			}
 		case <-ctx.Done():
 			return
 		}
@@ -282,12 +317,126 @@ func (h *transportHandler) touchPolicy(now time.Time, k flowKey, ttl time.Durati
 	h.conntrack.Touch(now, k, ttl)
 }
 
+// evaluatePolicy runs the decision evaluator for the flow identified by key.
+// hostname is used when the evaluator does not resolve a hostname itself.
+// ActionBlock refuses the flow, ActionProxy sends it through the forwarder
+// and any other action, or no decision, dials it directly.
+func (h *transportHandler) evaluatePolicy(key flowKey, proto string, hostname string, log logger.Logger) flowPolicy {
+	p := flowPolicy{action: flowActionDirect, proxyHost: hostname, hostname: hostname}
+
+	var appID, hname string
+	if h.dec != nil {
+		appID, hname = h.dec.ResolveMetadata(key.srcIP.String(), key.dstIP.String(), int(key.srcPort), int(key.dstPort), proto)
+	}
+	if appID == "" && h.sockOwner != nil {
+		appID, _ = h.sockOwner.ResolveMetadata(key.srcIP.String(), key.dstIP.String(), int(key.srcPort), int(key.dstPort), proto)
+	}
+	p.appID = appID
+
+	if h.dec == nil {
+		p.class = matchBandwidthRule(h.bandwidthRules, appID, hostname)
+		return p
+	}
+
+	if hname != "" {
+		hostname = hname
+	}
+	if hostname == "" && h.dns != nil {
+		hostname = h.dns.names.Lookup(time.Now(), key.dstIP)
+	}
+	if hostname == "" {
+		type domainLookup interface{ GetDomainsForIP(string) []string }
+		if dl, ok := any(h.dec).(domainLookup); ok {
+			if domains := dl.GetDomainsForIP(key.dstIP.String()); len(domains) > 0 {
+				hostname = domains[0]
+			}
+		}
+	}
+	p.proxyHost = hostname
+	p.hostname = hostname
+
+	p.input = &decision.RuleInput{
+		SteamAppID: appID,
+		DestHost:   key.dstIP.String(),
+		DestPort:   int32(key.dstPort),
+		Protocol:   proto,
+		DestDomain: hostname,
+	}
+	if d := h.dec.CheckTrafficRules(*p.input); d != nil {
+		log.Debugf("traffic decision: action=%s rule=%s appID=%s dst=%s domain=%s",
+			d.Action, d.RuleName, appID, netip.AddrPortFrom(key.dstIP, key.dstPort), hostname)
+		p.rule = d.RuleName
+		if ri, ok := h.dec.(tundec.RuleIDer); ok {
+			p.ruleID = ri.RuleID(d)
+		}
+		if bc, ok := h.dec.(tundec.BandwidthClasser); ok {
+			p.class = bc.BandwidthClass(d)
+		}
+		switch {
+		case d.Action == decision.ActionBlock:
+			p.action = flowActionBlock
+		case d.Action == decision.ActionProxy,
+			strings.EqualFold(strings.TrimSpace(string(d.Action)), "PROXY"):
+			p.action = flowActionProxy
+			p.useProxy = true
+			if ph, ok := h.dec.(tundec.ProxyHoster); ok {
+				if host := ph.ProxyHost(d); host != "" {
+					p.proxyHost = host
+				}
+			}
+		}
+	}
+	if p.class == "" {
+		p.class = matchBandwidthRule(h.bandwidthRules, appID, hostname)
+	}
+
+	return p
+}
+
+// recorderObject returns the decision section of the flow record,
+// cached reports whether the policy was taken from the conntrack cache.
+func (p flowPolicy) recorderObject(cached bool) *xrecorder.DecisionRecorderObject {
+	o := &xrecorder.DecisionRecorderObject{
+		AppID:    p.appID,
+		Hostname: p.hostname,
+		Action:   p.action,
+		Cached:   cached,
+		Rule:     p.rule,
+		RuleID:   p.ruleID,
+		Class:    p.class,
+	}
+	if p.useProxy {
+		o.ProxyHost = p.proxyHost
+	}
+	if in := p.input; in != nil {
+		o.Input = &xrecorder.DecisionInputRecorderObject{
+			AppID:      in.SteamAppID,
+			DestHost:   in.DestHost,
+			DestPort:   int(in.DestPort),
+			Protocol:   in.Protocol,
+			DestDomain: in.DestDomain,
+		}
+	}
+	return o
+}
+
+func protoForNetwork(network string) string {
+	n := strings.ToLower(strings.TrimSpace(network))
+	switch {
+	case strings.HasPrefix(n, "udp"):
+		return "UDP"
+	default:
+		return "TCP"
+	}
+}
+
 // ProcessAsync can be safely called multiple times, but will only be effective once.
 func (h *transportHandler) ProcessAsync() {
 	h.procOnce.Do(func() {
 		ctx, cancel := context.WithCancel(context.Background())
 		h.procCancel = cancel
 		go h.process(ctx)
+		go h.watchRules(ctx)
 	})
 }
 
@@ -340,11 +489,23 @@ func (h *transportHandler) handleTCPConn(originConn adapter.TCPConn) {
 
 	key := h.flowKeyTCP(remoteIP, dstIP, id.RemotePort, id.LocalPort)
 
+	// dstTarget is the address used to dial the destination. For a fake IP,
+	// the real address is unknown, so the mapped domain is dialed instead.
+	dstTarget := dstAddr.String()
+	fakeDomain, fake := h.lookupFakeIP(dstIP)
+	if fakeDomain != "" {
+		dstTarget = net.JoinHostPort(fakeDomain, strconv.Itoa(int(dstAddr.Port())))
+		ro.Host = dstTarget
+	}
+
 	var err error
 	var conn net.Conn = originConn
 
 	pStats := xstats.Stats{}
 	conn = stats_wrapper.WrapConn(conn, &pStats)
+	cconn := newClassConn(ctx, conn)
+	defer cconn.Close()
+	conn = cconn
 
 	defer func() {
 		if err != nil {
@@ -365,6 +526,20 @@ func (h *transportHandler) handleTCPConn(originConn adapter.TCPConn) {
 		}).Infof("%s >< %s", remoteAddr.String(), dstAddr.String())
 	}()
 
+	if fake && fakeDomain == "" {
+		err = errFakeIPNotFound
+		log.Warnf("fakeip: %s: %v", dstIP, err)
+		return
+	}
+
+	meter := newFlowMeter(h.statsGUID, "tcp", remoteAddr.String(), dstAddr.String(), &pStats, h.statsInterval)
+	defer meter.End()
+
+	// the flow is killed through cconn, so that its waits for the bandwidth limits are released.
+	flow := newTrackedFlow(sid, key, &pStats, cconn)
+	h.conntrack.Track(flow)
+	defer h.conntrack.Untrack(flow)
+
 	if pstats := h.stats.Stats(""); pstats != nil {
 		pstats.Add(stats.KindTotalConns, 1)
 		pstats.Add(stats.KindCurrentConns, 1)
@@ -386,65 +561,35 @@ func (h *transportHandler) handleTCPConn(originConn adapter.TCPConn) {
 			conn.SetReadDeadline(time.Time{})
 		}
 
-		protoForNetwork := func(network string) string {
-			n := strings.ToLower(strings.TrimSpace(network))
-			switch {
-			case strings.HasPrefix(n, "udp"):
-				return "UDP"
-			case strings.HasPrefix(n, "tcp"):
-				return "TCP"
-			default:
-				return "TCP"
-			}
-		}
-
 		dial := func(ctx context.Context, network, address string) (net.Conn, error) {
 			var cc net.Conn
 			var err error
 			now := time.Now()
 			p, ok := h.getCachedPolicy(now, key)
 			if !ok {
-				useProxy := false
-				hostname := ""
-				if h.dec != nil {
-					proto := protoForNetwork(network)
-					appID, hname := h.dec.ResolveMetadata(remoteIP.String(), dstIP.String(), int(id.RemotePort), int(id.LocalPort), proto)
-					hostname = hname
-					if hostname == "" {
-						if host, _, _ := net.SplitHostPort(address); host != "" {
-							hostname = host
-						} else if strings.TrimSpace(address) != "" {
-							hostname = strings.TrimSpace(address)
-						}
-					}
-					if hostname == "" {
-						type domainLookup interface{ GetDomainsForIP(string) []string }
-						if dl, ok := any(h.dec).(domainLookup); ok {
-							if domains := dl.GetDomainsForIP(dstIP.String()); len(domains) > 0 {
-								hostname = domains[0]
-							}
-						}
-					}
-					if d := h.dec.CheckTrafficRules(decision.RuleInput{
-						SteamAppID: appID,
-						DestHost:   dstIP.String(),
-						DestPort:   int32(id.LocalPort),
-						Protocol:   proto,
-						DestDomain: hostname,
-					}); d != nil {
-						log.Debugf("traffic decision: action=%s rule=%s appID=%s dst=%s domain=%s", d.Action, d.RuleName, appID, dstAddr.String(), hostname)
-						if strings.EqualFold(strings.TrimSpace(string(d.Action)), "PROXY") {
-							useProxy = true
-						}
+				hostname := fakeDomain
+				if hostname == "" {
+					if host, _, _ := net.SplitHostPort(address); host != "" {
+						hostname = host
+					} else {
+						hostname = strings.TrimSpace(address)
 					}
 				}
-				p = flowPolicy{useProxy: useProxy, proxyHost: hostname}
+				p = h.evaluatePolicy(key, protoForNetwork(network), hostname, log)
 				ttl := h.tcpConntrackTTLShort
 				if ttl <= 0 {
 					ttl = 60 * time.Second
 				}
 				h.putCachedPolicy(now, key, p, ttl)
 			}
+			ro.Decision = p.recorderObject(ok)
+			flow.SetPolicy(protoForNetwork(network), p)
+			meter.Start(p.action, p.hostname, p.class)
+			if p.action == flowActionBlock {
+				log.Debugf("traffic blocked by decision: %s", dstAddr)
+				return nil, errFlowBlocked
+			}
+			cconn.setClass(h.flowClass(p, dstAddr.String()))
 
 			useProxy := p.useProxy
 			proxyHost := p.proxyHost
@@ -456,7 +601,7 @@ func (h *transportHandler) handleTCPConn(originConn adapter.TCPConn) {
 				_, port, _ := net.SplitHostPort(dstAddr.String())
 				if useProxy {
 					if !h.proxyDialByDomain {
-						address = dstAddr.String()
+						address = dstTarget
 					} else if ph, ok := normalizeProxyHost(proxyHost); ok {
 						address = net.JoinHostPort(ph, port)
 					} else {
@@ -485,12 +630,14 @@ func (h *transportHandler) handleTCPConn(originConn adapter.TCPConn) {
 
 			if cc == nil {
 				var buf bytes.Buffer
-				cc, err = h.opts.Router.Dial(ictx.ContextWithBuffer(ctx, &buf), network, dstAddr.String())
+				cc, err = h.opts.Router.Dial(ictx.ContextWithBuffer(ctx, &buf), network, dstTarget)
 				ro.Route = buf.String()
-				ro.Host = dstAddr.String()
+				ro.Host = dstTarget
 			}
 
 			if err == nil {
+				flow.AddCloser(cc)
+
 				ttl := h.tcpConntrackTTLLong
 				if ttl <= 0 {
 					ttl = 5 * time.Minute
@@ -536,73 +683,45 @@ func (h *transportHandler) handleTCPConn(originConn adapter.TCPConn) {
 		}
 	}
 
-	useProxy := false
-	hostname := ""
-	if h.dec != nil {
-		now := time.Now()
-		if p, ok := h.getCachedPolicy(now, key); ok {
-			useProxy = p.useProxy
-			hostname = p.proxyHost
-		} else {
-			protoForNetwork := func(network string) string {
-				n := strings.ToLower(strings.TrimSpace(network))
-				switch {
-				case strings.HasPrefix(n, "udp"):
-					return "UDP"
-				case strings.HasPrefix(n, "tcp"):
-					return "TCP"
-				default:
-					return "TCP"
-				}
-			}
-
-			proto := protoForNetwork(network)
-			appID, hname := h.dec.ResolveMetadata(remoteIP.String(), dstIP.String(), int(id.RemotePort), int(id.LocalPort), proto)
-			hostname = hname
-			if hostname == "" {
-				type domainLookup interface{ GetDomainsForIP(string) []string }
-				if dl, ok := any(h.dec).(domainLookup); ok {
-					if domains := dl.GetDomainsForIP(dstIP.String()); len(domains) > 0 {
-						hostname = domains[0]
-					}
-				}
-			}
-			if d := h.dec.CheckTrafficRules(decision.RuleInput{
-				SteamAppID: appID,
-				DestHost:   dstIP.String(),
-				DestPort:   int32(id.LocalPort),
-				Protocol:   proto,
-				DestDomain: hostname,
-			}); d != nil {
-				log.Debugf("traffic decision: action=%s rule=%s appID=%s dst=%s domain=%s", d.Action, d.RuleName, appID, dstAddr.String(), hostname)
-				if strings.EqualFold(strings.TrimSpace(string(d.Action)), "PROXY") {
-					useProxy = true
-				}
-			}
-			p := flowPolicy{action: "", useProxy: useProxy, proxyHost: hostname}
-			ttl := h.tcpConntrackTTLShort
-			if ttl <= 0 {
-				ttl = 60 * time.Second
-			}
-			h.putCachedPolicy(now, key, p, ttl)
+	now := time.Now()
+	p, ok := h.getCachedPolicy(now, key)
+	if !ok {
+		p = h.evaluatePolicy(key, protoForNetwork(network), fakeDomain, log)
+		ttl := h.tcpConntrackTTLShort
+		if ttl <= 0 {
+			ttl = 60 * time.Second
 		}
+		h.putCachedPolicy(now, key, p, ttl)
+	}
+	ro.Decision = p.recorderObject(ok)
+	flow.SetPolicy(protoForNetwork(network), p)
+	useProxy := p.useProxy
+	proxyHost := p.proxyHost
+
+	meter.Start(p.action, p.hostname, p.class)
+	if p.action == flowActionBlock {
+		err = errFlowBlocked
+		log.Debugf("traffic blocked by decision: %s", dstAddr)
+		return
 	}
+	cconn.setClass(h.flowClass(p, dstAddr.String()))
+
 	log.Debugf("traffic routing: useProxy=%t forwarderInjected=%t", useProxy, h.forwarder != nil)
 	if useProxy && h.forwarder == nil {
 		log.Warnf("traffic decision is PROXY but forwarder is nil; falling back to direct dial")
 	}
 
 	if h.opts.Bypass != nil &&
-		h.opts.Bypass.Contains(ctx, network, dstAddr.String(), bypass.WithService(h.opts.Service)) {
-		log.Debug("bypass: ", dstAddr)
+		h.opts.Bypass.Contains(ctx, network, dstTarget, bypass.WithService(h.opts.Service)) {
+		log.Debug("bypass: ", dstTarget)
 		return
 	}
 
 	var buf bytes.Buffer
 	var cc net.Conn
-	dialAddr := dstAddr.String()
+	dialAddr := dstTarget
 	if useProxy && h.proxyDialByDomain {
-		if ph, ok := normalizeProxyHost(hostname); ok {
+		if ph, ok := normalizeProxyHost(proxyHost); ok {
 			dialAddr = net.JoinHostPort(ph, strconv.Itoa(int(dstAddr.Port())))
 		}
 	}
@@ -618,10 +737,11 @@ func (h *transportHandler) handleTCPConn(originConn adapter.TCPConn) {
 	}
 	ro.Route = buf.String()
 	if err != nil {
-		log.Errorf("dial %s: %v", dstAddr.String(), err)
+		log.Errorf("dial %s: %v", dialAddr, err)
 		return
 	}
 	defer cc.Close()
+	flow.AddCloser(cc)
 
 	// We have a successfully established outbound connection; extend TTL.
 	{
@@ -705,50 +825,37 @@ func (h *transportHandler) handleUDPConn(uc adapter.UDPConn) {
 
 	log.Debugf("%s <> %s", remoteAddr.String(), dstAddr.String())
 
+	if (h.fakeIP != nil && id.LocalPort == 53) || h.dns.intercepts(dstAddr) {
+		h.handleDNS(ctx, uc, dstAddr, ro, log)
+		return
+	}
+
 	key := h.flowKeyUDP(remoteIP, dstIP, id.RemotePort, id.LocalPort)
 
+	dstTarget := dstAddr.String()
+	fakeDomain, fake := h.lookupFakeIP(dstIP)
+	if fake && fakeDomain == "" {
+		log.Warnf("fakeip: %s: %v", dstIP, errFakeIPNotFound)
+		return
+	}
+	if fakeDomain != "" {
+		dstTarget = net.JoinHostPort(fakeDomain, strconv.Itoa(int(dstAddr.Port())))
+		ro.Host = dstTarget
+	}
+
 	udpTTL := h.udpConntrackTTL
 	if udpTTL <= 0 {
 		udpTTL = 60 * time.Second
 	}
 
-	useProxy := false
-	if p, ok := h.getCachedPolicy(time.Now(), key); ok {
-		useProxy = p.useProxy
-	} else if h.dec != nil {
-		appID, hostname := h.dec.ResolveMetadata(remoteIP.String(), dstIP.String(), int(id.RemotePort), int(id.LocalPort), "UDP")
-		if hostname == "" {
-			type domainLookup interface{ GetDomainsForIP(string) []string }
-			if dl, ok := any(h.dec).(domainLookup); ok {
-				if domains := dl.GetDomainsForIP(dstIP.String()); len(domains) > 0 {
-					hostname = domains[0]
-				}
-			}
-		}
-		if d := h.dec.CheckTrafficRules(decision.RuleInput{
-			SteamAppID: appID,
-			DestHost:   dstIP.String(),
-			DestPort:   int32(id.LocalPort),
-			Protocol:   "UDP",
-			DestDomain: hostname,
-		}); d != nil {
-			log.Debugf("traffic decision: action=%s rule=%s appID=%s dst=%s domain=%s", d.Action, d.RuleName, appID, dstAddr.String(), hostname)
-			if strings.EqualFold(strings.TrimSpace(string(d.Action)), "PROXY") {
-				useProxy = true
-			}
-		}
-		h.putCachedPolicy(time.Now(), key, flowPolicy{useProxy: useProxy}, udpTTL)
-	}
-	log.Debugf("traffic routing: useProxy=%t forwarderInjected=%t", useProxy, h.forwarder != nil)
-	if useProxy && h.forwarder == nil {
-		log.Warnf("traffic decision is PROXY but forwarder is nil; falling back to direct dial")
-	}
-
 	var err error
 	var conn net.Conn = uc
 
 	pStats := xstats.Stats{}
 	conn = stats_wrapper.WrapConn(conn, &pStats)
+	cconn := newClassConn(ctx, conn)
+	defer cconn.Close()
+	conn = cconn
 
 	defer func() {
 		if err != nil {
@@ -769,6 +876,42 @@ func (h *transportHandler) handleUDPConn(uc adapter.UDPConn) {
 		}).Infof("%s >< %s", remoteAddr.String(), dstAddr.String())
 	}()
 
+	// The QUIC Initial packets are held back until the SNI is known,
+	// so that the decision is made with the hostname.
+	hostname := fakeDomain
+	var pending [][]byte
+	if h.sniffingUDP {
+		var sni string
+		sni, pending = h.sniffQUIC(conn, ro, log)
+		if hostname == "" && sni != "" {
+			hostname = sni
+			ro.Host = net.JoinHostPort(sni, strconv.Itoa(int(dstAddr.Port())))
+		}
+	}
+
+	p, ok := h.getCachedPolicy(time.Now(), key)
+	if !ok {
+		p = h.evaluatePolicy(key, "UDP", hostname, log)
+		h.putCachedPolicy(time.Now(), key, p, udpTTL)
+	}
+	ro.Decision = p.recorderObject(ok)
+	if p.action == flowActionBlock {
+		err = errFlowBlocked
+		log.Debugf("traffic blocked by decision: %s", dstAddr)
+		return
+	}
+	cconn.setClass(h.flowClass(p, dstAddr.String()))
+	useProxy := p.useProxy
+	log.Debugf("traffic routing: useProxy=%t forwarderInjected=%t", useProxy, h.forwarder != nil)
+	if useProxy && h.forwarder == nil {
+		log.Warnf("traffic decision is PROXY but forwarder is nil; falling back to direct dial")
+	}
+
+	flow := newTrackedFlow(sid, key, &pStats, cconn)
+	flow.SetPolicy("UDP", p)
+	h.conntrack.Track(flow)
+	defer h.conntrack.Untrack(flow)
+
 	var buf bytes.Buffer
 	var cc net.Conn
 	if useProxy && h.forwarder != nil {
@@ -791,21 +934,22 @@ func (h *transportHandler) handleUDPConn(uc adapter.UDPConn) {
 					corechain.ChainRouterOption(c),
 					corechain.LoggerRouterOption(log),
 				)
-				cc, err = proxyRouter.Dial(ictx.ContextWithBuffer(ctx, &buf), "udp", dstAddr.String())
+				cc, err = proxyRouter.Dial(ictx.ContextWithBuffer(ctx, &buf), "udp", dstTarget)
 			} else {
-				cc, err = h.opts.Router.Dial(ictx.ContextWithBuffer(ctx, &buf), "udp", dstAddr.String())
+				cc, err = h.opts.Router.Dial(ictx.ContextWithBuffer(ctx, &buf), "udp", dstTarget)
 			}
 		} else {
-			cc, err = h.opts.Router.Dial(ictx.ContextWithBuffer(ctx, &buf), "udp", dstAddr.String())
+			cc, err = h.opts.Router.Dial(ictx.ContextWithBuffer(ctx, &buf), "udp", dstTarget)
 		}
 	} else {
-		cc, err = h.opts.Router.Dial(ictx.ContextWithBuffer(ctx, &buf), "udp", dstAddr.String())
+		cc, err = h.opts.Router.Dial(ictx.ContextWithBuffer(ctx, &buf), "udp", dstTarget)
 	}
 	if err != nil {
-		log.Errorf("dial %s: %v", dstAddr.String(), err)
+		log.Errorf("dial %s: %v", dstTarget, err)
 		return
 	}
 	defer cc.Close()
+	flow.AddCloser(cc)
 
 	// Dispatch OnConnectionStart callback for UDP session lifecycle.
 	if h.statsGUID != "" {
@@ -826,6 +970,16 @@ func (h *transportHandler) handleUDPConn(uc adapter.UDPConn) {
 	ro.SrcAddr = cc.LocalAddr().String()
 	log = log.WithFields(map[string]any{"src": ro.SrcAddr})
 
+	for _, b := range pending {
+		if h.statsGUID != "" {
+			dispatchOnPacket(h.statsGUID, "udp", "rx", ro.RemoteAddr, ro.DstAddr, len(b))
+		}
+		if _, err = cc.Write(b); err != nil {
+			log.Errorf("write %s: %v", dstTarget, err)
+			return
+		}
+	}
+
 	t := time.Now()
 	log.Infof("%s <-> %s", remoteAddr, dstAddr)
 	h.pipePacketData(conn, cc, ro)
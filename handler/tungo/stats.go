@@ -2,6 +2,9 @@ package tungo
 
 import (
 	"sync"
+	"time"
+
+	"github.com/go-gost/core/observer/stats"
 )
 
 // TrafficStatsReporter defines the interface for reporting UDP traffic statistics.
@@ -26,6 +29,52 @@ type TrafficStatsReporter interface {
 	OnConnectionEnd(protocol, srcAddr, dstAddr string)
 }
 
+// FlowInfo describes a TCP flow reported to a FlowStatsReporter.
+type FlowInfo struct {
+	// Protocol is the transport protocol, currently always "tcp".
+	Protocol string
+	// SrcAddr is the client address in "ip:port" format.
+	SrcAddr string
+	// DstAddr is the original destination address in "ip:port" format.
+	DstAddr string
+	// Action is the final decision action: "proxy", "direct" or "block".
+	Action string
+	// Hostname is the hostname matched for the flow, if any.
+	Hostname string
+	// Class is the bandwidth class of the flow, if any.
+	Class string
+}
+
+// FlowStatsReporter is an optional extension of TrafficStatsReporter for TCP
+// byte accounting. If the reporter registered for a GUID also implements this
+// interface, the tungo handler reports TCP flows to it.
+//
+// Byte counts follow the same direction convention as OnPacket:
+// rx is client to destination, tx is destination back to client.
+type FlowStatsReporter interface {
+	TrafficStatsReporter
+
+	// OnFlowStart is called once the decision for a TCP flow is made.
+	OnFlowStart(flow FlowInfo)
+
+	// OnFlowStats is called periodically with the bytes transferred since the previous report.
+	OnFlowStats(flow FlowInfo, rxBytes, txBytes int64)
+
+	// OnFlowEnd is called when the TCP flow ends, with the bytes transferred since the previous report.
+	OnFlowEnd(flow FlowInfo, rxBytes, txBytes int64)
+}
+
+// ClassStatsReporter is an optional extension of TrafficStatsReporter for the
+// bandwidth classes. If the reporter registered for a GUID also implements this
+// interface, the tungo handler reports the bytes of the TCP and UDP flows of each class to it.
+type ClassStatsReporter interface {
+	TrafficStatsReporter
+
+	// OnClassStats is called periodically with the bytes transferred by the flows of the class
+	// since the previous report. It is not called for the classes without traffic.
+	OnClassStats(class string, rxBytes, txBytes int64)
+}
+
 var (
 	// Global registry of stats reporters by GUID
 	statsReporters   = make(map[string]TrafficStatsReporter)
@@ -101,3 +150,131 @@ func dispatchOnConnectionEnd(guid, protocol, srcAddr, dstAddr string) {
 		reporter.OnConnectionEnd(protocol, srcAddr, dstAddr)
 	}
 }
+
+// getFlowStatsReporter retrieves the FlowStatsReporter for the given GUID.
+// Returns nil if no reporter is registered or it does not implement FlowStatsReporter.
+func getFlowStatsReporter(guid string) FlowStatsReporter {
+	reporter, _ := getStatsReporter(guid).(FlowStatsReporter)
+	return reporter
+}
+
+// getClassStatsReporter retrieves the ClassStatsReporter for the given GUID.
+// Returns nil if no reporter is registered or it does not implement ClassStatsReporter.
+func getClassStatsReporter(guid string) ClassStatsReporter {
+	reporter, _ := getStatsReporter(guid).(ClassStatsReporter)
+	return reporter
+}
+
+// flowMeter reports the byte counters of a single TCP flow to a FlowStatsReporter.
+type flowMeter struct {
+	reporter FlowStatsReporter
+	flow     FlowInfo
+	stats    stats.Stats
+	interval time.Duration
+
+	mu      sync.Mutex
+	started bool
+	rx, tx  uint64
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// newFlowMeter creates a meter for the flow. The returned meter is a no-op if no
+// FlowStatsReporter is registered for the GUID.
+func newFlowMeter(guid, protocol, srcAddr, dstAddr string, st stats.Stats, interval time.Duration) *flowMeter {
+	reporter := getFlowStatsReporter(guid)
+	if reporter == nil {
+		return nil
+	}
+	return &flowMeter{
+		reporter: reporter,
+		flow: FlowInfo{
+			Protocol: protocol,
+			SrcAddr:  srcAddr,
+			DstAddr:  dstAddr,
+		},
+		stats:    st,
+		interval: interval,
+		done:     make(chan struct{}),
+	}
+}
+
+// Start reports the flow start with the decision result and begins the periodic reports.
+// Only the first call takes effect.
+func (m *flowMeter) Start(action, hostname, class string) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.started {
+		return
+	}
+	m.started = true
+
+	m.flow.Action = action
+	m.flow.Hostname = hostname
+	m.flow.Class = class
+	m.reporter.OnFlowStart(m.flow)
+
+	if m.interval > 0 && action != flowActionBlock {
+		m.wg.Add(1)
+		go m.loop()
+	}
+}
+
+func (m *flowMeter) loop() {
+	defer m.wg.Done()
+
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.mu.Lock()
+			rx, tx := m.delta()
+			m.mu.Unlock()
+			if rx > 0 || tx > 0 {
+				m.reporter.OnFlowStats(m.flow, rx, tx)
+			}
+		case <-m.done:
+			return
+		}
+	}
+}
+
+// delta returns the bytes transferred since the previous call. m.mu must be held.
+func (m *flowMeter) delta() (rx, tx int64) {
+	in := m.stats.Get(stats.KindInputBytes)
+	out := m.stats.Get(stats.KindOutputBytes)
+	rx, tx = int64(in-m.rx), int64(out-m.tx)
+	m.rx, m.tx = in, out
+	return
+}
+
+// End stops the periodic reports and reports the flow end.
+// It is a no-op if the flow was never started.
+func (m *flowMeter) End() {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	started := m.started
+	m.mu.Unlock()
+	if !started {
+		return
+	}
+
+	close(m.done)
+	m.wg.Wait()
+
+	m.mu.Lock()
+	rx, tx := m.delta()
+	m.mu.Unlock()
+
+	m.reporter.OnFlowEnd(m.flow, rx, tx)
+}
@@ -179,6 +179,27 @@
 //		// ... use connID to track connection ...
 //	}
 //
+// # TCP Flow Metering
+//
+// TCP traffic is not reported through OnPacket. A reporter that also implements
+// FlowStatsReporter receives per-flow callbacks for TCP connections, keyed by the
+// same statsGUID; reporters implementing only TrafficStatsReporter are unaffected.
+//
+//	func (r *MyTrafficReporter) OnFlowStart(flow tungo.FlowInfo) {
+//		// flow.Action is "proxy", "direct" or "block", flow.Hostname is the matched hostname.
+//	}
+//
+//	func (r *MyTrafficReporter) OnFlowStats(flow tungo.FlowInfo, rxBytes, txBytes int64) {
+//		// bytes transferred since the previous report
+//	}
+//
+//	func (r *MyTrafficReporter) OnFlowEnd(flow tungo.FlowInfo, rxBytes, txBytes int64) {
+//		// bytes transferred since the last OnFlowStats
+//	}
+//
+// OnFlowStats is called every statsInterval (default 5s) while the flow has traffic.
+// Blocked flows are reported with OnFlowStart and OnFlowEnd only.
+//
 // # Performance Considerations
 //
 // When no reporter is registered for a GUID, the dispatcher functions perform
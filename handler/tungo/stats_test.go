@@ -6,6 +6,10 @@ import (
 	"sync"
 	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/go-gost/core/observer/stats"
+	xstats "github.com/go-gost/x/observer/stats"
 )
 
 // normalizeConnID creates a normalized connection identifier
@@ -662,3 +666,130 @@ func TestRealisticUsage_ConnectionIDNormalization(t *testing.T) {
 		})
 	}
 }
+
+// mockFlowStatsReporter extends mockStatsReporter with TCP flow callbacks.
+type mockFlowStatsReporter struct {
+	mockStatsReporter
+
+	flowMu  sync.Mutex
+	starts  []FlowInfo
+	ends    []FlowInfo
+	rxBytes int64
+	txBytes int64
+	updates int
+}
+
+func (m *mockFlowStatsReporter) OnFlowStart(flow FlowInfo) {
+	m.flowMu.Lock()
+	defer m.flowMu.Unlock()
+	m.starts = append(m.starts, flow)
+}
+
+func (m *mockFlowStatsReporter) OnFlowStats(flow FlowInfo, rxBytes, txBytes int64) {
+	m.flowMu.Lock()
+	defer m.flowMu.Unlock()
+	m.updates++
+	m.rxBytes += rxBytes
+	m.txBytes += txBytes
+}
+
+func (m *mockFlowStatsReporter) OnFlowEnd(flow FlowInfo, rxBytes, txBytes int64) {
+	m.flowMu.Lock()
+	defer m.flowMu.Unlock()
+	m.ends = append(m.ends, flow)
+	m.rxBytes += rxBytes
+	m.txBytes += txBytes
+}
+
+func TestFlowMeter_LegacyReporter(t *testing.T) {
+	guid := "test-guid-flow-legacy"
+	RegisterStatsReporter(guid, &mockStatsReporter{})
+	defer UnregisterStatsReporter(guid)
+
+	// A reporter without the flow extension gets no meter.
+	if m := newFlowMeter(guid, "tcp", "10.0.0.1:12345", "1.1.1.1:443", &xstats.Stats{}, time.Second); m != nil {
+		t.Fatalf("expected nil meter for a legacy reporter")
+	}
+
+	// nil meters are safe to use.
+	var m *flowMeter
+	m.Start(flowActionDirect, "", "")
+	m.End()
+}
+
+func TestFlowMeter_Deltas(t *testing.T) {
+	guid := "test-guid-flow-deltas"
+	reporter := &mockFlowStatsReporter{}
+	RegisterStatsReporter(guid, reporter)
+	defer UnregisterStatsReporter(guid)
+
+	st := &xstats.Stats{}
+	m := newFlowMeter(guid, "tcp", "10.0.0.1:12345", "1.1.1.1:443", st, 10*time.Millisecond)
+	if m == nil {
+		t.Fatalf("expected meter")
+	}
+
+	m.Start(flowActionProxy, "example.com", "")
+	m.Start(flowActionDirect, "ignored", "")
+
+	st.Add(stats.KindInputBytes, 100)
+	st.Add(stats.KindOutputBytes, 1000)
+	time.Sleep(50 * time.Millisecond)
+	st.Add(stats.KindInputBytes, 20)
+	st.Add(stats.KindOutputBytes, 200)
+
+	m.End()
+
+	reporter.flowMu.Lock()
+	defer reporter.flowMu.Unlock()
+
+	if len(reporter.starts) != 1 || len(reporter.ends) != 1 {
+		t.Fatalf("expected 1 start and 1 end, got %d and %d", len(reporter.starts), len(reporter.ends))
+	}
+	flow := reporter.ends[0]
+	if flow.Protocol != "tcp" || flow.Action != flowActionProxy || flow.Hostname != "example.com" {
+		t.Errorf("unexpected flow info %+v", flow)
+	}
+	if reporter.updates == 0 {
+		t.Errorf("expected periodic updates")
+	}
+	if reporter.rxBytes != 120 || reporter.txBytes != 1200 {
+		t.Errorf("expected rx=120 tx=1200, got rx=%d tx=%d", reporter.rxBytes, reporter.txBytes)
+	}
+}
+
+func TestFlowMeter_Blocked(t *testing.T) {
+	guid := "test-guid-flow-blocked"
+	reporter := &mockFlowStatsReporter{}
+	RegisterStatsReporter(guid, reporter)
+	defer UnregisterStatsReporter(guid)
+
+	m := newFlowMeter(guid, "tcp", "10.0.0.1:12345", "1.1.1.1:443", &xstats.Stats{}, 10*time.Millisecond)
+	m.Start(flowActionBlock, "blocked.example", "")
+	m.End()
+
+	reporter.flowMu.Lock()
+	defer reporter.flowMu.Unlock()
+	if len(reporter.ends) != 1 || reporter.ends[0].Action != flowActionBlock {
+		t.Fatalf("expected one blocked flow, got %+v", reporter.ends)
+	}
+	if reporter.rxBytes != 0 || reporter.txBytes != 0 {
+		t.Errorf("expected no bytes for a blocked flow")
+	}
+}
+
+func TestFlowMeter_NotStarted(t *testing.T) {
+	guid := "test-guid-flow-not-started"
+	reporter := &mockFlowStatsReporter{}
+	RegisterStatsReporter(guid, reporter)
+	defer UnregisterStatsReporter(guid)
+
+	m := newFlowMeter(guid, "tcp", "10.0.0.1:12345", "1.1.1.1:443", &xstats.Stats{}, time.Second)
+	m.End()
+
+	reporter.flowMu.Lock()
+	defer reporter.flowMu.Unlock()
+	if len(reporter.ends) != 0 {
+		t.Fatalf("expected no flow end without a start")
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"net"
 	"net/http"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-gost/core/auth"
@@ -45,6 +47,13 @@ func init() {
 	registry.HandlerRegistry().Register("tunnel", NewHandler)
 }
 
+var (
+	handlersMu sync.Mutex
+	// handlers are the running handlers by service. The handler replacing another one in
+	// the same service takes over its connector pool and listens on its entrypoints.
+	handlers = map[string]*tunnelHandler{}
+)
+
 type tunnelHandler struct {
 	id          string
 	options     handler.Options
@@ -55,6 +64,8 @@ type tunnelHandler struct {
 	stats       *stats_util.HandlerStats
 	limiter     traffic.TrafficLimiter
 	cancel      context.CancelFunc
+	// replaced is set once the pool is taken over by the handler replacing this one.
+	replaced atomic.Bool
 }
 
 func NewHandler(opts ...handler.Option) handler.Handler {
@@ -73,21 +84,38 @@ func (h *tunnelHandler) Init(md md.Metadata) (err error) {
 		return err
 	}
 
-	uuid, err := uuid.NewRandom()
-	if err != nil {
-		return err
+	// the tunnels of the replaced handler are kept, the connections of
+	// their clients are drained on the replaced handler.
+	prev := getHandler(h.options.Service)
+	if prev != nil {
+		h.id = prev.id
+		h.pool = prev.pool
+		prev.closeEntrypoints()
+	} else {
+		uuid, err := uuid.NewRandom()
+		if err != nil {
+			return err
+		}
+		h.id = uuid.String()
+		h.pool = NewConnectorPool(h.id)
 	}
-	h.id = uuid.String()
 
 	h.log = h.options.Logger.WithFields(map[string]any{
 		"node": h.id,
 	})
-	h.pool = NewConnectorPool(h.id)
 
 	if err = h.initEntrypoints(); err != nil {
+		if prev == nil {
+			h.pool.Close()
+		}
 		return
 	}
 
+	if prev != nil {
+		prev.replaced.Store(true)
+	}
+	setHandler(h.options.Service, h)
+
 	ctx, cancel := context.WithCancel(context.Background())
 	h.cancel = cancel
 
@@ -107,6 +135,11 @@ func (h *tunnelHandler) Init(md md.Metadata) (err error) {
 	return nil
 }
 
+// ValidateMetadata checks the metadata only, Init also listens on the entrypoints.
+func (h *tunnelHandler) ValidateMetadata(md md.Metadata) (err error) {
+	return h.parseMetadata(md)
+}
+
 func (h *tunnelHandler) initEntrypoints() (err error) {
 	if h.md.entryPoint != "" {
 		svc, err := h.createEntrypointService(h.md.entryPoint, h.md.ingress)
@@ -328,10 +361,12 @@ func (h *tunnelHandler) Handle(ctx context.Context, conn net.Conn, opts ...handl
 
 // Close implements io.Closer interface.
 func (h *tunnelHandler) Close() error {
-	for _, ep := range h.entrypoints {
-		ep.Close()
+	removeHandler(h.options.Service, h)
+
+	h.closeEntrypoints()
+	if !h.replaced.Load() {
+		h.pool.Close()
 	}
-	h.pool.Close()
 
 	if h.cancel != nil {
 		h.cancel()
@@ -340,6 +375,41 @@ func (h *tunnelHandler) Close() error {
 	return nil
 }
 
+func (h *tunnelHandler) closeEntrypoints() {
+	for _, ep := range h.entrypoints {
+		ep.Close()
+	}
+}
+
+func getHandler(service string) *tunnelHandler {
+	if service == "" {
+		return nil
+	}
+	handlersMu.Lock()
+	defer handlersMu.Unlock()
+	return handlers[service]
+}
+
+func setHandler(service string, h *tunnelHandler) {
+	if service == "" {
+		return
+	}
+	handlersMu.Lock()
+	handlers[service] = h
+	handlersMu.Unlock()
+}
+
+func removeHandler(service string, h *tunnelHandler) {
+	if service == "" {
+		return
+	}
+	handlersMu.Lock()
+	if handlers[service] == h {
+		delete(handlers, service)
+	}
+	handlersMu.Unlock()
+}
+
 func (h *tunnelHandler) checkRateLimit(addr net.Addr) bool {
 	if h.options.RateLimiter == nil {
 		return true
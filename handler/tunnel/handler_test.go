@@ -0,0 +1,56 @@
+package tunnel
+
+import (
+	"net"
+	"testing"
+
+	"github.com/go-gost/core/handler"
+	"github.com/go-gost/relay"
+	xlogger "github.com/go-gost/x/logger"
+	mdx "github.com/go-gost/x/metadata"
+)
+
+func TestHandler_Replace(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	md := mdx.NewMetadata(map[string]any{"entrypoint": addr})
+	newHandler := func() *tunnelHandler {
+		return NewHandler(
+			handler.ServiceOption("tunnel-test"),
+			handler.LoggerOption(xlogger.Nop()),
+		).(*tunnelHandler)
+	}
+
+	h1 := newHandler()
+	if err := h1.Init(md); err != nil {
+		t.Fatal(err)
+	}
+
+	// the new handler listens on the entrypoint of the replaced one and keeps its tunnels.
+	h2 := newHandler()
+	if err := h2.Init(md); err != nil {
+		t.Fatal(err)
+	}
+	defer h2.Close()
+	if h2.pool != h1.pool || h2.id != h1.id {
+		t.Fatal("the connector pool is not taken over")
+	}
+
+	var tid relay.TunnelID
+	h2.pool.tunnels[tid.String()] = NewTunnel(h2.id, tid, 0)
+
+	h1.Close()
+	if h2.pool.tunnels[tid.String()] == nil || getHandler("tunnel-test") != h2 {
+		t.Fatal("the replaced handler closed the tunnels of the new one")
+	}
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
@@ -69,6 +69,11 @@ func (h *unixHandler) Init(md md.Metadata) (err error) {
 	return
 }
 
+// ValidateMetadata parses the metadata only, the certificate pool created by Init runs a cleanup goroutine.
+func (h *unixHandler) ValidateMetadata(md md.Metadata) error {
+	return h.parseMetadata(md)
+}
+
 // Forward implements handler.Forwarder.
 func (h *unixHandler) Forward(hop hop.Hop) {
 	h.hop = hop
@@ -0,0 +1,331 @@
+package hop
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-gost/core/chain"
+	"github.com/go-gost/core/logger"
+	"github.com/go-gost/core/resolver"
+	"github.com/go-gost/x/config"
+	node_parser "github.com/go-gost/x/config/parsing/node"
+	xlogger "github.com/go-gost/x/logger"
+	"github.com/go-gost/x/resolver/exchanger"
+	"github.com/miekg/dns"
+)
+
+// DNS record types of the node discovery.
+const (
+	DNSTypeSRV = "srv"
+	DNSTypeA   = "a"
+)
+
+const (
+	defaultDNSInterval = 30 * time.Second
+	defaultDNSTimeout  = 5 * time.Second
+	minDNSInterval     = time.Second
+)
+
+type DNSOptions struct {
+	// Name is the SRV record name, or the hostname expanded by its A/AAAA records.
+	Name string
+	// Type is the record type, srv or a.
+	Type string
+	// Port is the port of the nodes expanded from the A/AAAA records.
+	Port int
+	// Server is the name server of the SRV records, the system name server is used if it is empty.
+	Server string
+	// Resolver resolves the A/AAAA records, the system resolver is used if it is nil.
+	Resolver resolver.Resolver
+	// Interval is the refresh interval if the TTL of the records is unknown.
+	Interval time.Duration
+	Timeout  time.Duration
+	// Node is the template of the discovered nodes.
+	Node *config.NodeConfig
+}
+
+func DNSOption(opts *DNSOptions) Option {
+	return func(o *options) {
+		o.dns = opts
+	}
+}
+
+// dnsTarget is a discovered node address.
+type dnsTarget struct {
+	addr     string
+	priority int
+	weight   int
+	backup   bool
+}
+
+// dnsDiscovery discovers the nodes of a hop from the DNS records. The SRV records
+// are refreshed by their TTL, the records of the lowest priority are the primary nodes
+// and the others are the backup nodes, the weights are the node weights.
+type dnsDiscovery struct {
+	hop     string
+	options DNSOptions
+	ex      exchanger.Exchanger
+	logger  logger.Logger
+	// nodes are the discovered nodes by address, kept across the refreshes.
+	nodes map[string]*dnsNode
+}
+
+type dnsNode struct {
+	target dnsTarget
+	node   *chain.Node
+}
+
+// ValidateDNSOptions checks the discovery options, no record is looked up.
+func ValidateDNSOptions(opts DNSOptions) error {
+	_, err := newDNSDiscovery("", opts, xlogger.Nop())
+	return err
+}
+
+func newDNSDiscovery(hop string, opts DNSOptions, log logger.Logger) (*dnsDiscovery, error) {
+	opts.Type = strings.ToLower(strings.TrimSpace(opts.Type))
+	if opts.Type == "" {
+		opts.Type = DNSTypeSRV
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = defaultDNSInterval
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultDNSTimeout
+	}
+	if opts.Node == nil {
+		opts.Node = &config.NodeConfig{}
+	}
+
+	d := &dnsDiscovery{
+		hop:     hop,
+		options: opts,
+		logger:  log,
+		nodes:   make(map[string]*dnsNode),
+	}
+
+	switch opts.Type {
+	case DNSTypeSRV:
+		server := opts.Server
+		if server == "" {
+			cc, err := dns.ClientConfigFromFile("/etc/resolv.conf")
+			if err != nil {
+				return nil, err
+			}
+			if len(cc.Servers) == 0 {
+				return nil, fmt.Errorf("no name server for %s", opts.Name)
+			}
+			server = net.JoinHostPort(cc.Servers[0], cc.Port)
+		}
+		ex, err := exchanger.NewExchanger(server,
+			exchanger.TimeoutOption(opts.Timeout),
+			exchanger.LoggerOption(log),
+		)
+		if err != nil {
+			return nil, err
+		}
+		d.ex = ex
+	case DNSTypeA:
+		if opts.Port <= 0 {
+			return nil, fmt.Errorf("no port for %s", opts.Name)
+		}
+	default:
+		return nil, fmt.Errorf("unknown record type %s", opts.Type)
+	}
+
+	return d, nil
+}
+
+// run refreshes the nodes until the context is done, update is called with the discovered nodes.
+func (d *dnsDiscovery) run(ctx context.Context, update func([]*chain.Node)) {
+	for {
+		targets, ttl, err := d.lookup(ctx)
+		if err != nil {
+			d.logger.Warnf("dns %s: %v", d.options.Name, err)
+		} else {
+			update(d.parse(targets))
+		}
+
+		interval := d.options.Interval
+		if err == nil && ttl > 0 {
+			interval = ttl
+		}
+		if interval < minDNSInterval {
+			interval = minDNSInterval
+		}
+
+		timer := time.NewTimer(interval)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
+
+func (d *dnsDiscovery) lookup(ctx context.Context) ([]dnsTarget, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, d.options.Timeout)
+	defer cancel()
+
+	if d.options.Type == DNSTypeA {
+		targets, err := d.lookupHost(ctx)
+		return targets, 0, err
+	}
+	return d.lookupSRV(ctx)
+}
+
+func (d *dnsDiscovery) lookupSRV(ctx context.Context) ([]dnsTarget, time.Duration, error) {
+	mq := dns.Msg{}
+	mq.SetQuestion(dns.Fqdn(d.options.Name), dns.TypeSRV)
+	query, err := mq.Pack()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	reply, err := d.ex.Exchange(ctx, query)
+	if err != nil {
+		return nil, 0, err
+	}
+	mr := dns.Msg{}
+	if err := mr.Unpack(reply); err != nil {
+		return nil, 0, err
+	}
+	if mr.Rcode != dns.RcodeSuccess {
+		return nil, 0, fmt.Errorf("%s", dns.RcodeToString[mr.Rcode])
+	}
+
+	var records []*dns.SRV
+	var ttl uint32
+	for _, ans := range mr.Answer {
+		srv, _ := ans.(*dns.SRV)
+		if srv == nil || srv.Target == "." {
+			continue
+		}
+		records = append(records, srv)
+		if ttl == 0 || srv.Hdr.Ttl < ttl {
+			ttl = srv.Hdr.Ttl
+		}
+	}
+	if len(records) == 0 {
+		return nil, 0, fmt.Errorf("no SRV records")
+	}
+
+	primary := records[0].Priority
+	for _, srv := range records {
+		if srv.Priority < primary {
+			primary = srv.Priority
+		}
+	}
+
+	var targets []dnsTarget
+	for _, srv := range records {
+		targets = append(targets, dnsTarget{
+			addr:     net.JoinHostPort(strings.TrimSuffix(srv.Target, "."), strconv.Itoa(int(srv.Port))),
+			priority: -int(srv.Priority - primary),
+			weight:   int(srv.Weight),
+			backup:   srv.Priority != primary,
+		})
+	}
+	return targets, time.Duration(ttl) * time.Second, nil
+}
+
+func (d *dnsDiscovery) lookupHost(ctx context.Context) ([]dnsTarget, error) {
+	var ips []net.IP
+	if d.options.Resolver != nil {
+		v, err := d.options.Resolver.Resolve(ctx, "ip", d.options.Name)
+		if err != nil {
+			return nil, err
+		}
+		ips = v
+	} else {
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, d.options.Name)
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range addrs {
+			ips = append(ips, addr.IP)
+		}
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("no address")
+	}
+
+	var targets []dnsTarget
+	for _, ip := range ips {
+		targets = append(targets, dnsTarget{
+			addr: net.JoinHostPort(ip.String(), strconv.Itoa(d.options.Port)),
+		})
+	}
+	return targets, nil
+}
+
+// parse creates the nodes of the targets from the template,
+// the nodes of the unchanged targets are kept with their states.
+func (d *dnsDiscovery) parse(targets []dnsTarget) []*chain.Node {
+	sort.Slice(targets, func(i, j int) bool {
+		return targets[i].addr < targets[j].addr
+	})
+
+	nodes := make(map[string]*dnsNode, len(targets))
+	var list []*chain.Node
+	for _, t := range targets {
+		if _, ok := nodes[t.addr]; ok {
+			continue
+		}
+
+		if v := d.nodes[t.addr]; v != nil && v.target == t {
+			nodes[t.addr] = v
+			list = append(list, v.node)
+			continue
+		}
+
+		node, err := node_parser.ParseNode(d.hop, d.nodeConfig(t), d.logger)
+		if err != nil {
+			d.logger.Errorf("dns %s: node %s: %v", d.options.Name, t.addr, err)
+			continue
+		}
+		nodes[t.addr] = &dnsNode{target: t, node: node}
+		list = append(list, node)
+	}
+	for addr, v := range d.nodes {
+		if nodes[addr] != v {
+			closeNode(v.node)
+		}
+	}
+	d.nodes = nodes
+
+	return list
+}
+
+func (d *dnsDiscovery) nodeConfig(t dnsTarget) *config.NodeConfig {
+	nc := *d.options.Node
+
+	name := nc.Name
+	if name == "" {
+		name = d.hop
+	}
+	nc.Name = fmt.Sprintf("%s-%s", name, t.addr)
+	nc.Addr = t.addr
+
+	md := make(map[string]any, len(nc.Metadata)+2)
+	for k, v := range nc.Metadata {
+		md[k] = v
+	}
+	if t.weight > 0 {
+		md["weight"] = t.weight
+	}
+	if t.backup {
+		md["backup"] = true
+	}
+	nc.Metadata = md
+
+	if t.priority != 0 && nc.Matcher == nil {
+		nc.Matcher = &config.NodeMatcherConfig{Priority: t.priority}
+	}
+	return &nc
+}
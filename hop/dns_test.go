@@ -0,0 +1,180 @@
+package hop
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-gost/core/chain"
+	"github.com/go-gost/core/connector"
+	"github.com/go-gost/core/dialer"
+	"github.com/go-gost/core/metadata"
+	"github.com/go-gost/core/resolver"
+	"github.com/go-gost/x/config"
+	xlogger "github.com/go-gost/x/logger"
+	"github.com/go-gost/x/registry"
+	"github.com/miekg/dns"
+)
+
+type testConnector struct{}
+
+func (c *testConnector) Init(metadata.Metadata) error { return nil }
+
+func (c *testConnector) Connect(ctx context.Context, conn net.Conn, network, address string, opts ...connector.ConnectOption) (net.Conn, error) {
+	return conn, nil
+}
+
+type testDialer struct{}
+
+func (d *testDialer) Init(metadata.Metadata) error { return nil }
+
+func (d *testDialer) Dial(ctx context.Context, addr string, opts ...dialer.DialOption) (net.Conn, error) {
+	return nil, net.ErrClosed
+}
+
+func init() {
+	registry.ConnectorRegistry().Register("dnstest", func(opts ...connector.Option) connector.Connector { return &testConnector{} })
+	registry.DialerRegistry().Register("dnstest", func(opts ...dialer.Option) dialer.Dialer { return &testDialer{} })
+}
+
+// testNode is the node template with the test connector and dialer.
+func testNode() *config.NodeConfig {
+	return &config.NodeConfig{
+		Connector: &config.ConnectorConfig{Type: "dnstest"},
+		Dialer:    &config.DialerConfig{Type: "dnstest"},
+	}
+}
+
+func serveSRV(t *testing.T, records func() []dns.RR) string {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &dns.Server{
+		PacketConn: pc,
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+			m := new(dns.Msg)
+			m.SetReply(r)
+			m.Answer = records()
+			w.WriteMsg(m)
+		}),
+	}
+	go srv.ActivateAndServe()
+	t.Cleanup(func() { srv.Shutdown() })
+	return pc.LocalAddr().String()
+}
+
+func srvRecord(target string, port, priority, weight uint16, ttl uint32) dns.RR {
+	return &dns.SRV{
+		Hdr:      dns.RR_Header{Name: "_proxy._tcp.example.", Rrtype: dns.TypeSRV, Class: dns.ClassINET, Ttl: ttl},
+		Priority: priority,
+		Weight:   weight,
+		Port:     port,
+		Target:   target,
+	}
+}
+
+func TestDNSDiscovery_SRV(t *testing.T) {
+	var records atomic.Pointer[[]dns.RR]
+	records.Store(&[]dns.RR{
+		srvRecord("a.example.", 8080, 10, 3, 60),
+		srvRecord("b.example.", 8080, 10, 1, 30),
+		srvRecord("c.example.", 8080, 20, 1, 60),
+	})
+	server := serveSRV(t, func() []dns.RR { return *records.Load() })
+
+	d, err := newDNSDiscovery("hop-0", DNSOptions{Name: "_proxy._tcp.example", Server: server, Node: testNode()}, xlogger.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	targets, ttl, err := d.lookup(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttl != 30*time.Second {
+		t.Errorf("expected ttl 30s, got %v", ttl)
+	}
+	nodes := d.parse(targets)
+	if len(nodes) != 3 {
+		t.Fatalf("expected 3 nodes, got %d", len(nodes))
+	}
+
+	a, b, c := nodes[0], nodes[1], nodes[2]
+	if a.Name != "hop-0-a.example:8080" || a.Addr != "a.example:8080" {
+		t.Errorf("unexpected node %s %s", a.Name, a.Addr)
+	}
+	if v := a.Metadata().Get("weight"); v != 3 {
+		t.Errorf("expected weight 3, got %v", v)
+	}
+	if b.Options().Priority != 0 || b.Metadata().Get("backup") != nil {
+		t.Errorf("unexpected primary node %s", b.Name)
+	}
+	if c.Options().Priority >= 0 || c.Metadata().Get("backup") != true {
+		t.Errorf("unexpected backup node %s", c.Name)
+	}
+
+	// the unchanged nodes are kept with their states, the changed ones are recreated.
+	records.Store(&[]dns.RR{
+		srvRecord("a.example.", 8080, 10, 3, 60),
+		srvRecord("b.example.", 8080, 10, 2, 30),
+	})
+	targets, _, err = d.lookup(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodes = d.parse(targets)
+	if len(nodes) != 2 || nodes[0] != a || nodes[1] == b {
+		t.Fatalf("unexpected nodes %v", nodes)
+	}
+}
+
+type testResolver struct {
+	ips []net.IP
+}
+
+func (r *testResolver) Resolve(ctx context.Context, network, host string, opts ...resolver.Option) ([]net.IP, error) {
+	return r.ips, nil
+}
+
+func TestDNSDiscovery_A(t *testing.T) {
+	if _, err := newDNSDiscovery("hop-0", DNSOptions{Name: "proxy.example", Type: "A"}, xlogger.Nop()); err == nil {
+		t.Fatal("expected error without port")
+	}
+
+	r := &testResolver{ips: []net.IP{net.ParseIP("10.0.0.2"), net.ParseIP("fd00::1"), net.ParseIP("10.0.0.1")}}
+	d, err := newDNSDiscovery("hop-0", DNSOptions{Name: "proxy.example", Type: "A", Port: 1080, Resolver: r, Node: testNode()}, xlogger.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := &chainHop{
+		options: options{nodes: []*chain.Node{chain.NewNode("static", "127.0.0.1:1")}},
+		logger:  xlogger.Nop(),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	go d.run(ctx, func(nodes []*chain.Node) {
+		h.setDiscovered(nodes)
+		close(done)
+		cancel()
+	})
+	<-done
+
+	var addrs []string
+	for _, node := range h.Nodes() {
+		addrs = append(addrs, node.Addr)
+	}
+	expected := []string{"127.0.0.1:1", "10.0.0.1:1080", "10.0.0.2:1080", "[fd00::1]:1080"}
+	if len(addrs) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, addrs)
+	}
+	for i := range expected {
+		if addrs[i] != expected[i] {
+			t.Fatalf("expected %v, got %v", expected, addrs)
+		}
+	}
+}
@@ -0,0 +1,405 @@
+package hop
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-gost/core/chain"
+	"github.com/go-gost/core/logger"
+	"github.com/go-gost/core/metrics"
+	xnet "github.com/go-gost/x/internal/net"
+	xmetrics "github.com/go-gost/x/metrics"
+	xselector "github.com/go-gost/x/selector"
+)
+
+// health check types.
+const (
+	// HealthCheckTCP connects to the node address.
+	HealthCheckTCP = "tcp"
+	// HealthCheckTLS completes a TLS handshake with the node.
+	HealthCheckTLS = "tls"
+	// HealthCheckHTTP sends a GET request to the URL through the node transport.
+	HealthCheckHTTP = "http"
+	// HealthCheckConnect connects to the target through the node transport.
+	HealthCheckConnect = "connect"
+)
+
+const (
+	defaultHealthCheckInterval = 10 * time.Second
+	defaultHealthCheckTimeout  = 5 * time.Second
+	defaultHealthCheckRise     = 2
+	defaultHealthCheckFall     = 3
+)
+
+var (
+	ErrHealthCheckStatus = errors.New("unexpected status")
+)
+
+type HealthCheckOptions struct {
+	// Type is the probe type, tcp, tls, http or connect.
+	Type     string
+	Interval time.Duration
+	Timeout  time.Duration
+	// Rise is the number of consecutive successful probes for a down node to be up,
+	// Fall is the number of consecutive failed probes for an up node to be down.
+	Rise int
+	Fall int
+	// URL is the URL requested by the http probe.
+	URL string
+	// Target is the address connected to by the connect probe.
+	Target string
+}
+
+func HealthCheckOption(opts *HealthCheckOptions) Option {
+	return func(o *options) {
+		o.healthCheck = opts
+	}
+}
+
+// NodeHealth is the health state of a node.
+type NodeHealth struct {
+	Node string `json:"node"`
+	Addr string `json:"addr"`
+	Up   bool   `json:"up"`
+	// Successes and Failures are the numbers of consecutive successful and failed probes.
+	Successes int           `json:"successes"`
+	Failures  int           `json:"failures"`
+	LastCheck time.Time     `json:"lastCheck,omitempty"`
+	Latency   time.Duration `json:"latency,omitempty"`
+	Error     string        `json:"error,omitempty"`
+}
+
+var healthCheckers sync.Map
+
+// Health returns the health states of the nodes of the hop,
+// ok is false if the hop does not check the health of its nodes.
+func Health(hop string) (nodes []NodeHealth, ok bool) {
+	v, ok := healthCheckers.Load(hop)
+	if !ok {
+		return nil, false
+	}
+	return v.(*healthChecker).Health(), true
+}
+
+// healthChecker probes the nodes of a hop periodically. A node is up until
+// it fails the Fall consecutive probes, then down until it passes the Rise consecutive probes.
+type healthChecker struct {
+	hop     string
+	options HealthCheckOptions
+	logger  logger.Logger
+
+	mu    sync.RWMutex
+	nodes map[string]*NodeHealth
+}
+
+func newHealthChecker(hop string, opts HealthCheckOptions, log logger.Logger) *healthChecker {
+	opts.Type = strings.ToLower(strings.TrimSpace(opts.Type))
+	if opts.Type == "" {
+		opts.Type = HealthCheckTCP
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = defaultHealthCheckInterval
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultHealthCheckTimeout
+	}
+	if opts.Rise <= 0 {
+		opts.Rise = defaultHealthCheckRise
+	}
+	if opts.Fall <= 0 {
+		opts.Fall = defaultHealthCheckFall
+	}
+
+	return &healthChecker{
+		hop:     hop,
+		options: opts,
+		logger:  log,
+		nodes:   make(map[string]*NodeHealth),
+	}
+}
+
+// IsUp reports whether the node is up, the nodes not probed yet are up.
+func (c *healthChecker) IsUp(node *chain.Node) bool {
+	if c == nil || node == nil {
+		return true
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if h := c.nodes[node.Name]; h != nil {
+		return h.Up
+	}
+	return true
+}
+
+func (c *healthChecker) Health() []NodeHealth {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	nodes := make([]NodeHealth, 0, len(c.nodes))
+	for _, h := range c.nodes {
+		nodes = append(nodes, *h)
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].Node < nodes[j].Node
+	})
+	return nodes
+}
+
+func (c *healthChecker) run(ctx context.Context, nodes func() []*chain.Node) {
+	ticker := time.NewTicker(c.options.Interval)
+	defer ticker.Stop()
+
+	for {
+		c.checkAll(ctx, nodes())
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// checkAll probes the nodes concurrently and forgets the nodes removed from the hop.
+func (c *healthChecker) checkAll(ctx context.Context, nodes []*chain.Node) {
+	names := make(map[string]struct{}, len(nodes))
+
+	var wg sync.WaitGroup
+	for _, node := range nodes {
+		if node == nil {
+			continue
+		}
+		names[node.Name] = struct{}{}
+
+		wg.Add(1)
+		go func(node *chain.Node) {
+			defer wg.Done()
+			start := time.Now()
+			err := c.check(ctx, node)
+			c.update(node, start, time.Since(start), err)
+		}(node)
+	}
+	wg.Wait()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for name := range c.nodes {
+		if _, ok := names[name]; !ok {
+			delete(c.nodes, name)
+		}
+	}
+}
+
+func (c *healthChecker) update(node *chain.Node, t time.Time, d time.Duration, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	h := c.nodes[node.Name]
+	if h == nil {
+		h = &NodeHealth{Node: node.Name, Up: true}
+		c.nodes[node.Name] = h
+	}
+	h.Addr = node.Addr
+	h.LastCheck = t
+	h.Latency = d
+
+	up := h.Up
+	if err == nil {
+		h.Error = ""
+		h.Successes++
+		h.Failures = 0
+		if !h.Up && h.Successes >= c.options.Rise {
+			h.Up = true
+		}
+	} else {
+		h.Error = err.Error()
+		h.Failures++
+		h.Successes = 0
+		if h.Up && h.Failures >= c.options.Fall {
+			h.Up = false
+		}
+	}
+
+	if h.Up != up {
+		if h.Up {
+			c.logger.Infof("node %s(%s) is up", node.Name, node.Addr)
+		} else {
+			c.logger.Warnf("node %s(%s) is down: %v", node.Name, node.Addr, err)
+		}
+	}
+	if err != nil {
+		c.logger.Debugf("health check %s(%s): %v", node.Name, node.Addr, err)
+	}
+
+	labels := metrics.Labels{"hop": c.hop, "node": node.Name}
+	if v := xmetrics.GetGauge(xmetrics.MetricHopNodeUpGauge, labels); v != nil {
+		if h.Up {
+			v.Set(1)
+		} else {
+			v.Set(0)
+		}
+	}
+	if err == nil {
+		// the probe latency feeds the lowest-latency strategy.
+		xselector.StatsOf(node).ObserveLatency(d)
+		if v := xmetrics.GetObserver(xmetrics.MetricHopNodeHealthCheckDurationObserver, labels); v != nil {
+			v.Observe(d.Seconds())
+		}
+	} else {
+		if v := xmetrics.GetCounter(xmetrics.MetricHopNodeHealthCheckErrorsCounter, labels); v != nil {
+			v.Inc()
+		}
+	}
+}
+
+func (c *healthChecker) check(ctx context.Context, node *chain.Node) error {
+	ctx, cancel := context.WithTimeout(ctx, c.options.Timeout)
+	defer cancel()
+
+	addr, err := xnet.Resolve(ctx, "ip", node.Addr, node.Options().Resolver, node.Options().HostMapper, c.logger)
+	if err != nil {
+		return err
+	}
+
+	switch c.options.Type {
+	case HealthCheckTCP:
+		return c.checkTCP(ctx, addr)
+	case HealthCheckTLS:
+		return c.checkTLS(ctx, node, addr)
+	case HealthCheckHTTP:
+		return c.checkHTTP(ctx, node, addr)
+	case HealthCheckConnect:
+		return c.checkConnect(ctx, node, addr)
+	default:
+		return fmt.Errorf("unknown health check type %s", c.options.Type)
+	}
+}
+
+func (c *healthChecker) checkTCP(ctx context.Context, addr string) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func (c *healthChecker) checkTLS(ctx context.Context, node *chain.Node, addr string) error {
+	cfg := &tls.Config{
+		InsecureSkipVerify: true,
+	}
+	if host, _, _ := net.SplitHostPort(node.Addr); host != "" {
+		cfg.ServerName = host
+	}
+	if settings := node.Options().TLS; settings != nil {
+		if settings.ServerName != "" {
+			cfg.ServerName = settings.ServerName
+		}
+		cfg.InsecureSkipVerify = !settings.Secure
+	}
+
+	d := tls.Dialer{Config: cfg}
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// connect connects to the address through the node transport.
+func (c *healthChecker) connect(ctx context.Context, node *chain.Node, addr, address string) (net.Conn, error) {
+	tr := node.Options().Transport
+	if tr == nil {
+		return nil, fmt.Errorf("node %s has no transport", node.Name)
+	}
+
+	cc, err := tr.Dial(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	cn, err := tr.Handshake(ctx, cc)
+	if err != nil {
+		cc.Close()
+		return nil, err
+	}
+	conn, err := tr.Connect(ctx, cn, "tcp", address)
+	if err != nil {
+		cn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (c *healthChecker) checkConnect(ctx context.Context, node *chain.Node, addr string) error {
+	conn, err := c.connect(ctx, node, addr, c.options.Target)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func (c *healthChecker) checkHTTP(ctx context.Context, node *chain.Node, addr string) error {
+	u, err := url.Parse(c.options.URL)
+	if err != nil {
+		return err
+	}
+	address := u.Host
+	if u.Port() == "" {
+		port := "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+		address = net.JoinHostPort(u.Hostname(), port)
+	}
+
+	conn, err := c.connect(ctx, node, addr, address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if u.Scheme == "https" {
+		tc := tls.Client(conn, &tls.Config{ServerName: u.Hostname()})
+		if err := tc.HandshakeContext(ctx); err != nil {
+			return err
+		}
+		conn = tc
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "gost-health-check")
+	req.Close = true
+	if err := req.Write(conn); err != nil {
+		return err
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("%w %s", ErrHealthCheckStatus, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package hop
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-gost/core/chain"
+	"github.com/go-gost/core/connector"
+	xlogger "github.com/go-gost/x/logger"
+)
+
+func TestHealthChecker_RiseFall(t *testing.T) {
+	c := newHealthChecker("hop-0", HealthCheckOptions{Rise: 2, Fall: 2}, xlogger.Nop())
+	node := chain.NewNode("node-0", "127.0.0.1:1")
+
+	errProbe := errors.New("probe failed")
+	for i, v := range []struct {
+		err error
+		up  bool
+	}{
+		{nil, true},
+		{errProbe, true},
+		{errProbe, false},
+		{nil, false},
+		{errProbe, false},
+		{nil, false},
+		{nil, true},
+	} {
+		c.update(node, time.Now(), 0, v.err)
+		if up := c.IsUp(node); up != v.up {
+			t.Fatalf("probe %d: expected up=%t, got %t", i, v.up, up)
+		}
+	}
+}
+
+func TestHealthChecker_TCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+
+	up := chain.NewNode("up", ln.Addr().String())
+	down := chain.NewNode("down", closed.Addr().String())
+	nodes := []*chain.Node{up, down}
+
+	h := &chainHop{
+		nodes:      nodes,
+		options:    options{name: "health-tcp"},
+		logger:     xlogger.Nop(),
+		cancelFunc: func() {},
+		health:     newHealthChecker("health-tcp", HealthCheckOptions{Fall: 1}, xlogger.Nop()),
+	}
+	healthCheckers.Store("health-tcp", h.health)
+	defer h.Close()
+	defer ln.Close()
+
+	h.health.checkAll(context.Background(), nodes)
+
+	states, ok := Health("health-tcp")
+	if !ok || len(states) != 2 {
+		t.Fatalf("unexpected health %v", states)
+	}
+	if states[0].Node != "down" || states[0].Up || states[0].Error == "" {
+		t.Errorf("unexpected health %+v", states[0])
+	}
+	if states[1].Node != "up" || !states[1].Up || states[1].Successes != 1 {
+		t.Errorf("unexpected health %+v", states[1])
+	}
+
+	for i := 0; i < 4; i++ {
+		if node := h.Select(context.Background()); node != up {
+			t.Fatalf("down node selected: %v", node)
+		}
+	}
+
+	// the nodes are still selected when none is up.
+	h.health.update(up, time.Now(), 0, errors.New("probe failed"))
+	if node := h.Select(context.Background()); node == nil {
+		t.Fatal("no node selected")
+	}
+
+	// the removed nodes are forgotten.
+	h.health.checkAll(context.Background(), []*chain.Node{down})
+	if states, _ := Health("health-tcp"); len(states) != 1 {
+		t.Fatalf("removed node kept: %v", states)
+	}
+
+	h.Close()
+	if _, ok := Health("health-tcp"); ok {
+		t.Fatal("health kept after close")
+	}
+}
+
+type testTransport struct {
+	chain.Transporter
+	target string
+}
+
+func (tr *testTransport) Dial(ctx context.Context, addr string) (net.Conn, error) {
+	c1, c2 := net.Pipe()
+	c2.Close()
+	return c1, nil
+}
+
+func (tr *testTransport) Handshake(ctx context.Context, conn net.Conn) (net.Conn, error) {
+	return conn, nil
+}
+
+func (tr *testTransport) Connect(ctx context.Context, conn net.Conn, network, address string) (net.Conn, error) {
+	if address != tr.target {
+		return nil, errors.New("connection refused")
+	}
+	return conn, nil
+}
+
+func (tr *testTransport) Bind(ctx context.Context, conn net.Conn, network, address string, opts ...connector.BindOption) (net.Listener, error) {
+	return nil, errors.New("unsupported")
+}
+
+func TestHealthChecker_Connect(t *testing.T) {
+	c := newHealthChecker("hop-0", HealthCheckOptions{Type: "CONNECT", Target: "probe.example:443"}, xlogger.Nop())
+
+	ok := chain.NewNode("ok", "127.0.0.1:1", chain.TransportNodeOption(&testTransport{target: "probe.example:443"}))
+	if err := c.check(context.Background(), ok); err != nil {
+		t.Fatal(err)
+	}
+	bad := chain.NewNode("bad", "127.0.0.1:1", chain.TransportNodeOption(&testTransport{target: "other.example:443"}))
+	if err := c.check(context.Background(), bad); err == nil {
+		t.Fatal("expected error")
+	}
+}
@@ -18,6 +18,7 @@ import (
 	"github.com/go-gost/core/selector"
 	"github.com/go-gost/x/config"
 	node_parser "github.com/go-gost/x/config/parsing/node"
+	xctx "github.com/go-gost/x/ctx"
 	"github.com/go-gost/x/internal/loader"
 	xlogger "github.com/go-gost/x/logger"
 )
@@ -31,6 +32,9 @@ type options struct {
 	redisLoader loader.Loader
 	httpLoader  loader.Loader
 	period      time.Duration
+	healthCheck *HealthCheckOptions
+	sticky      *StickyOptions
+	dns         *DNSOptions
 	logger      logger.Logger
 }
 
@@ -89,11 +93,16 @@ func LoggerOption(logger logger.Logger) Option {
 }
 
 type chainHop struct {
-	nodes      []*chain.Node
+	nodes []*chain.Node
+	// loaded are the nodes from the loaders, discovered are the nodes from DNS.
+	loaded     []*chain.Node
+	discovered []*chain.Node
 	options    options
 	logger     logger.Logger
 	mu         sync.RWMutex
 	cancelFunc context.CancelFunc
+	health     *healthChecker
+	sticky     *stickyTable
 }
 
 func NewHop(opts ...Option) hop.Hop {
@@ -116,8 +125,29 @@ func NewHop(opts ...Option) hop.Hop {
 		p.logger = xlogger.Nop()
 	}
 
+	if options.sticky != nil {
+		p.sticky = newStickyTable(*options.sticky)
+	}
+
 	go p.periodReload(ctx)
 
+	if options.dns != nil {
+		d, err := newDNSDiscovery(options.name, *options.dns, p.logger)
+		if err != nil {
+			p.logger.Errorf("dns: %v", err)
+		} else {
+			go d.run(ctx, p.setDiscovered)
+		}
+	}
+
+	if options.healthCheck != nil {
+		p.health = newHealthChecker(options.name, *options.healthCheck, p.logger)
+		if options.name != "" {
+			healthCheckers.Store(options.name, p.health)
+		}
+		go p.health.run(ctx, p.Nodes)
+	}
+
 	return p
 }
 
@@ -177,6 +207,7 @@ func (p *chainHop) Select(ctx context.Context, opts ...hop.SelectOption) *chain.
 
 		nodes = append(nodes, node)
 	}
+	nodes = p.filterHealthy(nodes)
 	if len(nodes) == 0 {
 		return nil
 	}
@@ -192,10 +223,51 @@ func (p *chainHop) Select(ctx context.Context, opts ...hop.SelectOption) *chain.
 		return nodes[0]
 	}
 
+	var session string
+	if p.sticky != nil {
+		if session = p.sticky.key(ctx, &options); session != "" {
+			if node := p.sticky.get(session, nodes); node != nil {
+				log.Debugf("session sticks to node %s", node.Name)
+				return node
+			}
+		}
+	}
+
+	node := nodes[0]
 	if s := p.options.selector; s != nil {
-		return s.Select(ctx, nodes...)
+		host := options.Host
+		if host == "" {
+			host = options.Addr
+		}
+		if h, _, _ := net.SplitHostPort(host); h != "" {
+			host = h
+		}
+		node = s.Select(xctx.ContextWithTarget(ctx, host), nodes...)
+	}
+	if session != "" && node != nil {
+		p.sticky.set(session, node)
+	}
+	return node
+}
+
+// filterHealthy filters the nodes down by the health checks.
+// All the nodes are kept if none is up, so that the hop still works if the probes fail on their own.
+func (p *chainHop) filterHealthy(nodes []*chain.Node) []*chain.Node {
+	if p.health == nil || len(nodes) == 0 {
+		return nodes
+	}
+
+	var l []*chain.Node
+	for _, node := range nodes {
+		if p.health.IsUp(node) {
+			l = append(l, node)
+		}
+	}
+	if len(l) == 0 {
+		p.logger.Debugf("all %d nodes are down", len(nodes))
+		return nodes
 	}
-	return nodes[0]
+	return l
 }
 
 func (p *chainHop) isEligible(node *chain.Node, opts *hop.SelectOptions) bool {
@@ -291,20 +363,38 @@ func (p *chainHop) periodReload(ctx context.Context) error {
 }
 
 func (p *chainHop) reload(ctx context.Context) (err error) {
-	nodes := p.options.nodes
-
 	nl, err := p.load(ctx)
 
-	nodes = append(nodes, nl...)
+	p.mu.Lock()
+	defer p.mu.Unlock()
 
-	p.logger.Debugf("load items %d", len(nodes))
+	// the loaded nodes are parsed again by each reload.
+	for _, node := range p.loaded {
+		closeNode(node)
+	}
+	p.loaded = nl
+	p.nodes = p.merge()
+
+	p.logger.Debugf("load items %d", len(p.nodes))
+
+	return
+}
 
+func (p *chainHop) setDiscovered(nodes []*chain.Node) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	p.nodes = nodes
+	p.discovered = nodes
+	p.nodes = p.merge()
 
-	return
+	p.logger.Debugf("discover items %d", len(nodes))
+}
+
+func (p *chainHop) merge() []*chain.Node {
+	nodes := make([]*chain.Node, 0, len(p.options.nodes)+len(p.loaded)+len(p.discovered))
+	nodes = append(nodes, p.options.nodes...)
+	nodes = append(nodes, p.loaded...)
+	return append(nodes, p.discovered...)
 }
 
 func (p *chainHop) load(ctx context.Context) (nodes []*chain.Node, err error) {
@@ -365,6 +455,12 @@ func (p *chainHop) parseNode(r io.Reader) ([]*chain.Node, error) {
 
 func (p *chainHop) Close() error {
 	p.cancelFunc()
+	for _, node := range p.Nodes() {
+		closeNode(node)
+	}
+	if p.health != nil {
+		healthCheckers.CompareAndDelete(p.options.name, p.health)
+	}
 	if p.options.fileLoader != nil {
 		p.options.fileLoader.Close()
 	}
@@ -373,3 +469,13 @@ func (p *chainHop) Close() error {
 	}
 	return nil
 }
+
+// closeNode releases the resources of the node transport, such as its connection pool.
+func closeNode(node *chain.Node) {
+	if node == nil || node.Options() == nil {
+		return
+	}
+	if closer, ok := node.Options().Transport.(io.Closer); ok {
+		closer.Close()
+	}
+}
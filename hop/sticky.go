@@ -0,0 +1,183 @@
+package hop
+
+import (
+	"container/list"
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-gost/core/chain"
+	"github.com/go-gost/core/hop"
+	xctx "github.com/go-gost/x/ctx"
+	xselector "github.com/go-gost/x/selector"
+)
+
+// session affinity keys.
+const (
+	// StickyClientIP pins the client IP.
+	StickyClientIP = "ip"
+	// StickyAuth pins the authenticated client ID.
+	StickyAuth = "auth"
+	// StickyCookie pins the value of the HTTP cookie.
+	StickyCookie = "cookie"
+	// StickyHeader pins the value of the HTTP header.
+	StickyHeader = "header"
+)
+
+const (
+	defaultStickyTTL        = 30 * time.Minute
+	defaultStickyMaxEntries = 65536
+)
+
+type StickyOptions struct {
+	// Key is the session affinity key, ip, auth, cookie or header.
+	Key string
+	// Name is the name of the cookie or header.
+	Name string
+	// TTL is the time a session is pinned after it is last used.
+	TTL time.Duration
+	// MaxEntries is the maximum number of the pinned sessions,
+	// the least recently used session is unpinned beyond it.
+	MaxEntries int
+}
+
+func StickyOption(opts *StickyOptions) Option {
+	return func(o *options) {
+		o.sticky = opts
+	}
+}
+
+type stickyEntry struct {
+	key     string
+	node    string
+	expires time.Time
+}
+
+// stickyTable pins the sessions to the nodes. A session pinned to a node
+// not selectable or failed falls back to the selector and is pinned again.
+// The sessions are kept in the order of use, the expired and the least recently
+// used sessions are removed from the back of the list.
+type stickyTable struct {
+	options StickyOptions
+
+	mu       sync.Mutex
+	sessions map[string]*list.Element
+	lru      *list.List
+}
+
+func newStickyTable(opts StickyOptions) *stickyTable {
+	opts.Key = strings.ToLower(strings.TrimSpace(opts.Key))
+	if opts.Key == "" || opts.Key == "client" {
+		opts.Key = StickyClientIP
+	}
+	if opts.TTL <= 0 {
+		opts.TTL = defaultStickyTTL
+	}
+	if opts.MaxEntries <= 0 {
+		opts.MaxEntries = defaultStickyMaxEntries
+	}
+
+	return &stickyTable{
+		options:  opts,
+		sessions: make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+// key returns the session key of the request, empty if the request has no session.
+func (t *stickyTable) key(ctx context.Context, options *hop.SelectOptions) string {
+	switch t.options.Key {
+	case StickyClientIP:
+		if options.ClientIP != nil {
+			return options.ClientIP.String()
+		}
+		if addr := xctx.SrcAddrFromContext(ctx); addr != nil {
+			if host, _, _ := net.SplitHostPort(addr.String()); host != "" {
+				return host
+			}
+			return addr.String()
+		}
+	case StickyAuth:
+		return string(xctx.ClientIDFromContext(ctx))
+	case StickyCookie:
+		if options.Header != nil {
+			req := http.Request{Header: options.Header}
+			if c, _ := req.Cookie(t.options.Name); c != nil {
+				return c.Value
+			}
+		}
+	case StickyHeader:
+		if options.Header != nil {
+			return options.Header.Get(t.options.Name)
+		}
+	}
+	return ""
+}
+
+// get returns the node pinned by the session among the nodes.
+func (t *stickyTable) get(key string, nodes []*chain.Node) *chain.Node {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	elem := t.sessions[key]
+	if elem == nil {
+		return nil
+	}
+	e := elem.Value.(*stickyEntry)
+	now := time.Now()
+	if now.After(e.expires) {
+		t.remove(elem)
+		return nil
+	}
+
+	for _, node := range nodes {
+		if node.Name != e.node {
+			continue
+		}
+		if marker := node.Marker(); marker != nil && marker.Count() > 0 {
+			return nil
+		}
+		if !xselector.LookupBreaker(node).Allow() {
+			return nil
+		}
+		e.expires = now.Add(t.options.TTL)
+		t.lru.MoveToFront(elem)
+		return node
+	}
+	return nil
+}
+
+func (t *stickyTable) set(key string, node *chain.Node) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	if elem := t.sessions[key]; elem != nil {
+		e := elem.Value.(*stickyEntry)
+		e.node = node.Name
+		e.expires = now.Add(t.options.TTL)
+		t.lru.MoveToFront(elem)
+	} else {
+		t.sessions[key] = t.lru.PushFront(&stickyEntry{
+			key:     key,
+			node:    node.Name,
+			expires: now.Add(t.options.TTL),
+		})
+	}
+
+	// the sessions expire in the order of use, the back of the list expires first.
+	for elem := t.lru.Back(); elem != nil; elem = t.lru.Back() {
+		if t.lru.Len() <= t.options.MaxEntries && !now.After(elem.Value.(*stickyEntry).expires) {
+			break
+		}
+		t.remove(elem)
+	}
+}
+
+func (t *stickyTable) remove(elem *list.Element) {
+	t.lru.Remove(elem)
+	delete(t.sessions, elem.Value.(*stickyEntry).key)
+}
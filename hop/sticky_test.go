@@ -0,0 +1,151 @@
+package hop
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/go-gost/core/chain"
+	"github.com/go-gost/core/hop"
+	xctx "github.com/go-gost/x/ctx"
+	xlogger "github.com/go-gost/x/logger"
+	xselector "github.com/go-gost/x/selector"
+)
+
+func stickyHop(opts StickyOptions, nodes ...*chain.Node) *chainHop {
+	return &chainHop{
+		nodes: nodes,
+		options: options{
+			selector: xselector.NewSelector(
+				xselector.RoundRobinStrategy[*chain.Node](),
+				xselector.FailFilter[*chain.Node](1, time.Minute),
+			),
+		},
+		logger:     xlogger.Nop(),
+		cancelFunc: func() {},
+		sticky:     newStickyTable(opts),
+	}
+}
+
+func TestSticky_ClientIP(t *testing.T) {
+	nodes := []*chain.Node{
+		chain.NewNode("a", "127.0.0.1:1"),
+		chain.NewNode("b", "127.0.0.1:2"),
+		chain.NewNode("c", "127.0.0.1:3"),
+	}
+	h := stickyHop(StickyOptions{}, nodes...)
+
+	ctx := xctx.ContextWithSrcAddr(context.Background(), &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1000})
+	node := h.Select(ctx)
+	for i := 0; i < 5; i++ {
+		if v := h.Select(ctx); v != node {
+			t.Fatalf("expected %s, got %s", node.Name, v.Name)
+		}
+	}
+
+	// another client is balanced by the selector.
+	other := xctx.ContextWithSrcAddr(context.Background(), &net.TCPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 1000})
+	if v := h.Select(other); v == node {
+		t.Fatalf("unexpected node %s", v.Name)
+	}
+
+	// the session falls back to the selector when the pinned node fails, and sticks to the new node.
+	node.Marker().Mark()
+	fallback := h.Select(ctx)
+	if fallback == node {
+		t.Fatal("failed node selected")
+	}
+	node.Marker().Reset()
+	if v := h.Select(ctx); v != fallback {
+		t.Fatalf("expected %s, got %s", fallback.Name, v.Name)
+	}
+
+	// the reloaded node keeps the session.
+	reloaded := []*chain.Node{nodes[0].Copy(), nodes[1].Copy(), nodes[2].Copy()}
+	h.nodes = reloaded
+	if v := h.Select(ctx); v.Name != fallback.Name {
+		t.Fatalf("expected %s, got %s", fallback.Name, v.Name)
+	}
+}
+
+func TestSticky_Cookie(t *testing.T) {
+	h := stickyHop(StickyOptions{Key: "COOKIE", Name: "SESSIONID", TTL: 50 * time.Millisecond},
+		chain.NewNode("a", "127.0.0.1:1"),
+		chain.NewNode("b", "127.0.0.1:2"),
+	)
+
+	header := http.Header{}
+	header.Set("Cookie", "lang=en; SESSIONID=s1")
+	node := h.Select(context.Background(), hop.HeaderSelectOption(header))
+	for i := 0; i < 3; i++ {
+		if v := h.Select(context.Background(), hop.HeaderSelectOption(header)); v != node {
+			t.Fatalf("expected %s, got %s", node.Name, v.Name)
+		}
+	}
+
+	// the requests without the cookie are not pinned.
+	if len(h.sticky.sessions) != 1 {
+		t.Fatalf("expected 1 session, got %d", len(h.sticky.sessions))
+	}
+	h.Select(context.Background())
+	if len(h.sticky.sessions) != 1 {
+		t.Fatalf("expected 1 session, got %d", len(h.sticky.sessions))
+	}
+
+	// the idle session expires.
+	time.Sleep(60 * time.Millisecond)
+	if v := h.sticky.get("s1", h.nodes); v != nil {
+		t.Fatalf("expired session sticks to %s", v.Name)
+	}
+}
+
+func TestSticky_Header(t *testing.T) {
+	h := stickyHop(StickyOptions{Key: StickyHeader, Name: "X-Session"},
+		chain.NewNode("a", "127.0.0.1:1"),
+		chain.NewNode("b", "127.0.0.1:2"),
+	)
+
+	header := http.Header{}
+	header.Set("X-Session", "s1")
+	node := h.Select(context.Background(), hop.HeaderSelectOption(header))
+	if v := h.Select(context.Background(), hop.HeaderSelectOption(header)); v != node {
+		t.Fatalf("expected %s, got %s", node.Name, v.Name)
+	}
+}
+
+func TestSticky_MaxEntries(t *testing.T) {
+	table := newStickyTable(StickyOptions{MaxEntries: 2, TTL: time.Minute})
+	nodes := []*chain.Node{
+		chain.NewNode("a", "127.0.0.1:1"),
+		chain.NewNode("b", "127.0.0.1:2"),
+	}
+
+	table.set("s1", nodes[0])
+	table.set("s2", nodes[1])
+	// s1 is used, s2 is the least recently used session.
+	if v := table.get("s1", nodes); v != nodes[0] {
+		t.Fatalf("expected a, got %v", v)
+	}
+	table.set("s3", nodes[1])
+
+	if len(table.sessions) != 2 || table.lru.Len() != 2 {
+		t.Fatalf("expected 2 sessions, got %d", len(table.sessions))
+	}
+	if v := table.get("s2", nodes); v != nil {
+		t.Fatalf("unexpected node %s of the evicted session", v.Name)
+	}
+	if table.get("s1", nodes) != nodes[0] || table.get("s3", nodes) != nodes[1] {
+		t.Fatal("the recently used sessions are evicted")
+	}
+
+	// the expired sessions are removed on insert.
+	for _, elem := range table.sessions {
+		elem.Value.(*stickyEntry).expires = time.Now().Add(-time.Second)
+	}
+	table.set("s4", nodes[0])
+	if len(table.sessions) != 1 || table.lru.Len() != 1 {
+		t.Fatalf("expected 1 session, got %d", len(table.sessions))
+	}
+}
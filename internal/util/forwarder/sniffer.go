@@ -38,6 +38,7 @@ import (
 	xstats "github.com/go-gost/x/observer/stats"
 	stats_wrapper "github.com/go-gost/x/observer/stats/wrapper"
 	xrecorder "github.com/go-gost/x/recorder"
+	xselector "github.com/go-gost/x/selector"
 	"golang.org/x/net/http/httpguts"
 	"golang.org/x/net/http2"
 	"golang.org/x/time/rate"
@@ -298,6 +299,8 @@ func (h *Sniffer) dial(ctx context.Context, conn net.Conn, req *http.Request, ho
 	})
 	ho.log.Debugf("find node for host %s -> %s(%s)", host, node.Name, node.Addr)
 
+	breaker := xselector.LookupBreaker(node)
+	breaker.Begin()
 	cc, err = dial(ctx, "tcp", node.Addr)
 	if err != nil {
 		// TODO: the router itself may be failed due to the failed node in the router,
@@ -305,6 +308,7 @@ func (h *Sniffer) dial(ctx context.Context, conn net.Conn, req *http.Request, ho
 		if marker := node.Marker(); marker != nil {
 			marker.Mark()
 		}
+		breaker.Done(err)
 		ho.log.Warnf("connect to node %s(%s) failed: %v", node.Name, node.Addr, err)
 		res.Write(conn)
 		return
@@ -312,6 +316,7 @@ func (h *Sniffer) dial(ctx context.Context, conn net.Conn, req *http.Request, ho
 	if marker := node.Marker(); marker != nil {
 		marker.Reset()
 	}
+	breaker.Done(nil)
 
 	if tlsSettings := node.Options().TLS; tlsSettings != nil {
 		cfg := &tls.Config{
@@ -939,6 +944,8 @@ func (h *Sniffer) dialTLS(ctx context.Context, host string, ho *HandleOptions) (
 	})
 	ho.log.Debugf("find node for host %s -> %s(%s)", host, node.Name, addr)
 
+	breaker := xselector.LookupBreaker(node)
+	breaker.Begin()
 	cc, err = dial(ctx, ro.Network, addr)
 	if err != nil {
 		// TODO: the router itself may be failed due to the failed node in the router,
@@ -946,6 +953,7 @@ func (h *Sniffer) dialTLS(ctx context.Context, host string, ho *HandleOptions) (
 		if marker := node.Marker(); marker != nil {
 			marker.Mark()
 		}
+		breaker.Done(err)
 		ho.log.Warnf("connect to node %s(%s) failed: %v", node.Name, node.Addr, err)
 		return
 	}
@@ -953,6 +961,7 @@ func (h *Sniffer) dialTLS(ctx context.Context, host string, ho *HandleOptions) (
 	if marker := node.Marker(); marker != nil {
 		marker.Reset()
 	}
+	breaker.Done(nil)
 
 	if tlsSettings := node.Options().TLS; tlsSettings != nil {
 		cfg := &tls.Config{
@@ -0,0 +1,291 @@
+package pcap
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultMaxSize  = 16 * 1024 * 1024
+	defaultMaxFiles = 4
+	defaultSnapLen  = 65535
+	// queueSize is the number of the packets waiting for the file writer,
+	// the packets are dropped when the queue is full.
+	queueSize = 1024
+)
+
+var (
+	ErrCaptureRunning    = errors.New("pcap: capture is already running")
+	ErrCaptureNotRunning = errors.New("pcap: capture is not running")
+	ErrTapNotFound       = errors.New("pcap: no capture point")
+)
+
+// Options are the options of a capture.
+type Options struct {
+	// Path is the file the packets are written to. The rotated files are Path.1, Path.2 and so on.
+	Path string
+	// MaxSize is the size a file is rotated at.
+	MaxSize int64
+	// MaxFiles is the number of the files kept in the ring, including the current one.
+	MaxFiles int
+	// SnapLen is the maximum number of bytes captured of a packet.
+	SnapLen int
+	// Filter is the filter expression, see ParseFilter.
+	Filter string
+}
+
+// Stats are the statistics of a capture.
+type Stats struct {
+	Packets uint64
+	Bytes   uint64
+	Dropped uint64
+	Files   int
+}
+
+// Capturer writes packets to a ring of pcapng files. The packets are queued
+// and written by a goroutine, the packet path never waits for the file.
+type Capturer struct {
+	opts   Options
+	name   string
+	filter Filter
+
+	// qmu guards the queue against the send after Close.
+	qmu     sync.RWMutex
+	queue   chan packet
+	stopped bool
+	done    chan struct{}
+	err     atomic.Value
+
+	mu      sync.Mutex
+	file    *os.File
+	bw      *bufio.Writer
+	w       *writer
+	size    int64
+	files   int
+	closed  bool
+	flushed time.Time
+
+	packets atomic.Uint64
+	bytes   atomic.Uint64
+	dropped atomic.Uint64
+}
+
+// packet is a queued packet, data is a copy owned by the queue.
+type packet struct {
+	t       time.Time
+	data    []byte
+	dir     Direction
+	comment string
+}
+
+// NewCapturer creates a capture of the interface named name.
+func NewCapturer(name string, opts Options) (*Capturer, error) {
+	if opts.Path == "" {
+		return nil, errors.New("pcap: path is required")
+	}
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = defaultMaxSize
+	}
+	if opts.MaxFiles <= 0 {
+		opts.MaxFiles = defaultMaxFiles
+	}
+	if opts.SnapLen <= 0 {
+		opts.SnapLen = defaultSnapLen
+	}
+
+	filter, err := ParseFilter(opts.Filter)
+	if err != nil {
+		return nil, err
+	}
+
+	if dir := filepath.Dir(opts.Path); dir != "" {
+		os.MkdirAll(dir, 0755)
+	}
+
+	c := &Capturer{
+		opts:   opts,
+		name:   name,
+		filter: filter,
+		queue:  make(chan packet, queueSize),
+		done:   make(chan struct{}),
+	}
+	if err := c.open(); err != nil {
+		return nil, err
+	}
+	go c.run()
+
+	return c, nil
+}
+
+// Options returns the options of the capture with the defaults applied.
+func (c *Capturer) Options() Options {
+	return c.opts
+}
+
+// Write queues the packet b if it matches the filter, b is copied. comment is called only for the captured packets.
+// The packet is dropped if the queue is full.
+func (c *Capturer) Write(b []byte, dir Direction, comment func() string) error {
+	if c.filter != nil {
+		t, ok := Parse(b)
+		if !ok || !c.filter.Match(t) {
+			return nil
+		}
+	}
+
+	var s string
+	if comment != nil {
+		s = comment()
+	}
+
+	c.qmu.RLock()
+	defer c.qmu.RUnlock()
+
+	if c.stopped {
+		return ErrCaptureNotRunning
+	}
+
+	select {
+	case c.queue <- packet{t: time.Now(), data: append([]byte(nil), b...), dir: dir, comment: s}:
+	default:
+		c.dropped.Add(1)
+	}
+	return nil
+}
+
+// Err returns the last error of the file writer.
+func (c *Capturer) Err() string {
+	s, _ := c.err.Load().(string)
+	return s
+}
+
+// run writes the queued packets until the queue is closed.
+func (c *Capturer) run() {
+	defer close(c.done)
+
+	for p := range c.queue {
+		if err := c.write(p); err != nil {
+			c.err.Store(err.Error())
+		}
+	}
+}
+
+func (c *Capturer) write(p packet) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed || c.file == nil {
+		c.dropped.Add(1)
+		return nil
+	}
+
+	n, err := c.w.writePacket(p.t, p.data, p.dir, p.comment)
+	if err != nil {
+		c.dropped.Add(1)
+		return err
+	}
+	c.size += n
+	c.packets.Add(1)
+	c.bytes.Add(uint64(len(p.data)))
+
+	if c.size >= c.opts.MaxSize {
+		return c.rotate()
+	}
+	// keep the file readable while the capture is running.
+	if now := time.Now(); now.Sub(c.flushed) >= time.Second {
+		c.flushed = now
+		return c.bw.Flush()
+	}
+	return nil
+}
+
+// Stats returns the statistics of the capture.
+func (c *Capturer) Stats() Stats {
+	c.mu.Lock()
+	files := c.files
+	c.mu.Unlock()
+
+	return Stats{
+		Packets: c.packets.Load(),
+		Bytes:   c.bytes.Load(),
+		Dropped: c.dropped.Load(),
+		Files:   files,
+	}
+}
+
+// Close writes the queued packets, then flushes and closes the current file.
+func (c *Capturer) Close() error {
+	c.qmu.Lock()
+	if !c.stopped {
+		c.stopped = true
+		close(c.queue)
+	}
+	c.qmu.Unlock()
+	<-c.done
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	return c.closeFile()
+}
+
+func (c *Capturer) open() error {
+	f, err := os.OpenFile(c.opts.Path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(f)
+	w, n, err := newWriter(bw, c.name, c.opts.SnapLen)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	c.file, c.bw, c.w, c.size = f, bw, w, n
+	if c.files < c.opts.MaxFiles {
+		c.files++
+	}
+	return nil
+}
+
+func (c *Capturer) closeFile() error {
+	if c.file == nil {
+		return nil
+	}
+	err := c.bw.Flush()
+	if e := c.file.Close(); err == nil {
+		err = e
+	}
+	c.file = nil
+	return err
+}
+
+// rotate shifts the files of the ring and starts a new file, c.mu must be held.
+func (c *Capturer) rotate() error {
+	if err := c.closeFile(); err != nil {
+		return err
+	}
+
+	if c.opts.MaxFiles > 1 {
+		for i := c.opts.MaxFiles - 1; i > 1; i-- {
+			os.Rename(c.rotatedPath(i-1), c.rotatedPath(i))
+		}
+		if err := os.Rename(c.opts.Path, c.rotatedPath(1)); err != nil {
+			return err
+		}
+	}
+	return c.open()
+}
+
+func (c *Capturer) rotatedPath(i int) string {
+	return fmt.Sprintf("%s.%d", c.opts.Path, i)
+}
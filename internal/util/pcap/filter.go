@@ -0,0 +1,223 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+const (
+	protoICMP   = 1
+	protoTCP    = 6
+	protoUDP    = 17
+	protoICMPv6 = 58
+)
+
+// Tuple is the addresses and the protocol of an IP packet.
+type Tuple struct {
+	Proto   uint8
+	Src     netip.Addr
+	Dst     netip.Addr
+	SrcPort uint16
+	DstPort uint16
+}
+
+// Parse parses the tuple of the raw IPv4 or IPv6 packet b.
+func Parse(b []byte) (t Tuple, ok bool) {
+	if len(b) == 0 {
+		return
+	}
+
+	var payload []byte
+	switch b[0] >> 4 {
+	case 4:
+		if len(b) < 20 {
+			return
+		}
+		ihl := int(b[0]&0x0f) * 4
+		if ihl < 20 || len(b) < ihl {
+			return
+		}
+		t.Proto = b[9]
+		t.Src = netip.AddrFrom4([4]byte(b[12:16]))
+		t.Dst = netip.AddrFrom4([4]byte(b[16:20]))
+		// only the first fragment carries the transport header.
+		if binary.BigEndian.Uint16(b[6:8])&0x1fff == 0 {
+			payload = b[ihl:]
+		}
+	case 6:
+		if len(b) < 40 {
+			return
+		}
+		t.Proto = b[6]
+		t.Src = netip.AddrFrom16([16]byte(b[8:24]))
+		t.Dst = netip.AddrFrom16([16]byte(b[24:40]))
+		payload = b[40:]
+	default:
+		return
+	}
+
+	if (t.Proto == protoTCP || t.Proto == protoUDP) && len(payload) >= 4 {
+		t.SrcPort = binary.BigEndian.Uint16(payload[0:2])
+		t.DstPort = binary.BigEndian.Uint16(payload[2:4])
+	}
+	return t, true
+}
+
+// Filter selects the packets to capture.
+type Filter interface {
+	Match(t Tuple) bool
+}
+
+type andFilter []Filter
+
+func (f andFilter) Match(t Tuple) bool {
+	for _, v := range f {
+		if !v.Match(t) {
+			return false
+		}
+	}
+	return true
+}
+
+type netFilter []netip.Prefix
+
+func (f netFilter) Match(t Tuple) bool {
+	for _, p := range f {
+		if p.Contains(t.Src) || p.Contains(t.Dst) {
+			return true
+		}
+	}
+	return false
+}
+
+type portFilter []uint16
+
+func (f portFilter) Match(t Tuple) bool {
+	if t.Proto != protoTCP && t.Proto != protoUDP {
+		return false
+	}
+	for _, port := range f {
+		if t.SrcPort == port || t.DstPort == port {
+			return true
+		}
+	}
+	return false
+}
+
+type protoFilter []uint8
+
+func (f protoFilter) Match(t Tuple) bool {
+	for _, proto := range f {
+		if t.Proto == proto {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseFilter parses the filter expression s, a list of terms joined by "and":
+//
+//	net 10.0.0.0/8,fd00::/8
+//	host 1.1.1.1
+//	port 53,443
+//	proto tcp|udp|icmp|icmp6|<number>
+//	tcp, udp, icmp, icmp6
+//
+// The values of a term are alternatives. An empty expression matches all packets.
+func ParseFilter(s string) (Filter, error) {
+	var f andFilter
+
+	fields := strings.Fields(s)
+	for i := 0; i < len(fields); i++ {
+		term := strings.ToLower(fields[i])
+		if term == "and" || term == "&&" {
+			continue
+		}
+
+		switch term {
+		case "tcp", "udp", "icmp", "icmp6", "icmpv6":
+			proto, _ := parseProto(term)
+			f = append(f, protoFilter{proto})
+			continue
+		}
+
+		if i+1 >= len(fields) {
+			return nil, fmt.Errorf("pcap: missing value of %s", term)
+		}
+		i++
+		values := strings.Split(fields[i], ",")
+
+		switch term {
+		case "net", "host":
+			var nf netFilter
+			for _, v := range values {
+				p, err := parsePrefix(v)
+				if err != nil {
+					return nil, fmt.Errorf("pcap: invalid %s %s", term, v)
+				}
+				nf = append(nf, p)
+			}
+			f = append(f, nf)
+		case "port":
+			var pf portFilter
+			for _, v := range values {
+				port, err := strconv.ParseUint(v, 10, 16)
+				if err != nil {
+					return nil, fmt.Errorf("pcap: invalid port %s", v)
+				}
+				pf = append(pf, uint16(port))
+			}
+			f = append(f, pf)
+		case "proto":
+			var pf protoFilter
+			for _, v := range values {
+				proto, err := parseProto(strings.ToLower(v))
+				if err != nil {
+					return nil, err
+				}
+				pf = append(pf, proto)
+			}
+			f = append(f, pf)
+		default:
+			return nil, fmt.Errorf("pcap: unknown filter term %s", term)
+		}
+	}
+
+	if len(f) == 0 {
+		return nil, nil
+	}
+	return f, nil
+}
+
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		return p.Masked(), err
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+func parseProto(s string) (uint8, error) {
+	switch s {
+	case "tcp":
+		return protoTCP, nil
+	case "udp":
+		return protoUDP, nil
+	case "icmp":
+		return protoICMP, nil
+	case "icmp6", "icmpv6":
+		return protoICMPv6, nil
+	}
+	n, err := strconv.ParseUint(s, 10, 8)
+	if err != nil {
+		return 0, fmt.Errorf("pcap: invalid protocol %s", s)
+	}
+	return uint8(n), nil
+}
@@ -0,0 +1,284 @@
+package pcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testBlock struct {
+	typ  uint32
+	body []byte
+}
+
+func readBlocks(t *testing.T, b []byte) []testBlock {
+	t.Helper()
+
+	var blocks []testBlock
+	for len(b) > 0 {
+		if len(b) < 12 {
+			t.Fatalf("short block: %d bytes", len(b))
+		}
+		typ := binary.LittleEndian.Uint32(b[0:4])
+		n := binary.LittleEndian.Uint32(b[4:8])
+		if n%4 != 0 || int(n) > len(b) {
+			t.Fatalf("invalid block length %d", n)
+		}
+		if trailer := binary.LittleEndian.Uint32(b[n-4 : n]); trailer != n {
+			t.Fatalf("block length mismatch: %d != %d", n, trailer)
+		}
+		blocks = append(blocks, testBlock{typ: typ, body: b[8 : n-4]})
+		b = b[n:]
+	}
+	return blocks
+}
+
+func readOptions(b []byte) map[uint16][]byte {
+	opts := make(map[uint16][]byte)
+	for len(b) >= 4 {
+		code := binary.LittleEndian.Uint16(b[0:2])
+		n := int(binary.LittleEndian.Uint16(b[2:4]))
+		if code == optEndOfOpt {
+			break
+		}
+		opts[code] = b[4 : 4+n]
+		b = b[4+(n+3)/4*4:]
+	}
+	return opts
+}
+
+func testPacket(proto uint8, src, dst string, sport, dport uint16) []byte {
+	b := make([]byte, 28)
+	b[0] = 0x45
+	binary.BigEndian.PutUint16(b[2:4], uint16(len(b)))
+	b[8] = 64
+	b[9] = proto
+	s, d := netip.MustParseAddr(src).As4(), netip.MustParseAddr(dst).As4()
+	copy(b[12:16], s[:])
+	copy(b[16:20], d[:])
+	binary.BigEndian.PutUint16(b[20:22], sport)
+	binary.BigEndian.PutUint16(b[22:24], dport)
+	return b
+}
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w, _, err := newWriter(&buf, "tun0", 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkt := testPacket(protoUDP, "10.0.0.2", "1.1.1.1", 5353, 53)
+	ts := time.UnixMicro(1700000000123456)
+	if _, err := w.writePacket(ts, pkt, DirectionInbound, "action=proxy"); err != nil {
+		t.Fatal(err)
+	}
+
+	blocks := readBlocks(t, buf.Bytes())
+	if len(blocks) != 3 {
+		t.Fatalf("expected 3 blocks, got %d", len(blocks))
+	}
+	if blocks[0].typ != blockSectionHeader || binary.LittleEndian.Uint32(blocks[0].body) != byteOrderMagic {
+		t.Fatal("invalid section header block")
+	}
+	if blocks[1].typ != blockInterfaceDescription || binary.LittleEndian.Uint16(blocks[1].body) != LinkTypeRaw {
+		t.Fatal("invalid interface description block")
+	}
+	if name := readOptions(blocks[1].body[8:])[optIfName]; string(name) != "tun0" {
+		t.Fatalf("unexpected interface name %q", name)
+	}
+
+	epb := blocks[2]
+	if epb.typ != blockEnhancedPacket {
+		t.Fatalf("unexpected block type %x", epb.typ)
+	}
+	tsv := uint64(binary.LittleEndian.Uint32(epb.body[4:8]))<<32 | uint64(binary.LittleEndian.Uint32(epb.body[8:12]))
+	if tsv != uint64(ts.UnixMicro()) {
+		t.Fatalf("unexpected timestamp %d", tsv)
+	}
+	captured := binary.LittleEndian.Uint32(epb.body[12:16])
+	orig := binary.LittleEndian.Uint32(epb.body[16:20])
+	if captured != 16 || orig != uint32(len(pkt)) {
+		t.Fatalf("unexpected lengths %d/%d", captured, orig)
+	}
+	if !bytes.Equal(epb.body[20:36], pkt[:16]) {
+		t.Fatal("packet data mismatch")
+	}
+	opts := readOptions(epb.body[36:])
+	if string(opts[optComment]) != "action=proxy" {
+		t.Fatalf("unexpected comment %q", opts[optComment])
+	}
+	if flags := binary.LittleEndian.Uint32(opts[optEpbFlags]); flags != uint32(DirectionInbound) {
+		t.Fatalf("unexpected flags %d", flags)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	dns := testPacket(protoUDP, "10.0.0.2", "1.1.1.1", 5353, 53)
+	https := testPacket(protoTCP, "10.0.0.2", "93.184.216.34", 40000, 443)
+	ping := testPacket(protoICMP, "10.0.0.2", "1.1.1.1", 0, 0)
+
+	cases := []struct {
+		expr    string
+		matches [3]bool
+	}{
+		{"udp", [3]bool{true, false, false}},
+		{"port 53,443", [3]bool{true, true, false}},
+		{"net 1.1.1.0/24", [3]bool{true, false, true}},
+		{"host 1.1.1.1 and proto icmp", [3]bool{false, false, true}},
+		{"tcp and port 443 and net 10.0.0.0/8", [3]bool{false, true, false}},
+		{"proto 17", [3]bool{true, false, false}},
+	}
+	for _, c := range cases {
+		f, err := ParseFilter(c.expr)
+		if err != nil {
+			t.Fatalf("%s: %v", c.expr, err)
+		}
+		for i, pkt := range [][]byte{dns, https, ping} {
+			tuple, ok := Parse(pkt)
+			if !ok {
+				t.Fatal("parse failed")
+			}
+			if f.Match(tuple) != c.matches[i] {
+				t.Errorf("%s: packet %d: expected %v", c.expr, i, c.matches[i])
+			}
+		}
+	}
+
+	if f, err := ParseFilter(" "); f != nil || err != nil {
+		t.Fatalf("empty filter: %v, %v", f, err)
+	}
+	for _, expr := range []string{"port", "port http", "net 1.1.1", "foo 1", "53"} {
+		if _, err := ParseFilter(expr); err == nil {
+			t.Errorf("%s: expected error", expr)
+		}
+	}
+}
+
+func TestCapturer_Rotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.pcapng")
+	c, err := NewCapturer("tun0", Options{
+		Path:     path,
+		MaxSize:  256,
+		MaxFiles: 3,
+		Filter:   "udp",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pkt := testPacket(protoUDP, "10.0.0.2", "1.1.1.1", 5353, 53)
+	for i := 0; i < 20; i++ {
+		if err := c.Write(pkt, DirectionOutbound, nil); err != nil {
+			t.Fatal(err)
+		}
+		// filtered out
+		c.Write(testPacket(protoTCP, "10.0.0.2", "1.1.1.1", 1, 2), DirectionOutbound, func() string {
+			t.Fatal("comment of a filtered packet")
+			return ""
+		})
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := c.Stats()
+	if stats.Packets != 20 || stats.Files != 3 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	for _, p := range []string{path, path + ".1", path + ".2"} {
+		b, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if blocks := readBlocks(t, b); len(blocks) < 2 || blocks[0].typ != blockSectionHeader {
+			t.Fatalf("%s: invalid file", p)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Fatal("the ring should keep 3 files")
+	}
+}
+
+func TestTap(t *testing.T) {
+	if _, err := Start("tap-test", Options{}); err != ErrTapNotFound {
+		t.Fatalf("expected ErrTapNotFound, got %v", err)
+	}
+
+	tap := GetTap("tap-test")
+	pkt := testPacket(protoUDP, "10.0.0.2", "1.1.1.1", 5353, 53)
+	tap.Capture(pkt, DirectionInbound, nil)
+
+	path := filepath.Join(t.TempDir(), "tap.pcapng")
+	if _, err := Start("tap-test", Options{Path: path}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Start("tap-test", Options{Path: path}); err != ErrCaptureRunning {
+		t.Fatalf("expected ErrCaptureRunning, got %v", err)
+	}
+	tap.Capture(pkt, DirectionInbound, func() string { return "action=direct" })
+
+	status, err := Stop("tap-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Stats.Packets != 1 {
+		t.Fatalf("unexpected status %+v", status)
+	}
+	if _, err := Stop("tap-test"); err != ErrCaptureNotRunning {
+		t.Fatalf("expected ErrCaptureNotRunning, got %v", err)
+	}
+
+	// the capture is stopped and the tap is removed with the last release.
+	other := GetTap("tap-test")
+	if other != tap {
+		t.Fatal("the tap of the service is not shared")
+	}
+	if _, err := Start("tap-test", Options{Path: path}); err != nil {
+		t.Fatal(err)
+	}
+	tap.Release()
+	if !other.Enabled() {
+		t.Fatal("the capture is stopped by the first release")
+	}
+	other.Release()
+	if other.Enabled() {
+		t.Fatal("the capture is running after the last release")
+	}
+	if _, err := GetStatus("tap-test"); err != ErrTapNotFound {
+		t.Fatalf("expected ErrTapNotFound, got %v", err)
+	}
+}
+
+func TestCapturer_Queue(t *testing.T) {
+	c, err := NewCapturer("tun0", Options{Path: filepath.Join(t.TempDir(), "capture.pcapng")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the writer is held up by the file, the packets beyond the queue are dropped.
+	c.mu.Lock()
+	pkt := testPacket(protoUDP, "10.0.0.2", "1.1.1.1", 5353, 53)
+	n := queueSize + 16
+	for i := 0; i < n; i++ {
+		if err := c.Write(pkt, DirectionOutbound, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.mu.Unlock()
+
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Write(pkt, DirectionOutbound, nil); err != ErrCaptureNotRunning {
+		t.Fatalf("expected ErrCaptureNotRunning, got %v", err)
+	}
+
+	stats := c.Stats()
+	if stats.Dropped == 0 || stats.Packets+stats.Dropped != uint64(n) {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
@@ -0,0 +1,150 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"io"
+	"time"
+)
+
+// pcapng block types and options, see https://www.ietf.org/archive/id/draft-ietf-opsawg-pcapng-02.html
+const (
+	blockSectionHeader        = 0x0A0D0D0A
+	blockInterfaceDescription = 0x00000001
+	blockEnhancedPacket       = 0x00000006
+
+	byteOrderMagic = 0x1A2B3C4D
+
+	optEndOfOpt = 0
+	optComment  = 1
+	optShbApp   = 4
+	optIfName   = 2
+	optEpbFlags = 2
+
+	// LinkTypeRaw is the link type of the raw IPv4/IPv6 packets read from a TUN device.
+	LinkTypeRaw = 101
+)
+
+// Direction is the direction of a packet relative to the TUN device.
+type Direction uint8
+
+const (
+	// DirectionUnknown is a packet of unknown direction.
+	DirectionUnknown Direction = iota
+	// DirectionInbound is a packet read from the TUN device.
+	DirectionInbound
+	// DirectionOutbound is a packet written to the TUN device.
+	DirectionOutbound
+)
+
+func (d Direction) String() string {
+	switch d {
+	case DirectionInbound:
+		return "in"
+	case DirectionOutbound:
+		return "out"
+	default:
+		return ""
+	}
+}
+
+// writer writes a pcapng section with a single interface.
+type writer struct {
+	w       io.Writer
+	snapLen int
+	buf     []byte
+}
+
+func newWriter(w io.Writer, ifName string, snapLen int) (*writer, int64, error) {
+	pw := &writer{
+		w:       w,
+		snapLen: snapLen,
+	}
+
+	n1, err := pw.writeBlock(blockSectionHeader, func(b []byte) []byte {
+		b = binary.LittleEndian.AppendUint32(b, byteOrderMagic)
+		b = binary.LittleEndian.AppendUint16(b, 1) // major version
+		b = binary.LittleEndian.AppendUint16(b, 0) // minor version
+		b = binary.LittleEndian.AppendUint64(b, ^uint64(0))
+		b = appendOption(b, optShbApp, []byte("gost"))
+		return appendOption(b, optEndOfOpt, nil)
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	n2, err := pw.writeBlock(blockInterfaceDescription, func(b []byte) []byte {
+		b = binary.LittleEndian.AppendUint16(b, LinkTypeRaw)
+		b = binary.LittleEndian.AppendUint16(b, 0) // reserved
+		b = binary.LittleEndian.AppendUint32(b, uint32(snapLen))
+		if ifName != "" {
+			b = appendOption(b, optIfName, []byte(ifName))
+		}
+		return appendOption(b, optEndOfOpt, nil)
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return pw, n1 + n2, nil
+}
+
+// writePacket writes an enhanced packet block and returns its size.
+func (pw *writer) writePacket(t time.Time, data []byte, dir Direction, comment string) (int64, error) {
+	captured := data
+	if pw.snapLen > 0 && len(captured) > pw.snapLen {
+		captured = captured[:pw.snapLen]
+	}
+
+	return pw.writeBlock(blockEnhancedPacket, func(b []byte) []byte {
+		ts := uint64(t.UnixMicro())
+		b = binary.LittleEndian.AppendUint32(b, 0) // interface ID
+		b = binary.LittleEndian.AppendUint32(b, uint32(ts>>32))
+		b = binary.LittleEndian.AppendUint32(b, uint32(ts))
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(captured)))
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(data)))
+		b = append(b, captured...)
+		b = pad(b)
+
+		if comment != "" {
+			b = appendOption(b, optComment, []byte(comment))
+		}
+		if dir != DirectionUnknown {
+			b = appendOption(b, optEpbFlags, binary.LittleEndian.AppendUint32(nil, uint32(dir)))
+		}
+		return appendOption(b, optEndOfOpt, nil)
+	})
+}
+
+// writeBlock writes the block of type typ with the body appended by body.
+func (pw *writer) writeBlock(typ uint32, body func(b []byte) []byte) (int64, error) {
+	b := pw.buf[:0]
+	b = binary.LittleEndian.AppendUint32(b, typ)
+	b = binary.LittleEndian.AppendUint32(b, 0) // total length, set below
+	b = body(b)
+	b = binary.LittleEndian.AppendUint32(b, 0)
+
+	n := uint32(len(b))
+	binary.LittleEndian.PutUint32(b[4:8], n)
+	binary.LittleEndian.PutUint32(b[n-4:], n)
+	pw.buf = b
+
+	if _, err := pw.w.Write(b); err != nil {
+		return 0, err
+	}
+	return int64(n), nil
+}
+
+func appendOption(b []byte, code uint16, value []byte) []byte {
+	b = binary.LittleEndian.AppendUint16(b, code)
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(value)))
+	b = append(b, value...)
+	return pad(b)
+}
+
+// pad pads b to a multiple of 32 bits.
+func pad(b []byte) []byte {
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
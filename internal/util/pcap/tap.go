@@ -0,0 +1,181 @@
+package pcap
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+)
+
+// Tap is a capture point of a service. A capture is started and stopped on it at runtime.
+type Tap struct {
+	name      string
+	capturer  atomic.Pointer[Capturer]
+	mu        sync.Mutex
+	lastError atomic.Value
+	// refs is the number of the holders of the tap, guarded by tapsMu.
+	refs int
+}
+
+var (
+	tapsMu sync.Mutex
+	taps   = make(map[string]*Tap)
+)
+
+// GetTap returns the capture point of the service, it is created if it does not exist.
+// The tap must be released by Release when the service no longer uses it.
+func GetTap(service string) *Tap {
+	tapsMu.Lock()
+	defer tapsMu.Unlock()
+
+	t := taps[service]
+	if t == nil {
+		t = &Tap{name: service}
+		taps[service] = t
+	}
+	t.refs++
+	return t
+}
+
+// Release releases the tap returned by GetTap. The tap is removed with the last release
+// and its capture is stopped.
+func (t *Tap) Release() {
+	if t == nil {
+		return
+	}
+
+	tapsMu.Lock()
+	t.refs--
+	last := t.refs <= 0
+	if last && taps[t.name] == t {
+		delete(taps, t.name)
+	}
+	tapsMu.Unlock()
+
+	if !last {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if c := t.capturer.Swap(nil); c != nil {
+		c.Close()
+	}
+}
+
+func lookupTap(service string) *Tap {
+	tapsMu.Lock()
+	defer tapsMu.Unlock()
+
+	return taps[service]
+}
+
+// Enabled reports whether a capture is running on the tap.
+func (t *Tap) Enabled() bool {
+	return t != nil && t.capturer.Load() != nil
+}
+
+// Capture writes the packet b to the running capture, it is a no-op if no capture is running.
+func (t *Tap) Capture(b []byte, dir Direction, comment func() string) {
+	if t == nil {
+		return
+	}
+	c := t.capturer.Load()
+	if c == nil {
+		return
+	}
+	if err := c.Write(b, dir, comment); err != nil && err != ErrCaptureNotRunning {
+		t.lastError.Store(err.Error())
+	}
+}
+
+// Status is the state of the capture of a service.
+type Status struct {
+	Running bool
+	Options Options
+	Stats   Stats
+	Error   string
+}
+
+// Start starts a capture on the tap of the service.
+func Start(service string, opts Options) (Status, error) {
+	t := lookupTap(service)
+	if t == nil {
+		return Status{}, ErrTapNotFound
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.capturer.Load() != nil {
+		return Status{}, ErrCaptureRunning
+	}
+
+	if opts.Path == "" {
+		opts.Path = filepath.Join(os.TempDir(), "gost-capture-"+service+".pcapng")
+	}
+	c, err := NewCapturer(service, opts)
+	if err != nil {
+		return Status{}, err
+	}
+	t.lastError.Store("")
+	t.capturer.Store(c)
+
+	return t.status(), nil
+}
+
+// Stop stops the capture on the tap of the service and returns its final state.
+func Stop(service string) (Status, error) {
+	t := lookupTap(service)
+	if t == nil {
+		return Status{}, ErrTapNotFound
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	c := t.capturer.Swap(nil)
+	if c == nil {
+		return Status{}, ErrCaptureNotRunning
+	}
+	err := c.Close()
+
+	status := Status{
+		Options: c.Options(),
+		Stats:   c.Stats(),
+		Error:   c.Err(),
+	}
+	if err != nil {
+		status.Error = err.Error()
+	}
+	t.lastError.Store(status.Error)
+	return status, nil
+}
+
+// GetStatus returns the state of the capture on the tap of the service.
+func GetStatus(service string) (Status, error) {
+	t := lookupTap(service)
+	if t == nil {
+		return Status{}, ErrTapNotFound
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.status(), nil
+}
+
+func (t *Tap) status() Status {
+	var status Status
+	status.Error, _ = t.lastError.Load().(string)
+	if c := t.capturer.Load(); c != nil {
+		status.Running = true
+		status.Options = c.Options()
+		status.Stats = c.Stats()
+		if err := c.Err(); err != "" {
+			status.Error = err
+		}
+	}
+	return status
+}
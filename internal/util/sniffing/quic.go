@@ -0,0 +1,392 @@
+package sniffing
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	dissector "github.com/go-gost/tls-dissector"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	quicVersion1 = 0x00000001
+	quicVersion2 = 0x6b3343cf
+
+	// maxQUICCryptoSize limits the size of the reassembled CRYPTO stream.
+	maxQUICCryptoSize = 32 * 1024
+	// handshake message type of the ClientHello.
+	tlsClientHello = 0x01
+)
+
+var (
+	// initial salts of RFC 9001 and RFC 9369.
+	quicSaltV1 = []byte{
+		0x38, 0x76, 0x2c, 0xf7, 0xf5, 0x59, 0x34, 0xb3, 0x4d, 0x17,
+		0x9a, 0xe6, 0xa4, 0xc8, 0x0c, 0xad, 0xcc, 0xbb, 0x7f, 0x0a,
+	}
+	quicSaltV2 = []byte{
+		0x0d, 0xed, 0xe3, 0xde, 0xf7, 0x00, 0xa6, 0xdb, 0x81, 0x93,
+		0x81, 0xbe, 0x6e, 0x26, 0x9d, 0xcb, 0xf9, 0xbd, 0x2e, 0xd9,
+	}
+)
+
+var (
+	ErrNotQUICInitial = errors.New("sniffing: not a QUIC client initial packet")
+	ErrQUICMalformed  = errors.New("sniffing: malformed QUIC packet")
+)
+
+// QUICSniffer extracts the TLS ClientHello carried by the client Initial packets of a QUIC v1 or v2 connection.
+// The ClientHello may span several Initial packets, the datagrams are fed to the sniffer in order with Sniff.
+type QUICSniffer struct {
+	keys        *quicInitialKeys
+	frames      []quicCryptoFrame
+	size        int
+	clientHello []byte
+}
+
+type quicCryptoFrame struct {
+	offset uint64
+	data   []byte
+}
+
+// Sniff decrypts the Initial packets of the datagram b and reassembles their CRYPTO frames.
+// It returns the ClientHello once it is complete, or nil if more datagrams are needed.
+// ErrNotQUICInitial is returned if the first datagram does not start with a client Initial packet.
+// The datagram b is not modified.
+func (s *QUICSniffer) Sniff(b []byte) (*dissector.ClientHelloInfo, error) {
+	if s.clientHello != nil {
+		return parseQUICClientHello(s.clientHello)
+	}
+
+	decoded := false
+	for len(b) > 0 {
+		// short header packets are only sent after the handshake.
+		if b[0]&0x80 == 0 {
+			break
+		}
+
+		pkt, pnOffset, initial, err := parseQUICLongHeader(b)
+		if err != nil {
+			if !decoded && s.keys == nil {
+				return nil, ErrNotQUICInitial
+			}
+			return nil, err
+		}
+		b = b[len(pkt):]
+
+		if !initial.ok {
+			if !decoded && s.keys == nil {
+				return nil, ErrNotQUICInitial
+			}
+			continue
+		}
+
+		if s.keys == nil || s.keys.version != initial.version || !bytes.Equal(s.keys.dcid, initial.dcid) {
+			if s.keys, err = newQUICInitialKeys(initial.version, initial.dcid); err != nil {
+				return nil, err
+			}
+		}
+
+		payload, err := s.keys.open(pkt, pnOffset)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.readFrames(payload); err != nil {
+			return nil, err
+		}
+		decoded = true
+	}
+
+	if !decoded && s.keys == nil {
+		return nil, ErrNotQUICInitial
+	}
+
+	data := s.assemble()
+	if len(data) < 4 {
+		return nil, nil
+	}
+	if data[0] != tlsClientHello {
+		return nil, ErrNotQUICInitial
+	}
+	n := 4 + (int(data[1])<<16 | int(data[2])<<8 | int(data[3]))
+	if n > maxQUICCryptoSize {
+		return nil, ErrQUICMalformed
+	}
+	if len(data) < n {
+		return nil, nil
+	}
+	s.clientHello = data[:n]
+	s.frames = nil
+
+	return parseQUICClientHello(s.clientHello)
+}
+
+// ClientHello returns the raw ClientHello handshake message, or nil if it is not complete yet.
+func (s *QUICSniffer) ClientHello() []byte {
+	return s.clientHello
+}
+
+func (s *QUICSniffer) readFrames(p []byte) error {
+	for len(p) > 0 {
+		typ := p[0]
+		p = p[1:]
+
+		switch typ {
+		case 0x00, 0x01: // PADDING, PING
+		case 0x02, 0x03: // ACK
+			var v [4]uint64
+			var err error
+			for i := range v {
+				if v[i], p, err = readQUICVarint(p); err != nil {
+					return err
+				}
+			}
+			// ack ranges, followed by the ECN counts for ACK_ECN.
+			n := 2 * v[2]
+			if typ == 0x03 {
+				n += 3
+			}
+			for ; n > 0; n-- {
+				if _, p, err = readQUICVarint(p); err != nil {
+					return err
+				}
+			}
+		case 0x06: // CRYPTO
+			offset, rest, err := readQUICVarint(p)
+			if err != nil {
+				return err
+			}
+			length, rest, err := readQUICVarint(rest)
+			if err != nil {
+				return err
+			}
+			if length > uint64(len(rest)) {
+				return ErrQUICMalformed
+			}
+			if offset+length > maxQUICCryptoSize || s.size+int(length) > maxQUICCryptoSize {
+				return ErrQUICMalformed
+			}
+			s.frames = append(s.frames, quicCryptoFrame{
+				offset: offset,
+				data:   append([]byte(nil), rest[:length]...),
+			})
+			s.size += int(length)
+			p = rest[length:]
+		case 0x1c: // CONNECTION_CLOSE
+			return nil
+		default:
+			// other frames are not allowed in Initial packets.
+			return ErrQUICMalformed
+		}
+	}
+	return nil
+}
+
+// assemble returns the contiguous CRYPTO stream data starting at offset 0.
+func (s *QUICSniffer) assemble() []byte {
+	var data []byte
+	for {
+		end := uint64(len(data))
+		extended := false
+		for _, f := range s.frames {
+			if f.offset <= end && f.offset+uint64(len(f.data)) > end {
+				data = append(data, f.data[end-f.offset:]...)
+				extended = true
+				break
+			}
+		}
+		if !extended {
+			return data
+		}
+	}
+}
+
+func parseQUICClientHello(b []byte) (*dissector.ClientHelloInfo, error) {
+	// The handshake message is wrapped in a TLS record for the dissector.
+	record := make([]byte, dissector.RecordHeaderLen, dissector.RecordHeaderLen+len(b))
+	record[0] = dissector.Handshake
+	binary.BigEndian.PutUint16(record[1:3], 0x0301)
+	binary.BigEndian.PutUint16(record[3:5], uint16(len(b)))
+	record = append(record, b...)
+
+	return dissector.ParseClientHello(bytes.NewReader(record))
+}
+
+type quicInitialHeader struct {
+	ok      bool
+	version uint32
+	dcid    []byte
+}
+
+// parseQUICLongHeader parses the long header packet at the beginning of b.
+// It returns the packet and the offset of its packet number.
+func parseQUICLongHeader(b []byte) (pkt []byte, pnOffset int, initial quicInitialHeader, err error) {
+	if len(b) < 7 {
+		err = ErrQUICMalformed
+		return
+	}
+	version := binary.BigEndian.Uint32(b[1:5])
+	typ := (b[0] >> 4) & 0x03
+
+	switch version {
+	case quicVersion1:
+		initial.ok = typ == 0x00
+	case quicVersion2:
+		initial.ok = typ == 0x01
+	default:
+		// version negotiation or unknown version, the packet length can not be parsed.
+		err = ErrNotQUICInitial
+		return
+	}
+	initial.version = version
+
+	p := b[5:]
+	dcidLen := int(p[0])
+	if dcidLen > 20 || len(p) < 1+dcidLen+1 {
+		err = ErrQUICMalformed
+		return
+	}
+	initial.dcid = p[1 : 1+dcidLen]
+	p = p[1+dcidLen:]
+
+	scidLen := int(p[0])
+	if scidLen > 20 || len(p) < 1+scidLen {
+		err = ErrQUICMalformed
+		return
+	}
+	p = p[1+scidLen:]
+
+	// Retry packets have no length field and end the datagram.
+	if (version == quicVersion1 && typ == 0x03) || (version == quicVersion2 && typ == 0x00) {
+		return b, len(b), initial, nil
+	}
+
+	if initial.ok {
+		var tokenLen uint64
+		if tokenLen, p, err = readQUICVarint(p); err != nil {
+			return
+		}
+		if tokenLen > uint64(len(p)) {
+			err = ErrQUICMalformed
+			return
+		}
+		p = p[tokenLen:]
+	}
+
+	var length uint64
+	if length, p, err = readQUICVarint(p); err != nil {
+		return
+	}
+	if length > uint64(len(p)) {
+		err = ErrQUICMalformed
+		return
+	}
+
+	pnOffset = len(b) - len(p)
+	return b[:pnOffset+int(length)], pnOffset, initial, nil
+}
+
+func readQUICVarint(b []byte) (uint64, []byte, error) {
+	if len(b) == 0 {
+		return 0, nil, ErrQUICMalformed
+	}
+	n := 1 << (b[0] >> 6)
+	if len(b) < n {
+		return 0, nil, ErrQUICMalformed
+	}
+	v := uint64(b[0] & 0x3f)
+	for i := 1; i < n; i++ {
+		v = v<<8 | uint64(b[i])
+	}
+	return v, b[n:], nil
+}
+
+type quicInitialKeys struct {
+	version uint32
+	dcid    []byte
+	aead    cipher.AEAD
+	iv      []byte
+	hp      cipher.Block
+}
+
+// newQUICInitialKeys derives the client Initial keys from the destination connection ID.
+func newQUICInitialKeys(version uint32, dcid []byte) (*quicInitialKeys, error) {
+	salt, prefix := quicSaltV1, "quic "
+	if version == quicVersion2 {
+		salt, prefix = quicSaltV2, "quicv2 "
+	}
+
+	initialSecret := hkdf.Extract(sha256.New, dcid, salt)
+	secret := hkdfExpandLabel(initialSecret, "client in", sha256.Size)
+
+	block, err := aes.NewCipher(hkdfExpandLabel(secret, prefix+"key", 16))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	hp, err := aes.NewCipher(hkdfExpandLabel(secret, prefix+"hp", 16))
+	if err != nil {
+		return nil, err
+	}
+
+	return &quicInitialKeys{
+		version: version,
+		dcid:    append([]byte(nil), dcid...),
+		aead:    aead,
+		iv:      hkdfExpandLabel(secret, prefix+"iv", aead.NonceSize()),
+		hp:      hp,
+	}, nil
+}
+
+// open removes the header protection and decrypts the payload of the packet,
+// the packet number starts at pnOffset.
+func (k *quicInitialKeys) open(pkt []byte, pnOffset int) ([]byte, error) {
+	if len(pkt) < pnOffset+4+aes.BlockSize {
+		return nil, ErrQUICMalformed
+	}
+	// the packet is copied, the datagram is forwarded as is.
+	pkt = append([]byte(nil), pkt...)
+
+	mask := make([]byte, aes.BlockSize)
+	k.hp.Encrypt(mask, pkt[pnOffset+4:pnOffset+4+aes.BlockSize])
+
+	pkt[0] ^= mask[0] & 0x0f
+	pnLen := int(pkt[0]&0x03) + 1
+
+	var pn uint64
+	for i := 0; i < pnLen; i++ {
+		pkt[pnOffset+i] ^= mask[1+i]
+		pn = pn<<8 | uint64(pkt[pnOffset+i])
+	}
+
+	nonce := append([]byte(nil), k.iv...)
+	for i := 0; i < 8; i++ {
+		nonce[len(nonce)-1-i] ^= byte(pn >> (8 * i))
+	}
+
+	hdrLen := pnOffset + pnLen
+	return k.aead.Open(nil, nonce, pkt[hdrLen:], pkt[:hdrLen])
+}
+
+// hkdfExpandLabel is the HKDF-Expand-Label function of TLS 1.3 with an empty context.
+func hkdfExpandLabel(secret []byte, label string, length int) []byte {
+	label = "tls13 " + label
+
+	info := make([]byte, 0, 4+len(label))
+	info = binary.BigEndian.AppendUint16(info, uint16(length))
+	info = append(info, byte(len(label)))
+	info = append(info, label...)
+	info = append(info, 0)
+
+	out := make([]byte, length)
+	io.ReadFull(hkdf.Expand(sha256.New, secret, info), out)
+	return out
+}
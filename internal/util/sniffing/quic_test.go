@@ -0,0 +1,80 @@
+package sniffing
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/hex"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQUICInitialKeys(t *testing.T) {
+	// RFC 9001 A.1 and RFC 9369 A.1
+	dcid, _ := hex.DecodeString("8394c8f03e515708")
+
+	testCases := []struct {
+		version uint32
+		iv      string
+	}{
+		{version: quicVersion1, iv: "fa044b2f42a3fd3b46fb255c"},
+		{version: quicVersion2, iv: "91f73e2351d8fa91660e909f"},
+	}
+	for _, tc := range testCases {
+		keys, err := newQUICInitialKeys(tc.version, dcid)
+		require.NoError(t, err)
+		assert.Equal(t, tc.iv, hex.EncodeToString(keys.iv))
+	}
+}
+
+func TestQUICSniffer(t *testing.T) {
+	for _, version := range []quic.Version{quic.Version1, quic.Version2} {
+		t.Run(version.String(), func(t *testing.T) {
+			pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+			require.NoError(t, err)
+			defer pc.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			go quic.DialAddr(ctx, pc.LocalAddr().String(), &tls.Config{
+				ServerName: "www.example.com",
+				NextProtos: []string{"h3"},
+			}, &quic.Config{Versions: []quic.Version{version}})
+
+			var sniffer QUICSniffer
+			b := make([]byte, 4096)
+			for i := 0; i < 8; i++ {
+				pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+				n, _, err := pc.ReadFrom(b)
+				require.NoError(t, err)
+
+				clientHello, err := sniffer.Sniff(b[:n])
+				require.NoError(t, err)
+				if clientHello == nil {
+					continue
+				}
+				assert.Equal(t, "www.example.com", clientHello.ServerName)
+				assert.Equal(t, []string{"h3"}, clientHello.SupportedProtos)
+				assert.NotEmpty(t, sniffer.ClientHello())
+				return
+			}
+			t.Fatal("incomplete ClientHello")
+		})
+	}
+}
+
+func TestQUICSniffer_NotQUIC(t *testing.T) {
+	var sniffer QUICSniffer
+
+	_, err := sniffer.Sniff([]byte{0x12, 0x34, 0x01, 0x00, 0x00, 0x01, 0x00, 0x00})
+	assert.ErrorIs(t, err, ErrNotQUICInitial)
+
+	// short header packet
+	_, err = sniffer.Sniff([]byte{0x40, 0x01, 0x02, 0x03})
+	assert.ErrorIs(t, err, ErrNotQUICInitial)
+}
@@ -0,0 +1,165 @@
+// Package sockowner attributes network flows to the local process owning the socket.
+package sockowner
+
+import (
+	"errors"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultTTL         = time.Minute
+	defaultNegativeTTL = 5 * time.Second
+	maxCacheSize       = 8192
+)
+
+// Modes of the application ID reported by the Resolver.
+const (
+	// ModeExe reports the executable path of the owner process.
+	ModeExe = "exe"
+	// ModeCgroup reports the cgroup of the owner process.
+	ModeCgroup = "cgroup"
+)
+
+var (
+	ErrUnsupported = errors.New("sockowner: unsupported platform")
+	ErrNotFound    = errors.New("sockowner: socket not found")
+)
+
+// Owner is the process owning a socket.
+type Owner struct {
+	PID    int
+	UID    int
+	Exe    string
+	Cgroup string
+}
+
+type options struct {
+	mode        string
+	ttl         time.Duration
+	negativeTTL time.Duration
+}
+
+type Option func(opts *options)
+
+// ModeOption sets the application ID reported by the Resolver, ModeExe or ModeCgroup.
+func ModeOption(mode string) Option {
+	return func(opts *options) {
+		opts.mode = mode
+	}
+}
+
+// TTLOption sets the lifetime of the cached lookups.
+func TTLOption(ttl time.Duration) Option {
+	return func(opts *options) {
+		opts.ttl = ttl
+	}
+}
+
+type cacheEntry struct {
+	appID     string
+	expiresAt time.Time
+}
+
+// Resolver resolves the application ID of a flow from its 5-tuple.
+// The results are cached per flow.
+type Resolver struct {
+	options options
+	cache   map[string]cacheEntry
+	mu      sync.Mutex
+}
+
+func NewResolver(opts ...Option) *Resolver {
+	var options options
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if options.mode != ModeCgroup {
+		options.mode = ModeExe
+	}
+	if options.ttl <= 0 {
+		options.ttl = defaultTTL
+	}
+	if options.negativeTTL <= 0 {
+		options.negativeTTL = defaultNegativeTTL
+	}
+
+	return &Resolver{
+		options: options,
+		cache:   make(map[string]cacheEntry),
+	}
+}
+
+// ResolveMetadata returns the executable path or the cgroup of the process owning the local socket
+// of the flow as appID. The hostname is never resolved.
+func (r *Resolver) ResolveMetadata(srcIP, dstIP string, srcPort, dstPort int, proto string) (appID string, hostname string) {
+	if r == nil {
+		return
+	}
+
+	network := strings.ToLower(proto)
+	if network != "tcp" && network != "udp" {
+		return
+	}
+	src, err := netip.ParseAddr(srcIP)
+	if err != nil {
+		return
+	}
+	dst, err := netip.ParseAddr(dstIP)
+	if err != nil {
+		return
+	}
+	local := netip.AddrPortFrom(src.Unmap(), uint16(srcPort))
+	remote := netip.AddrPortFrom(dst.Unmap(), uint16(dstPort))
+
+	key := network + "|" + local.String() + "|" + remote.String()
+	now := time.Now()
+
+	r.mu.Lock()
+	entry, ok := r.cache[key]
+	r.mu.Unlock()
+	if ok && now.Before(entry.expiresAt) {
+		return entry.appID, ""
+	}
+
+	ttl := r.options.ttl
+	owner, err := Lookup(network, local, remote)
+	if err == nil {
+		if r.options.mode == ModeCgroup {
+			appID = owner.Cgroup
+		} else {
+			appID = owner.Exe
+		}
+	}
+	if appID == "" {
+		ttl = r.options.negativeTTL
+	}
+
+	r.mu.Lock()
+	if len(r.cache) >= maxCacheSize {
+		r.cleanup(now)
+	}
+	r.cache[key] = cacheEntry{appID: appID, expiresAt: now.Add(ttl)}
+	r.mu.Unlock()
+
+	return
+}
+
+// cleanup removes the expired entries, or all entries if none has expired. r.mu must be held.
+func (r *Resolver) cleanup(now time.Time) {
+	for k, entry := range r.cache {
+		if !now.Before(entry.expiresAt) {
+			delete(r.cache, k)
+		}
+	}
+	if len(r.cache) >= maxCacheSize {
+		r.cache = make(map[string]cacheEntry)
+	}
+}
+
+// Lookup returns the process owning the local socket of a tcp or udp flow.
+func Lookup(network string, local, remote netip.AddrPort) (*Owner, error) {
+	return lookup(network, local, remote)
+}
@@ -0,0 +1,384 @@
+//go:build linux
+// +build linux
+
+package sockowner
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	sockDiagByFamily = 20
+	inetDiagNoCookie = ^uint32(0)
+
+	sizeofInetDiagReqV2 = 56
+	sizeofInetDiagMsg   = 72
+
+	// minimum interval between two /proc scans for socket inodes.
+	procScanInterval = 500 * time.Millisecond
+)
+
+// inodes caches the socket inode to pid table built by scanning /proc/<pid>/fd.
+var inodes = struct {
+	m        map[uint32]int
+	scanTime time.Time
+	mu       sync.Mutex
+}{}
+
+func lookup(network string, local, remote netip.AddrPort) (*Owner, error) {
+	inode, uid, err := sockDiagLookup(network, local, remote)
+	if err != nil {
+		inode, uid, err = procNetLookup(network, local, remote)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if inode == 0 {
+		return nil, ErrNotFound
+	}
+
+	pid, err := findPID(inode)
+	if err != nil {
+		return nil, err
+	}
+
+	owner := &Owner{
+		PID: pid,
+		UID: int(uid),
+	}
+	owner.Exe, _ = os.Readlink(fmt.Sprintf("/proc/%d/exe", pid))
+	owner.Cgroup = readCgroup(pid)
+
+	return owner, nil
+}
+
+// sockDiagLookup finds the socket with netlink sock_diag. An exact lookup is tried first,
+// unconnected UDP sockets are then searched by their local address.
+func sockDiagLookup(network string, local, remote netip.AddrPort) (inode, uid uint32, err error) {
+	proto := uint8(unix.IPPROTO_TCP)
+	if network == "udp" {
+		proto = unix.IPPROTO_UDP
+	}
+	family := uint8(unix.AF_INET)
+	if local.Addr().Is6() {
+		family = unix.AF_INET6
+	}
+
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, unix.NETLINK_SOCK_DIAG)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer unix.Close(fd)
+
+	tv := unix.NsecToTimeval(time.Second.Nanoseconds())
+	unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv)
+
+	if err = unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return 0, 0, err
+	}
+
+	msgs, err := sockDiagRequest(fd, family, proto, local, remote, false)
+	if err == nil && len(msgs) > 0 {
+		return msgs[0].inode, msgs[0].uid, nil
+	}
+	if network != "udp" {
+		if err == nil {
+			err = ErrNotFound
+		}
+		return 0, 0, err
+	}
+
+	msgs, err = sockDiagRequest(fd, family, proto, local, remote, true)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, msg := range msgs {
+		if matchUDP(msg.local, msg.remote, local, remote) {
+			return msg.inode, msg.uid, nil
+		}
+	}
+	return 0, 0, ErrNotFound
+}
+
+type inetDiagMsg struct {
+	local  netip.AddrPort
+	remote netip.AddrPort
+	uid    uint32
+	inode  uint32
+}
+
+func sockDiagRequest(fd int, family, proto uint8, local, remote netip.AddrPort, dump bool) ([]inetDiagMsg, error) {
+	flags := uint16(unix.NLM_F_REQUEST)
+	if dump {
+		flags |= unix.NLM_F_DUMP
+	}
+
+	b := make([]byte, unix.SizeofNlMsghdr+sizeofInetDiagReqV2)
+	binary.NativeEndian.PutUint32(b[0:4], uint32(len(b)))
+	binary.NativeEndian.PutUint16(b[4:6], sockDiagByFamily)
+	binary.NativeEndian.PutUint16(b[6:8], flags)
+	binary.NativeEndian.PutUint32(b[8:12], 1)
+
+	req := b[unix.SizeofNlMsghdr:]
+	req[0] = family
+	req[1] = proto
+	binary.NativeEndian.PutUint32(req[4:8], 0xffffffff) // all states
+	if !dump {
+		id := req[8:]
+		binary.BigEndian.PutUint16(id[0:2], local.Port())
+		binary.BigEndian.PutUint16(id[2:4], remote.Port())
+		putDiagAddr(id[4:20], local.Addr())
+		putDiagAddr(id[20:36], remote.Addr())
+		binary.NativeEndian.PutUint32(id[40:44], inetDiagNoCookie)
+		binary.NativeEndian.PutUint32(id[44:48], inetDiagNoCookie)
+	}
+
+	if err := unix.Sendto(fd, b, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return nil, err
+	}
+
+	var msgs []inetDiagMsg
+	rb := make([]byte, 32*1024)
+	for {
+		n, _, err := unix.Recvfrom(fd, rb, 0)
+		if err != nil {
+			return nil, err
+		}
+		nlmsgs, err := syscall.ParseNetlinkMessage(rb[:n])
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range nlmsgs {
+			switch m.Header.Type {
+			case unix.NLMSG_DONE:
+				return msgs, nil
+			case unix.NLMSG_ERROR:
+				if len(m.Data) < 4 {
+					return nil, ErrNotFound
+				}
+				if errno := int32(binary.NativeEndian.Uint32(m.Data[0:4])); errno != 0 {
+					return nil, syscall.Errno(-errno)
+				}
+				return msgs, nil
+			case sockDiagByFamily:
+				if msg, ok := parseInetDiagMsg(m.Data); ok {
+					msgs = append(msgs, msg)
+				}
+			}
+		}
+		if !dump {
+			return msgs, nil
+		}
+	}
+}
+
+func putDiagAddr(b []byte, addr netip.Addr) {
+	if addr.Is4() {
+		a := addr.As4()
+		copy(b, a[:])
+		return
+	}
+	a := addr.As16()
+	copy(b, a[:])
+}
+
+func parseInetDiagMsg(b []byte) (msg inetDiagMsg, ok bool) {
+	if len(b) < sizeofInetDiagMsg {
+		return
+	}
+	family := b[0]
+	id := b[4:52]
+
+	parseAddr := func(p []byte) netip.Addr {
+		if family == unix.AF_INET {
+			return netip.AddrFrom4([4]byte(p[:4]))
+		}
+		return netip.AddrFrom16([16]byte(p[:16])).Unmap()
+	}
+	msg.local = netip.AddrPortFrom(parseAddr(id[4:20]), binary.BigEndian.Uint16(id[0:2]))
+	msg.remote = netip.AddrPortFrom(parseAddr(id[20:36]), binary.BigEndian.Uint16(id[2:4]))
+	msg.uid = binary.NativeEndian.Uint32(b[64:68])
+	msg.inode = binary.NativeEndian.Uint32(b[68:72])
+	return msg, true
+}
+
+// matchUDP reports whether the UDP socket bound to sockLocal and optionally connected to
+// sockRemote carries the flow local -> remote.
+func matchUDP(sockLocal, sockRemote, local, remote netip.AddrPort) bool {
+	if sockLocal.Port() != local.Port() {
+		return false
+	}
+	if !sockLocal.Addr().IsUnspecified() && sockLocal.Addr() != local.Addr() {
+		return false
+	}
+	if sockRemote.Port() != 0 && sockRemote != remote {
+		return false
+	}
+	return true
+}
+
+// procNetLookup finds the socket in /proc/net/{tcp,udp}{,6}.
+func procNetLookup(network string, local, remote netip.AddrPort) (inode, uid uint32, err error) {
+	files := []string{"/proc/net/" + network, "/proc/net/" + network + "6"}
+	if local.Addr().Is6() {
+		files = files[1:]
+	}
+
+	for _, file := range files {
+		inode, uid, err = procNetFind(file, network, local, remote)
+		if err == nil {
+			return
+		}
+	}
+	return 0, 0, ErrNotFound
+}
+
+func procNetFind(file string, network string, local, remote netip.AddrPort) (inode, uid uint32, err error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	sc.Scan() // header
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 10 {
+			continue
+		}
+		sockLocal, err := parseProcNetAddr(fields[1])
+		if err != nil {
+			continue
+		}
+		sockRemote, err := parseProcNetAddr(fields[2])
+		if err != nil {
+			continue
+		}
+
+		if network == "udp" {
+			if !matchUDP(sockLocal, sockRemote, local, remote) {
+				continue
+			}
+		} else if sockLocal != local || sockRemote != remote {
+			continue
+		}
+
+		u, _ := strconv.ParseUint(fields[7], 10, 32)
+		n, _ := strconv.ParseUint(fields[9], 10, 32)
+		return uint32(n), uint32(u), nil
+	}
+	return 0, 0, ErrNotFound
+}
+
+// parseProcNetAddr parses an address of /proc/net/{tcp,udp}{,6}, such as 0100007F:1F90.
+// The address is printed as 32-bit words in host byte order.
+func parseProcNetAddr(s string) (netip.AddrPort, error) {
+	host, port, ok := strings.Cut(s, ":")
+	if !ok {
+		return netip.AddrPort{}, fmt.Errorf("invalid address %s", s)
+	}
+	b, err := hex.DecodeString(host)
+	if err != nil || (len(b) != 4 && len(b) != 16) {
+		return netip.AddrPort{}, fmt.Errorf("invalid address %s", s)
+	}
+	for i := 0; i < len(b); i += 4 {
+		binary.NativeEndian.PutUint32(b[i:], binary.BigEndian.Uint32(b[i:]))
+	}
+	p, err := strconv.ParseUint(port, 16, 16)
+	if err != nil {
+		return netip.AddrPort{}, fmt.Errorf("invalid address %s", s)
+	}
+
+	addr, _ := netip.AddrFromSlice(b)
+	return netip.AddrPortFrom(addr.Unmap(), uint16(p)), nil
+}
+
+// findPID returns the pid of the process holding the socket inode.
+func findPID(inode uint32) (int, error) {
+	inodes.mu.Lock()
+	defer inodes.mu.Unlock()
+
+	if pid, ok := inodes.m[inode]; ok {
+		if _, err := os.Stat(fmt.Sprintf("/proc/%d", pid)); err == nil {
+			return pid, nil
+		}
+	}
+	if time.Since(inodes.scanTime) < procScanInterval {
+		return 0, ErrNotFound
+	}
+
+	inodes.m = scanSocketInodes()
+	inodes.scanTime = time.Now()
+
+	if pid, ok := inodes.m[inode]; ok {
+		return pid, nil
+	}
+	return 0, ErrNotFound
+}
+
+func scanSocketInodes() map[uint32]int {
+	m := make(map[uint32]int)
+
+	procs, _ := os.ReadDir("/proc")
+	for _, proc := range procs {
+		pid, err := strconv.Atoi(proc.Name())
+		if err != nil {
+			continue
+		}
+		dir := filepath.Join("/proc", proc.Name(), "fd")
+		fds, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			link, err := os.Readlink(filepath.Join(dir, fd.Name()))
+			if err != nil || !strings.HasPrefix(link, "socket:[") {
+				continue
+			}
+			n, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(link, "socket:["), "]"), 10, 32)
+			if err != nil {
+				continue
+			}
+			m[uint32(n)] = pid
+		}
+	}
+	return m
+}
+
+// readCgroup returns the cgroup v2 path of the process, or the first cgroup v1 path.
+func readCgroup(pid int) string {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/cgroup", pid))
+	if err != nil {
+		return ""
+	}
+
+	var cgroup string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		// hierarchy-ID:controller-list:cgroup-path
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		if parts[0] == "0" && parts[1] == "" {
+			return parts[2]
+		}
+		if cgroup == "" {
+			cgroup = parts[2]
+		}
+	}
+	return cgroup
+}
@@ -0,0 +1,60 @@
+//go:build linux
+// +build linux
+
+package sockowner
+
+import (
+	"net"
+	"net/netip"
+	"os"
+	"testing"
+)
+
+func TestParseProcNetAddr(t *testing.T) {
+	ap, err := parseProcNetAddr("0100007F:1F90")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// /proc/net is printed in host byte order, this test assumes a little-endian host.
+	if ap != netip.MustParseAddrPort("127.0.0.1:8080") {
+		t.Fatalf("unexpected address %s", ap)
+	}
+
+	ap, err = parseProcNetAddr("0000000000000000FFFF00000100007F:0050")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ap != netip.MustParseAddrPort("127.0.0.1:80") {
+		t.Fatalf("unexpected mapped address %s", ap)
+	}
+}
+
+func TestLookupTCP(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer ln.Close()
+
+	c, err := net.Dial("tcp4", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	local := c.LocalAddr().(*net.TCPAddr).AddrPort()
+	remote := c.RemoteAddr().(*net.TCPAddr).AddrPort()
+
+	owner, err := Lookup("tcp", netip.AddrPortFrom(local.Addr().Unmap(), local.Port()), netip.AddrPortFrom(remote.Addr().Unmap(), remote.Port()))
+	if err != nil {
+		t.Skip(err)
+	}
+	if owner.PID != os.Getpid() {
+		t.Fatalf("expected pid %d, got %d", os.Getpid(), owner.PID)
+	}
+
+	exe, _ := os.Executable()
+	if owner.Exe != exe {
+		t.Fatalf("expected exe %s, got %s", exe, owner.Exe)
+	}
+}
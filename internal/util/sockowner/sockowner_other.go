@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package sockowner
+
+import (
+	"net/netip"
+)
+
+func lookup(network string, local, remote netip.AddrPort) (*Owner, error) {
+	return nil, ErrUnsupported
+}
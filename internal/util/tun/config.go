@@ -16,3 +16,8 @@ type Config struct {
 	Router  router.Router
 	DNS []net.IP
 }
+
+// Configurator reconfigures the addresses, routes and DNS servers of a running TUN device.
+type Configurator interface {
+	Configure(nets []net.IPNet, routes []*router.Route, dns []net.IP) error
+}
@@ -0,0 +1,17 @@
+package tun
+
+import (
+	"net"
+
+	"github.com/go-gost/core/router"
+)
+
+// configurator applies the network configuration assigned at runtime, e.g. by the server of a tun tunnel.
+type configurator struct {
+	l    *tunListener
+	name string
+}
+
+func (c *configurator) Configure(nets []net.IPNet, routes []*router.Route, dns []net.IP) error {
+	return c.l.configureTun(c.name, nets, routes, dns)
+}
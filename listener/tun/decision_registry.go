@@ -42,3 +42,50 @@ func getDecisionEvaluator(guid string) ht.DecisionEvaluator {
 	decMu.RUnlock()
 	return dec
 }
+
+var (
+	notifierMu sync.Mutex
+	notifiers  = map[string]*rulesNotifier{}
+)
+
+// rulesNotifier broadcasts the rules changes of the DecisionEvaluator of a tun listener.
+type rulesNotifier struct {
+	mu sync.Mutex
+	ch chan struct{}
+}
+
+func (n *rulesNotifier) RulesChanged() <-chan struct{} {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.ch
+}
+
+func (n *rulesNotifier) notify() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	close(n.ch)
+	n.ch = make(chan struct{})
+}
+
+// NotifyDecisionRulesChanged signals the handlers of the tun listener guid that the rules
+// of its DecisionEvaluator have changed, so that the cached flow policies are re-evaluated.
+func NotifyDecisionRulesChanged(guid string) {
+	if n := getRulesNotifier(guid); n != nil {
+		n.notify()
+	}
+}
+
+func getRulesNotifier(guid string) *rulesNotifier {
+	if guid == "" {
+		return nil
+	}
+	notifierMu.Lock()
+	defer notifierMu.Unlock()
+
+	n := notifiers[guid]
+	if n == nil {
+		n = &rulesNotifier{ch: make(chan struct{})}
+		notifiers[guid] = n
+	}
+	return n
+}
@@ -94,10 +94,12 @@ func (l *tunListener) listenLoop(ready context.CancelCauseFunc) {
 				itf.Name, ip, l.md.config.MTU, addrs)
 
 			mdMap := map[string]any{
-				"config": l.md.config,
+				"config":       l.md.config,
+				"configurator": &configurator{l: l, name: name},
 			}
 			if dec := getDecisionEvaluator(l.md.guid); dec != nil {
 				mdMap["decisionEvaluator"] = dec
+				mdMap["decisionRulesNotifier"] = getRulesNotifier(l.md.guid)
 			}
 			ctx = ictx.ContextWithMetadata(ctx, mdx.NewMetadata(mdMap))
 
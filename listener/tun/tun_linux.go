@@ -9,6 +9,7 @@ import (
 	"strings"
 	"syscall"
 
+	"github.com/go-gost/core/router"
 	"github.com/vishvananda/netlink"
 )
 
@@ -92,3 +93,69 @@ func (l *tunListener) addRoutes(ifce *net.Interface) error {
 	}
 	return nil
 }
+
+func (l *tunListener) configureTun(name string, nets []net.IPNet, routes []*router.Route, dns []net.IP) error {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return err
+	}
+
+	addrs, err := netlink.AddrList(link, netlink.FAMILY_ALL)
+	if err != nil {
+		return err
+	}
+	for _, addr := range addrs {
+		if addr.IP.IsLinkLocalUnicast() || containsIPNet(nets, addr.IPNet) {
+			continue
+		}
+		if err := netlink.AddrDel(link, &addr); err != nil {
+			l.log.Warnf("delete address %s: %v", addr.IPNet, err)
+		}
+	}
+	for _, ipNet := range nets {
+		if err := netlink.AddrReplace(link, &netlink.Addr{IPNet: &ipNet}); err != nil {
+			return fmt.Errorf("add address %s: %v", ipNet.String(), err)
+		}
+	}
+
+	for _, route := range routes {
+		if route.Net == nil {
+			continue
+		}
+		r := netlink.Route{
+			Dst:       route.Net,
+			LinkIndex: link.Attrs().Index,
+		}
+		if err := netlink.RouteReplace(&r); err != nil {
+			return fmt.Errorf("add route %v: %v", r.Dst, err)
+		}
+	}
+
+	var dnsServers []string
+	for _, ip := range dns {
+		dnsServers = append(dnsServers, ip.String())
+	}
+	if len(dnsServers) > 0 {
+		cmd := fmt.Sprintf("resolvectl dns %s %s", name, strings.Join(dnsServers, " "))
+		l.log.Debug(cmd)
+
+		args := strings.Split(cmd, " ")
+		if er := exec.Command(args[0], args[1:]...).Run(); er != nil {
+			return fmt.Errorf("%s: %v", cmd, er)
+		}
+	}
+
+	return nil
+}
+
+func containsIPNet(nets []net.IPNet, ipNet *net.IPNet) bool {
+	if ipNet == nil {
+		return false
+	}
+	for _, n := range nets {
+		if n.IP.Equal(ipNet.IP) && n.Mask.String() == ipNet.Mask.String() {
+			return true
+		}
+	}
+	return false
+}
@@ -8,6 +8,8 @@ import (
 	"net"
 	"os/exec"
 	"strings"
+
+	"github.com/go-gost/core/router"
 )
 
 const (
@@ -56,3 +58,33 @@ func (l *tunListener) addRoutes(ifName string) error {
 	}
 	return nil
 }
+
+func (l *tunListener) configureTun(name string, nets []net.IPNet, routes []*router.Route, dns []net.IP) error {
+	var cmds []string
+	for _, ipNet := range nets {
+		if ipNet.IP.To4() == nil {
+			cmds = append(cmds, fmt.Sprintf("ifconfig %s inet6 %s alias", name, ipNet.String()))
+			continue
+		}
+		cmds = append(cmds, fmt.Sprintf("ifconfig %s inet %s mtu %d up", name, ipNet.String(), l.md.config.MTU))
+	}
+	for _, route := range routes {
+		if route.Net == nil {
+			continue
+		}
+		cmds = append(cmds, fmt.Sprintf("route add -net %s -interface %s", route.Net.String(), name))
+	}
+
+	for _, cmd := range cmds {
+		l.log.Debug(cmd)
+		args := strings.Split(cmd, " ")
+		if er := exec.Command(args[0], args[1:]...).Run(); er != nil {
+			return fmt.Errorf("%s: %v", cmd, er)
+		}
+	}
+
+	if len(dns) > 0 {
+		l.log.Warnf("dns servers %v are not configured on %s", dns, name)
+	}
+	return nil
+}
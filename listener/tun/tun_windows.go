@@ -134,3 +134,45 @@ func (l *tunListener) deleteRoute(ifName string, route *router.Route) error {
 func ipMask(mask net.IPMask) string {
 	return fmt.Sprintf("%d.%d.%d.%d", mask[0], mask[1], mask[2], mask[3])
 }
+
+func (l *tunListener) configureTun(name string, nets []net.IPNet, routes []*router.Route, dns []net.IP) error {
+	var cmds []string
+	for _, ipNet := range nets {
+		if ipNet.IP.To4() == nil {
+			cmds = append(cmds, fmt.Sprintf("netsh interface ipv6 set address %s %s", name, ipNet.IP.String()))
+			continue
+		}
+		cmds = append(cmds, fmt.Sprintf("netsh interface ip set address name=%s "+
+			"source=static addr=%s mask=%s gateway=none",
+			name, ipNet.IP.String(), ipMask(ipNet.Mask)))
+	}
+	for _, route := range routes {
+		if route.Net == nil {
+			continue
+		}
+		l.deleteRoute(name, route)
+
+		network := "ip"
+		if route.Net.IP.To4() == nil {
+			network = "ipv6"
+		}
+		cmds = append(cmds, fmt.Sprintf("netsh interface %s add route prefix=%s interface=%s store=active",
+			network, route.Net.String(), name))
+	}
+	for _, ip := range dns {
+		network := "ip"
+		if ip.To4() == nil {
+			network = "ipv6"
+		}
+		cmds = append(cmds, fmt.Sprintf("netsh interface %s add dnsservers name=%s address=%s validate=no", network, name, ip.String()))
+	}
+
+	for _, cmd := range cmds {
+		l.log.Debug(cmd)
+		args := strings.Split(cmd, " ")
+		if er := exec.Command(args[0], args[1:]...).Run(); er != nil {
+			return fmt.Errorf("%s: %v", cmd, er)
+		}
+	}
+	return nil
+}
@@ -27,6 +27,16 @@ const (
 	MetricChainErrorsCounter metrics.MetricName = "gost_chain_errors_total"
 	// Total recorder records. Labels: host, recorder.
 	MetricRecorderRecordsCounter metrics.MetricName = "gost_recorder_records_total"
+	// Hop node health, 1 for up and 0 for down. Labels: host, hop, node.
+	MetricHopNodeUpGauge metrics.MetricName = "gost_hop_node_up"
+	// Hop node health check duration histogram. Labels: host, hop, node.
+	MetricHopNodeHealthCheckDurationObserver metrics.MetricName = "gost_hop_node_health_check_duration_seconds"
+	// Total hop node health check errors. Labels: host, hop, node.
+	MetricHopNodeHealthCheckErrorsCounter metrics.MetricName = "gost_hop_node_health_check_errors_total"
+	// Hop node circuit breaker state, 0 for closed, 1 for half-open and 2 for open. Labels: host, hop, node.
+	MetricHopNodeBreakerStateGauge metrics.MetricName = "gost_hop_node_breaker_state"
+	// Total hop node circuit breaker trips. Labels: host, hop, node.
+	MetricHopNodeBreakerTripsCounter metrics.MetricName = "gost_hop_node_breaker_trips_total"
 )
 
 var (
@@ -31,6 +31,18 @@ func NewMetrics() metrics.Metrics {
 					Help: "Current in-flight requests",
 				},
 				[]string{"host", "service", "client"}),
+			MetricHopNodeUpGauge: prometheus.NewGaugeVec(
+				prometheus.GaugeOpts{
+					Name: string(MetricHopNodeUpGauge),
+					Help: "Current health of hop nodes, 1 for up and 0 for down",
+				},
+				[]string{"host", "hop", "node"}),
+			MetricHopNodeBreakerStateGauge: prometheus.NewGaugeVec(
+				prometheus.GaugeOpts{
+					Name: string(MetricHopNodeBreakerStateGauge),
+					Help: "Current circuit breaker state of hop nodes, 0 for closed, 1 for half-open and 2 for open",
+				},
+				[]string{"host", "hop", "node"}),
 		},
 		counters: map[metrics.MetricName]*prometheus.CounterVec{
 			MetricServiceRequestsCounter: prometheus.NewCounterVec(
@@ -69,6 +81,18 @@ func NewMetrics() metrics.Metrics {
 					Help: "Total records written by recorder",
 				},
 				[]string{"host", "recorder"}),
+			MetricHopNodeHealthCheckErrorsCounter: prometheus.NewCounterVec(
+				prometheus.CounterOpts{
+					Name: string(MetricHopNodeHealthCheckErrorsCounter),
+					Help: "Total hop node health check errors",
+				},
+				[]string{"host", "hop", "node"}),
+			MetricHopNodeBreakerTripsCounter: prometheus.NewCounterVec(
+				prometheus.CounterOpts{
+					Name: string(MetricHopNodeBreakerTripsCounter),
+					Help: "Total hop node circuit breaker trips",
+				},
+				[]string{"host", "hop", "node"}),
 		},
 		histograms: map[metrics.MetricName]*prometheus.HistogramVec{
 			MetricServiceRequestsDurationObserver: prometheus.NewHistogramVec(
@@ -89,6 +113,15 @@ func NewMetrics() metrics.Metrics {
 					},
 				},
 				[]string{"host", "chain", "node"}),
+			MetricHopNodeHealthCheckDurationObserver: prometheus.NewHistogramVec(
+				prometheus.HistogramOpts{
+					Name: string(MetricHopNodeHealthCheckDurationObserver),
+					Help: "Distribution of hop node health check latencies",
+					Buckets: []float64{
+						.01, .05, .1, .25, .5, 1, 1.5, 2, 5, 10, 15, 30, 60,
+					},
+				},
+				[]string{"host", "hop", "node"}),
 		},
 	}
 	for k := range m.gauges {
@@ -71,6 +71,40 @@ type DNSRecorderObject struct {
 	Cached   bool   `json:"cached"`
 }
 
+type ICMPRecorderObject struct {
+	Type  string        `json:"type"`
+	ID    int           `json:"id"`
+	Seq   int           `json:"seq"`
+	Size  int           `json:"size"`
+	Local bool          `json:"local"`
+	RTT   time.Duration `json:"rtt"`
+}
+
+// DecisionInputRecorderObject is the input of a traffic decision.
+type DecisionInputRecorderObject struct {
+	AppID      string `json:"appID,omitempty"`
+	DestHost   string `json:"destHost"`
+	DestPort   int    `json:"destPort"`
+	Protocol   string `json:"protocol"`
+	DestDomain string `json:"destDomain,omitempty"`
+}
+
+// DecisionRecorderObject records how the route of a flow was decided.
+type DecisionRecorderObject struct {
+	// Input is nil if no decision evaluator is configured.
+	Input     *DecisionInputRecorderObject `json:"input,omitempty"`
+	AppID     string                       `json:"appID,omitempty"`
+	Hostname  string                       `json:"hostname,omitempty"`
+	Action    string                       `json:"action"`
+	ProxyHost string                       `json:"proxyHost,omitempty"`
+	// Cached is true if the decision was taken from the connection tracking cache.
+	Cached bool   `json:"cached"`
+	Rule   string `json:"rule,omitempty"`
+	RuleID string `json:"ruleID,omitempty"`
+	// Class is the bandwidth class the flow is limited by.
+	Class string `json:"class,omitempty"`
+}
+
 type HandlerRecorderObject struct {
 	Node       string `json:"node,omitempty"`
 	Service    string `json:"service"`
@@ -88,6 +122,8 @@ type HandlerRecorderObject struct {
 	Websocket   *WebsocketRecorderObject `json:"websocket,omitempty"`
 	TLS         *TLSRecorderObject       `json:"tls,omitempty"`
 	DNS         *DNSRecorderObject       `json:"dns,omitempty"`
+	ICMP        *ICMPRecorderObject      `json:"icmp,omitempty"`
+	Decision    *DecisionRecorderObject  `json:"decision,omitempty"`
 	Route       string                   `json:"route,omitempty"`
 	InputBytes  uint64                   `json:"inputBytes"`
 	OutputBytes uint64                   `json:"outputBytes"`
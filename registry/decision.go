@@ -0,0 +1,10 @@
+package registry
+
+// DecisionEvaluator is a traffic decision evaluator of the tun and tungo handlers.
+// The registry does not depend on the decision types, the handlers assert the
+// registered evaluators to their own DecisionEvaluator interface.
+type DecisionEvaluator any
+
+type decisionRegistry struct {
+	registry[DecisionEvaluator]
+}
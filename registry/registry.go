@@ -50,6 +50,7 @@ var (
 
 	ingressReg  reg.Registry[ingress.Ingress]   = new(ingressRegistry)
 	routerReg   reg.Registry[router.Router]     = new(routerRegistry)
+	decisionReg reg.Registry[DecisionEvaluator] = new(decisionRegistry)
 	sdReg       reg.Registry[sd.SD]             = new(sdRegistry)
 	observerReg reg.Registry[observer.Observer] = new(observerRegistry)
 
@@ -80,6 +81,19 @@ func (r *registry[T]) Unregister(name string) {
 	}
 }
 
+// swap registers v in place of the object registered by the name,
+// the replaced object is closed.
+func (r *registry[T]) swap(name string, v T) {
+	if name == "" {
+		return
+	}
+	if old, loaded := r.m.Swap(name, v); loaded {
+		if closer, ok := old.(io.Closer); ok {
+			closer.Close()
+		}
+	}
+}
+
 func (r *registry[T]) IsRegistered(name string) bool {
 	_, ok := r.m.Load(name)
 	return ok
@@ -105,6 +119,17 @@ func (r *registry[T]) GetAll() (m map[string]T) {
 	return
 }
 
+// Swap registers v in place of the object registered by the name without a window
+// in which the name is unregistered. The replaced object is closed.
+func Swap[T any](r reg.Registry[T], name string, v T) error {
+	if sw, ok := r.(interface{ swap(string, T) }); ok {
+		sw.swap(name, v)
+		return nil
+	}
+	r.Unregister(name)
+	return r.Register(name, v)
+}
+
 func ListenerRegistry() reg.Registry[NewListener] {
 	return listenerReg
 }
@@ -177,6 +202,10 @@ func RouterRegistry() reg.Registry[router.Router] {
 	return routerReg
 }
 
+func DecisionRegistry() reg.Registry[DecisionEvaluator] {
+	return decisionReg
+}
+
 func SDRegistry() reg.Registry[sd.SD] {
 	return sdReg
 }
@@ -0,0 +1,345 @@
+package selector
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-gost/core/metrics"
+	"github.com/go-gost/core/selector"
+	xmetrics "github.com/go-gost/x/metrics"
+)
+
+// circuit breaker states.
+const (
+	BreakerClosed   = "closed"
+	BreakerHalfOpen = "half-open"
+	BreakerOpen     = "open"
+)
+
+// default options for BreakerFilter
+const (
+	DefaultBreakerMaxFails         = 5
+	DefaultBreakerWindow           = 30 * time.Second
+	DefaultBreakerMinRequests      = 10
+	DefaultBreakerOpenTimeout      = 30 * time.Second
+	DefaultBreakerHalfOpenRequests = 1
+)
+
+// breakerBuckets is the number of buckets of the sliding window.
+const breakerBuckets = 10
+
+type BreakerOptions struct {
+	// Hop is the name of the hop, used as the metrics label.
+	Hop string
+	// MaxFails trips the breaker after the consecutive failures.
+	MaxFails int
+	// ErrorRate trips the breaker when the rate of the failed connections
+	// in the sliding window reaches it, if there are at least MinRequests connections.
+	ErrorRate   float64
+	Window      time.Duration
+	MinRequests int
+	// OpenTimeout is the time the breaker stays open before it is half-open.
+	OpenTimeout time.Duration
+	// HalfOpenRequests is the number of the trial connections in the half-open state,
+	// the breaker is closed if all of them succeed.
+	HalfOpenRequests int
+}
+
+func (opts BreakerOptions) withDefaults() BreakerOptions {
+	if opts.MaxFails <= 0 && opts.ErrorRate <= 0 {
+		opts.MaxFails = DefaultBreakerMaxFails
+	}
+	if opts.Window <= 0 {
+		opts.Window = DefaultBreakerWindow
+	}
+	if opts.MinRequests <= 0 {
+		opts.MinRequests = DefaultBreakerMinRequests
+	}
+	if opts.OpenTimeout <= 0 {
+		opts.OpenTimeout = DefaultBreakerOpenTimeout
+	}
+	if opts.HalfOpenRequests <= 0 {
+		opts.HalfOpenRequests = DefaultBreakerHalfOpenRequests
+	}
+	return opts
+}
+
+// BreakerState is the snapshot of a circuit breaker.
+type BreakerState struct {
+	State string `json:"state"`
+	// Failures is the number of the consecutive failures.
+	Failures int `json:"failures"`
+	// Requests and Errors are the numbers of the connections and the failed ones in the sliding window.
+	Requests  int       `json:"requests"`
+	Errors    int       `json:"errors"`
+	ErrorRate float64   `json:"errorRate"`
+	Trials    int       `json:"trials,omitempty"`
+	OpenedAt  time.Time `json:"openedAt,omitempty"`
+}
+
+type breakerBucket struct {
+	start    time.Time
+	requests int
+	errors   int
+}
+
+// Breaker is the circuit breaker of a node. It is closed while the connections succeed,
+// open after the failures reach the threshold, and half-open after the open timeout,
+// when a limited number of trial connections decides whether it is closed or open again.
+type Breaker struct {
+	name string
+
+	mu       sync.Mutex
+	options  BreakerOptions
+	state    string
+	fails    int
+	buckets  [breakerBuckets]breakerBucket
+	openedAt time.Time
+	trials   int
+	passed   int
+}
+
+func newBreaker(name string, opts BreakerOptions) *Breaker {
+	return &Breaker{
+		name:    name,
+		options: opts,
+		state:   BreakerClosed,
+	}
+}
+
+// LookupBreaker returns the circuit breaker of v, nil if there is none.
+func LookupBreaker(v any) *Breaker {
+	if st := LookupStats(v); st != nil {
+		return st.breaker.Load()
+	}
+	return nil
+}
+
+func breakerOf(v any, opts BreakerOptions) *Breaker {
+	st := StatsOf(v)
+	if st == nil {
+		return nil
+	}
+
+	b := st.breaker.Load()
+	if b == nil {
+		st.breaker.CompareAndSwap(nil, newBreaker(hashIDOf(v), opts))
+		b = st.breaker.Load()
+	}
+
+	b.mu.Lock()
+	b.options = opts
+	b.mu.Unlock()
+
+	return b
+}
+
+// Allow reports whether a connection is allowed by the breaker.
+func (b *Breaker) Allow() bool {
+	if b == nil {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.updateState(time.Now()) {
+	case BreakerOpen:
+		return false
+	case BreakerHalfOpen:
+		return b.trials < b.options.HalfOpenRequests
+	default:
+		return true
+	}
+}
+
+// Begin is called when a connection to the node starts.
+func (b *Breaker) Begin() {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.updateState(time.Now()) == BreakerHalfOpen {
+		b.trials++
+	}
+}
+
+// Done records the result of a connection started by Begin.
+func (b *Breaker) Done(err error) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	switch b.updateState(now) {
+	case BreakerClosed:
+		bucket := b.bucket(now)
+		bucket.requests++
+		if err == nil {
+			b.fails = 0
+			return
+		}
+		bucket.errors++
+		b.fails++
+
+		if b.options.MaxFails > 0 && b.fails >= b.options.MaxFails {
+			b.trip(now)
+			return
+		}
+		if b.options.ErrorRate > 0 {
+			requests, errors := b.window(now)
+			if requests >= b.options.MinRequests && float64(errors)/float64(requests) >= b.options.ErrorRate {
+				b.trip(now)
+			}
+		}
+
+	case BreakerHalfOpen:
+		if b.trials > 0 {
+			b.trials--
+		}
+		if err != nil {
+			b.trip(now)
+			return
+		}
+		b.passed++
+		if b.passed >= b.options.HalfOpenRequests {
+			b.reset()
+		}
+	}
+}
+
+// Cancel releases a connection started by Begin without a result.
+func (b *Breaker) Cancel() {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerHalfOpen && b.trials > 0 {
+		b.trials--
+	}
+}
+
+func (b *Breaker) State() BreakerState {
+	if b == nil {
+		return BreakerState{State: BreakerClosed}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	requests, errors := b.window(now)
+	st := BreakerState{
+		State:    b.updateState(now),
+		Failures: b.fails,
+		Requests: requests,
+		Errors:   errors,
+		Trials:   b.trials,
+		OpenedAt: b.openedAt,
+	}
+	if requests > 0 {
+		st.ErrorRate = float64(errors) / float64(requests)
+	}
+	return st
+}
+
+// updateState moves the open breaker to half-open after the open timeout.
+func (b *Breaker) updateState(now time.Time) string {
+	if b.state == BreakerOpen && now.Sub(b.openedAt) >= b.options.OpenTimeout {
+		b.setState(BreakerHalfOpen)
+		b.trials = 0
+		b.passed = 0
+	}
+	return b.state
+}
+
+func (b *Breaker) trip(now time.Time) {
+	b.setState(BreakerOpen)
+	b.openedAt = now
+	b.trials = 0
+	b.passed = 0
+
+	if v := xmetrics.GetCounter(xmetrics.MetricHopNodeBreakerTripsCounter,
+		metrics.Labels{"hop": b.options.Hop, "node": b.name}); v != nil {
+		v.Inc()
+	}
+}
+
+func (b *Breaker) reset() {
+	b.setState(BreakerClosed)
+	b.fails = 0
+	b.buckets = [breakerBuckets]breakerBucket{}
+	b.openedAt = time.Time{}
+}
+
+func (b *Breaker) setState(state string) {
+	b.state = state
+
+	if v := xmetrics.GetGauge(xmetrics.MetricHopNodeBreakerStateGauge,
+		metrics.Labels{"hop": b.options.Hop, "node": b.name}); v != nil {
+		switch state {
+		case BreakerOpen:
+			v.Set(2)
+		case BreakerHalfOpen:
+			v.Set(1)
+		default:
+			v.Set(0)
+		}
+	}
+}
+
+// bucket returns the bucket of the sliding window for the time.
+func (b *Breaker) bucket(now time.Time) *breakerBucket {
+	d := b.options.Window / breakerBuckets
+	if d <= 0 {
+		d = time.Second
+	}
+	start := now.Truncate(d)
+	bucket := &b.buckets[int(start.UnixNano()/int64(d))%breakerBuckets]
+	if !bucket.start.Equal(start) {
+		*bucket = breakerBucket{start: start}
+	}
+	return bucket
+}
+
+func (b *Breaker) window(now time.Time) (requests, errors int) {
+	for i := range b.buckets {
+		if now.Sub(b.buckets[i].start) < b.options.Window {
+			requests += b.buckets[i].requests
+			errors += b.buckets[i].errors
+		}
+	}
+	return
+}
+
+type breakerFilter[T any] struct {
+	options BreakerOptions
+}
+
+// BreakerFilter filters the objects whose circuit breaker is open,
+// and the half-open objects which have the maximum trial connections in progress.
+func BreakerFilter[T any](opts BreakerOptions) selector.Filter[T] {
+	return &breakerFilter[T]{
+		options: opts.withDefaults(),
+	}
+}
+
+func (f *breakerFilter[T]) Filter(ctx context.Context, vs ...T) []T {
+	var l []T
+	for _, v := range vs {
+		if b := breakerOf(v, f.options); b == nil || b.Allow() {
+			l = append(l, v)
+		}
+	}
+	return l
+}
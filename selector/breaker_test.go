@@ -0,0 +1,103 @@
+package selector
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-gost/core/chain"
+)
+
+var errConnect = errors.New("connection refused")
+
+func TestBreakerFilter_MaxFails(t *testing.T) {
+	a := chain.NewNode("a", "127.0.0.1:1")
+	b := chain.NewNode("b", "127.0.0.1:2")
+	f := BreakerFilter[*chain.Node](BreakerOptions{
+		MaxFails:         2,
+		OpenTimeout:      50 * time.Millisecond,
+		HalfOpenRequests: 2,
+	})
+
+	if vs := f.Filter(context.Background(), a, b); len(vs) != 2 {
+		t.Fatalf("expected 2 nodes, got %d", len(vs))
+	}
+
+	breaker := LookupBreaker(a)
+	for i := 0; i < 2; i++ {
+		breaker.Begin()
+		breaker.Done(errConnect)
+	}
+	if st := breaker.State(); st.State != BreakerOpen {
+		t.Fatalf("expected open, got %+v", st)
+	}
+	if vs := f.Filter(context.Background(), a, b); len(vs) != 1 || vs[0] != b {
+		t.Fatalf("open node not filtered: %v", vs)
+	}
+
+	// only the trial connections are allowed when half-open.
+	time.Sleep(60 * time.Millisecond)
+	for i := 0; i < 2; i++ {
+		if vs := f.Filter(context.Background(), a.Copy(), b); len(vs) != 2 {
+			t.Fatalf("trial %d: half-open node filtered", i)
+		}
+		breaker.Begin()
+	}
+	if vs := f.Filter(context.Background(), a, b); len(vs) != 1 {
+		t.Fatal("trials exceeded")
+	}
+
+	// a failed trial opens the breaker again.
+	breaker.Done(nil)
+	breaker.Done(errConnect)
+	if st := breaker.State(); st.State != BreakerOpen {
+		t.Fatalf("expected open, got %+v", st)
+	}
+
+	// the breaker is closed when all the trials succeed.
+	time.Sleep(60 * time.Millisecond)
+	for i := 0; i < 2; i++ {
+		breaker.Begin()
+	}
+	breaker.Done(nil)
+	if st := breaker.State(); st.State != BreakerHalfOpen {
+		t.Fatalf("expected half-open, got %+v", st)
+	}
+	breaker.Done(nil)
+	if st := breaker.State(); st.State != BreakerClosed || st.Failures != 0 {
+		t.Fatalf("expected closed, got %+v", st)
+	}
+}
+
+func TestBreakerFilter_ErrorRate(t *testing.T) {
+	a := chain.NewNode("a", "127.0.0.1:1")
+	f := BreakerFilter[*chain.Node](BreakerOptions{
+		ErrorRate:   0.5,
+		MinRequests: 10,
+		Window:      time.Minute,
+	})
+	f.Filter(context.Background(), a)
+	breaker := LookupBreaker(a)
+
+	// the failures interleaved with successes are not consecutive.
+	for i := 0; i < 8; i++ {
+		breaker.Begin()
+		if i%2 == 0 {
+			breaker.Done(errConnect)
+		} else {
+			breaker.Done(nil)
+		}
+	}
+	if st := breaker.State(); st.State != BreakerClosed || st.Requests != 8 || st.Errors != 4 {
+		t.Fatalf("expected closed below the minimum requests, got %+v", st)
+	}
+
+	breaker.Begin()
+	breaker.Done(nil)
+	breaker.Begin()
+	breaker.Done(errConnect)
+	if st := breaker.State(); st.State != BreakerOpen || st.ErrorRate != 0.5 {
+		t.Fatalf("expected open, got %+v", st)
+	}
+}
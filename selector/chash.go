@@ -0,0 +1,229 @@
+package selector
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-gost/core/chain"
+	"github.com/go-gost/core/selector"
+	xctx "github.com/go-gost/x/ctx"
+)
+
+// hash keys of ConsistentHashStrategy.
+const (
+	// HashKeySource is the hash source of the context, the client IP or the target host set by the handler.
+	HashKeySource = "hash"
+	// HashKeyClient is the client IP.
+	HashKeyClient = "client"
+	// HashKeyAuth is the authenticated client ID.
+	HashKeyAuth = "auth"
+	// HashKeyHost is the target host.
+	HashKeyHost = "host"
+)
+
+// DefaultReplicas is the default number of virtual nodes per unit of weight.
+const DefaultReplicas = 160
+
+type consistentHashStrategy[T any] struct {
+	keys     []string
+	replicas int
+
+	mu   sync.Mutex
+	ring *hashRing
+	r    *rand.Rand
+}
+
+// ConsistentHashStrategy is a strategy for node selector.
+// The node will be selected by the consistent hashing of the keys on a ring of virtual nodes,
+// each node owns a share of the ring proportional to its weight. When a node is added or removed,
+// only the keys owned by that node are remapped.
+// The keys are the names of the request attributes, hash, client, auth and host,
+// which are joined as the hash key. The hash source of the context is used if no key is specified.
+// The node will be selected randomly if the hash key is empty.
+func ConsistentHashStrategy[T any](keys []string, replicas int) selector.Strategy[T] {
+	if len(keys) == 0 {
+		keys = []string{HashKeySource}
+	}
+	if replicas <= 0 {
+		replicas = DefaultReplicas
+	}
+	return &consistentHashStrategy[T]{
+		keys:     keys,
+		replicas: replicas,
+		r:        rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+func (s *consistentHashStrategy[T]) Apply(ctx context.Context, vs ...T) (v T) {
+	if len(vs) == 0 {
+		return
+	}
+
+	ids := make([]string, len(vs))
+	weights := make([]int, len(vs))
+	for i := range vs {
+		ids[i] = hashIDOf(vs[i])
+		weights[i] = weightOf(vs[i])
+	}
+
+	key := s.hashKey(ctx)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if key == "" {
+		return vs[s.r.Intn(len(vs))]
+	}
+
+	if s.ring == nil || !s.ring.equal(ids, weights) {
+		s.ring = newHashRing(ids, weights, s.replicas)
+	}
+
+	id := s.ring.get(key)
+	for i := range ids {
+		if ids[i] == id {
+			return vs[i]
+		}
+	}
+	return vs[0]
+}
+
+func (s *consistentHashStrategy[T]) hashKey(ctx context.Context) string {
+	var b strings.Builder
+	for _, key := range s.keys {
+		var v string
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case HashKeySource, "":
+			if h := xctx.HashFromContext(ctx); h != nil {
+				v = h.Source
+			}
+		case HashKeyClient, "ip", "clientip":
+			if addr := xctx.SrcAddrFromContext(ctx); addr != nil {
+				v = addr.String()
+				if host, _, _ := net.SplitHostPort(v); host != "" {
+					v = host
+				}
+			}
+		case HashKeyAuth, "clientid", "client-id":
+			v = string(xctx.ClientIDFromContext(ctx))
+		case HashKeyHost, "target":
+			v = xctx.TargetFromContext(ctx)
+		}
+		if v == "" {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteByte('|')
+		}
+		b.WriteString(v)
+	}
+	return b.String()
+}
+
+// hashIDOf returns the stable identity of v on the hash ring,
+// which survives the reloading of the nodes.
+func hashIDOf(v any) string {
+	switch t := v.(type) {
+	case *chain.Node:
+		if t.Name != "" {
+			return t.Name
+		}
+		return t.Addr
+	case interface{ Name() string }:
+		return t.Name()
+	case fmt.Stringer:
+		return t.String()
+	}
+	return fmt.Sprintf("%p", v)
+}
+
+type hashRing struct {
+	ids     []string
+	weights []int
+	points  []uint64
+	owners  []string
+}
+
+func newHashRing(ids []string, weights []int, replicas int) *hashRing {
+	r := &hashRing{
+		ids:     append([]string(nil), ids...),
+		weights: append([]int(nil), weights...),
+	}
+
+	type point struct {
+		hash  uint64
+		owner string
+	}
+	var points []point
+	for i, id := range ids {
+		for j := 0; j < replicas*weights[i]; j++ {
+			points = append(points, point{
+				hash:  hashString(id + "#" + strconv.Itoa(j)),
+				owner: id,
+			})
+		}
+	}
+	sort.Slice(points, func(i, j int) bool {
+		if points[i].hash == points[j].hash {
+			return points[i].owner < points[j].owner
+		}
+		return points[i].hash < points[j].hash
+	})
+
+	r.points = make([]uint64, len(points))
+	r.owners = make([]string, len(points))
+	for i := range points {
+		r.points[i] = points[i].hash
+		r.owners[i] = points[i].owner
+	}
+	return r
+}
+
+func (r *hashRing) equal(ids []string, weights []int) bool {
+	if len(ids) != len(r.ids) {
+		return false
+	}
+	for i := range ids {
+		if ids[i] != r.ids[i] || weights[i] != r.weights[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// get returns the owner of the first virtual node clockwise from the key.
+func (r *hashRing) get(key string) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+	h := hashString(key)
+	i := sort.Search(len(r.points), func(i int) bool {
+		return r.points[i] >= h
+	})
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[i]
+}
+
+// hashString returns the FNV-1a hash of s, mixed by the splitmix64 finalizer
+// to spread the similar strings over the ring.
+func hashString(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
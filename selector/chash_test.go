@@ -0,0 +1,117 @@
+package selector
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/go-gost/core/chain"
+	xctx "github.com/go-gost/x/ctx"
+	"github.com/go-gost/x/metadata"
+)
+
+func hashNodes(n int) []*chain.Node {
+	var nodes []*chain.Node
+	for i := 0; i < n; i++ {
+		nodes = append(nodes, chain.NewNode(fmt.Sprintf("node-%d", i), fmt.Sprintf("127.0.0.1:%d", 1000+i)))
+	}
+	return nodes
+}
+
+func hashSelect(s interface {
+	Apply(context.Context, ...*chain.Node) *chain.Node
+}, keys int, nodes []*chain.Node) map[string]string {
+	m := make(map[string]string, keys)
+	for i := 0; i < keys; i++ {
+		source := fmt.Sprintf("10.0.%d.%d", i/256, i%256)
+		ctx := xctx.ContextWithHash(context.Background(), &xctx.Hash{Source: source})
+		m[source] = s.Apply(ctx, nodes...).Name
+	}
+	return m
+}
+
+func TestConsistentHashStrategy_Remap(t *testing.T) {
+	const keys = 10000
+	s := ConsistentHashStrategy[*chain.Node](nil, 0)
+
+	nodes := hashNodes(5)
+	before := hashSelect(s, keys, nodes)
+
+	// the nodes reloaded in another order are new objects of the same names.
+	reloaded := hashNodes(5)
+	reloaded[0], reloaded[4] = reloaded[4], reloaded[0]
+	for k, v := range hashSelect(s, keys, reloaded) {
+		if before[k] != v {
+			t.Fatalf("%s: remapped from %s to %s after reload", k, before[k], v)
+		}
+	}
+
+	// only the keys of the removed node are remapped.
+	after := hashSelect(s, keys, nodes[:4])
+	for k, v := range after {
+		if before[k] != "node-4" && before[k] != v {
+			t.Fatalf("%s: remapped from %s to %s", k, before[k], v)
+		}
+	}
+
+	// only the keys taken by the added node are remapped.
+	nodes = hashNodes(6)
+	moved := 0
+	for k, v := range hashSelect(s, keys, nodes) {
+		if v == before[k] {
+			continue
+		}
+		if v != "node-5" {
+			t.Fatalf("%s: remapped from %s to %s", k, before[k], v)
+		}
+		moved++
+	}
+	if moved < keys/12 || moved > keys/4 {
+		t.Fatalf("%d of %d keys moved to the new node", moved, keys)
+	}
+}
+
+func TestConsistentHashStrategy_Weight(t *testing.T) {
+	const keys = 20000
+	nodes := hashNodes(2)
+	nodes = append(nodes, chain.NewNode("node-2", "127.0.0.1:1002",
+		chain.MetadataNodeOption(metadata.NewMetadata(map[string]any{"weight": 2}))))
+
+	counts := map[string]int{}
+	for _, v := range hashSelect(ConsistentHashStrategy[*chain.Node](nil, 0), keys, nodes) {
+		counts[v]++
+	}
+	// node-2 owns half of the ring.
+	if n := counts["node-2"]; n < keys*4/10 || n > keys*6/10 {
+		t.Fatalf("unexpected distribution %v", counts)
+	}
+}
+
+func TestConsistentHashStrategy_Keys(t *testing.T) {
+	nodes := hashNodes(8)
+	s := ConsistentHashStrategy[*chain.Node]([]string{HashKeyAuth, HashKeyHost}, 0)
+
+	ctx := xctx.ContextWithSrcAddr(context.Background(), &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1})
+	ctx = xctx.ContextWithClientID(ctx, "user-1")
+	ctx = xctx.ContextWithTarget(ctx, "a.example")
+	node := s.Apply(ctx, nodes...)
+	for i := 0; i < 10; i++ {
+		if v := s.Apply(xctx.ContextWithHash(ctx, &xctx.Hash{Source: fmt.Sprint(i)}), nodes...); v != node {
+			t.Fatalf("expected %s, got %s", node.Name, v.Name)
+		}
+	}
+
+	s = ConsistentHashStrategy[*chain.Node]([]string{HashKeyClient}, 0)
+	node = s.Apply(ctx, nodes...)
+	for _, host := range []string{"b.example", "c.example", "d.example"} {
+		if v := s.Apply(xctx.ContextWithTarget(ctx, host), nodes...); v != node {
+			t.Fatalf("expected %s, got %s", node.Name, v.Name)
+		}
+	}
+
+	// a node is still selected without a hash key.
+	if v := s.Apply(context.Background(), nodes...); v == nil {
+		t.Fatal("no node selected")
+	}
+}
@@ -13,6 +13,9 @@ const (
 	DefaultFailTimeout = 10 * time.Second
 )
 
+// DefaultLatencyTolerance is the default tolerance of LowestLatencyStrategy.
+const DefaultLatencyTolerance = 10 * time.Millisecond
+
 const (
 	labelWeight      = "weight"
 	labelBackup      = "backup"
@@ -0,0 +1,145 @@
+package selector
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-gost/core/selector"
+)
+
+const (
+	// ewmaWeight is the weight of a new latency sample in the moving average.
+	ewmaWeight = 0.3
+	// statsIdleTimeout is the time the stats of an object are kept after it is last used.
+	statsIdleTimeout = 10 * time.Minute
+)
+
+// Stats are the runtime statistics of a selectable object,
+// used by the leastconn and lowest-latency strategies.
+type Stats struct {
+	conns      atomic.Int64
+	trackConns atomic.Bool
+	// latency is the EWMA of the connect latency in nanoseconds, as float64 bits.
+	latency  atomic.Uint64
+	lastUsed atomic.Int64
+	breaker  atomic.Pointer[Breaker]
+}
+
+// Conns returns the number of the in-flight connections.
+func (s *Stats) Conns() int64 {
+	if s == nil {
+		return 0
+	}
+	return s.conns.Load()
+}
+
+// TrackConns reports whether the in-flight connections are tracked.
+func (s *Stats) TrackConns() bool {
+	return s != nil && s.trackConns.Load()
+}
+
+// Acquire counts a new in-flight connection,
+// the returned function is called when the connection is closed.
+func (s *Stats) Acquire() (release func()) {
+	if s == nil {
+		return func() {}
+	}
+
+	s.conns.Add(1)
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.conns.Add(-1)
+		})
+	}
+}
+
+// Latency returns the moving average of the connect latency, 0 if it is unknown.
+func (s *Stats) Latency() time.Duration {
+	if s == nil {
+		return 0
+	}
+	return time.Duration(math.Float64frombits(s.latency.Load()))
+}
+
+// ObserveLatency adds a connect latency sample.
+func (s *Stats) ObserveLatency(d time.Duration) {
+	if s == nil || d <= 0 {
+		return
+	}
+
+	for {
+		old := s.latency.Load()
+		v := float64(d)
+		if old != 0 {
+			v = ewmaWeight*v + (1-ewmaWeight)*math.Float64frombits(old)
+		}
+		if s.latency.CompareAndSwap(old, math.Float64bits(v)) {
+			return
+		}
+	}
+}
+
+var (
+	stats     sync.Map
+	lastSweep atomic.Int64
+)
+
+// StatsOf returns the stats of v, nil if v is not Markable.
+// The stats are keyed by the marker of v, so that the copies of a node share them.
+func StatsOf(v any) *Stats {
+	key := statsKey(v)
+	if key == nil {
+		return nil
+	}
+
+	now := time.Now().UnixNano()
+	sweep(now)
+
+	v, ok := stats.Load(key)
+	if !ok {
+		v, _ = stats.LoadOrStore(key, &Stats{})
+	}
+	st := v.(*Stats)
+	st.lastUsed.Store(now)
+	return st
+}
+
+// LookupStats returns the stats of v without creating them, nil if there is none.
+func LookupStats(v any) *Stats {
+	key := statsKey(v)
+	if key == nil {
+		return nil
+	}
+	if v, ok := stats.Load(key); ok {
+		return v.(*Stats)
+	}
+	return nil
+}
+
+func statsKey(v any) selector.Marker {
+	if m, _ := v.(selector.Markable); m != nil {
+		if marker := m.Marker(); marker != nil {
+			return marker
+		}
+	}
+	return nil
+}
+
+// sweep removes the stats of the objects not used for statsIdleTimeout, such as the reloaded nodes.
+func sweep(now int64) {
+	last := lastSweep.Load()
+	if now-last < int64(statsIdleTimeout) || !lastSweep.CompareAndSwap(last, now) {
+		return
+	}
+
+	stats.Range(func(key, value any) bool {
+		st := value.(*Stats)
+		if st.Conns() == 0 && now-st.lastUsed.Load() >= int64(statsIdleTimeout) {
+			stats.Delete(key)
+		}
+		return true
+	})
+}
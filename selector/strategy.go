@@ -113,3 +113,90 @@ func (s *hashStrategy[T]) Apply(ctx context.Context, vs ...T) (v T) {
 
 	return vs[s.r.Intn(len(vs))]
 }
+
+type leastConnStrategy[T any] struct {
+	counter uint64
+}
+
+// LeastConnStrategy is a strategy for node selector.
+// The node with the fewest in-flight connections relative to its weight will be selected,
+// the ties are broken by round-robin.
+func LeastConnStrategy[T any]() selector.Strategy[T] {
+	return &leastConnStrategy[T]{}
+}
+
+func (s *leastConnStrategy[T]) Apply(ctx context.Context, vs ...T) (v T) {
+	if len(vs) == 0 {
+		return
+	}
+
+	offset := int(atomic.AddUint64(&s.counter, 1) % uint64(len(vs)))
+
+	best := -1
+	var bestLoad float64
+	for i := range vs {
+		k := (offset + i) % len(vs)
+		st := StatsOf(vs[k])
+		if st != nil {
+			st.trackConns.Store(true)
+		}
+		load := float64(st.Conns()) / float64(weightOf(vs[k]))
+		if best < 0 || load < bestLoad {
+			best, bestLoad = k, load
+		}
+	}
+	return vs[best]
+}
+
+type lowestLatencyStrategy[T any] struct {
+	tolerance time.Duration
+	counter   uint64
+}
+
+// LowestLatencyStrategy is a strategy for node selector.
+// The node with the lowest connect latency will be selected. The nodes within
+// the tolerance of the lowest latency and the nodes not measured yet are selected by round-robin.
+func LowestLatencyStrategy[T any](tolerance time.Duration) selector.Strategy[T] {
+	if tolerance <= 0 {
+		tolerance = DefaultLatencyTolerance
+	}
+	return &lowestLatencyStrategy[T]{
+		tolerance: tolerance,
+	}
+}
+
+func (s *lowestLatencyStrategy[T]) Apply(ctx context.Context, vs ...T) (v T) {
+	if len(vs) == 0 {
+		return
+	}
+
+	latencies := make([]time.Duration, len(vs))
+	var lowest time.Duration
+	for i := range vs {
+		latencies[i] = StatsOf(vs[i]).Latency()
+		if d := latencies[i]; d > 0 && (lowest == 0 || d < lowest) {
+			lowest = d
+		}
+	}
+
+	var candidates []T
+	for i := range vs {
+		if d := latencies[i]; d == 0 || d <= lowest+s.tolerance {
+			candidates = append(candidates, vs[i])
+		}
+	}
+
+	n := atomic.AddUint64(&s.counter, 1) - 1
+	return candidates[int(n%uint64(len(candidates)))]
+}
+
+func weightOf(v any) int {
+	weight := 0
+	if md, _ := v.(metadata.Metadatable); md != nil {
+		weight = mdutil.GetInt(md.Metadata(), labelWeight)
+	}
+	if weight <= 0 {
+		weight = 1
+	}
+	return weight
+}
@@ -0,0 +1,91 @@
+package selector
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-gost/core/chain"
+	"github.com/go-gost/x/metadata"
+)
+
+func TestLeastConnStrategy(t *testing.T) {
+	nodes := []*chain.Node{
+		chain.NewNode("a", "127.0.0.1:1"),
+		chain.NewNode("b", "127.0.0.1:2"),
+		chain.NewNode("c", "127.0.0.1:3", chain.MetadataNodeOption(metadata.NewMetadata(map[string]any{"weight": 3}))),
+	}
+	s := LeastConnStrategy[*chain.Node]()
+
+	// a node is selected in turn while the loads are even.
+	counts := map[string]int{}
+	var releases []func()
+	for i := 0; i < 5; i++ {
+		node := s.Apply(context.Background(), nodes...)
+		counts[node.Name]++
+		releases = append(releases, StatsOf(node).Acquire())
+	}
+	// c has 3 times the weight of a and b.
+	if counts["a"] != 1 || counts["b"] != 1 || counts["c"] != 3 {
+		t.Fatalf("unexpected selections %v", counts)
+	}
+	if !LookupStats(nodes[0]).TrackConns() {
+		t.Fatal("connections not tracked")
+	}
+
+	for _, release := range releases {
+		release()
+		release()
+	}
+	for _, node := range nodes {
+		if n := StatsOf(node).Conns(); n != 0 {
+			t.Fatalf("%s: expected 0 connections, got %d", node.Name, n)
+		}
+	}
+
+	// copies of a node share its stats.
+	release := StatsOf(nodes[0].Copy()).Acquire()
+	defer release()
+	if node := s.Apply(context.Background(), nodes[:2]...); node != nodes[1] {
+		t.Fatalf("expected b, got %s", node.Name)
+	}
+}
+
+func TestLowestLatencyStrategy(t *testing.T) {
+	a := chain.NewNode("a", "127.0.0.1:1")
+	b := chain.NewNode("b", "127.0.0.1:2")
+	c := chain.NewNode("c", "127.0.0.1:3")
+	StatsOf(a).ObserveLatency(20 * time.Millisecond)
+	StatsOf(b).ObserveLatency(25 * time.Millisecond)
+	StatsOf(c).ObserveLatency(100 * time.Millisecond)
+
+	s := LowestLatencyStrategy[*chain.Node](10 * time.Millisecond)
+	counts := map[string]int{}
+	for i := 0; i < 4; i++ {
+		counts[s.Apply(context.Background(), a, b, c).Name]++
+	}
+	if counts["a"] != 2 || counts["b"] != 2 {
+		t.Fatalf("unexpected selections %v", counts)
+	}
+
+	// the average moves towards the new samples.
+	for i := 0; i < 10; i++ {
+		StatsOf(c).ObserveLatency(5 * time.Millisecond)
+	}
+	if d := StatsOf(c).Latency(); d > 10*time.Millisecond {
+		t.Fatalf("unexpected latency %v", d)
+	}
+	if node := s.Apply(context.Background(), a, c); node != c {
+		t.Fatalf("expected c, got %s", node.Name)
+	}
+
+	// the nodes not measured yet are selected to be measured.
+	d := chain.NewNode("d", "127.0.0.1:4")
+	counts = map[string]int{}
+	for i := 0; i < 2; i++ {
+		counts[s.Apply(context.Background(), c, d).Name]++
+	}
+	if counts["c"] != 1 || counts["d"] != 1 {
+		t.Fatalf("unexpected selections %v", counts)
+	}
+}
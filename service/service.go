@@ -10,6 +10,7 @@ import (
 	"os/exec"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-gost/core/admission"
@@ -103,12 +104,57 @@ func LoggerOption(logger logger.Logger) Option {
 	}
 }
 
+var (
+	ErrServing = errors.New("service is already serving")
+)
+
+// handlerDrainTimeout is how long the connections in flight are drained on a replaced handler,
+// the remaining connections are then closed.
+var handlerDrainTimeout = time.Minute
+
 type defaultService struct {
 	name     string
 	listener listener.Listener
-	handler  handler.Handler
 	status   *Status
 	options  options
+	serving  atomic.Bool
+
+	mu      sync.RWMutex
+	handler *serviceHandler
+}
+
+// serviceHandler tracks the connections in flight of a handler,
+// so that a replaced handler is closed once they are drained.
+type serviceHandler struct {
+	handler.Handler
+	wg    sync.WaitGroup
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+}
+
+func newServiceHandler(h handler.Handler) *serviceHandler {
+	return &serviceHandler{
+		Handler: h,
+		conns:   make(map[net.Conn]struct{}),
+	}
+}
+
+func (h *serviceHandler) release(conn net.Conn) {
+	h.mu.Lock()
+	delete(h.conns, conn)
+	h.mu.Unlock()
+	h.wg.Done()
+}
+
+// closeConns closes the connections in flight and returns their number.
+func (h *serviceHandler) closeConns() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for conn := range h.conns {
+		conn.Close()
+	}
+	return len(h.conns)
 }
 
 func NewService(name string, ln listener.Listener, h handler.Handler, opts ...Option) service.Service {
@@ -119,7 +165,7 @@ func NewService(name string, ln listener.Listener, h handler.Handler, opts ...Op
 	s := &defaultService{
 		name:     name,
 		listener: ln,
-		handler:  h,
+		handler:  newServiceHandler(h),
 		options:  options,
 		status: &Status{
 			createTime: time.Now(),
@@ -138,10 +184,70 @@ func (s *defaultService) Addr() net.Addr {
 	return s.listener.Addr()
 }
 
+// SetHandler replaces the handler of the service without closing the listener.
+// The new connections are handled by h, the connections in flight are drained
+// on the replaced handler, which is then closed. The connections still in flight
+// after the drain timeout are closed.
+func (s *defaultService) SetHandler(h handler.Handler) {
+	s.mu.Lock()
+	old := s.handler
+	s.handler = newServiceHandler(h)
+	s.mu.Unlock()
+
+	go func() {
+		log := s.options.logger
+
+		done := make(chan struct{})
+		go func() {
+			old.wg.Wait()
+			close(done)
+		}()
+
+		select {
+		case <-done:
+		default:
+			old.mu.Lock()
+			n := len(old.conns)
+			old.mu.Unlock()
+			log.Infof("service %s: handler replaced, %d connections pending on the old handler", s.name, n)
+
+			select {
+			case <-done:
+			case <-time.After(handlerDrainTimeout):
+				n := old.closeConns()
+				log.Warnf("service %s: replaced handler not drained after %s, %d connections closed", s.name, handlerDrainTimeout, n)
+				<-done
+			}
+		}
+
+		if closer, ok := old.Handler.(io.Closer); ok {
+			closer.Close()
+		}
+		log.Debugf("service %s: replaced handler is drained", s.name)
+	}()
+}
+
+// acquireHandler returns the current handler with conn tracked in flight.
+func (s *defaultService) acquireHandler(conn net.Conn) *serviceHandler {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	h := s.handler
+	h.wg.Add(1)
+	h.mu.Lock()
+	h.conns[conn] = struct{}{}
+	h.mu.Unlock()
+	return h
+}
+
 func (s *defaultService) Serve() error {
+	if !s.serving.CompareAndSwap(false, true) {
+		return ErrServing
+	}
+
 	s.execCmds("post-up", s.options.postUp)
 	s.setState(StateReady)
-	s.status.addEvent(Event{
+	s.status.AddEvent(Event{
 		Time:    time.Now(),
 		Message: fmt.Sprintf("service %s is listening on %s", s.name, s.listener.Addr()),
 	})
@@ -254,9 +360,11 @@ func (s *defaultService) Serve() error {
 		}
 
 		wg.Add(1)
+		h := s.acquireHandler(conn)
 
 		go func() {
 			defer wg.Done()
+			defer h.release(conn)
 
 			if v := xmetrics.GetCounter(xmetrics.MetricServiceRequestsCounter,
 				metrics.Labels{"service": s.name, "client": clientIP}); v != nil {
@@ -277,7 +385,7 @@ func (s *defaultService) Serve() error {
 				}()
 			}
 
-			if err := s.handler.Handle(ctx, conn); err != nil {
+			if err := h.Handle(ctx, conn); err != nil {
 				log.Error(err)
 				if v := xmetrics.GetCounter(xmetrics.MetricServiceHandlerErrorsCounter,
 					metrics.Labels{"service": s.name, "client": clientIP}); v != nil {
@@ -299,7 +407,11 @@ func (s *defaultService) Close() error {
 	s.execCmds("pre-down", s.options.preDown)
 	defer s.execCmds("post-down", s.options.postDown)
 
-	if closer, ok := s.handler.(io.Closer); ok {
+	s.mu.RLock()
+	h := s.handler
+	s.mu.RUnlock()
+
+	if closer, ok := h.Handler.(io.Closer); ok {
 		closer.Close()
 	}
 	return s.listener.Close()
@@ -337,7 +449,7 @@ func (s *defaultService) setState(state State) {
 	s.status.setState(state)
 
 	msg := fmt.Sprintf("service %s is %s", s.name, state)
-	s.status.addEvent(Event{
+	s.status.AddEvent(Event{
 		Time:    time.Now(),
 		Message: msg,
 	})
@@ -59,7 +59,7 @@ func (p *Status) Events() []Event {
 	return events
 }
 
-func (p *Status) addEvent(event Event) {
+func (p *Status) AddEvent(event Event) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 